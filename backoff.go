@@ -0,0 +1,119 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erpc
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff returns the interval before each redial attempt.
+type Backoff interface {
+	// Next returns the interval before the attempt-th redial, attempt starts from 1.
+	Next(attempt int) time.Duration
+}
+
+// ConstantBackoff waits the same interval before each redial attempt.
+type ConstantBackoff time.Duration
+
+// Next returns the interval before the attempt-th redial.
+func (c ConstantBackoff) Next(attempt int) time.Duration {
+	return time.Duration(c)
+}
+
+// ExponentialBackoff multiplies the interval by Factor after each redial attempt, up to Max.
+// NOTE:
+//
+//	If Jitter is true, the interval is randomized in [0, interval), that is the "full jitter",
+//	which spreads the redials of many clients after a server restart.
+type ExponentialBackoff struct {
+	Base   time.Duration
+	Max    time.Duration
+	Factor float64
+	Jitter bool
+}
+
+// Next returns the interval before the attempt-th redial.
+func (e *ExponentialBackoff) Next(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	factor := e.Factor
+	if factor <= 1 {
+		factor = 2
+	}
+	d := float64(e.Base) * math.Pow(factor, float64(attempt-1))
+	if e.Max > 0 && d > float64(e.Max) {
+		d = float64(e.Max)
+	}
+	if d > math.MaxInt64 {
+		d = math.MaxInt64
+	}
+	interval := time.Duration(d)
+	if e.Jitter && interval > 0 {
+		interval = time.Duration(rand.Int63n(int64(interval)))
+	}
+	return interval
+}
+
+// BackoffConfig redial backoff options
+type BackoffConfig struct {
+	Strategy    string        `yaml:"strategy"      ini:"strategy"      comment:"Backoff strategy; constant or exponential, default constant"`
+	MaxInterval time.Duration `yaml:"max_interval"  ini:"max_interval"  comment:"Maximum interval of the exponential backoff, default 30s; ns,µs,ms,s,m,h"`
+	Factor      float64       `yaml:"factor"        ini:"factor"        comment:"Multiplier of the exponential backoff, default 2"`
+	Jitter      bool          `yaml:"jitter"        ini:"jitter"        comment:"Randomize the exponential backoff interval in [0, interval)"`
+	// Custom is the user-defined backoff strategy, it takes precedence over the others.
+	Custom Backoff `yaml:"-" ini:"-"`
+}
+
+const (
+	backoffConstant    = "constant"
+	backoffExponential = "exponential"
+)
+
+func (b *BackoffConfig) check() error {
+	switch b.Strategy {
+	case "":
+		b.Strategy = backoffConstant
+	case backoffConstant, backoffExponential:
+	default:
+		return fmt.Errorf("invalid backoff strategy: %q, refer to the following: constant or exponential", b.Strategy)
+	}
+	if b.MaxInterval <= 0 {
+		b.MaxInterval = time.Second * 30
+	}
+	if b.Factor <= 1 {
+		b.Factor = 2
+	}
+	return nil
+}
+
+// backoff creates the backoff strategy, base is the redial interval.
+func (b *BackoffConfig) backoff(base time.Duration) Backoff {
+	if b.Custom != nil {
+		return b.Custom
+	}
+	if b.Strategy == backoffExponential {
+		return &ExponentialBackoff{
+			Base:   base,
+			Max:    b.MaxInterval,
+			Factor: b.Factor,
+			Jitter: b.Jitter,
+		}
+	}
+	return ConstantBackoff(base)
+}
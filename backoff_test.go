@@ -0,0 +1,76 @@
+package erpc
+
+import (
+	"crypto/tls"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := &ExponentialBackoff{Base: time.Millisecond * 100, Max: time.Second}
+	want := []time.Duration{
+		time.Millisecond * 100,
+		time.Millisecond * 200,
+		time.Millisecond * 400,
+		time.Millisecond * 800,
+		time.Second,
+		time.Second,
+	}
+	for i, w := range want {
+		if d := b.Next(i + 1); d != w {
+			t.Fatalf("attempt %d: want %v, got %v", i+1, w, d)
+		}
+	}
+	b.Jitter = true
+	for i := 1; i < 10; i++ {
+		if d := b.Next(i); d < 0 || d >= time.Second {
+			t.Fatalf("attempt %d: jitter out of range: %v", i, d)
+		}
+	}
+}
+
+func TestBackoffConfig(t *testing.T) {
+	var cfg BackoffConfig
+	if err := cfg.check(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.backoff(time.Second).(ConstantBackoff); !ok {
+		t.Fatal("want constant backoff by default")
+	}
+	cfg = BackoffConfig{Strategy: "linear"}
+	if err := cfg.check(); err == nil {
+		t.Fatal("want invalid strategy error")
+	}
+}
+
+func TestResolveAddrs(t *testing.T) {
+	d := &Dialer{network: "tcp"}
+	got, hosts := d.resolveAddrs([]string{"127.0.0.1:1", "127.0.0.2:2", "[::1]:3"})
+	if len(hosts) != 0 {
+		t.Fatalf("want no host names of the IP addresses, got %v", hosts)
+	}
+	want := []string{"[::1]:3", "127.0.0.1:1", "127.0.0.2:2"}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+	if got := failoverAddrs(want, "127.0.0.1:1"); got[2] != "127.0.0.1:1" {
+		t.Fatalf("want the broken address at the end, got %v", got)
+	}
+	d = &Dialer{network: "tcp4"}
+	got, hosts = d.resolveAddrs([]string{"localhost:4"})
+	if len(got) == 0 || hosts[got[0]] != "localhost" {
+		t.Fatalf("want the host name of the resolved address, got %v %v", got, hosts)
+	}
+	d.tlsConfig = &tls.Config{}
+	if name := d.serverTLSConfig(hosts[got[0]]).ServerName; name != "localhost" {
+		t.Fatalf("want server name localhost, got %q", name)
+	}
+	if d.tlsConfig.ServerName != "" {
+		t.Fatal("want the TLS config of the dialer unchanged")
+	}
+}
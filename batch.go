@@ -0,0 +1,321 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erpc
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/andeya/erpc/v7/quic"
+	"github.com/andeya/erpc/v7/socket"
+)
+
+// WriteBatchConfig write coalescing options
+type WriteBatchConfig struct {
+	Enable   bool          `yaml:"enable"     ini:"enable"     comment:"Coalesce the messages written concurrently by a session, and flush them with vectored writes"`
+	MaxBytes int           `yaml:"max_bytes"  ini:"max_bytes"  comment:"Maximum bytes of a batch, default 64KB"`
+	Linger   time.Duration `yaml:"linger"     ini:"linger"     comment:"Maximum duration to wait for more messages before flushing; 0 means flushing as soon as the writer is idle; ns,µs,ms,s,m,h"`
+}
+
+const defaultWriteBatchMaxBytes = 64 * 1024
+
+func (w *WriteBatchConfig) check() {
+	if w.MaxBytes <= 0 {
+		w.MaxBytes = defaultWriteBatchMaxBytes
+	}
+	if w.Linger < 0 {
+		w.Linger = 0
+	}
+}
+
+// writeBatcher collects the packed messages of a session,
+// and a writer goroutine flushes them with vectored writes.
+// NOTE:
+//
+//	The writer goroutine is started on demand, and exits when there is no pending message;
+//	The protocols writing the connection directly, e.g. websocket, are not coalesced;
+//	In sync mode, the messages are not coalesced, it only captures the packed bytes for the session resumption.
+type writeBatcher struct {
+	sync         bool
+	maxBytes     int
+	linger       time.Duration
+	cur          *batchEntry // the message being packed, protected by session.writeLock
+	mu           sync.Mutex
+	pending      []*batchEntry
+	pendingBytes int
+	running      bool
+	wakeCh       chan struct{}
+	scratch      []byte
+	stateful     bool // the protocol is a StatefulProto, all the packed messages must be sent
+}
+
+type batchEntry struct {
+	conn     net.Conn
+	deadline time.Time
+	b        []byte
+	err      error
+	done     chan struct{}
+}
+
+var batchEntryPool = sync.Pool{
+	New: func() interface{} {
+		return &batchEntry{done: make(chan struct{}, 1)}
+	},
+}
+
+func newWriteBatcher(cfg *WriteBatchConfig, capture bool) *writeBatcher {
+	if cfg == nil || !cfg.Enable {
+		if capture {
+			return &writeBatcher{sync: true}
+		}
+		return nil
+	}
+	return &writeBatcher{
+		maxBytes: cfg.MaxBytes,
+		linger:   cfg.Linger,
+		wakeCh:   make(chan struct{}, 1),
+	}
+}
+
+// canBatch returns whether the messages written to the connection can be coalesced.
+func canBatch(conn net.Conn) bool {
+	switch conn.(type) {
+	case nil, *quic.StreamConn: // each write is sent on its own QUIC stream
+		return false
+	default:
+		return true
+	}
+}
+
+// wrapProtoFunc makes the protocol write to the batcher.
+func (b *writeBatcher) wrapProtoFunc(protoFunc ProtoFunc) ProtoFunc {
+	return func(rw IOWithReadBuffer) Proto {
+		s, ok := rw.(socket.UnsafeSocket)
+		if !ok || !canBatch(s.RawLocked()) {
+			return protoFunc(rw)
+		}
+		proto := protoFunc(&batchSocket{UnsafeSocket: s, b: b})
+		if sp, ok := proto.(StatefulProto); ok && sp.Stateful() {
+			b.stateful = true
+		}
+		return proto
+	}
+}
+
+// batchSocket is the socket seen by the protocol, its Write appends to the batch.
+type batchSocket struct {
+	socket.UnsafeSocket
+	b *writeBatcher
+}
+
+// Write appends the packed bytes to the message being packed.
+func (s *batchSocket) Write(p []byte) (int, error) {
+	if e := s.b.cur; e != nil {
+		e.b = append(e.b, p...)
+		return len(p), nil
+	}
+	return s.UnsafeSocket.Write(p)
+}
+
+// begin starts packing a message.
+// NOTE: It must be called with session.writeLock held.
+func (b *writeBatcher) begin(conn net.Conn, deadline time.Time) *batchEntry {
+	e := batchEntryPool.Get().(*batchEntry)
+	e.conn, e.deadline, e.b, e.err = conn, deadline, e.b[:0], nil
+	b.cur = e
+	return e
+}
+
+// end finishes packing the message, and queues it if it is not empty.
+// If hold is true, the message is not sent.
+// It returns false if the message does not need to wait for the flushing.
+// NOTE: It must be called with session.writeLock held.
+func (b *writeBatcher) end(e *batchEntry, err error, hold bool) (bool, error) {
+	b.cur = nil
+	if err != nil || hold || len(e.b) == 0 {
+		putBatchEntry(e)
+		return false, err
+	}
+	if b.sync {
+		b.flushConn([]*batchEntry{e})
+		return false, b.wait(context.Background(), e)
+	}
+	b.mu.Lock()
+	b.pending = append(b.pending, e)
+	b.pendingBytes += len(e.b)
+	if !b.running {
+		b.running = true
+		go b.run()
+	}
+	b.mu.Unlock()
+	select {
+	case b.wakeCh <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
+// wait waits for the message to be flushed, and returns the writing error.
+// NOTE: If ctx is done first, it returns ctx.Err(), and the message may still be sent.
+func (b *writeBatcher) wait(ctx context.Context, e *batchEntry) error {
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		// the entry is left to the writer goroutine, and not reused
+		return ctx.Err()
+	}
+	err := e.err
+	putBatchEntry(e)
+	return err
+}
+
+func putBatchEntry(e *batchEntry) {
+	e.conn, e.err = nil, nil
+	if cap(e.b) > defaultWriteBatchMaxBytes {
+		e.b = nil
+	}
+	batchEntryPool.Put(e)
+}
+
+// run is the writer goroutine.
+func (b *writeBatcher) run() {
+	for {
+		if b.linger > 0 {
+			b.lingerWait()
+		}
+		b.mu.Lock()
+		if len(b.pending) == 0 {
+			b.running = false
+			b.mu.Unlock()
+			return
+		}
+		var size, n int
+		for n < len(b.pending) && (n == 0 || size+len(b.pending[n].b) <= b.maxBytes) {
+			size += len(b.pending[n].b)
+			n++
+		}
+		batch := make([]*batchEntry, n)
+		copy(batch, b.pending)
+		rest := copy(b.pending, b.pending[n:])
+		for i := rest; i < len(b.pending); i++ {
+			b.pending[i] = nil
+		}
+		b.pending = b.pending[:rest]
+		b.pendingBytes -= size
+		b.mu.Unlock()
+		b.flush(batch)
+	}
+}
+
+// lingerWait waits until the pending bytes reach the maximum or the linger time elapses.
+func (b *writeBatcher) lingerWait() {
+	timer := time.NewTimer(b.linger)
+	defer timer.Stop()
+	for {
+		b.mu.Lock()
+		full := b.pendingBytes >= b.maxBytes
+		b.mu.Unlock()
+		if full {
+			return
+		}
+		select {
+		case <-b.wakeCh:
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// flush writes the batch, the consecutive messages of the same connection are written together.
+func (b *writeBatcher) flush(batch []*batchEntry) {
+	for len(batch) > 0 {
+		batch = b.flushConn(batch)
+	}
+}
+
+// flushConn writes the leading messages of the same connection, and returns the rest.
+func (b *writeBatcher) flushConn(batch []*batchEntry) []*batchEntry {
+	conn := batch[0].conn
+	n := 1
+	for n < len(batch) && batch[n].conn == conn {
+		n++
+	}
+	group, rest := batch[:n], batch[n:]
+
+	// the messages out of time fail, and they are not sent unless the protocol is stateful;
+	// the batch is written before the earliest deadline of the others
+	var (
+		now      = time.Now()
+		deadline time.Time
+		bufs     = make(net.Buffers, 0, len(group))
+		live     = make([]*batchEntry, 0, len(group))
+		expired  []*batchEntry
+	)
+	for _, e := range group {
+		if !e.deadline.IsZero() && !e.deadline.After(now) {
+			if b.stateful {
+				bufs = append(bufs, e.b)
+				expired = append(expired, e)
+			} else {
+				b.done(e, os.ErrDeadlineExceeded)
+			}
+			continue
+		}
+		if !e.deadline.IsZero() && (deadline.IsZero() || e.deadline.Before(deadline)) {
+			deadline = e.deadline
+		}
+		bufs = append(bufs, e.b)
+		live = append(live, e)
+	}
+	if len(bufs) == 0 {
+		return rest
+	}
+	if !b.sync {
+		conn.SetWriteDeadline(deadline)
+	}
+	var err error
+	switch conn.(type) {
+	case *net.TCPConn, *net.UnixConn: // writev
+		_, err = bufs.WriteTo(conn)
+	default:
+		if len(bufs) == 1 {
+			_, err = conn.Write(bufs[0])
+			break
+		}
+		b.scratch = b.scratch[:0]
+		for _, buf := range bufs {
+			b.scratch = append(b.scratch, buf...)
+		}
+		_, err = conn.Write(b.scratch)
+		if cap(b.scratch) > b.maxBytes*2 {
+			b.scratch = nil
+		}
+	}
+	for _, e := range live {
+		b.done(e, err)
+	}
+	for _, e := range expired {
+		b.done(e, os.ErrDeadlineExceeded)
+	}
+	return rest
+}
+
+func (b *writeBatcher) done(e *batchEntry, err error) {
+	e.err = err
+	e.done <- struct{}{}
+}
@@ -0,0 +1,154 @@
+package erpc_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+)
+
+type countWritesConn struct {
+	net.Conn
+	writes *int32
+}
+
+func (c *countWritesConn) Write(b []byte) (int, error) {
+	atomic.AddInt32(c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+type countWritesPlugin struct {
+	writes int32
+}
+
+func (p *countWritesPlugin) Name() string {
+	return "count_writes"
+}
+
+func (p *countWritesPlugin) PostDial(sess erpc.PreSession, isRedial bool) *erpc.Status {
+	sess.ModifySocket(func(conn net.Conn) (net.Conn, erpc.ProtoFunc) {
+		return &countWritesConn{Conn: conn, writes: &p.writes}, nil
+	})
+	return nil
+}
+
+func TestWriteBatch(t *testing.T) {
+	const writers, pushes = 20, 50
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		recv = make(map[[2]int]bool)
+	)
+	wg.Add(writers * pushes)
+	srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+	path := srv.RoutePushFunc(func(ctx erpc.PushCtx, arg *[2]int) *erpc.Status {
+		mu.Lock()
+		defer mu.Unlock()
+		if recv[*arg] {
+			t.Errorf("duplicate message: %v", *arg)
+			return nil
+		}
+		recv[*arg] = true
+		wg.Done()
+		return nil
+	})
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+
+	counter := new(countWritesPlugin)
+	cli := erpc.NewPeer(erpc.PeerConfig{
+		Network:    mem.Network,
+		WriteBatch: erpc.WriteBatchConfig{Enable: true, Linger: time.Millisecond},
+	}, counter)
+	defer cli.Close()
+	sess, stat := cli.Dial(addr)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			for j := 0; j < pushes; j++ {
+				if stat := sess.Push(path, [2]int{i, j}); !stat.OK() {
+					t.Error(stat)
+					wg.Done()
+				}
+			}
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 10):
+		t.Fatal("timeout")
+	}
+	writes := atomic.LoadInt32(&counter.writes)
+	t.Logf("%d messages, %d writes", writers*pushes, writes)
+	if writes >= writers*pushes {
+		t.Fatalf("want coalesced writes, got: %d", writes)
+	}
+}
+
+type blockWritesConn struct {
+	net.Conn
+	release chan struct{}
+}
+
+func (c *blockWritesConn) Write(b []byte) (int, error) {
+	<-c.release
+	return c.Conn.Write(b)
+}
+
+type blockWritesPlugin struct {
+	release chan struct{}
+}
+
+func (p *blockWritesPlugin) Name() string {
+	return "block_writes"
+}
+
+func (p *blockWritesPlugin) PostDial(sess erpc.PreSession, isRedial bool) *erpc.Status {
+	sess.ModifySocket(func(conn net.Conn) (net.Conn, erpc.ProtoFunc) {
+		return &blockWritesConn{Conn: conn, release: p.release}, nil
+	})
+	return nil
+}
+
+func TestWriteBatchContext(t *testing.T) {
+	srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+
+	blocker := &blockWritesPlugin{release: make(chan struct{})}
+	cli := erpc.NewPeer(erpc.PeerConfig{
+		Network:     mem.Network,
+		DialTimeout: time.Second * 3,
+		WriteBatch:  erpc.WriteBatchConfig{Enable: true},
+	}, blocker)
+	defer cli.Close()
+	sess, stat := cli.Dial(addr)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	defer close(blocker.release)
+	// the writing is stuck, the message returns when its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	stat = sess.Push("/home/test", "x", erpc.WithContext(ctx))
+	if stat.Code() != erpc.CodeWriteFailed {
+		t.Fatalf("want write failed, got: %v", stat)
+	}
+	if cost := time.Since(start); cost > time.Second {
+		t.Fatalf("want returning at the context deadline, cost: %v", cost)
+	}
+}
@@ -0,0 +1,467 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// cbor codec name and id
+const (
+	NAME_CBOR = "cbor"
+	ID_CBOR   = 'b'
+)
+
+func init() {
+	Reg(new(CBORCodec))
+}
+
+// CBORCodec CBOR(RFC 8949) codec
+// NOTE:
+//
+//	The struct is encoded as a map honoring the `cbor` tags, or the `json` tags if absent;
+//	The time.Time is encoded as the tag 0 date/time string, and decoded from the tag 0 or 1;
+//	The other extension types are registered by RegCBORTag, or decoded as CBORTag;
+//	The indefinite-length items are decoded.
+type CBORCodec struct{}
+
+// Name returns codec name.
+func (CBORCodec) Name() string {
+	return NAME_CBOR
+}
+
+// ID returns codec id.
+func (CBORCodec) ID() byte {
+	return ID_CBOR
+}
+
+// Marshal returns the CBOR encoding of v.
+func (CBORCodec) Marshal(v interface{}) ([]byte, error) {
+	return cborFormat.marshal(v)
+}
+
+// Append appends the CBOR encoding of v to dst,
+// it does not allocate for the structs, slices and common maps if dst has enough capacity.
+func (CBORCodec) Append(dst []byte, v interface{}) ([]byte, error) {
+	return cborFormat.appendValue(dst, v)
+}
+
+// Unmarshal parses the CBOR-encoded data and stores the result
+// in the value pointed to by v.
+func (CBORCodec) Unmarshal(data []byte, v interface{}) error {
+	return cborFormat.unmarshal(&cborReader{data: data}, v)
+}
+
+// CBORTag the raw CBOR tagged item of the unregistered tag number.
+type CBORTag struct {
+	Number  uint64
+	Content interface{}
+}
+
+// RegCBORTag registers the CBOR tag number of the value type, which is tagged on a byte string,
+// and whose pointer must implement encoding.BinaryMarshaler and encoding.BinaryUnmarshaler.
+// NOTE: The tag numbers 0 and 1 are the date/time.
+func RegCBORTag(tag uint64, value interface{}) {
+	if tag == cborTagDateTime || tag == cborTagEpoch {
+		panic("cbor: the tag numbers 0 and 1 are the date/time")
+	}
+	cborFormat.exts.reg(NAME_CBOR, int64(tag), value)
+}
+
+var cborFormat = &binaryFormat{
+	name:   NAME_CBOR,
+	tagKey: NAME_CBOR,
+	newEnc: func() encWriter { return new(cborWriter) },
+}
+
+// cbor major types
+const (
+	cborUint byte = iota << 5
+	cborNegInt
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+const (
+	cborTagDateTime = 0
+	cborTagEpoch    = 1
+	cborIndefinite  = 31
+	cborBreak       = 0xff
+)
+
+var (
+	cborTagType   = reflect.TypeOf(CBORTag{})
+	errCBORShort  = errors.New("cbor: unexpected end of data")
+	errCBORLength = errors.New("cbor: invalid length")
+)
+
+type cborWriter struct {
+	b []byte
+}
+
+func (w *cborWriter) reset(b []byte) { w.b = b }
+func (w *cborWriter) bytes() []byte  { return w.b }
+func (w *cborWriter) writeNil()      { w.b = append(w.b, cborSimple|22) }
+
+func (w *cborWriter) writeBool(v bool) {
+	if v {
+		w.b = append(w.b, cborSimple|21)
+	} else {
+		w.b = append(w.b, cborSimple|20)
+	}
+}
+
+// writeHead writes the head of the major type with the shortest argument.
+func (w *cborWriter) writeHead(major byte, v uint64) {
+	switch {
+	case v < 24:
+		w.b = append(w.b, major|byte(v))
+	case v <= math.MaxUint8:
+		w.b = append(w.b, major|24, byte(v))
+	case v <= math.MaxUint16:
+		w.b = append(w.b, major|25, byte(v>>8), byte(v))
+	case v <= math.MaxUint32:
+		w.b = append(w.b, major|26, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		w.b = append(w.b, major|27, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+func (w *cborWriter) writeInt(v int64) {
+	if v >= 0 {
+		w.writeHead(cborUint, uint64(v))
+	} else {
+		w.writeHead(cborNegInt, uint64(-1-v))
+	}
+}
+
+func (w *cborWriter) writeUint(v uint64) { w.writeHead(cborUint, v) }
+
+func (w *cborWriter) writeFloat32(v float32) {
+	u := math.Float32bits(v)
+	w.b = append(w.b, cborSimple|26, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
+
+func (w *cborWriter) writeFloat64(v float64) {
+	u := math.Float64bits(v)
+	w.b = append(w.b, cborSimple|27, byte(u>>56), byte(u>>48), byte(u>>40), byte(u>>32), byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
+
+func (w *cborWriter) writeString(v string) {
+	w.writeHead(cborText, uint64(len(v)))
+	w.b = append(w.b, v...)
+}
+
+func (w *cborWriter) writeBytes(v []byte) {
+	w.writeHead(cborBytes, uint64(len(v)))
+	w.b = append(w.b, v...)
+}
+
+func (w *cborWriter) writeArrayLen(n int) { w.writeHead(cborArray, uint64(n)) }
+func (w *cborWriter) writeMapLen(n int)   { w.writeHead(cborMap, uint64(n)) }
+
+func (w *cborWriter) writeExt(code int64, data []byte) {
+	w.writeHead(cborTag, uint64(code))
+	w.writeBytes(data)
+}
+
+// writeTime writes the tag 0 RFC3339 date/time string in place.
+func (w *cborWriter) writeTime(t time.Time) {
+	w.writeHead(cborTag, cborTagDateTime)
+	start := len(w.b)
+	w.b = append(w.b, cborText|24, 0)
+	w.b = t.AppendFormat(w.b, time.RFC3339Nano)
+	n := len(w.b) - start - 2
+	if n < 24 {
+		copy(w.b[start+1:], w.b[start+2:])
+		w.b[start] = cborText | byte(n)
+		w.b = w.b[:len(w.b)-1]
+	} else {
+		w.b[start+1] = byte(n)
+	}
+}
+
+func (w *cborWriter) writeSpecial(e *encoder, rv reflect.Value) (bool, error) {
+	if rv.Type() != cborTagType {
+		return false, nil
+	}
+	w.writeHead(cborTag, rv.Field(0).Uint())
+	return true, e.encodeValue(rv.Field(1))
+}
+
+type cborReader struct {
+	data []byte
+	off  int
+}
+
+func (r *cborReader) read(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.off < n {
+		return nil, errCBORShort
+	}
+	b := r.data[r.off : r.off+n]
+	r.off += n
+	return b, nil
+}
+
+// head reads the major type and the argument of the item, indefinite reports the indefinite-length item.
+func (r *cborReader) head() (major, info byte, arg uint64, indefinite bool, err error) {
+	b, err := r.read(1)
+	if err != nil {
+		return
+	}
+	major, info = b[0]&0xe0, b[0]&0x1f
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		b, err = r.read(1 << (info - 24))
+		if err != nil {
+			return
+		}
+		switch len(b) {
+		case 1:
+			arg = uint64(b[0])
+		case 2:
+			arg = uint64(binary.BigEndian.Uint16(b))
+		case 4:
+			arg = uint64(binary.BigEndian.Uint32(b))
+		default:
+			arg = binary.BigEndian.Uint64(b)
+		}
+	case info == cborIndefinite && major != cborUint && major != cborNegInt && major != cborTag:
+		indefinite = true
+	default:
+		err = fmt.Errorf("cbor: invalid additional information %d", info)
+	}
+	return
+}
+
+// length checks that the remaining data are enough for at least min bytes per item.
+func (r *cborReader) length(arg uint64, min int) (int, error) {
+	if arg > uint64(len(r.data)-r.off)/uint64(min) {
+		return 0, errCBORLength
+	}
+	return int(arg), nil
+}
+
+func (r *cborReader) next() (tok token, err error) {
+	major, info, arg, indefinite, err := r.head()
+	if err != nil {
+		return tok, err
+	}
+	switch major {
+	case cborUint:
+		return token{kind: tokUint, u: arg}, nil
+	case cborNegInt:
+		if arg > math.MaxInt64 {
+			return tok, fmt.Errorf("cbor: negative integer -1-%d overflows int64", arg)
+		}
+		return token{kind: tokInt, i: -1 - int64(arg)}, nil
+	case cborBytes, cborText:
+		kind := tokBytes
+		if major == cborText {
+			kind = tokString
+		}
+		if indefinite {
+			s, err := r.chunks(major)
+			return token{kind: kind, s: s}, err
+		}
+		n, err := r.length(arg, 1)
+		if err != nil {
+			return tok, err
+		}
+		s, err := r.read(n)
+		return token{kind: kind, s: s}, err
+	case cborArray, cborMap:
+		kind, min := tokArray, 1
+		if major == cborMap {
+			kind, min = tokMap, 2
+		}
+		if indefinite {
+			return token{kind: kind, n: -1}, nil
+		}
+		n, err := r.length(arg, min)
+		return token{kind: kind, n: n}, err
+	case cborTag:
+		return r.tag(arg)
+	}
+	switch info {
+	case 20, 21:
+		return token{kind: tokBool, b: info == 21}, nil
+	case 22, 23:
+		return token{kind: tokNil}, nil
+	case 25:
+		return token{kind: tokFloat, f: halfToFloat(uint16(arg))}, nil
+	case 26:
+		return token{kind: tokFloat, f: float64(math.Float32frombits(uint32(arg)))}, nil
+	case 27:
+		return token{kind: tokFloat, f: math.Float64frombits(arg)}, nil
+	case cborIndefinite:
+		return tok, errors.New("cbor: unexpected break")
+	}
+	return tok, fmt.Errorf("cbor: unsupported simple value %d", arg)
+}
+
+// chunks concatenates the definite-length chunks of the indefinite-length string.
+func (r *cborReader) chunks(major byte) ([]byte, error) {
+	var s []byte
+	for {
+		if brk, err := r.breakNext(); err != nil || brk {
+			if s == nil {
+				s = []byte{}
+			}
+			return s, err
+		}
+		m, _, arg, indefinite, err := r.head()
+		if err != nil {
+			return nil, err
+		}
+		if m != major || indefinite {
+			return nil, errors.New("cbor: invalid indefinite-length string chunk")
+		}
+		n, err := r.length(arg, 1)
+		if err != nil {
+			return nil, err
+		}
+		chunk, _ := r.read(n)
+		s = append(s, chunk...)
+	}
+}
+
+// tagContent reads the content of the tag decoded by the reader, which is never a tag itself,
+// so that the nested tags cannot recurse without limit.
+func (r *cborReader) tagContent(number uint64) (token, error) {
+	if r.off < len(r.data) && r.data[r.off]&0xe0 == cborTag {
+		return token{}, fmt.Errorf("cbor: the tag %d content is a tag", number)
+	}
+	return r.next()
+}
+
+func (r *cborReader) tag(number uint64) (tok token, err error) {
+	switch number {
+	case cborTagDateTime:
+		content, err := r.tagContent(number)
+		if err != nil {
+			return tok, err
+		}
+		if content.kind != tokString {
+			return tok, errors.New("cbor: the tag 0 content is not a text string")
+		}
+		t, err := time.Parse(time.RFC3339Nano, string(content.s))
+		if err != nil {
+			return tok, fmt.Errorf("cbor: %s", err.Error())
+		}
+		return token{kind: tokTime, t: t}, nil
+	case cborTagEpoch:
+		content, err := r.tagContent(number)
+		if err != nil {
+			return tok, err
+		}
+		switch content.kind {
+		case tokInt:
+			return token{kind: tokTime, t: time.Unix(content.i, 0)}, nil
+		case tokUint:
+			if content.u > math.MaxInt64 {
+				return tok, errors.New("cbor: the tag 1 epoch overflows int64")
+			}
+			return token{kind: tokTime, t: time.Unix(int64(content.u), 0)}, nil
+		case tokFloat:
+			sec, frac := math.Modf(content.f)
+			return token{kind: tokTime, t: time.Unix(int64(sec), int64(frac*1e9))}, nil
+		}
+		return tok, errors.New("cbor: the tag 1 content is not a number")
+	}
+	if number <= math.MaxInt64 {
+		if _, ok := cborFormat.exts.typ(int64(number)); ok {
+			content, err := r.tagContent(number)
+			if err != nil {
+				return tok, err
+			}
+			if content.kind != tokBytes {
+				return tok, fmt.Errorf("cbor: the tag %d content is not a byte string", number)
+			}
+			return token{kind: tokExt, code: int64(number), s: content.s}, nil
+		}
+	}
+	return token{kind: tokTag, code: int64(number)}, nil
+}
+
+func (r *cborReader) breakNext() (bool, error) {
+	if r.off >= len(r.data) {
+		return false, errCBORShort
+	}
+	if r.data[r.off] == cborBreak {
+		r.off++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *cborReader) readSpecial(d *decoder, tok token, rv reflect.Value) (bool, error) {
+	if rv.Type() != cborTagType {
+		return false, nil
+	}
+	tag, err := r.anySpecial(d, tok)
+	if err != nil {
+		return true, err
+	}
+	rv.Set(reflect.ValueOf(tag))
+	return true, nil
+}
+
+func (r *cborReader) anySpecial(d *decoder, tok token) (interface{}, error) {
+	switch tok.kind {
+	case tokTag:
+		content, err := d.nextAny()
+		if err != nil {
+			return nil, err
+		}
+		return CBORTag{Number: uint64(tok.code), Content: content}, nil
+	case tokExt:
+		return CBORTag{Number: uint64(tok.code), Content: append([]byte{}, tok.s...)}, nil
+	}
+	return nil, fmt.Errorf("cbor: unexpected %s", tokenNames[tok.kind])
+}
+
+// halfToFloat converts the IEEE 754 half-precision float.
+func halfToFloat(h uint16) float64 {
+	exp, mant := int(h>>10)&0x1f, float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
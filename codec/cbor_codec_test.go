@@ -0,0 +1,141 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCBOR(t *testing.T) {
+	c := new(CBORCodec)
+	for _, x := range []struct {
+		v   interface{}
+		hex string
+	}{
+		{nil, "f6"},
+		{false, "f4"},
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000000, "1a000f4240"},
+		{uint64(math.MaxUint64), "1bffffffffffffffff"},
+		{-1, "20"},
+		{-1000, "3903e7"},
+		{1.1, "fb3ff199999999999a"},
+		{"IETF", "6449455446"},
+		{[]byte{1, 2, 3, 4}, "4401020304"},
+		{[]interface{}{1, []int{2, 3}}, "8201820203"},
+		{map[string]string{"a": "A"}, "a161616141"},
+		{time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC), "c074323031332d30332d32315432303a30343a30305a"},
+		{CBORTag{Number: 32, Content: "http://www.example.com"}, "d82076687474703a2f2f7777772e6578616d706c652e636f6d"},
+	} {
+		b, err := c.Marshal(x.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(b); got != x.hex {
+			t.Fatalf("Marshal(%#v): got %s, want %s", x.v, got, x.hex)
+		}
+	}
+
+	for _, x := range []struct {
+		hex  string
+		want interface{}
+	}{
+		{"f93c00", 1.0},
+		{"f97bff", 65504.0},
+		{"f90001", 5.960464477539063e-8},
+		{"fa47c35000", 100000.0},
+		{"f7", nil},
+		{"3bffffffffffffffff", nil},
+		{"c11a514b67b0", time.Unix(1363896240, 0)},
+		{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"9f018202039f0405ffff", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+		{"bf61610161629f0203ffff", map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{"d818456449455446", CBORTag{Number: 24, Content: []byte("dIETF")}},
+	} {
+		data, _ := hex.DecodeString(x.hex)
+		var v interface{}
+		err := c.Unmarshal(data, &v)
+		if x.hex == "3bffffffffffffffff" {
+			if err == nil {
+				t.Fatal("expected overflow error")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unmarshal(%s): %v", x.hex, err)
+		}
+		if tm, ok := v.(time.Time); ok {
+			if !tm.Equal(x.want.(time.Time)) {
+				t.Fatalf("Unmarshal(%s): got %v, want %v", x.hex, v, x.want)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(v, x.want) {
+			t.Fatalf("Unmarshal(%s): got %#v, want %#v", x.hex, v, x.want)
+		}
+	}
+
+	var s struct {
+		A string `cbor:"a"`
+	}
+	data, _ := hex.DecodeString("bf61617f6178617960ffff")
+	if err := c.Unmarshal(data, &s); err != nil || s.A != "xy" {
+		t.Fatalf("Unmarshal indefinite struct: %+v, %v", s, err)
+	}
+	testRoundTrip(t, c)
+}
+
+func TestCBORDepth(t *testing.T) {
+	c := new(CBORCodec)
+	for _, head := range []string{"81", "9f", "d820"} {
+		h, _ := hex.DecodeString(head)
+		deep := append(bytes.Repeat(h, maxDecodeDepth+1), 0xf6)
+		var v interface{}
+		if err := c.Unmarshal(deep, &v); err == nil || !strings.Contains(err.Error(), "max depth") {
+			t.Fatalf("%s: unexpected error: %v", head, err)
+		}
+		var a []interface{}
+		if err := c.Unmarshal(deep, &a); err == nil || !strings.Contains(err.Error(), "max depth") {
+			t.Fatalf("%s: unexpected error: %v", head, err)
+		}
+	}
+	var v interface{}
+	if err := c.Unmarshal(append(bytes.Repeat([]byte{0xc1}, maxDecodeDepth+1), 0x00), &v); err == nil {
+		t.Fatal("nested tag 1 decoded")
+	}
+	shallow := append(bytes.Repeat([]byte{0x81}, maxDecodeDepth-1), 0xf6)
+	if err := c.Unmarshal(shallow, &v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func FuzzCBOR(f *testing.F) {
+	c := new(CBORCodec)
+	for _, s := range []string{"f6", "8201820203", "a161616141", "9f0102ff", "c11a514b67b0", "d82076687474703a2f2f7777772e6578616d706c652e636f6d", "5f42010243030405ff"} {
+		b, _ := hex.DecodeString(s)
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v interface{}
+		if err := c.Unmarshal(data, &v); err != nil {
+			return
+		}
+		b, err := c.Marshal(v)
+		if err != nil {
+			return
+		}
+		if err = c.Unmarshal(b, &v); err != nil {
+			t.Fatalf("Unmarshal(Marshal(%#v)): %v", v, err)
+		}
+		var x testRecord
+		c.Unmarshal(data, &x)
+	})
+}
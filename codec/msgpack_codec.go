@@ -0,0 +1,460 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// msgpack codec name and id
+const (
+	NAME_MSGPACK = "msgpack"
+	ID_MSGPACK   = 'm'
+)
+
+func init() {
+	Reg(new(MsgpackCodec))
+}
+
+// MsgpackCodec MessagePack codec
+// NOTE:
+//
+//	The struct is encoded as a map honoring the `msgpack` tags, or the `json` tags if absent;
+//	The time.Time is the timestamp extension type -1;
+//	The other extension types are registered by RegMsgpackExt, or decoded as MsgpackExt.
+type MsgpackCodec struct{}
+
+// Name returns codec name.
+func (MsgpackCodec) Name() string {
+	return NAME_MSGPACK
+}
+
+// ID returns codec id.
+func (MsgpackCodec) ID() byte {
+	return ID_MSGPACK
+}
+
+// Marshal returns the MessagePack encoding of v.
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpackFormat.marshal(v)
+}
+
+// Append appends the MessagePack encoding of v to dst,
+// it does not allocate for the structs, slices and common maps if dst has enough capacity.
+func (MsgpackCodec) Append(dst []byte, v interface{}) ([]byte, error) {
+	return msgpackFormat.appendValue(dst, v)
+}
+
+// Unmarshal parses the MessagePack-encoded data and stores the result
+// in the value pointed to by v.
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpackFormat.unmarshal(&msgpackReader{data: data}, v)
+}
+
+// MsgpackExt the raw MessagePack extension value of the unregistered type.
+type MsgpackExt struct {
+	Type int8
+	Data []byte
+}
+
+// RegMsgpackExt registers the MessagePack extension type code of the value type,
+// whose pointer must implement encoding.BinaryMarshaler and encoding.BinaryUnmarshaler.
+// NOTE: The negative codes are reserved by the specification, and -1 is the timestamp.
+func RegMsgpackExt(typeCode int8, value interface{}) {
+	msgpackFormat.exts.reg(NAME_MSGPACK, int64(typeCode), value)
+}
+
+var msgpackFormat = &binaryFormat{
+	name:   NAME_MSGPACK,
+	tagKey: NAME_MSGPACK,
+	newEnc: func() encWriter { return new(msgpackWriter) },
+}
+
+const msgpackTimestamp = -1
+
+var (
+	msgpackExtType   = reflect.TypeOf(MsgpackExt{})
+	errMsgpackShort  = errors.New("msgpack: unexpected end of data")
+	errMsgpackLength = errors.New("msgpack: invalid length")
+)
+
+type msgpackWriter struct {
+	b []byte
+}
+
+func (w *msgpackWriter) reset(b []byte) { w.b = b }
+func (w *msgpackWriter) bytes() []byte  { return w.b }
+func (w *msgpackWriter) writeNil()      { w.b = append(w.b, 0xc0) }
+
+func (w *msgpackWriter) writeBool(v bool) {
+	if v {
+		w.b = append(w.b, 0xc3)
+	} else {
+		w.b = append(w.b, 0xc2)
+	}
+}
+
+func (w *msgpackWriter) write8(c byte, v uint8) { w.b = append(w.b, c, v) }
+
+func (w *msgpackWriter) write16(c byte, v uint16) {
+	w.b = append(w.b, c, byte(v>>8), byte(v))
+}
+
+func (w *msgpackWriter) write32(c byte, v uint32) {
+	w.b = append(w.b, c, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *msgpackWriter) write64(c byte, v uint64) {
+	w.b = append(w.b, c, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *msgpackWriter) writeInt(v int64) {
+	switch {
+	case v >= 0:
+		w.writeUint(uint64(v))
+	case v >= -32:
+		w.b = append(w.b, byte(v))
+	case v >= math.MinInt8:
+		w.write8(0xd0, uint8(v))
+	case v >= math.MinInt16:
+		w.write16(0xd1, uint16(v))
+	case v >= math.MinInt32:
+		w.write32(0xd2, uint32(v))
+	default:
+		w.write64(0xd3, uint64(v))
+	}
+}
+
+func (w *msgpackWriter) writeUint(v uint64) {
+	switch {
+	case v <= 0x7f:
+		w.b = append(w.b, byte(v))
+	case v <= math.MaxUint8:
+		w.write8(0xcc, uint8(v))
+	case v <= math.MaxUint16:
+		w.write16(0xcd, uint16(v))
+	case v <= math.MaxUint32:
+		w.write32(0xce, uint32(v))
+	default:
+		w.write64(0xcf, v)
+	}
+}
+
+func (w *msgpackWriter) writeFloat32(v float32) { w.write32(0xca, math.Float32bits(v)) }
+func (w *msgpackWriter) writeFloat64(v float64) { w.write64(0xcb, math.Float64bits(v)) }
+
+func (w *msgpackWriter) writeString(v string) {
+	n := len(v)
+	switch {
+	case n < 32:
+		w.b = append(w.b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		w.write8(0xd9, uint8(n))
+	case n <= math.MaxUint16:
+		w.write16(0xda, uint16(n))
+	default:
+		w.write32(0xdb, uint32(n))
+	}
+	w.b = append(w.b, v...)
+}
+
+func (w *msgpackWriter) writeBytes(v []byte) {
+	n := len(v)
+	switch {
+	case n <= math.MaxUint8:
+		w.write8(0xc4, uint8(n))
+	case n <= math.MaxUint16:
+		w.write16(0xc5, uint16(n))
+	default:
+		w.write32(0xc6, uint32(n))
+	}
+	w.b = append(w.b, v...)
+}
+
+func (w *msgpackWriter) writeArrayLen(n int) {
+	switch {
+	case n < 16:
+		w.b = append(w.b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		w.write16(0xdc, uint16(n))
+	default:
+		w.write32(0xdd, uint32(n))
+	}
+}
+
+func (w *msgpackWriter) writeMapLen(n int) {
+	switch {
+	case n < 16:
+		w.b = append(w.b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		w.write16(0xde, uint16(n))
+	default:
+		w.write32(0xdf, uint32(n))
+	}
+}
+
+func (w *msgpackWriter) writeExtHeader(typ int8, n int) {
+	switch n {
+	case 1:
+		w.b = append(w.b, 0xd4, byte(typ))
+	case 2:
+		w.b = append(w.b, 0xd5, byte(typ))
+	case 4:
+		w.b = append(w.b, 0xd6, byte(typ))
+	case 8:
+		w.b = append(w.b, 0xd7, byte(typ))
+	case 16:
+		w.b = append(w.b, 0xd8, byte(typ))
+	default:
+		switch {
+		case n <= math.MaxUint8:
+			w.write8(0xc7, uint8(n))
+		case n <= math.MaxUint16:
+			w.write16(0xc8, uint16(n))
+		default:
+			w.write32(0xc9, uint32(n))
+		}
+		w.b = append(w.b, byte(typ))
+	}
+}
+
+func (w *msgpackWriter) writeExt(code int64, data []byte) {
+	w.writeExtHeader(int8(code), len(data))
+	w.b = append(w.b, data...)
+}
+
+// writeTime writes the timestamp 32, 64 or 96 format.
+func (w *msgpackWriter) writeTime(t time.Time) {
+	sec, nsec := uint64(t.Unix()), uint64(t.Nanosecond())
+	switch {
+	case sec>>34 == 0 && nsec == 0 && sec <= math.MaxUint32:
+		w.writeExtHeader(msgpackTimestamp, 4)
+		w.b = append(w.b, byte(sec>>24), byte(sec>>16), byte(sec>>8), byte(sec))
+	case sec>>34 == 0:
+		v := nsec<<34 | sec
+		w.writeExtHeader(msgpackTimestamp, 8)
+		w.b = append(w.b, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	default:
+		w.writeExtHeader(msgpackTimestamp, 12)
+		w.b = append(w.b, byte(nsec>>24), byte(nsec>>16), byte(nsec>>8), byte(nsec))
+		w.b = append(w.b, byte(sec>>56), byte(sec>>48), byte(sec>>40), byte(sec>>32), byte(sec>>24), byte(sec>>16), byte(sec>>8), byte(sec))
+	}
+}
+
+func (w *msgpackWriter) writeSpecial(_ *encoder, rv reflect.Value) (bool, error) {
+	if rv.Type() != msgpackExtType {
+		return false, nil
+	}
+	w.writeExt(rv.Field(0).Int(), rv.Field(1).Bytes())
+	return true, nil
+}
+
+type msgpackReader struct {
+	data []byte
+	off  int
+}
+
+func (r *msgpackReader) read(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.off < n {
+		return nil, errMsgpackShort
+	}
+	b := r.data[r.off : r.off+n]
+	r.off += n
+	return b, nil
+}
+
+func (r *msgpackReader) readUint(n int) (uint64, error) {
+	b, err := r.read(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+// readLen reads the n-byte length, and checks that the remaining data are enough for at least min bytes per item.
+func (r *msgpackReader) readLen(n, min int) (int, error) {
+	l, err := r.readUint(n)
+	if err != nil {
+		return 0, err
+	}
+	if l > uint64(len(r.data)-r.off)/uint64(min) {
+		return 0, errMsgpackLength
+	}
+	return int(l), nil
+}
+
+func (r *msgpackReader) next() (tok token, err error) {
+	b, err := r.read(1)
+	if err != nil {
+		return tok, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return token{kind: tokUint, u: uint64(c)}, nil
+	case c >= 0xe0:
+		return token{kind: tokInt, i: int64(int8(c))}, nil
+	case c&0xf0 == 0x80:
+		return r.container(tokMap, int(c&0x0f), 2)
+	case c&0xf0 == 0x90:
+		return r.container(tokArray, int(c&0x0f), 1)
+	case c&0xe0 == 0xa0:
+		return r.str(tokString, int(c&0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return token{kind: tokNil}, nil
+	case 0xc2, 0xc3:
+		return token{kind: tokBool, b: c == 0xc3}, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := r.readLen(1<<(c-0xc4), 1)
+		if err != nil {
+			return tok, err
+		}
+		return r.str(tokBytes, n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := r.readLen(1<<(c-0xc7), 1)
+		if err != nil {
+			return tok, err
+		}
+		return r.ext(n)
+	case 0xca:
+		u, err := r.readUint(4)
+		return token{kind: tokFloat, f: float64(math.Float32frombits(uint32(u)))}, err
+	case 0xcb:
+		u, err := r.readUint(8)
+		return token{kind: tokFloat, f: math.Float64frombits(u)}, err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := r.readUint(1 << (c - 0xcc))
+		return token{kind: tokUint, u: u}, err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		u, err := r.readUint(1 << (c - 0xd0))
+		var i int64
+		switch c {
+		case 0xd0:
+			i = int64(int8(u))
+		case 0xd1:
+			i = int64(int16(u))
+		case 0xd2:
+			i = int64(int32(u))
+		default:
+			i = int64(u)
+		}
+		if i >= 0 {
+			return token{kind: tokUint, u: uint64(i)}, err
+		}
+		return token{kind: tokInt, i: i}, err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return r.ext(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.readLen(1<<(c-0xd9), 1)
+		if err != nil {
+			return tok, err
+		}
+		return r.str(tokString, n)
+	case 0xdc, 0xdd:
+		n, err := r.readLen(2<<(c-0xdc), 1)
+		if err != nil {
+			return tok, err
+		}
+		return token{kind: tokArray, n: n}, nil
+	case 0xde, 0xdf:
+		n, err := r.readLen(2<<(c-0xde), 2)
+		if err != nil {
+			return tok, err
+		}
+		return token{kind: tokMap, n: n}, nil
+	}
+	return tok, fmt.Errorf("msgpack: invalid code 0x%x", c)
+}
+
+func (r *msgpackReader) container(kind tokenKind, n, min int) (token, error) {
+	if n*min > len(r.data)-r.off {
+		return token{}, errMsgpackLength
+	}
+	return token{kind: kind, n: n}, nil
+}
+
+func (r *msgpackReader) str(kind tokenKind, n int) (token, error) {
+	s, err := r.read(n)
+	return token{kind: kind, s: s}, err
+}
+
+func (r *msgpackReader) ext(n int) (tok token, err error) {
+	b, err := r.read(1)
+	if err != nil {
+		return tok, err
+	}
+	typ := int8(b[0])
+	data, err := r.read(n)
+	if err != nil {
+		return tok, err
+	}
+	if typ != msgpackTimestamp {
+		return token{kind: tokExt, code: int64(typ), s: data}, nil
+	}
+	var sec, nsec uint64
+	switch n {
+	case 4:
+		sec = uint64(binary.BigEndian.Uint32(data))
+	case 8:
+		v := binary.BigEndian.Uint64(data)
+		sec, nsec = v&(1<<34-1), v>>34
+	case 12:
+		nsec, sec = uint64(binary.BigEndian.Uint32(data)), binary.BigEndian.Uint64(data[4:])
+	default:
+		return tok, fmt.Errorf("msgpack: invalid timestamp length %d", n)
+	}
+	if nsec >= 1e9 {
+		return tok, errors.New("msgpack: invalid timestamp nanoseconds")
+	}
+	return token{kind: tokTime, t: time.Unix(int64(sec), int64(nsec))}, nil
+}
+
+func (r *msgpackReader) breakNext() (bool, error) {
+	return false, nil
+}
+
+func (r *msgpackReader) readSpecial(d *decoder, tok token, rv reflect.Value) (bool, error) {
+	if rv.Type() != msgpackExtType {
+		return false, nil
+	}
+	if tok.kind != tokExt {
+		return true, d.mismatch(tok, msgpackExtType)
+	}
+	rv.Set(reflect.ValueOf(MsgpackExt{Type: int8(tok.code), Data: append([]byte{}, tok.s...)}))
+	return true, nil
+}
+
+func (r *msgpackReader) anySpecial(d *decoder, tok token) (interface{}, error) {
+	if tok.kind == tokExt {
+		return MsgpackExt{Type: int8(tok.code), Data: append([]byte{}, tok.s...)}, nil
+	}
+	return nil, fmt.Errorf("msgpack: unexpected %s", tokenNames[tok.kind])
+}
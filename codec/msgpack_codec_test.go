@@ -0,0 +1,234 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPoint the extension type registered by the binary codec tests.
+type testPoint struct {
+	X, Y int8
+}
+
+func (p testPoint) MarshalBinary() ([]byte, error) {
+	return []byte{byte(p.X), byte(p.Y)}, nil
+}
+
+func (p *testPoint) UnmarshalBinary(b []byte) error {
+	if len(b) != 2 {
+		return errors.New("invalid point")
+	}
+	p.X, p.Y = int8(b[0]), int8(b[1])
+	return nil
+}
+
+func init() {
+	RegMsgpackExt(7, testPoint{})
+	RegCBORTag(40000, testPoint{})
+}
+
+type testBase struct {
+	ID   int64  `json:"id"`
+	Kind string `json:"kind,omitempty"`
+}
+
+type testRecord struct {
+	testBase
+	Name    string                 `json:"name"`
+	Alias   string                 `msgpack:"alias" cbor:"alias" json:"nick"`
+	Skip    string                 `json:"-"`
+	Empty   []int                  `json:"empty,omitempty"`
+	Tags    []string               `json:"tags"`
+	Scores  map[string]float64     `json:"scores"`
+	Meta    map[string]interface{} `json:"meta"`
+	Created time.Time              `json:"created"`
+	Point   testPoint              `json:"point"`
+	Data    []byte                 `json:"data"`
+	Ptr     *uint16                `json:"ptr"`
+	Fixed   [2]int32               `json:"fixed"`
+}
+
+type testFlat struct {
+	testBase
+	Name    string                 `json:"name"`
+	Tags    []string               `json:"tags"`
+	Meta    map[string]interface{} `json:"meta"`
+	Created time.Time              `json:"created"`
+	Data    []byte                 `json:"data"`
+	Ptr     *uint16                `json:"ptr"`
+}
+
+func newTestRecord() *testRecord {
+	u := uint16(65535)
+	return &testRecord{
+		testBase: testBase{ID: -1 << 40},
+		Name:     "erpc",
+		Alias:    "rpc",
+		Skip:     "skip",
+		Tags:     []string{"a", strings.Repeat("b", 40)},
+		Scores:   map[string]float64{"x": 1.5},
+		Meta:     map[string]interface{}{"k": "v"},
+		Created:  time.Date(2019, 1, 2, 3, 4, 5, 6, time.UTC),
+		Point:    testPoint{X: 1, Y: -2},
+		Data:     []byte{0, 1, 2},
+		Ptr:      &u,
+		Fixed:    [2]int32{math.MinInt32, math.MaxInt32},
+	}
+}
+
+func testRoundTrip(t *testing.T, c interface {
+	Marshal(interface{}) ([]byte, error)
+	Unmarshal([]byte, interface{}) error
+	Append([]byte, interface{}) ([]byte, error)
+}) {
+	src := newTestRecord()
+	b, err := c.Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dst testRecord
+	if err = c.Unmarshal(b, &dst); err != nil {
+		t.Fatal(err)
+	}
+	src.Skip = ""
+	if !dst.Created.Equal(src.Created) {
+		t.Fatalf("time: got %v, want %v", dst.Created, src.Created)
+	}
+	dst.Created = src.Created
+	if !reflect.DeepEqual(&dst, src) {
+		t.Fatalf("got %+v, want %+v", dst, *src)
+	}
+
+	var m map[string]interface{}
+	if err = c.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"id", "name", "alias", "tags", "created", "point"} {
+		if _, ok := m[key]; !ok {
+			t.Fatalf("missing key %q: %v", key, m)
+		}
+	}
+	for _, key := range []string{"kind", "Skip", "empty", "nick"} {
+		if _, ok := m[key]; ok {
+			t.Fatalf("unexpected key %q: %v", key, m)
+		}
+	}
+	if p, ok := m["point"].(testPoint); !ok || p != src.Point {
+		t.Fatalf("point: %#v", m["point"])
+	}
+
+	// NOTE: The extension types and the reflected maps are not allocation free.
+	flat := &testFlat{testBase: src.testBase, Name: src.Name, Tags: src.Tags, Meta: src.Meta, Created: src.Created, Data: src.Data, Ptr: src.Ptr}
+	b, _ = c.Marshal(flat)
+	buf := make([]byte, 0, 1024)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = c.Append(buf[:0], flat)
+	})
+	if allocs != 0 {
+		t.Fatalf("Append allocs: %v", allocs)
+	}
+	if !bytes.Equal(buf, b) {
+		t.Fatalf("Append: %x, Marshal: %x", buf, b)
+	}
+}
+
+func TestMsgpack(t *testing.T) {
+	c := new(MsgpackCodec)
+	for _, x := range []struct {
+		v   interface{}
+		hex string
+	}{
+		{nil, "c0"},
+		{true, "c3"},
+		{0, "00"},
+		{127, "7f"},
+		{128, "cc80"},
+		{-1, "ff"},
+		{-32, "e0"},
+		{-33, "d0df"},
+		{65536, "ce00010000"},
+		{int64(math.MinInt64), "d38000000000000000"},
+		{1.5, "cb3ff8000000000000"},
+		{float32(1.5), "ca3fc00000"},
+		{"a", "a161"},
+		{[]byte{1}, "c40101"},
+		{[]int{1, 2}, "920102"},
+		{map[string]int{"a": 1}, "81a16101"},
+		{time.Unix(1, 0), "d6ff00000001"},
+		{time.Unix(1, 1), "d7ff0000000400000001"},
+		{time.Unix(-1, 0), "c70cff00000000ffffffffffffffff"},
+		{MsgpackExt{Type: 5, Data: []byte{1, 2, 3}}, "c70305010203"},
+	} {
+		b, err := c.Marshal(x.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(b); got != x.hex {
+			t.Fatalf("Marshal(%#v): got %s, want %s", x.v, got, x.hex)
+		}
+	}
+
+	var ext interface{}
+	if err := c.Unmarshal([]byte{0xd4, 0x05, 0x09}, &ext); err != nil || !reflect.DeepEqual(ext, MsgpackExt{Type: 5, Data: []byte{9}}) {
+		t.Fatalf("Unmarshal ext: %#v, %v", ext, err)
+	}
+	var i8 int8
+	if err := c.Unmarshal([]byte{0xcc, 0xff}, &i8); err == nil {
+		t.Fatal("expected overflow error")
+	}
+	var s []int
+	if err := c.Unmarshal([]byte{0xdd, 0xff, 0xff, 0xff, 0xff}, &s); err == nil {
+		t.Fatal("expected length error")
+	}
+	testRoundTrip(t, c)
+}
+
+func TestMsgpackDepth(t *testing.T) {
+	c := new(MsgpackCodec)
+	deep := append(bytes.Repeat([]byte{0x91}, maxDecodeDepth+1), 0xc0)
+	var v interface{}
+	if err := c.Unmarshal(deep, &v); err == nil || !strings.Contains(err.Error(), "max depth") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var a []interface{}
+	if err := c.Unmarshal(deep, &a); err == nil || !strings.Contains(err.Error(), "max depth") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var s struct{ A int }
+	if err := c.Unmarshal(append([]byte{0x81, 0xa1, 'B'}, deep...), &s); err == nil || !strings.Contains(err.Error(), "max depth") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	shallow := append(bytes.Repeat([]byte{0x91}, maxDecodeDepth-1), 0xc0)
+	if err := c.Unmarshal(shallow, &v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func FuzzMsgpack(f *testing.F) {
+	c := new(MsgpackCodec)
+	for _, s := range []string{"c0", "920102", "81a16101", "d6ff00000001", "c70305010203", "d70701ff", "9191c0"} {
+		b, _ := hex.DecodeString(s)
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v interface{}
+		if err := c.Unmarshal(data, &v); err != nil {
+			return
+		}
+		b, err := c.Marshal(v)
+		if err != nil {
+			return
+		}
+		if err = c.Unmarshal(b, &v); err != nil {
+			t.Fatalf("Unmarshal(Marshal(%#v)): %v", v, err)
+		}
+		var x testRecord
+		c.Unmarshal(data, &x)
+	})
+}
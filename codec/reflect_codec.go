@@ -0,0 +1,1131 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The reflection-based encoder and decoder shared by the schema-less binary codecs, MessagePack and CBOR.
+// The values are encoded as encoding/json does:
+//   - The struct is a map keyed by the field names, honoring the struct tags of the codec name,
+//     or the `json` tags if absent, with the options "-" and "omitempty";
+//   - The embedded struct fields are promoted;
+//   - The []byte is the binary, the time.Time and the registered extension types are the extensions of the format.
+
+// binaryFormat the format of a schema-less binary codec.
+type binaryFormat struct {
+	name    string
+	tagKey  string
+	fields  sync.Map // reflect.Type -> *structFields
+	exts    extRegistry
+	encPool sync.Pool
+	newEnc  func() encWriter
+}
+
+// encWriter appends the items of a format.
+type encWriter interface {
+	reset(b []byte)
+	bytes() []byte
+	writeNil()
+	writeBool(bool)
+	writeInt(int64)
+	writeUint(uint64)
+	writeFloat32(float32)
+	writeFloat64(float64)
+	writeString(string)
+	writeBytes([]byte)
+	writeArrayLen(int)
+	writeMapLen(int)
+	writeTime(time.Time)
+	writeExt(code int64, data []byte)
+	// writeSpecial writes the raw extension types of the format, e.g. MsgpackExt and CBORTag.
+	writeSpecial(e *encoder, rv reflect.Value) (handled bool, err error)
+}
+
+// tokenKind the kind of the decoded item header.
+type tokenKind uint8
+
+const (
+	tokNil    tokenKind = iota
+	tokBool             // b
+	tokInt              // i, negative
+	tokUint             // u
+	tokFloat            // f
+	tokString           // s
+	tokBytes            // s
+	tokArray            // n, -1 for the indefinite length
+	tokMap              // n, -1 for the indefinite length
+	tokTime             // t
+	tokExt              // code, s
+	tokTag              // code, the tagged item follows
+)
+
+var tokenNames = [...]string{"nil", "bool", "int", "uint", "float", "string", "bytes", "array", "map", "time", "ext", "tag"}
+
+// token the header of a decoded item, the data of the string, bytes and ext refer to the input.
+type token struct {
+	kind tokenKind
+	b    bool
+	i    int64
+	u    uint64
+	f    float64
+	s    []byte
+	n    int
+	code int64
+	t    time.Time
+}
+
+// decReader reads the items of a format.
+type decReader interface {
+	next() (token, error)
+	// breakNext consumes the break of the indefinite-length item if it is next.
+	breakNext() (bool, error)
+	// readSpecial decodes the raw extension types of the format, e.g. MsgpackExt and CBORTag.
+	readSpecial(d *decoder, tok token, rv reflect.Value) (handled bool, err error)
+	// anySpecial returns the raw extension value of the unregistered ext or tag.
+	anySpecial(d *decoder, tok token) (interface{}, error)
+}
+
+// appendValue appends the encoding of v to dst.
+func (f *binaryFormat) appendValue(dst []byte, v interface{}) ([]byte, error) {
+	e, _ := f.encPool.Get().(*encoder)
+	if e == nil {
+		e = &encoder{f: f, w: f.newEnc()}
+	}
+	e.w.reset(dst)
+	err := e.encode(v)
+	b := e.w.bytes()
+	e.w.reset(nil)
+	f.encPool.Put(e)
+	return b, err
+}
+
+var bufPool = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+func (f *binaryFormat) marshal(v interface{}) ([]byte, error) {
+	buf := bufPool.Get().(*[]byte)
+	b, err := f.appendValue((*buf)[:0], v)
+	var r []byte
+	if err == nil {
+		r = make([]byte, len(b))
+		copy(r, b)
+	}
+	*buf = b
+	bufPool.Put(buf)
+	return r, err
+}
+
+func (f *binaryFormat) unmarshal(r decReader, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("%s: Unmarshal(non-pointer %T)", f.name, v)
+	}
+	d := &decoder{f: f, r: r}
+	return d.decode(rv.Elem())
+}
+
+// encoder the reflection-based encoder.
+type encoder struct {
+	f *binaryFormat
+	w encWriter
+}
+
+func (e *encoder) encode(v interface{}) error {
+	// the fast paths of the common types
+	switch x := v.(type) {
+	case nil:
+		e.w.writeNil()
+	case string:
+		e.w.writeString(x)
+	case []byte:
+		if x == nil {
+			e.w.writeNil()
+		} else {
+			e.w.writeBytes(x)
+		}
+	case bool:
+		e.w.writeBool(x)
+	case int:
+		e.w.writeInt(int64(x))
+	case int64:
+		e.w.writeInt(x)
+	case int32:
+		e.w.writeInt(int64(x))
+	case uint64:
+		e.w.writeUint(x)
+	case float64:
+		e.w.writeFloat64(x)
+	case float32:
+		e.w.writeFloat32(x)
+	case time.Time:
+		e.w.writeTime(x)
+	case *time.Time:
+		if x == nil {
+			e.w.writeNil()
+		} else {
+			e.w.writeTime(*x)
+		}
+	case []interface{}:
+		if x == nil {
+			e.w.writeNil()
+			return nil
+		}
+		e.w.writeArrayLen(len(x))
+		for _, elem := range x {
+			if err := e.encode(elem); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if x == nil {
+			e.w.writeNil()
+			return nil
+		}
+		e.w.writeMapLen(len(x))
+		for k, elem := range x {
+			e.w.writeString(k)
+			if err := e.encode(elem); err != nil {
+				return err
+			}
+		}
+	case map[string]string:
+		if x == nil {
+			e.w.writeNil()
+			return nil
+		}
+		e.w.writeMapLen(len(x))
+		for k, elem := range x {
+			e.w.writeString(k)
+			e.w.writeString(elem)
+		}
+	default:
+		return e.encodeValue(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+var (
+	timeType               = reflect.TypeOf(time.Time{})
+	mapStringInterfaceType = reflect.TypeOf(map[string]interface{}(nil))
+	mapStringStringType    = reflect.TypeOf(map[string]string(nil))
+	binaryMarshalerType    = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+)
+
+func (e *encoder) encodeValue(rv reflect.Value) error {
+	if !rv.IsValid() {
+		e.w.writeNil()
+		return nil
+	}
+	t := rv.Type()
+	if t == timeType {
+		if rv.CanAddr() {
+			e.w.writeTime(*rv.Addr().Interface().(*time.Time))
+		} else {
+			e.w.writeTime(rv.Interface().(time.Time))
+		}
+		return nil
+	}
+	if code, ok := e.f.exts.code(t); ok {
+		return e.encodeExt(code, rv)
+	}
+	if handled, err := e.w.writeSpecial(e, rv); handled {
+		return err
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		e.w.writeBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.w.writeInt(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.w.writeUint(rv.Uint())
+	case reflect.Float32:
+		e.w.writeFloat32(float32(rv.Float()))
+	case reflect.Float64:
+		e.w.writeFloat64(rv.Float())
+	case reflect.String:
+		e.w.writeString(rv.String())
+	case reflect.Slice:
+		if rv.IsNil() {
+			e.w.writeNil()
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			e.w.writeBytes(rv.Bytes())
+			return nil
+		}
+		return e.encodeArray(rv)
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			if rv.CanAddr() {
+				e.w.writeBytes(rv.Slice(0, rv.Len()).Bytes())
+			} else {
+				b := make([]byte, rv.Len())
+				reflect.Copy(reflect.ValueOf(b), rv)
+				e.w.writeBytes(b)
+			}
+			return nil
+		}
+		return e.encodeArray(rv)
+	case reflect.Map:
+		if rv.IsNil() {
+			e.w.writeNil()
+			return nil
+		}
+		if (t == mapStringInterfaceType || t == mapStringStringType) && rv.CanInterface() {
+			// NOTE: The map interface does not allocate.
+			return e.encode(rv.Interface())
+		}
+		e.w.writeMapLen(rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			if err := e.encodeValue(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.encodeValue(iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		return e.encodeStruct(rv)
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			e.w.writeNil()
+			return nil
+		}
+		return e.encodeValue(rv.Elem())
+	default:
+		return fmt.Errorf("%s: unsupported type: %s", e.f.name, t)
+	}
+	return nil
+}
+
+func (e *encoder) encodeArray(rv reflect.Value) error {
+	n := rv.Len()
+	e.w.writeArrayLen(n)
+	for i := 0; i < n; i++ {
+		if err := e.encodeValue(rv.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) encodeStruct(rv reflect.Value) error {
+	fields := e.f.cachedFields(rv.Type())
+	n := 0
+	for i := range fields.list {
+		if fv, ok := fields.list[i].value(rv); ok && !(fields.list[i].omitEmpty && isEmptyValue(fv)) {
+			n++
+		}
+	}
+	e.w.writeMapLen(n)
+	for i := range fields.list {
+		fd := &fields.list[i]
+		fv, ok := fd.value(rv)
+		if !ok || (fd.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		e.w.writeString(fd.name)
+		if err := e.encodeValue(fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) encodeExt(code int64, rv reflect.Value) error {
+	var m encoding.BinaryMarshaler
+	switch {
+	case rv.Type().Implements(binaryMarshalerType):
+		m = rv.Interface().(encoding.BinaryMarshaler)
+	case rv.CanAddr():
+		m = rv.Addr().Interface().(encoding.BinaryMarshaler)
+	default:
+		ptr := reflect.New(rv.Type())
+		ptr.Elem().Set(rv)
+		m = ptr.Interface().(encoding.BinaryMarshaler)
+	}
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("%s: marshal the extension %s: %s", e.f.name, rv.Type(), err.Error())
+	}
+	e.w.writeExt(code, data)
+	return nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// maxDecodeDepth the maximum nesting depth of the decoded items,
+// so that the crafted input cannot exhaust the stack.
+const maxDecodeDepth = 10000
+
+// decoder the reflection-based decoder.
+type decoder struct {
+	f     *binaryFormat
+	r     decReader
+	depth int
+}
+
+// enter increases the nesting depth, the caller decreases it when the item is decoded.
+func (d *decoder) enter() error {
+	if d.depth++; d.depth > maxDecodeDepth {
+		return fmt.Errorf("%s: exceeded max depth %d", d.f.name, maxDecodeDepth)
+	}
+	return nil
+}
+
+func (d *decoder) decode(rv reflect.Value) error {
+	tok, err := d.r.next()
+	if err != nil {
+		return err
+	}
+	if err = d.enter(); err != nil {
+		return err
+	}
+	err = d.decodeToken(tok, rv)
+	d.depth--
+	return err
+}
+
+func (d *decoder) mismatch(tok token, t reflect.Type) error {
+	return fmt.Errorf("%s: cannot decode %s into %s", d.f.name, tokenNames[tok.kind], t)
+}
+
+func (d *decoder) decodeToken(tok token, rv reflect.Value) error {
+	if tok.kind == tokNil {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+	t := rv.Type()
+	switch t.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(t.Elem()))
+		}
+		return d.decodeToken(tok, rv.Elem())
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			v, err := d.decodeAny(tok)
+			if err != nil {
+				return err
+			}
+			if v == nil {
+				rv.Set(reflect.Zero(t))
+			} else {
+				rv.Set(reflect.ValueOf(v))
+			}
+			return nil
+		}
+		if !rv.IsNil() && rv.Elem().Kind() == reflect.Ptr {
+			return d.decodeToken(tok, rv.Elem())
+		}
+		return d.mismatch(tok, t)
+	}
+	if t == timeType {
+		return d.decodeTime(tok, rv)
+	}
+	if code, ok := d.f.exts.code(t); ok {
+		if tok.kind != tokExt || tok.code != code {
+			return d.mismatch(tok, t)
+		}
+		return d.decodeExt(tok, rv)
+	}
+	if handled, err := d.r.readSpecial(d, tok, rv); handled {
+		return err
+	}
+	switch tok.kind {
+	case tokBool:
+		if t.Kind() != reflect.Bool {
+			return d.mismatch(tok, t)
+		}
+		rv.SetBool(tok.b)
+	case tokInt, tokUint, tokFloat:
+		return d.decodeNumber(tok, rv)
+	case tokString, tokBytes:
+		switch {
+		case t.Kind() == reflect.String:
+			rv.SetString(string(tok.s))
+		case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+			b := rv.Bytes()
+			if b == nil || cap(b) < len(tok.s) {
+				b = make([]byte, len(tok.s))
+			}
+			b = b[:len(tok.s)]
+			copy(b, tok.s)
+			rv.SetBytes(b)
+		case t.Kind() == reflect.Array && t.Elem().Kind() == reflect.Uint8:
+			if len(tok.s) != t.Len() {
+				return fmt.Errorf("%s: cannot decode %d bytes into %s", d.f.name, len(tok.s), t)
+			}
+			reflect.Copy(rv, reflect.ValueOf(tok.s))
+		default:
+			return d.mismatch(tok, t)
+		}
+	case tokArray:
+		return d.decodeArray(tok, rv)
+	case tokMap:
+		switch t.Kind() {
+		case reflect.Map:
+			return d.decodeMap(tok, rv)
+		case reflect.Struct:
+			return d.decodeStruct(tok, rv)
+		}
+		return d.mismatch(tok, t)
+	case tokTag:
+		// the unknown tag is ignored
+		return d.decode(rv)
+	default:
+		return d.mismatch(tok, t)
+	}
+	return nil
+}
+
+func (d *decoder) decodeNumber(tok token, rv reflect.Value) error {
+	t := rv.Type()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch tok.kind {
+		case tokInt:
+			i = tok.i
+		case tokUint:
+			if tok.u > math.MaxInt64 {
+				return d.overflow(tok, t)
+			}
+			i = int64(tok.u)
+		default:
+			if tok.f != math.Trunc(tok.f) || tok.f < math.MinInt64 || tok.f >= math.MaxInt64 {
+				return d.mismatch(tok, t)
+			}
+			i = int64(tok.f)
+		}
+		if rv.OverflowInt(i) {
+			return d.overflow(tok, t)
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch tok.kind {
+		case tokUint:
+			u = tok.u
+		case tokInt:
+			return d.overflow(tok, t)
+		default:
+			if tok.f != math.Trunc(tok.f) || tok.f < 0 || tok.f >= math.MaxUint64 {
+				return d.mismatch(tok, t)
+			}
+			u = uint64(tok.f)
+		}
+		if rv.OverflowUint(u) {
+			return d.overflow(tok, t)
+		}
+		rv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		switch tok.kind {
+		case tokInt:
+			rv.SetFloat(float64(tok.i))
+		case tokUint:
+			rv.SetFloat(float64(tok.u))
+		default:
+			rv.SetFloat(tok.f)
+		}
+	default:
+		return d.mismatch(tok, t)
+	}
+	return nil
+}
+
+func (d *decoder) overflow(tok token, t reflect.Type) error {
+	switch tok.kind {
+	case tokInt:
+		return fmt.Errorf("%s: %d overflows %s", d.f.name, tok.i, t)
+	default:
+		return fmt.Errorf("%s: %d overflows %s", d.f.name, tok.u, t)
+	}
+}
+
+func (d *decoder) decodeTime(tok token, rv reflect.Value) error {
+	var tm time.Time
+	switch tok.kind {
+	case tokTime:
+		tm = tok.t
+	case tokString:
+		var err error
+		if tm, err = time.Parse(time.RFC3339Nano, string(tok.s)); err != nil {
+			return fmt.Errorf("%s: %s", d.f.name, err.Error())
+		}
+	case tokInt:
+		tm = time.Unix(tok.i, 0)
+	case tokUint:
+		if tok.u > math.MaxInt64 {
+			return d.overflow(tok, timeType)
+		}
+		tm = time.Unix(int64(tok.u), 0)
+	case tokFloat:
+		sec, frac := math.Modf(tok.f)
+		tm = time.Unix(int64(sec), int64(frac*1e9))
+	default:
+		return d.mismatch(tok, timeType)
+	}
+	rv.Set(reflect.ValueOf(tm))
+	return nil
+}
+
+func (d *decoder) decodeExt(tok token, rv reflect.Value) error {
+	ptr := reflect.New(rv.Type())
+	if err := ptr.Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(tok.s); err != nil {
+		return fmt.Errorf("%s: unmarshal the extension %s: %s", d.f.name, rv.Type(), err.Error())
+	}
+	rv.Set(ptr.Elem())
+	return nil
+}
+
+// more reports whether the i-th element of the array or map of length n follows.
+func (d *decoder) more(n, i int) (bool, error) {
+	if n >= 0 {
+		return i < n, nil
+	}
+	brk, err := d.r.breakNext()
+	return !brk, err
+}
+
+func (d *decoder) decodeArray(tok token, rv reflect.Value) error {
+	t := rv.Type()
+	switch t.Kind() {
+	case reflect.Slice:
+		if tok.n >= 0 && (rv.IsNil() || rv.Cap() < tok.n) {
+			rv.Set(reflect.MakeSlice(t, 0, tok.n))
+		}
+		rv.SetLen(0)
+		for i := 0; ; i++ {
+			ok, err := d.more(tok.n, i)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			if i >= rv.Cap() {
+				rv.Set(reflect.Append(rv, reflect.Zero(t.Elem())))
+			} else {
+				rv.SetLen(i + 1)
+				rv.Index(i).Set(reflect.Zero(t.Elem()))
+			}
+			if err = d.decode(rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.MakeSlice(t, 0, 0))
+		}
+	case reflect.Array:
+		for i := 0; ; i++ {
+			ok, err := d.more(tok.n, i)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				for ; i < rv.Len(); i++ {
+					rv.Index(i).Set(reflect.Zero(t.Elem()))
+				}
+				break
+			}
+			if i < rv.Len() {
+				err = d.decode(rv.Index(i))
+			} else {
+				err = d.skip()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return d.mismatch(tok, t)
+	}
+	return nil
+}
+
+func (d *decoder) decodeMap(tok token, rv reflect.Value) error {
+	t := rv.Type()
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMap(t))
+	}
+	for i := 0; ; i++ {
+		ok, err := d.more(tok.n, i)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		k := reflect.New(t.Key()).Elem()
+		if err = d.decode(k); err != nil {
+			return err
+		}
+		v := reflect.New(t.Elem()).Elem()
+		if err = d.decode(v); err != nil {
+			return err
+		}
+		rv.SetMapIndex(k, v)
+	}
+}
+
+func (d *decoder) decodeStruct(tok token, rv reflect.Value) error {
+	fields := d.f.cachedFields(rv.Type())
+	for i := 0; ; i++ {
+		ok, err := d.more(tok.n, i)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		key, err := d.r.next()
+		if err != nil {
+			return err
+		}
+		if key.kind != tokString && key.kind != tokBytes {
+			if err = d.skipToken(key); err == nil {
+				err = d.skip()
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		fd := fields.lookup(key.s)
+		if fd == nil {
+			if err = d.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = d.decode(fd.alloc(rv)); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *decoder) skip() error {
+	tok, err := d.r.next()
+	if err != nil {
+		return err
+	}
+	if err = d.enter(); err != nil {
+		return err
+	}
+	err = d.skipToken(tok)
+	d.depth--
+	return err
+}
+
+func (d *decoder) skipToken(tok token) error {
+	switch tok.kind {
+	case tokArray, tokMap:
+		items := 1
+		if tok.kind == tokMap {
+			items = 2
+		}
+		for i := 0; ; i++ {
+			ok, err := d.more(tok.n, i)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+			for j := 0; j < items; j++ {
+				if err = d.skip(); err != nil {
+					return err
+				}
+			}
+		}
+	case tokTag:
+		return d.skip()
+	}
+	return nil
+}
+
+// decodeAny decodes the item as encoding/json does into interface{},
+// the map is map[string]interface{}, or map[interface{}]interface{} if it has any non-string key.
+func (d *decoder) decodeAny(tok token) (interface{}, error) {
+	switch tok.kind {
+	case tokNil:
+		return nil, nil
+	case tokBool:
+		return tok.b, nil
+	case tokInt:
+		return tok.i, nil
+	case tokUint:
+		if tok.u <= math.MaxInt64 {
+			return int64(tok.u), nil
+		}
+		return tok.u, nil
+	case tokFloat:
+		return tok.f, nil
+	case tokString:
+		return string(tok.s), nil
+	case tokBytes:
+		return append([]byte{}, tok.s...), nil
+	case tokTime:
+		return tok.t, nil
+	case tokArray:
+		a := make([]interface{}, 0, maxInt(tok.n, 0))
+		for i := 0; ; i++ {
+			ok, err := d.more(tok.n, i)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return a, nil
+			}
+			elem, err := d.nextAny()
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, elem)
+		}
+	case tokMap:
+		return d.decodeAnyMap(tok)
+	case tokExt:
+		if t, ok := d.f.exts.typ(tok.code); ok {
+			ptr := reflect.New(t)
+			if err := d.decodeExt(tok, ptr.Elem()); err != nil {
+				return nil, err
+			}
+			return ptr.Elem().Interface(), nil
+		}
+	}
+	return d.r.anySpecial(d, tok)
+}
+
+func (d *decoder) nextAny() (interface{}, error) {
+	tok, err := d.r.next()
+	if err != nil {
+		return nil, err
+	}
+	if err = d.enter(); err != nil {
+		return nil, err
+	}
+	v, err := d.decodeAny(tok)
+	d.depth--
+	return v, err
+}
+
+func (d *decoder) decodeAnyMap(tok token) (interface{}, error) {
+	m := make(map[string]interface{}, maxInt(tok.n, 0))
+	var im map[interface{}]interface{}
+	for i := 0; ; i++ {
+		ok, err := d.more(tok.n, i)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		k, err := d.nextAny()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.nextAny()
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := k.(string); ok && im == nil {
+			m[s] = v
+			continue
+		}
+		if k == nil || !hashable(reflect.ValueOf(k)) {
+			return nil, fmt.Errorf("%s: unhashable map key type %T", d.f.name, k)
+		}
+		if im == nil {
+			im = make(map[interface{}]interface{}, len(m)+1)
+			for s, v := range m {
+				im[s] = v
+			}
+		}
+		im[k] = v
+	}
+	if im != nil {
+		return im, nil
+	}
+	return m, nil
+}
+
+// hashable reports whether the value can be a map key,
+// the dynamic values of the interfaces in it are checked too, e.g. the content of CBORTag.
+func hashable(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Interface:
+		return rv.IsNil() || hashable(rv.Elem())
+	case reflect.Struct:
+		for i := 0; i < rv.NumField(); i++ {
+			if !hashable(rv.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if !hashable(rv.Index(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return rv.Type().Comparable()
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// extRegistry the registered extension types of a format.
+type extRegistry struct {
+	mu    sync.RWMutex
+	types map[int64]reflect.Type
+	codes map[reflect.Type]int64
+}
+
+var binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+
+func (r *extRegistry) reg(formatName string, code int64, value interface{}) {
+	t := reflect.TypeOf(value)
+	if t == nil {
+		panic(formatName + ": the extension type can not be nil")
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if !reflect.PtrTo(t).Implements(binaryMarshalerType) || !reflect.PtrTo(t).Implements(binaryUnmarshalerType) {
+		panic(fmt.Sprintf("%s: the extension type %s must implement encoding.BinaryMarshaler and encoding.BinaryUnmarshaler", formatName, t))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.types == nil {
+		r.types = make(map[int64]reflect.Type)
+		r.codes = make(map[reflect.Type]int64)
+	}
+	if _, ok := r.types[code]; ok {
+		panic(fmt.Sprintf("%s: multi-register extension code: %d", formatName, code))
+	}
+	if _, ok := r.codes[t]; ok {
+		panic(fmt.Sprintf("%s: multi-register extension type: %s", formatName, t))
+	}
+	r.types[code] = t
+	r.codes[t] = code
+}
+
+func (r *extRegistry) code(t reflect.Type) (int64, bool) {
+	r.mu.RLock()
+	code, ok := r.codes[t]
+	r.mu.RUnlock()
+	return code, ok
+}
+
+func (r *extRegistry) typ(code int64) (reflect.Type, bool) {
+	r.mu.RLock()
+	t, ok := r.types[code]
+	r.mu.RUnlock()
+	return t, ok
+}
+
+// field an encoded field of the struct.
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	tagged    bool
+}
+
+// value returns the field value, ok is false if it is in a nil embedded pointer.
+func (fd *field) value(rv reflect.Value) (reflect.Value, bool) {
+	for i, x := range fd.index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, true
+}
+
+// alloc returns the field value, and allocates the nil embedded pointers.
+func (fd *field) alloc(rv reflect.Value) reflect.Value {
+	for i, x := range fd.index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv
+}
+
+type structFields struct {
+	list   []field
+	byName map[string]*field
+	byFold map[string]*field
+}
+
+// lookup returns the field by the exact name, or by the case-insensitive name as encoding/json does.
+func (s *structFields) lookup(name []byte) *field {
+	if fd, ok := s.byName[string(name)]; ok {
+		return fd
+	}
+	return s.byFold[strings.ToLower(string(name))]
+}
+
+func (f *binaryFormat) cachedFields(t reflect.Type) *structFields {
+	if v, ok := f.fields.Load(t); ok {
+		return v.(*structFields)
+	}
+	v, _ := f.fields.LoadOrStore(t, typeFields(t, f.tagKey))
+	return v.(*structFields)
+}
+
+// typeFields returns the encoded fields of the struct type, the embedded struct fields are promoted,
+// the field at the shallower depth dominates, and the tagged one dominates at the same depth.
+func typeFields(t reflect.Type, tagKey string) *structFields {
+	type candidate struct {
+		field
+		depth int
+	}
+	var (
+		all     []candidate
+		visited = map[reflect.Type]bool{}
+		walk    func(t reflect.Type, index []int, depth int)
+	)
+	walk = func(t reflect.Type, index []int, depth int) {
+		if visited[t] {
+			return
+		}
+		visited[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			tag, ok := sf.Tag.Lookup(tagKey)
+			if !ok {
+				tag = sf.Tag.Get("json")
+			}
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			idx := append(append([]int(nil), index...), i)
+			if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+				// the nil embedded pointer to the unexported struct can not be allocated
+				if sf.IsExported() || sf.Type.Kind() != reflect.Ptr {
+					walk(ft, idx, depth+1)
+				}
+				continue
+			}
+			if !sf.IsExported() {
+				continue
+			}
+			fd := candidate{field: field{name: name, index: idx, tagged: name != ""}, depth: depth}
+			if name == "" {
+				fd.name = sf.Name
+			}
+			for opts != "" {
+				var opt string
+				opt, opts, _ = strings.Cut(opts, ",")
+				if opt == "omitempty" {
+					fd.omitEmpty = true
+				}
+			}
+			all = append(all, fd)
+		}
+	}
+	walk(t, nil, 0)
+
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].name != all[j].name {
+			return all[i].name < all[j].name
+		}
+		if all[i].depth != all[j].depth {
+			return all[i].depth < all[j].depth
+		}
+		return all[i].tagged && !all[j].tagged
+	})
+	var list []field
+	for i := 0; i < len(all); {
+		j := i + 1
+		for j < len(all) && all[j].name == all[i].name {
+			j++
+		}
+		// the dominant field is unique at the shallowest depth, or it is the only tagged one
+		if j-i == 1 || all[i+1].depth > all[i].depth || (all[i].tagged && !all[i+1].tagged) {
+			list = append(list, all[i].field)
+		}
+		i = j
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i].index, list[j].index
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+	s := &structFields{
+		list:   list,
+		byName: make(map[string]*field, len(list)),
+		byFold: make(map[string]*field, len(list)),
+	}
+	for i := range s.list {
+		fd := &s.list[i]
+		s.byName[fd.name] = fd
+		if _, ok := s.byFold[strings.ToLower(fd.name)]; !ok {
+			s.byFold[strings.ToLower(fd.name)] = fd
+		}
+	}
+	return s
+}
@@ -23,6 +23,9 @@ import (
 
 	"github.com/andeya/cfgo"
 	"github.com/andeya/erpc/v7/codec"
+	"github.com/andeya/erpc/v7/kcp"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/quic"
 	"github.com/andeya/erpc/v7/socket"
 )
 
@@ -32,19 +35,31 @@ import (
 //	yaml tag is used for github.com/andeya/cfgo
 //	ini tag is used for github.com/andeya/ini
 type PeerConfig struct {
-	Network           string        `yaml:"network"              ini:"network"              comment:"Network; tcp, tcp4, tcp6, unix, unixpacket, kcp or quic"`
-	LocalIP           string        `yaml:"local_ip"             ini:"local_ip"             comment:"Local IP"`
-	LocalPort         uint16        `yaml:"local_port"           ini:"local_port"           comment:"Local port; for client role"`
-	ListenPort        uint16        `yaml:"listen_port"          ini:"listen_port"          comment:"Listen port; for server role"`
-	DialTimeout       time.Duration `yaml:"dial_timeout"         ini:"dial_timeout"         comment:"Maximum duration for dialing; for client role; ns,µs,ms,s,m,h"`
-	RedialTimes       int32         `yaml:"redial_times"         ini:"redial_times"         comment:"The maximum times of attempts to redial, after the connection has been unexpectedly broken; Unlimited when <0; for client role"`
-	RedialInterval    time.Duration `yaml:"redial_interval"      ini:"redial_interval"      comment:"Interval of redialing each time, default 100ms; for client role; ns,µs,ms,s,m,h"`
-	DefaultBodyCodec  string        `yaml:"default_body_codec"   ini:"default_body_codec"   comment:"Default body codec type id"`
-	DefaultSessionAge time.Duration `yaml:"default_session_age"  ini:"default_session_age"  comment:"Default session max age, if less than or equal to 0, no time limit; ns,µs,ms,s,m,h"`
-	DefaultContextAge time.Duration `yaml:"default_context_age"  ini:"default_context_age"  comment:"Default CALL or PUSH context max age, if less than or equal to 0, no time limit; ns,µs,ms,s,m,h"`
-	SlowCometDuration time.Duration `yaml:"slow_comet_duration"  ini:"slow_comet_duration"  comment:"Slow operation alarm threshold; ns,µs,ms,s ..."`
-	PrintDetail       bool          `yaml:"print_detail"         ini:"print_detail"         comment:"Is print body and metadata or not"`
-	CountTime         bool          `yaml:"count_time"           ini:"count_time"           comment:"Is count cost time or not"`
+	Network           string           `yaml:"network"              ini:"network"              comment:"Network; tcp, tcp4, tcp6, unix, unixpacket, kcp, quic or mem"`
+	LocalIP           string           `yaml:"local_ip"             ini:"local_ip"             comment:"Local IP"`
+	LocalPort         uint16           `yaml:"local_port"           ini:"local_port"           comment:"Local port; for client role"`
+	ListenPort        uint16           `yaml:"listen_port"          ini:"listen_port"          comment:"Listen port; for server role"`
+	Listeners         []ListenerConfig `yaml:"listeners"            ini:"listeners"            comment:"Extra listeners sharing the router and sessions, each with its own network, TLS and protocol; for server role"`
+	GoAwayAddr        string           `yaml:"goaway_addr"          ini:"goaway_addr"          comment:"Alternate address announced by the GOAWAY when draining, empty means the clients redial the original addresses; for server role"`
+	DialTimeout       time.Duration    `yaml:"dial_timeout"         ini:"dial_timeout"         comment:"Maximum duration for dialing; for client role; ns,µs,ms,s,m,h"`
+	RedialTimes       int32            `yaml:"redial_times"         ini:"redial_times"         comment:"The maximum times of attempts to redial, after the connection has been unexpectedly broken; Unlimited when <0; for client role"`
+	RedialInterval    time.Duration    `yaml:"redial_interval"      ini:"redial_interval"      comment:"Interval of redialing each time, default 100ms; the base interval of the exponential backoff; for client role; ns,µs,ms,s,m,h"`
+	Backoff           BackoffConfig    `yaml:"backoff"              ini:"backoff"              comment:"Redial backoff options; for client role"`
+	Failover          FailoverConfig   `yaml:"failover"             ini:"failover"             comment:"Multi-address dialing options; for client role"`
+	Proxy             ProxyConfig      `yaml:"proxy"                ini:"proxy"                comment:"Outbound proxy options; for tcp, tcp4 and tcp6 networks; for client role"`
+	DefaultBodyCodec  string           `yaml:"default_body_codec"   ini:"default_body_codec"   comment:"Default body codec type id"`
+	DefaultSessionAge time.Duration    `yaml:"default_session_age"  ini:"default_session_age"  comment:"Default session max age, if less than or equal to 0, no time limit; ns,µs,ms,s,m,h"`
+	DefaultContextAge time.Duration    `yaml:"default_context_age"  ini:"default_context_age"  comment:"Default CALL or PUSH context max age, if less than or equal to 0, no time limit; ns,µs,ms,s,m,h"`
+	SlowCometDuration time.Duration    `yaml:"slow_comet_duration"  ini:"slow_comet_duration"  comment:"Slow operation alarm threshold; ns,µs,ms,s ..."`
+	PrintDetail       bool             `yaml:"print_detail"         ini:"print_detail"         comment:"Is print body and metadata or not"`
+	CountTime         bool             `yaml:"count_time"           ini:"count_time"           comment:"Is count cost time or not"`
+	QUIC              QUICConfig       `yaml:"quic"                 ini:"quic"                 comment:"QUIC transport options; for quic network"`
+	KCP               KCPConfig        `yaml:"kcp"                  ini:"kcp"                  comment:"KCP transport options; for kcp, udp, udp4 or udp6 network"`
+	Mem               MemConfig        `yaml:"mem"                  ini:"mem"                  comment:"In-process transport options; for mem network"`
+	WriteBatch        WriteBatchConfig `yaml:"write_batch"          ini:"write_batch"          comment:"Write coalescing options"`
+	Resume            ResumeConfig     `yaml:"resume"               ini:"resume"               comment:"Session resumption options; not supported in QUIC multi-stream mode"`
+	Sniff             SniffConfig      `yaml:"sniff"                ini:"sniff"                comment:"Protocol auto-detection options of the accepted connections; for server role"`
+	Negotiate         NegotiateConfig  `yaml:"negotiate"            ini:"negotiate"            comment:"Body codec and xfer filter negotiation options of the sessions"`
 
 	localAddr         net.Addr
 	listenAddr        net.Addr
@@ -103,17 +118,40 @@ func (p *PeerConfig) check() (err error) {
 	if p.RedialInterval <= 0 {
 		p.RedialInterval = time.Millisecond * 100
 	}
-	return nil
+	if err = p.Backoff.check(); err != nil {
+		return err
+	}
+	p.Failover.check()
+	for i := range p.Listeners {
+		if err = p.Listeners[i].check(p.Network); err != nil {
+			return err
+		}
+	}
+	if err = p.Proxy.check(); err != nil {
+		return err
+	}
+	p.QUIC.check()
+	p.WriteBatch.check()
+	p.Resume.check()
+	if err = p.Sniff.check(); err != nil {
+		return err
+	}
+	if err = p.Negotiate.check(p.DefaultBodyCodec); err != nil {
+		return err
+	}
+	return p.KCP.check()
 }
 
 func (p *PeerConfig) newAddr(port string) (net.Addr, error) {
 	switch p.Network {
 	default:
-		return nil, errors.New("Invalid network config, refer to the following: tcp, tcp4, tcp6, unix, unixpacket, kcp or quic")
+		return nil, errors.New("Invalid network config, refer to the following: tcp, tcp4, tcp6, unix, unixpacket, kcp, quic or mem")
 	case "tcp", "tcp4", "tcp6":
 		return net.ResolveTCPAddr(p.Network, net.JoinHostPort(p.LocalIP, port))
 	case "unix", "unixpacket":
 		return net.ResolveUnixAddr(p.Network, net.JoinHostPort(p.LocalIP, port))
+	case mem.Network:
+		return NewFakeAddr(p.Network, p.LocalIP, port), nil
 	case "kcp", "udp", "udp4", "udp6", "quic":
 		udpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(p.LocalIP, port))
 		if err != nil {
@@ -133,6 +171,148 @@ func (p *PeerConfig) newAddr(port string) (net.Addr, error) {
 	}
 }
 
+// QUICConfig QUIC transport options
+type QUICConfig struct {
+	MultiStream      bool          `yaml:"multi_stream"       ini:"multi_stream"       comment:"Send each message on its own QUIC stream, and share one QUIC connection between the sessions of the same address"`
+	Allow0RTT        bool          `yaml:"allow_0rtt"         ini:"allow_0rtt"         comment:"Allow 0-RTT resumption of the QUIC connection"`
+	Migration        bool          `yaml:"migration"          ini:"migration"          comment:"Resume the multi-stream sessions on a new QUIC connection after the connection is lost"`
+	MigrationTimeout time.Duration `yaml:"migration_timeout"  ini:"migration_timeout"  comment:"Maximum duration to resume the multi-stream sessions, default 30s; ns,µs,ms,s,m,h"`
+	KeepAlivePeriod  time.Duration `yaml:"keep_alive_period"  ini:"keep_alive_period"  comment:"Period of the QUIC keep-alive packets, default 15s for server role; ns,µs,ms,s,m,h"`
+	MaxIdleTimeout   time.Duration `yaml:"max_idle_timeout"   ini:"max_idle_timeout"   comment:"Maximum duration without any network activity, default 30s; ns,µs,ms,s,m,h"`
+	Datagram         bool          `yaml:"datagram"           ini:"datagram"           comment:"Enable the unreliable datagram PUSH; not supported in multi-stream mode"`
+
+	config     *quic.Config
+	muxOptions *quic.MuxOptions
+}
+
+func (q *QUICConfig) check() {
+	q.config, q.muxOptions = nil, nil
+	if q.MultiStream {
+		q.muxOptions = &quic.MuxOptions{
+			Migration:        q.Migration,
+			MigrationTimeout: q.MigrationTimeout,
+			MaxMessageSize:   int64(socket.MessageSizeLimit()),
+		}
+	}
+	if !q.Allow0RTT && q.KeepAlivePeriod <= 0 && q.MaxIdleTimeout <= 0 && !q.MultiStream && !q.Datagram {
+		return
+	}
+	q.config = &quic.Config{
+		Allow0RTT:       q.Allow0RTT,
+		KeepAlivePeriod: q.KeepAlivePeriod,
+		MaxIdleTimeout:  q.MaxIdleTimeout,
+		EnableDatagrams: q.Datagram,
+	}
+	if q.config.KeepAlivePeriod <= 0 {
+		q.config.KeepAlivePeriod = time.Second * 15
+	}
+	if q.MultiStream {
+		q.config.MaxIncomingUniStreams = 1024
+	}
+}
+
+// KCPConfig KCP transport options
+// NOTE:
+//
+//	The zero value of a field means using the value of the preset, or the default value.
+type KCPConfig struct {
+	Preset       string `yaml:"preset"          ini:"preset"          comment:"Tuning preset; normal, fast, fast2, fast3, turbo (lossy networks) or lan (FEC off); empty means kcp-go defaults"`
+	DataShards   int    `yaml:"data_shards"     ini:"data_shards"     comment:"FEC data shards, default 10; <0 disables FEC"`
+	ParityShards int    `yaml:"parity_shards"   ini:"parity_shards"   comment:"FEC parity shards, default 3; <0 disables FEC"`
+	NoDelay      bool   `yaml:"no_delay"        ini:"no_delay"        comment:"Enable the nodelay mode"`
+	Interval     int    `yaml:"interval"        ini:"interval"        comment:"Internal update timer interval in millisecond"`
+	Resend       int    `yaml:"resend"          ini:"resend"          comment:"Fast resend after the number of ACK skips"`
+	NoCongestion bool   `yaml:"no_congestion"   ini:"no_congestion"   comment:"Disable the congestion control"`
+	SndWnd       int    `yaml:"snd_wnd"         ini:"snd_wnd"         comment:"Send window size in packets"`
+	RcvWnd       int    `yaml:"rcv_wnd"         ini:"rcv_wnd"         comment:"Receive window size in packets"`
+	MTU          int    `yaml:"mtu"             ini:"mtu"             comment:"Maximum transmission unit of the UDP packets"`
+	AckNoDelay   bool   `yaml:"ack_no_delay"    ini:"ack_no_delay"    comment:"Flush the ACK immediately when a packet is received"`
+	Crypt        string `yaml:"crypt"           ini:"crypt"           comment:"Packet encryption; aes, aes-128, aes-192, salsa20, blowfish, twofish, cast5, 3des, tea, xtea, xor, sm4 or none"`
+	Key          string `yaml:"key"             ini:"key"             comment:"Passphrase of the packet encryption"`
+	Datagram     bool   `yaml:"datagram"        ini:"datagram"        comment:"Enable the unreliable datagram PUSH sent as raw UDP packets; ignored when TLS is used"`
+	SessionStats bool   `yaml:"session_stats"   ini:"session_stats"   comment:"Count the packets and retransmitted segments of each session; it costs the batched UDP I/O"`
+
+	options *kcp.Options
+}
+
+func (k *KCPConfig) check() error {
+	opts := kcp.DefaultOptions()
+	if k.Preset != "" {
+		var ok bool
+		opts, ok = kcp.Preset(k.Preset)
+		if !ok {
+			return errors.New("Invalid kcp preset config, refer to the following: normal, fast, fast2, fast3, turbo or lan")
+		}
+	}
+	if k.DataShards < 0 || k.ParityShards < 0 {
+		opts.DataShards, opts.ParityShards = 0, 0
+	} else {
+		if k.DataShards > 0 {
+			opts.DataShards = k.DataShards
+		}
+		if k.ParityShards > 0 {
+			opts.ParityShards = k.ParityShards
+		}
+	}
+	if k.NoDelay {
+		opts.NoDelay = true
+	}
+	if k.Interval > 0 {
+		opts.Interval = k.Interval
+	}
+	if k.Resend > 0 {
+		opts.Resend = k.Resend
+	}
+	if k.NoCongestion {
+		opts.NoCongestion = true
+	}
+	if k.SndWnd > 0 {
+		opts.SndWnd = k.SndWnd
+	}
+	if k.RcvWnd > 0 {
+		opts.RcvWnd = k.RcvWnd
+	}
+	if k.MTU > 0 {
+		opts.MTU = k.MTU
+	}
+	if k.AckNoDelay {
+		opts.AckNoDelay = true
+	}
+	if k.Crypt != "" {
+		block, err := kcp.NewBlockCrypt(k.Crypt, k.Key)
+		if err != nil {
+			return err
+		}
+		opts.Block = block
+	}
+	opts.Datagram = k.Datagram
+	opts.SessionStats = k.SessionStats
+	k.options = opts
+	return nil
+}
+
+// MemConfig in-process transport options, they apply to the data written by the peer
+type MemConfig struct {
+	Latency         time.Duration `yaml:"latency"            ini:"latency"            comment:"One-way delay added to every write; ns,µs,ms,s,m,h"`
+	Bandwidth       int64         `yaml:"bandwidth"          ini:"bandwidth"          comment:"Maximum bytes per second; 0 means unlimited"`
+	BreakAfterBytes int64         `yaml:"break_after_bytes"  ini:"break_after_bytes"  comment:"Break the connection after writing the bytes; 0 means never"`
+	BreakAfter      time.Duration `yaml:"break_after"        ini:"break_after"        comment:"Break the connection after the duration since it is established; 0 means never; ns,µs,ms,s,m,h"`
+	BufferSize      int           `yaml:"buffer_size"        ini:"buffer_size"        comment:"Maximum bytes written but not read yet, the writing blocks when it is full; 0 means 4MB"`
+}
+
+func (m *MemConfig) options() *mem.Options {
+	if *m == (MemConfig{}) {
+		return nil
+	}
+	return &mem.Options{
+		Latency:         m.Latency,
+		Bandwidth:       m.Bandwidth,
+		BreakAfterBytes: m.BreakAfterBytes,
+		BreakAfter:      m.BreakAfter,
+		BufferSize:      m.BufferSize,
+	}
+}
+
 func asQUIC(network string) string {
 	switch network {
 	case "quic":
@@ -375,11 +375,15 @@ E:
 	c.output.SetStatus(statCodeMtypeNotAllowed)
 	Errorf(logFormatDisconnected,
 		c.input.Mtype(), c.IP(), c.input.ServiceMethod(), c.input.Seq(),
-		messageLogBytes(c.input, c.sess.peer.printDetail))
+		messageLogBytes(c.input, c.sess.peer.PrintDetail()))
 	go c.sess.Close()
 }
 
 func (c *handlerCtx) bindPush(header Header) interface{} {
+	if header.ServiceMethod() == goAwayServiceMethod {
+		c.input.SetBody(new(goAwayArgs))
+		return c.input.Body()
+	}
 	c.stat = c.pluginContainer.postReadPushHeader(c)
 	if !c.stat.OK() {
 		return nil
@@ -444,6 +448,10 @@ func (c *handlerCtx) handlePush() {
 }
 
 func (c *handlerCtx) bindCall(header Header) interface{} {
+	if header.ServiceMethod() == negotiateServiceMethod && c.sess.peer.negotiate != nil {
+		c.input.SetBody(new(negotiateArgs))
+		return c.input.Body()
+	}
 	c.stat = c.pluginContainer.postReadCallHeader(c)
 	if !c.stat.OK() {
 		return nil
@@ -581,6 +589,10 @@ func (c *handlerCtx) writeReply(stat *Status) *Status {
 }
 
 func (c *handlerCtx) bindReply(header Header) interface{} {
+	if c.sess.goAwayResent(header.Seq()) {
+		// the reply of the CALL dropped by the GOAWAY, it is filtered out
+		return nil
+	}
 	_callCmd, ok := c.sess.callCmdMap.Load(header.Seq())
 	if !ok {
 		Warnf("not found call cmd: %v", c.input)
@@ -723,6 +735,7 @@ type (
 		callCmdChan    chan<- CallCmd // Send itself to the public channel when call is complete.
 		doneChan       chan struct{}  // Strobes when call is complete.
 		inputBodyCodec byte
+		written        int32 // 1 if the CALL has been written
 	}
 )
 
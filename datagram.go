@@ -0,0 +1,119 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/andeya/goutil"
+
+	"github.com/andeya/erpc/v7/codec"
+	"github.com/andeya/erpc/v7/utils"
+)
+
+// datagramConn is a connection that can also send unreliable datagrams,
+// such as the QUIC or KCP connection.
+type datagramConn interface {
+	SendDatagram(b []byte) error
+	ReceiveDatagram(ctx context.Context) ([]byte, error)
+	// MaxDatagramSize returns 0 if the datagram can not be sent now.
+	MaxDatagramSize() int
+}
+
+// getDatagramConn returns the datagram connection of the session,
+// if the transport can not send datagrams now, returns false.
+func (s *session) getDatagramConn() (datagramConn, bool) {
+	dc, ok := s.getConn().(datagramConn)
+	if !ok || dc.MaxDatagramSize() <= 0 {
+		return nil, false
+	}
+	return dc, true
+}
+
+// writeDatagram writes the message as an unreliable datagram.
+// If the transport does not support datagrams, or the protocol is stateful, returns false.
+func (s *session) writeDatagram(message Message) (*Status, bool) {
+	dc, ok := s.getDatagramConn()
+	if !ok {
+		return nil, false
+	}
+	bb := utils.AcquireByteBuffer()
+	defer utils.ReleaseByteBuffer(bb)
+	proto := s.GetProtoFunc()(&datagramReadWriter{Writer: bb})
+	if sp, ok := proto.(StatefulProto); ok && sp.Stateful() {
+		return nil, false
+	}
+	if !s.checkStatus(statusOk) {
+		return statConnClosed, true
+	}
+	err := proto.Pack(message)
+	if err != nil {
+		return statWriteFailed.Copy(err), true
+	}
+	if max := dc.MaxDatagramSize(); bb.Len() > max {
+		return statWriteFailed.Copy(fmt.Errorf("datagram too large: %d > %d bytes", bb.Len(), max)), true
+	}
+	if err = dc.SendDatagram(bb.B); err != nil {
+		return statWriteFailed.Copy(err), true
+	}
+	return nil, true
+}
+
+// startReadDatagrams reads the datagrams until the connection is closed,
+// and handles the PUSH messages like the ones of the reliable stream.
+func (s *session) startReadDatagrams(dc datagramConn) {
+	defer func() {
+		if p := recover(); p != nil {
+			Errorf("panic:%v\n%s", p, goutil.PanicTrace(2))
+		}
+	}()
+	for s.goonRead() {
+		b, err := dc.ReceiveDatagram(context.Background())
+		if err != nil || !s.goonRead() {
+			return
+		}
+		var ctx = s.peer.getContext(s, false)
+		if s.peer.pluginContainer.preReadHeader(ctx) != nil {
+			s.peer.putContext(ctx, false)
+			continue
+		}
+		err = s.GetProtoFunc()(&datagramReadWriter{Reader: bytes.NewReader(b)}).Unpack(ctx.input)
+		if ctx.input.Mtype() != TypePush || (err != nil && ctx.GetBodyCodec() == codec.NilCodecID) {
+			Debugf("drop datagram (network:%s, addr:%s, id:%s): mtype=%s, error=%v",
+				s.peer.network, s.RemoteAddr().String(), s.ID(), TypeText(ctx.input.Mtype()), err)
+			s.peer.putContext(ctx, false)
+			continue
+		}
+		if err != nil {
+			ctx.stat = statBadMessage.Copy(err)
+		}
+		s.graceCtxWaitGroup.Add(1)
+		if !Go(func() {
+			defer s.peer.putContext(ctx, true)
+			ctx.handle()
+		}) {
+			s.peer.putContext(ctx, true)
+		}
+	}
+}
+
+// datagramReadWriter makes a single datagram usable by the ProtoFunc.
+type datagramReadWriter struct {
+	io.Reader
+	io.Writer
+}
@@ -17,10 +17,15 @@ package erpc
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"math/rand"
 	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/andeya/erpc/v7/kcp"
+	"github.com/andeya/erpc/v7/mem"
 	"github.com/andeya/erpc/v7/quic"
 )
 
@@ -32,6 +37,30 @@ type Dialer struct {
 	dialTimeout    time.Duration
 	redialInterval time.Duration
 	redialTimes    int32
+	backoff        Backoff
+	failover       *FailoverConfig
+	proxy          *ProxyConfig
+	postAttempt    func(remoteAddr string, attempt int, err error)
+	quicConfig     *QUICConfig
+	kcpConfig      *KCPConfig
+	memConfig      *MemConfig
+	quicTLSConfigs map[string]*tls.Config // reused between QUIC dials for sharing and 0-RTT resumption, by the server name
+	quicTLSSource  *tls.Config
+	mu             sync.Mutex
+}
+
+// FailoverConfig multi-address dialing options
+type FailoverConfig struct {
+	Shuffle   bool          `yaml:"shuffle"     ini:"shuffle"     comment:"Shuffle the address list before each dialing, instead of trying it in order"`
+	RaceDelay time.Duration `yaml:"race_delay"  ini:"race_delay"  comment:"Delay before racing the next address while the previous attempts are pending, happy eyeballs style; default 250ms; <0 means dialing the addresses one by one; ns,µs,ms,s,m,h"`
+}
+
+const defaultRaceDelay = time.Millisecond * 250
+
+func (f *FailoverConfig) check() {
+	if f.RaceDelay == 0 {
+		f.RaceDelay = defaultRaceDelay
+	}
 }
 
 // NewDialer creates a dialer.
@@ -78,84 +107,417 @@ func (d *Dialer) RedialTimes() int32 {
 	return d.redialTimes
 }
 
+// Backoff returns the redial backoff strategy.
+func (d *Dialer) Backoff() Backoff {
+	if d.backoff == nil {
+		return ConstantBackoff(d.redialInterval)
+	}
+	return d.backoff
+}
+
+// SetBackoff sets the redial backoff strategy.
+func (d *Dialer) SetBackoff(backoff Backoff) {
+	d.backoff = backoff
+}
+
 // Dial dials the connection, and try again if it fails.
 func (d *Dialer) Dial(addr string) (net.Conn, error) {
-	return d.dialWithRetry(addr, "", nil)
+	conn, _, err := d.dialWithRetry([]string{addr}, "", nil)
+	return conn, err
+}
+
+// DialAddrs dials the first reachable address of the list, and try again if it fails.
+// It returns the connection and the chosen address.
+// NOTE:
+//
+//	The addresses are raced in happy eyeballs style,
+//	and the host names are resolved and interleaved across IPv6 and IPv4.
+func (d *Dialer) DialAddrs(addrs []string) (net.Conn, string, error) {
+	return d.dialWithRetry(addrs, "", nil)
 }
 
 // dialWithRetry dials the connection, and try again if it fails.
+// It returns the connection and the chosen address.
 // NOTE:
 //
 //	sessID is not empty only when the disconnection is redialing
-func (d *Dialer) dialWithRetry(addr, sessID string, fn func(conn net.Conn) error) (net.Conn, error) {
-	conn, err := d.dialOne(addr)
-	if err == nil {
+func (d *Dialer) dialWithRetry(addrs []string, sessID string, fn func(conn net.Conn) error) (net.Conn, string, error) {
+	if len(addrs) == 0 {
+		return nil, "", errors.New("no address to dial")
+	}
+	var (
+		backoff     = d.Backoff()
+		redialTimes = d.newRedialCounter()
+		conn        net.Conn
+		addr        string
+		err         error
+	)
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if !redialTimes.Next() {
+				return nil, "", err
+			}
+			time.Sleep(backoff.Next(attempt))
+			if sessID == "" {
+				Debugf("trying to redial... (network:%s, addr:%s, attempt:%d)", d.network, strings.Join(addrs, ","), attempt)
+			} else {
+				Debugf("trying to redial... (network:%s, addr:%s, id:%s, attempt:%d)", d.network, strings.Join(addrs, ","), sessID, attempt)
+			}
+		}
+		conn, addr, err = d.dialAddrs(addrs, attempt)
+		if err != nil {
+			if attempt == 0 {
+				Errorf("dialOne error:%s", err.Error())
+			}
+			continue
+		}
 		if fn == nil {
-			return conn, nil
+			return conn, addr, nil
 		}
-		err = fn(conn)
-		if err == nil {
-			return conn, nil
+		if err = fn(conn); err == nil {
+			return conn, addr, nil
 		}
-	} else {
-		Errorf("dialOne error:%s", err.Error())
 	}
-	redialTimes := d.newRedialCounter()
-	for redialTimes.Next() {
-		time.Sleep(d.redialInterval)
-		if sessID == "" {
-			Debugf("trying to redial... (network:%s, addr:%s)", d.network, addr)
+}
+
+// dialAddrs dials the addresses once, and returns the first established connection.
+func (d *Dialer) dialAddrs(addrs []string, attempt int) (net.Conn, string, error) {
+	if len(addrs) == 1 {
+		conn, err := d.dialOne(context.Background(), addrs[0], "")
+		d.reportAttempt(addrs[0], attempt, err)
+		return conn, addrs[0], err
+	}
+	var raceDelay = defaultRaceDelay
+	if d.failover != nil {
+		raceDelay = d.failover.RaceDelay
+		if d.failover.Shuffle {
+			addrs = shuffleAddrs(addrs)
+		}
+	}
+	addrs, hosts := d.resolveAddrs(addrs)
+	return d.race(addrs, hosts, raceDelay, attempt)
+}
+
+type dialResult struct {
+	addr string
+	conn net.Conn
+	err  error
+}
+
+// race dials the addresses in order, the next one is started when the previous fails or raceDelay elapses,
+// the first established connection wins, and the others are canceled.
+// NOTE: hosts maps the resolved addresses to their host names, which are used as the TLS server names.
+func (d *Dialer) race(addrs []string, hosts map[string]string, raceDelay time.Duration, attempt int) (net.Conn, string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		results = make(chan dialResult, len(addrs))
+		next    int
+		pending int
+		timeout <-chan time.Time
+		lastErr error
+	)
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := d.dialOne(ctx, addr, hosts[addr])
+			results <- dialResult{addr: addr, conn: conn, err: err}
+		}()
+		timeout = nil
+		if next < len(addrs) && raceDelay >= 0 {
+			timeout = time.After(raceDelay)
+		}
+	}
+	start()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				d.reportAttempt(r.addr, attempt, nil)
+				if pending > 0 {
+					cancel()
+					go d.discardLosers(results, pending, attempt)
+				}
+				return r.conn, r.addr, nil
+			}
+			d.reportAttempt(r.addr, attempt, r.err)
+			lastErr = r.err
+			if next < len(addrs) {
+				start()
+			}
+		case <-timeout:
+			start()
+		}
+	}
+	return nil, "", lastErr
+}
+
+// discardLosers closes the connections established after the race is won.
+func (d *Dialer) discardLosers(results <-chan dialResult, pending int, attempt int) {
+	for ; pending > 0; pending-- {
+		r := <-results
+		if r.conn != nil {
+			r.conn.Close()
+			r.err = context.Canceled
+		}
+		d.reportAttempt(r.addr, attempt, r.err)
+	}
+}
+
+func (d *Dialer) reportAttempt(remoteAddr string, attempt int, err error) {
+	if d.postAttempt != nil {
+		d.postAttempt(remoteAddr, attempt, err)
+	}
+}
+
+// resolveAddrs resolves the host names of IP networks,
+// and interleaves the IP addresses across the families, IPv6 first.
+// It also returns the host names of the resolved addresses, for the TLS server names.
+// NOTE: The host names are resolved by the proxy if it is enabled.
+func (d *Dialer) resolveAddrs(addrs []string) ([]string, map[string]string) {
+	if d.proxy.enabled() {
+		return addrs, nil
+	}
+	var ipv4Only, ipv6Only bool
+	switch d.network {
+	case "tcp", "kcp", "udp", "quic":
+	case "tcp4", "udp4":
+		ipv4Only = true
+	case "tcp6", "udp6":
+		ipv6Only = true
+	default:
+		return addrs, nil
+	}
+	var (
+		v6, v4, others []string
+		hosts          = make(map[string]string)
+	)
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || host == "" {
+			others = append(others, addr)
+			continue
+		}
+		var ips []net.IP
+		if ip := net.ParseIP(host); ip != nil {
+			ips = []net.IP{ip}
 		} else {
-			Debugf("trying to redial... (network:%s, addr:%s, id:%s)", d.network, addr, sessID)
+			ctx, cancel := context.WithCancel(context.Background())
+			if d.dialTimeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, d.dialTimeout)
+			}
+			ipAddrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			cancel()
+			if err != nil {
+				Debugf("resolve %s error: %s", host, err.Error())
+				others = append(others, addr)
+				continue
+			}
+			for _, a := range ipAddrs {
+				ips = append(ips, a.IP)
+			}
 		}
-		conn, err = d.dialOne(addr)
-		if err == nil {
-			if fn == nil {
-				return conn, nil
+		for _, ip := range ips {
+			ipAddr := net.JoinHostPort(ip.String(), port)
+			if ip.To4() != nil {
+				if ipv6Only {
+					continue
+				}
+				v4 = append(v4, ipAddr)
+			} else {
+				if ipv4Only {
+					continue
+				}
+				v6 = append(v6, ipAddr)
 			}
-			err = fn(conn)
-			if err == nil {
-				return conn, nil
+			if ip.String() != host {
+				hosts[ipAddr] = host
 			}
 		}
 	}
-	return nil, err
+	r := make([]string, 0, len(v6)+len(v4)+len(others))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			r = append(r, v6[i])
+		}
+		if i < len(v4) {
+			r = append(r, v4[i])
+		}
+	}
+	return append(r, others...), hosts
 }
 
-const (
-	dataShards   = 10
-	parityShards = 3
-)
+func shuffleAddrs(addrs []string) []string {
+	r := make([]string, len(addrs))
+	copy(r, addrs)
+	rand.Shuffle(len(r), func(i, j int) {
+		r[i], r[j] = r[j], r[i]
+	})
+	return r
+}
+
+// failoverAddrs moves the broken address to the end of the list,
+// so that the redialing fails over to the other addresses first.
+func failoverAddrs(addrs []string, broken string) []string {
+	r := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr != broken {
+			r = append(r, addr)
+		}
+	}
+	if len(r) == len(addrs) {
+		return addrs
+	}
+	return append(r, broken)
+}
 
 // dialOne dials the connection once.
-func (d *Dialer) dialOne(addr string) (net.Conn, error) {
+// NOTE: serverName is the host name of the resolved addr, empty means taking it from addr.
+func (d *Dialer) dialOne(ctx context.Context, addr, serverName string) (net.Conn, error) {
 	if network := asQUIC(d.network); network != "" {
-		ctx := context.Background()
 		if d.dialTimeout > 0 {
-			ctx, _ = context.WithTimeout(ctx, d.dialTimeout)
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d.dialTimeout)
+			defer cancel()
 		}
-		var tlsConf = d.tlsConfig
-		if tlsConf == nil {
-			tlsConf = GenerateTLSConfigForClient()
+		var (
+			tlsConf = d.getQUICTLSConfig(serverName)
+			conf    *quic.Config
+		)
+		if d.quicConfig != nil {
+			conf = d.quicConfig.config
+			if opts := d.quicConfig.muxOptions; opts != nil {
+				Debugf("trying to DialMuxContext... (network:%s, addr:%s)", d.network, addr)
+				return quic.DialMuxContext(ctx, network, d.localAddr.(*FakeAddr).udpAddr, addr, tlsConf, conf, opts)
+			}
 		}
 		Debugf("trying to DialAddrContext... (network:%s, addr:%s)", d.network, addr)
-		return quic.DialAddrContext(ctx, network, d.localAddr.(*FakeAddr).udpAddr, addr, tlsConf, nil)
+		return quic.DialAddrContext(ctx, network, d.localAddr.(*FakeAddr).udpAddr, addr, tlsConf, conf)
+	}
+
+	if d.network == mem.Network {
+		if d.dialTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d.dialTimeout)
+			defer cancel()
+		}
+		var opts *mem.Options
+		if d.memConfig != nil {
+			opts = d.memConfig.options()
+		}
+		Debugf("trying to mem.DialContext... (network:%s, addr:%s)", d.network, addr)
+		return mem.DialContext(ctx, addr, opts)
 	}
 
 	if network := asKCP(d.network); network != "" {
 		Debugf("trying to asKCP... (network:%s, addr:%s)", d.network, addr)
-		return kcp.DialAddrContext(network, d.localAddr.(*FakeAddr).udpAddr, addr, d.tlsConfig, dataShards, parityShards)
+		var opts *kcp.Options
+		if d.kcpConfig != nil {
+			opts = d.kcpConfig.options
+		}
+		return kcp.DialAddrContextWithOptions(network, d.localAddr.(*FakeAddr).udpAddr, addr, d.serverTLSConfig(serverName), opts)
 	}
 	dialer := &net.Dialer{
 		LocalAddr: d.localAddr,
 		Timeout:   d.dialTimeout,
 	}
+	if d.proxy.enabled() && !d.proxy.bypassed(addr) {
+		return d.dialProxy(ctx, dialer, addr)
+	}
 	if d.tlsConfig != nil {
 		Debugf("trying to DialWithDialer... (network:%s, addr:%s)", d.network, addr)
-		return tls.DialWithDialer(dialer, d.network, addr, d.tlsConfig)
+		return (&tls.Dialer{NetDialer: dialer, Config: d.serverTLSConfig(serverName)}).DialContext(ctx, d.network, addr)
 	}
 	Debugf("trying to Dial... (network:%s, addr:%s)", d.network, addr)
-	return dialer.Dial(d.network, addr)
+	return dialer.DialContext(ctx, d.network, addr)
+}
+
+// serverTLSConfig returns the TLS config for dialing the server,
+// whose server name is set to serverName if it is not configured.
+func (d *Dialer) serverTLSConfig(serverName string) *tls.Config {
+	if d.tlsConfig == nil || d.tlsConfig.ServerName != "" || serverName == "" {
+		return d.tlsConfig
+	}
+	tlsConf := d.tlsConfig.Clone()
+	tlsConf.ServerName = serverName
+	return tlsConf
+}
+
+// dialProxy dials the connection through the proxy, and performs the TLS handshake through the tunnel.
+func (d *Dialer) dialProxy(ctx context.Context, dialer *net.Dialer, addr string) (net.Conn, error) {
+	if d.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.dialTimeout)
+		defer cancel()
+	}
+	Debugf("trying to dial through proxy... (network:%s, addr:%s, proxy:%s)", d.network, addr, d.proxy.proxyURL.Host)
+	conn, err := d.proxy.dial(ctx, dialer, d.network, addr)
+	if err != nil || d.tlsConfig == nil {
+		return conn, err
+	}
+	tlsConf := d.tlsConfig
+	if tlsConf.ServerName == "" {
+		host, _, _ := net.SplitHostPort(addr)
+		tlsConf = tlsConf.Clone()
+		tlsConf.ServerName = host
+	}
+	tlsConn := tls.Client(conn, tlsConf)
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// SetProxy sets the outbound proxy for TCP and TLS dialing, an empty proxyURL means dialing directly.
+// NOTE:
+//
+//	proxyURL is socks5://[user:password@]host:port or http://[user:password@]host:port,
+//	the destinations matched by the bypass rules are dialed directly, refer to ProxyConfig.
+func (d *Dialer) SetProxy(proxyURL string, bypass ...string) error {
+	proxy := &ProxyConfig{URL: proxyURL, Bypass: bypass}
+	if err := proxy.check(); err != nil {
+		return err
+	}
+	d.proxy = proxy
+	return nil
+}
+
+// getQUICTLSConfig returns the TLS config for QUIC dialing.
+// NOTE:
+//
+//	It is reused per server name until the TLS config of the dialer is changed,
+//	so that QUIC connections can be shared and resumed with 0-RTT.
+func (d *Dialer) getQUICTLSConfig(serverName string) *tls.Config {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.quicTLSSource != d.tlsConfig || d.quicTLSConfigs == nil {
+		d.quicTLSConfigs, d.quicTLSSource = make(map[string]*tls.Config), d.tlsConfig
+	}
+	if tlsConf := d.quicTLSConfigs[serverName]; tlsConf != nil {
+		return tlsConf
+	}
+	var tlsConf *tls.Config
+	if d.tlsConfig == nil {
+		tlsConf = GenerateTLSConfigForClient()
+	} else {
+		tlsConf = d.tlsConfig.Clone()
+	}
+	if tlsConf.ServerName == "" {
+		tlsConf.ServerName = serverName
+	}
+	if tlsConf.ClientSessionCache == nil {
+		tlsConf.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	if len(tlsConf.NextProtos) == 0 {
+		// QUIC requires ALPN, use the same protocols as the server default
+		tlsConf.NextProtos = []string{"http/1.1", "h2"}
+	}
+	d.quicTLSConfigs[serverName] = tlsConf
+	return tlsConf
 }
 
 // newRedialCounter creates a new redial counter.
@@ -0,0 +1,78 @@
+package erpc_test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+)
+
+func failoverEcho(ctx erpc.CallCtx, arg *string) (string, *erpc.Status) {
+	return ctx.Session().LocalAddr().String(), nil
+}
+
+func TestDialAddrsFailover(t *testing.T) {
+	newServer := func() (erpc.Peer, string) {
+		srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+		srv.RouteCallFunc(failoverEcho)
+		return srv, memtest.Serve(srv)
+	}
+	srvA, addrA := newServer()
+	srvB, addrB := newServer()
+	defer srvB.Close()
+	// no listener on the port 1 of the in-process network
+	const unreachable = ":1"
+
+	var (
+		mu       sync.Mutex
+		attempts = make(map[string]int)
+		chosen   []string
+	)
+	cli := erpc.NewPeer(erpc.PeerConfig{
+		Network:        mem.Network,
+		RedialTimes:    3,
+		RedialInterval: time.Millisecond * 10,
+		Failover:       erpc.FailoverConfig{RaceDelay: time.Millisecond * 50},
+	}, &erpc.PluginImpl{
+		PluginName: "dial_attempts",
+		OnPostDialAttempt: func(localAddr net.Addr, remoteAddr string, attempt int, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts[remoteAddr]++
+			if err == nil {
+				chosen = append(chosen, remoteAddr)
+			}
+		},
+	})
+	defer cli.Close()
+
+	// the first address is unreachable, and the second one wins the race
+	sess, stat := cli.DialAddrs([]string{unreachable, addrA, addrB})
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	var result string
+	if stat = sess.Call("/failover_echo", "", &result).Status(); !stat.OK() || result != addrA {
+		t.Fatalf("want %s, got: %q, %v", addrA, result, stat)
+	}
+
+	// fail over to the next address
+	srvA.Close()
+	time.Sleep(time.Millisecond * 200)
+	if stat = sess.Call("/failover_echo", "", &result).Status(); !stat.OK() || result != addrB {
+		t.Fatalf("want %s, got: %q, %v", addrB, result, stat)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts[unreachable] == 0 {
+		t.Fatalf("want the attempt to the unreachable address reported, got: %v", attempts)
+	}
+	if len(chosen) != 2 || chosen[0] != addrA || chosen[1] != addrB {
+		t.Fatalf("want chosen [%s %s], got: %v", addrA, addrB, chosen)
+	}
+}
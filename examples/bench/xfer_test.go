@@ -0,0 +1,88 @@
+package bench
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/klauspost/compress/dict"
+
+	"github.com/andeya/erpc/v7/codec"
+	"github.com/andeya/erpc/v7/examples/bench/msg"
+	"github.com/andeya/erpc/v7/xfer"
+	"github.com/andeya/erpc/v7/xfer/gzip"
+	"github.com/andeya/erpc/v7/xfer/lz4"
+	"github.com/andeya/erpc/v7/xfer/snappy"
+	"github.com/andeya/erpc/v7/xfer/zstd"
+)
+
+// go test -run=NONE -bench=Xfer -benchmem
+
+var xferOnce sync.Once
+
+// regXferFilters registers the compression filters, zstd-dict uses a dictionary trained from the json messages.
+func regXferFilters(b *testing.B) {
+	xferOnce.Do(func() {
+		samples := make([][]byte, 0, 200)
+		for i := 0; i < cap(samples); i++ {
+			args := msg.PrepareArgs()
+			args.Field1 = fmt.Sprintf("%s-%d", args.Field1, i)
+			args.Field2 = int32(i)
+			data, err := codec.Marshal(codec.ID_JSON, args)
+			if err != nil {
+				b.Fatal(err)
+			}
+			samples = append(samples, data)
+		}
+		d, err := dict.BuildZstdDict(samples, dict.Options{MaxDictSize: 8 << 10, HashBytes: 6})
+		if err != nil {
+			b.Fatal(err)
+		}
+		gzip.Reg('g', "gzip", 5)
+		zstd.Reg('z', "zstd", 3, 0)
+		zstd.Reg('d', "zstd-dict", 3, 0, d)
+		snappy.Reg('s', "snappy", 0)
+		lz4.Reg('l', "lz4", 0)
+	})
+}
+
+func BenchmarkXferProtobuf(b *testing.B) {
+	benchmarkXfer(b, codec.ID_PROTOBUF)
+}
+
+func BenchmarkXferJSON(b *testing.B) {
+	benchmarkXfer(b, codec.ID_JSON)
+}
+
+func benchmarkXfer(b *testing.B, codecID byte) {
+	regXferFilters(b)
+	src, err := codec.Marshal(codecID, msg.PrepareArgs())
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, name := range []string{"gzip", "zstd", "zstd-dict", "snappy", "lz4"} {
+		filter, err := xfer.GetByName(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name, func(b *testing.B) {
+			packed, err := filter.OnPack(src)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(src)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				packed, err = filter.OnPack(src)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err = filter.OnUnpack(packed); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(packed))/float64(len(src)), "ratio")
+		})
+	}
+}
@@ -9,13 +9,16 @@ require (
 	github.com/apache/thrift v0.17.0
 	github.com/gogo/protobuf v1.3.2
 	github.com/golang/protobuf v1.5.3
+	github.com/klauspost/compress v1.17.0
 	github.com/montanaflynn/stats v0.5.0
+	github.com/pierrec/lz4/v4 v4.1.18
 	github.com/quic-go/quic-go v0.38.1
 	github.com/stretchr/testify v1.7.5
 	github.com/tidwall/evio v1.0.8
 	github.com/tidwall/gjson v1.14.1
 	github.com/xtaci/kcp-go/v5 v5.6.1
 	golang.org/x/sys v0.8.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -43,7 +46,6 @@ require (
 	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/tools v0.9.1 // indirect
 	google.golang.org/protobuf v1.28.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
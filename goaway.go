@@ -0,0 +1,294 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erpc
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andeya/erpc/v7/codec"
+	"github.com/andeya/erpc/v7/socket"
+)
+
+const (
+	goAwayServiceMethod = "/erpc/goaway"
+	goAwayDrainTimeout  = time.Second * 30
+)
+
+// statGoingAway is the status of the CALL dropped after the GOAWAY.
+var statGoingAway = NewStatus(CodeServiceUnavailable, CodeText(CodeServiceUnavailable), "going away")
+
+// goAwayArgs is the body of the GOAWAY PUSH.
+type goAwayArgs struct {
+	Addr    string `json:"addr,omitempty"` // the alternate address to redial
+	LastSeq int32  `json:"last_seq"`       // the maximum seq of the accepted CALLs
+}
+
+// goAwayState is the draining state of a session.
+type goAwayState struct {
+	mu sync.Mutex
+
+	// the sender of the GOAWAY
+	sent        bool
+	acceptedSeq int32
+
+	// only for client role
+	draining   *goAwayArgs
+	redirect   string
+	readClosed chan struct{}
+	done       chan struct{}
+}
+
+// GoAway notifies the sessions to migrate by the GOAWAY PUSH, and stops accepting their new CALLs.
+// NOTE:
+//
+//	The GOAWAY carries the alternate address altAddr (optional) and the seq of the last accepted CALL;
+//	The client sessions that can redial stop sending new CALLs on the connection,
+//	and redial in the background after the accepted CALLs finish,
+//	then the CALLs not accepted are sent again on the new connection.
+func (p *peer) GoAway(altAddr string) {
+	p.pluginContainer.preGoAway(altAddr)
+	p.sessHub.rangeCallback(func(sess *session) bool {
+		sess.sendGoAway(altAddr)
+		return true
+	})
+}
+
+// sendGoAway sends the GOAWAY PUSH once.
+func (s *session) sendGoAway(altAddr string) {
+	g := &s.goAway
+	g.mu.Lock()
+	if g.sent {
+		g.mu.Unlock()
+		return
+	}
+	defer g.mu.Unlock()
+	g.sent = true
+	args := &goAwayArgs{Addr: altAddr, LastSeq: g.acceptedSeq}
+
+	// NOTE: Holding the lock, so the replies of the dropped CALLs are not sent before the GOAWAY.
+	output := socket.GetMessage(WithBodyCodec(codec.ID_JSON))
+	defer socket.PutMessage(output)
+	output.SetMtype(TypePush)
+	output.SetSeq(atomic.AddInt32(&s.seq, 1))
+	output.SetServiceMethod(goAwayServiceMethod)
+	output.SetBody(args)
+	if _, stat := s.write(output); !stat.OK() {
+		Debugf("GOAWAY fail (network:%s, addr:%s, id:%s): %v", s.peer.network, s.RemoteAddr().String(), s.ID(), stat)
+	}
+}
+
+// filterGoAway handles the GOAWAY PUSH, and drops the CALL read after the GOAWAY has been sent.
+// It returns true if the message is consumed.
+// NOTE:
+//
+//	It is executed synchronously when reading message;
+//	The dropped CALL is replied with CodeServiceUnavailable, which is ignored by the migrating client.
+func (s *session) filterGoAway(input Message) bool {
+	switch input.Mtype() {
+	case TypeCall:
+		g := &s.goAway
+		g.mu.Lock()
+		accepted := !g.sent || input.Seq() <= g.acceptedSeq
+		if accepted && input.Seq() > g.acceptedSeq {
+			g.acceptedSeq = input.Seq()
+		}
+		g.mu.Unlock()
+		if !accepted {
+			Debugf("drop the CALL after GOAWAY (network:%s, addr:%s, id:%s, seq:%d)", s.peer.network, s.RemoteAddr().String(), s.ID(), input.Seq())
+			s.replyGoingAway(input)
+		}
+		return !accepted
+	case TypeReply:
+		return s.goAwayResent(input.Seq())
+	case TypePush:
+		if input.ServiceMethod() != goAwayServiceMethod {
+			return false
+		}
+		if args, ok := input.Body().(*goAwayArgs); ok {
+			s.onGoAway(*args)
+		}
+		return true
+	}
+	return false
+}
+
+// goAwayResent returns true if the CALL is not accepted by the GOAWAY, and will be sent again after the migration.
+func (s *session) goAwayResent(seq int32) bool {
+	g := &s.goAway
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.draining != nil && seq > g.draining.LastSeq
+}
+
+// replyGoingAway replies the dropped CALL, so that the client not migrating does not wait forever.
+func (s *session) replyGoingAway(input Message) {
+	output := socket.GetMessage()
+	defer socket.PutMessage(output)
+	output.SetMtype(TypeReply)
+	output.SetSeq(input.Seq())
+	output.SetServiceMethod(input.ServiceMethod())
+	output.SetBodyCodec(s.peer.defaultBodyCodec)
+	output.SetStatus(statGoingAway)
+	if _, stat := s.write(output); !stat.OK() {
+		Debugf("reply the dropped CALL fail (network:%s, addr:%s, id:%s, seq:%d): %v", s.peer.network, s.RemoteAddr().String(), s.ID(), input.Seq(), stat)
+	}
+}
+
+// onGoAway starts draining the client session.
+func (s *session) onGoAway(args goAwayArgs) {
+	if s.redialForClientLocked == nil {
+		Debugf("GOAWAY received, but not redialable (network:%s, addr:%s, id:%s)", s.peer.network, s.RemoteAddr().String(), s.ID())
+		return
+	}
+	g := &s.goAway
+	g.mu.Lock()
+	if g.draining != nil || !s.tryChangeStatus(statusDraining, statusOk) {
+		g.mu.Unlock()
+		return
+	}
+	g.draining = &args
+	g.readClosed = make(chan struct{})
+	g.done = make(chan struct{})
+	g.mu.Unlock()
+	Infof("GOAWAY received (network:%s, addr:%s, id:%s, alt:%q, last_seq:%d)", s.peer.network, s.RemoteAddr().String(), s.ID(), args.Addr, args.LastSeq)
+	AnywayGo(s.migrate)
+}
+
+// readClosedWhenDraining notifies that the read loop of the draining connection has exited.
+// It returns false if the session is not draining.
+func (s *session) readClosedWhenDraining() bool {
+	g := &s.goAway
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.draining == nil || !s.checkStatus(statusDraining) {
+		return false
+	}
+	close(g.readClosed)
+	return true
+}
+
+// migrating returns the channel closed after the migration, or nil if the session is not draining.
+func (s *session) migrating() <-chan struct{} {
+	g := &s.goAway
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.draining == nil {
+		return nil
+	}
+	return g.done
+}
+
+// takeRedirect returns the alternate address of the migration once.
+func (s *session) takeRedirect() string {
+	g := &s.goAway
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	addr := g.redirect
+	g.redirect = ""
+	return addr
+}
+
+// migrate waits for the accepted CALLs to finish on the draining connection,
+// then redials the alternate or the original addresses, and sends the CALLs not accepted again.
+func (s *session) migrate() {
+	g := &s.goAway
+	g.mu.Lock()
+	args, readClosed, done := g.draining, g.readClosed, g.done
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		g.draining, g.redirect = nil, ""
+		g.mu.Unlock()
+		close(done)
+	}()
+
+	accepted, _ := s.goAwayCallCmds(args.LastSeq)
+	timer := time.NewTimer(goAwayDrainTimeout)
+	defer timer.Stop()
+WAIT:
+	for _, cmd := range accepted {
+		select {
+		case <-cmd.Done():
+		case <-readClosed:
+			break WAIT
+		case <-timer.C:
+			break WAIT
+		case <-s.closeNotifyCh:
+			return
+		}
+	}
+	s.getConn().Close()
+	select {
+	case <-readClosed:
+	case <-s.closeNotifyCh:
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	accepted, unaccepted := s.goAwayCallCmds(args.LastSeq)
+	for _, cmd := range accepted {
+		cmd.mu.Lock()
+		if !cmd.hasReply() && cmd.stat.OK() {
+			cmd.cancel("going away")
+		}
+		cmd.mu.Unlock()
+	}
+	if !s.tryChangeStatus(statusRedialing, statusDraining) {
+		return
+	}
+	if r := s.resume; r != nil {
+		// the remote peer is going away, so start a new resumable session
+		r.mu.Lock()
+		r.token, r.lastPush, r.resend = "", 0, nil
+		r.mu.Unlock()
+	}
+	g.mu.Lock()
+	g.redirect = args.Addr
+	g.mu.Unlock()
+	if !s.redialForClientLocked() {
+		s.peer.sessHub.delete(s.ID())
+		s.cancelCallCmds("migrate failed")
+		s.changeStatus(statusPassiveClosed)
+		s.notifyClosed()
+		s.forgetResume()
+		s.leaveGroups()
+		s.peer.pluginContainer.postDisconnect(s)
+		return
+	}
+	for _, cmd := range unaccepted {
+		s.rewriteCall(cmd)
+	}
+}
+
+// goAwayCallCmds returns the written callCmds waiting for a reply,
+// divided by whether they are accepted by the GOAWAY.
+func (s *session) goAwayCallCmds(lastSeq int32) (accepted, unaccepted []*callCmd) {
+	s.callCmdMap.Range(func(_, v interface{}) bool {
+		cmd := v.(*callCmd)
+		if atomic.LoadInt32(&cmd.written) != 1 {
+			return true
+		}
+		if cmd.output.Seq() <= lastSeq {
+			accepted = append(accepted, cmd)
+		} else {
+			unaccepted = append(unaccepted, cmd)
+		}
+		return true
+	})
+	return
+}
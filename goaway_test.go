@@ -0,0 +1,67 @@
+package erpc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+)
+
+type goAwayCall struct {
+	erpc.CallCtx
+}
+
+// Slow replies the server address after the milliseconds.
+func (g *goAwayCall) Slow(arg *int) (string, *erpc.Status) {
+	time.Sleep(time.Duration(*arg) * time.Millisecond)
+	return g.Session().LocalAddr().String(), nil
+}
+
+func TestGoAway(t *testing.T) {
+	var (
+		srvs  []erpc.Peer
+		addrs []string
+	)
+	for i := 0; i < 2; i++ {
+		srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+		srv.RouteCall(new(goAwayCall))
+		addrs = append(addrs, memtest.Serve(srv))
+		defer srv.Close()
+		srvs = append(srvs, srv)
+	}
+
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network, RedialTimes: 1})
+	defer cli.Close()
+	sess, stat := cli.Dial(addrs[0])
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	var inflight string
+	cmd := sess.AsyncCall("/go_away_call/slow", 200, &inflight, nil)
+	time.Sleep(time.Millisecond * 50)
+
+	srvs[0].GoAway(addrs[1])
+	var migrated string
+	if stat = sess.Call("/go_away_call/slow", 0, &migrated).Status(); !stat.OK() {
+		t.Fatal(stat)
+	}
+	if migrated != addrs[1] {
+		t.Fatalf("want the new CALL on the alternate server, got: %s", migrated)
+	}
+	<-cmd.Done()
+	if !cmd.StatusOK() || inflight != addrs[0] {
+		t.Fatalf("want the in-flight CALL finished on the draining server, got: %q, %v", inflight, cmd.Status())
+	}
+	if sess.RemoteAddr().String() != addrs[1] || !sess.Health() {
+		t.Fatalf("want the session migrated, got: %s", sess.RemoteAddr())
+	}
+
+	// redial the original address without the alternate one
+	srvs[1].GoAway("")
+	time.Sleep(time.Millisecond * 100)
+	if stat = sess.Call("/go_away_call/slow", 0, &migrated).Status(); !stat.OK() || migrated != addrs[0] {
+		t.Fatalf("want the CALL on the original server, got: %q, %v", migrated, stat)
+	}
+}
@@ -60,6 +60,10 @@ func shutdown() error {
 	for _, p := range list {
 		count++
 		go func(peer *peer) {
+			// stop accepting before the GOAWAY, so that the migrating clients do not reconnect to the peer,
+			// then drain the sessions by closing them gracefully
+			peer.stopListening()
+			peer.GoAway(peer.goAwayAddr)
 			errCh <- peer.Close()
 		}(p)
 	}
@@ -0,0 +1,277 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erpc
+
+import (
+	"sync"
+
+	"github.com/andeya/erpc/v7/codec"
+)
+
+// SessionGroup a named group of sessions, such as a chat room or a topic.
+// NOTE:
+//
+//	The sessions leave all groups automatically after disconnection;
+//	A suspended session for resumption stays in its groups.
+type SessionGroup struct {
+	name     string
+	peer     *peer
+	mu       sync.RWMutex
+	sessions map[*session]struct{}
+}
+
+// BroadcastResult the result of a broadcast.
+type BroadcastResult struct {
+	// Sent is the number of sessions the message was written to.
+	Sent int
+	// Failures are the sessions failed to write to.
+	Failures []BroadcastFailure
+}
+
+// BroadcastFailure a recipient failed to write to.
+type BroadcastFailure struct {
+	Session Session
+	Status  *Status
+}
+
+// OK returns whether the message was written to all the recipients.
+func (r *BroadcastResult) OK() bool {
+	return len(r.Failures) == 0
+}
+
+// maxBroadcastConcurrency is the maximum number of sessions written concurrently by a broadcast.
+const maxBroadcastConcurrency = 256
+
+// Group returns the session group by name, and creates it if it does not exist.
+func (p *peer) Group(name string) *SessionGroup {
+	if g, ok := p.groups.Load(name); ok {
+		return g.(*SessionGroup)
+	}
+	g, _ := p.groups.LoadOrStore(name, &SessionGroup{
+		name:     name,
+		peer:     p,
+		sessions: make(map[*session]struct{}),
+	})
+	return g.(*SessionGroup)
+}
+
+// RemoveGroup removes the session group, and its sessions leave it.
+func (p *peer) RemoveGroup(name string) {
+	g, ok := p.groups.Load(name)
+	if !ok {
+		return
+	}
+	p.groups.Delete(name)
+	g.(*SessionGroup).clear()
+}
+
+// Broadcast sends a TypePush message to the sessions of the group.
+// NOTE: It does nothing if the group does not exist.
+func (p *peer) Broadcast(group, serviceMethod string, body interface{}, setting ...MessageSetting) *BroadcastResult {
+	g, ok := p.groups.Load(group)
+	if !ok {
+		return new(BroadcastResult)
+	}
+	return g.(*SessionGroup).Broadcast(serviceMethod, body, setting...)
+}
+
+// Name returns the group name.
+func (g *SessionGroup) Name() string {
+	return g.name
+}
+
+// Join adds the session to the group.
+// It returns false if the session does not belong to the peer of the group, or it has been closed.
+func (g *SessionGroup) Join(sess CtxSession) bool {
+	s, ok := sess.(*session)
+	if !ok || s.peer != g.peer {
+		return false
+	}
+	s.groupsLock.Lock()
+	defer s.groupsLock.Unlock()
+	if s.groupsClosed {
+		return false
+	}
+	if s.groups == nil {
+		s.groups = make(map[*SessionGroup]struct{})
+	}
+	s.groups[g] = struct{}{}
+	g.mu.Lock()
+	g.sessions[s] = struct{}{}
+	g.mu.Unlock()
+	return true
+}
+
+// Leave removes the session from the group.
+func (g *SessionGroup) Leave(sess CtxSession) {
+	s, ok := sess.(*session)
+	if !ok {
+		return
+	}
+	s.groupsLock.Lock()
+	delete(s.groups, g)
+	s.groupsLock.Unlock()
+	g.remove(s)
+}
+
+// Has returns whether the session is in the group.
+func (g *SessionGroup) Has(sess CtxSession) bool {
+	s, ok := sess.(*session)
+	if !ok {
+		return false
+	}
+	g.mu.RLock()
+	_, ok = g.sessions[s]
+	g.mu.RUnlock()
+	return ok
+}
+
+// Len returns the number of sessions in the group.
+func (g *SessionGroup) Len() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.sessions)
+}
+
+// Range ranges the sessions of the group.
+// If fn returns false, stop traversing.
+func (g *SessionGroup) Range(fn func(sess Session) bool) {
+	for _, s := range g.snapshot() {
+		if !fn(s) {
+			return
+		}
+	}
+}
+
+// Broadcast sends a TypePush message to the sessions of the group.
+// NOTE:
+//
+//	The body is encoded once per body codec, and shared by the recipients;
+//	The messages are written concurrently, and it returns after all the writes are finished.
+func (g *SessionGroup) Broadcast(serviceMethod string, body interface{}, setting ...MessageSetting) *BroadcastResult {
+	return g.BroadcastFilter(nil, serviceMethod, body, setting...)
+}
+
+// BroadcastFilter sends a TypePush message to the sessions of the group for which filter returns true.
+// NOTE: All the sessions are the recipients if filter is nil.
+func (g *SessionGroup) BroadcastFilter(filter func(sess Session) bool, serviceMethod string, body interface{}, setting ...MessageSetting) *BroadcastResult {
+	var recipients []*session
+	for _, s := range g.snapshot() {
+		if filter == nil || filter(s) {
+			recipients = append(recipients, s)
+		}
+	}
+	var (
+		result  = new(BroadcastResult)
+		shared  = newSharedBody(body)
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		limitCh = make(chan struct{}, maxBroadcastConcurrency)
+	)
+	wg.Add(len(recipients))
+	for _, s := range recipients {
+		s := s
+		limitCh <- struct{}{}
+		AnywayGo(func() {
+			defer func() {
+				<-limitCh
+				wg.Done()
+			}()
+			stat := s.push(serviceMethod, body, shared, setting)
+			mu.Lock()
+			if stat.OK() {
+				result.Sent++
+			} else {
+				result.Failures = append(result.Failures, BroadcastFailure{Session: s, Status: stat})
+			}
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	return result
+}
+
+func (g *SessionGroup) snapshot() []*session {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	list := make([]*session, 0, len(g.sessions))
+	for s := range g.sessions {
+		list = append(list, s)
+	}
+	return list
+}
+
+func (g *SessionGroup) remove(s *session) {
+	g.mu.Lock()
+	delete(g.sessions, s)
+	g.mu.Unlock()
+}
+
+func (g *SessionGroup) clear() {
+	for _, s := range g.snapshot() {
+		g.Leave(s)
+	}
+}
+
+// leaveGroups removes the session from all its groups, and prevents it from joining again.
+func (s *session) leaveGroups() {
+	s.groupsLock.Lock()
+	groups := s.groups
+	s.groups = nil
+	s.groupsClosed = true
+	s.groupsLock.Unlock()
+	for g := range groups {
+		g.remove(s)
+	}
+}
+
+// sharedBody the body encoded once per body codec.
+type sharedBody struct {
+	body    interface{}
+	mu      sync.Mutex
+	encoded map[byte]encodedBody
+}
+
+type encodedBody struct {
+	b   []byte
+	err error
+}
+
+// newSharedBody returns nil if the body needs no encoding.
+func newSharedBody(body interface{}) *sharedBody {
+	switch body.(type) {
+	case nil, []byte, *[]byte:
+		return nil
+	}
+	return &sharedBody{body: body, encoded: make(map[byte]encodedBody, 1)}
+}
+
+// encode returns the encoding of the body with the codec.
+func (b *sharedBody) encode(codecID byte) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.encoded[codecID]; ok {
+		return e.b, e.err
+	}
+	var e encodedBody
+	c, err := codec.Get(codecID)
+	if err == nil {
+		e.b, e.err = c.Marshal(b.body)
+	} else {
+		e.err = err
+	}
+	b.encoded[codecID] = e
+	return e.b, e.err
+}
@@ -0,0 +1,160 @@
+package erpc_test
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/codec"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+)
+
+// countCodec is a JSON codec counting the marshaling.
+type countCodec struct {
+	marshals int32
+}
+
+func (*countCodec) Name() string { return "count_json" }
+
+func (*countCodec) ID() byte { return 'C' }
+
+func (c *countCodec) Marshal(v interface{}) ([]byte, error) {
+	atomic.AddInt32(&c.marshals, 1)
+	return json.Marshal(v)
+}
+
+func (*countCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+var groupCodec = new(countCodec)
+
+func init() {
+	codec.Reg(groupCodec)
+}
+
+func groupJoin(ctx erpc.CallCtx, arg *string) (bool, *erpc.Status) {
+	if *arg == "reject" {
+		ctx.Session().Swap().Store("reject", true)
+	}
+	return ctx.Peer().Group("room").Join(ctx.Session()), nil
+}
+
+// rejectPushPlugin fails the PUSH to the sessions marked as reject.
+type rejectPushPlugin struct{}
+
+func (rejectPushPlugin) Name() string { return "reject_push" }
+
+func (rejectPushPlugin) PreWritePush(ctx erpc.WriteCtx) *erpc.Status {
+	if _, ok := ctx.Session().Swap().Load("reject"); ok {
+		return erpc.NewStatus(erpc.CodeWriteFailed, "rejected", "")
+	}
+	return nil
+}
+
+type groupReceiver struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (r *groupReceiver) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msgs := append([]string(nil), r.msgs...)
+	sort.Strings(msgs) // the PUSH handlers run concurrently
+	return msgs
+}
+
+func TestSessionGroup(t *testing.T) {
+	srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network}, rejectPushPlugin{})
+	srv.RouteCallFunc(groupJoin)
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+
+	var (
+		names     = []string{"a", "b", "c", "reject"}
+		receivers = make(map[string]*groupReceiver)
+		sessions  = make(map[string]erpc.Session)
+	)
+	for _, name := range names {
+		r := new(groupReceiver)
+		cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+		defer cli.Close()
+		cli.RoutePushFunc(func(ctx erpc.PushCtx, arg *string) *erpc.Status {
+			r.mu.Lock()
+			r.msgs = append(r.msgs, *arg)
+			r.mu.Unlock()
+			return nil
+		})
+		sess, stat := cli.Dial(addr)
+		if !stat.OK() {
+			t.Fatal(stat)
+		}
+		var joined bool
+		if stat = sess.Call("/group_join", name, &joined).Status(); !stat.OK() || !joined {
+			t.Fatalf("join: %v, %v", joined, stat)
+		}
+		receivers[name], sessions[name] = r, sess
+	}
+	room := srv.Group("room")
+	if n := room.Len(); n != len(names) {
+		t.Fatalf("want %d sessions in the group, got: %d", len(names), n)
+	}
+
+	// the body is encoded once, and the failure is reported per recipient
+	atomic.StoreInt32(&groupCodec.marshals, 0)
+	result := srv.Broadcast("room", "/func1", "hello", erpc.WithBodyCodec(groupCodec.ID()))
+	if result.Sent != 3 || len(result.Failures) != 1 {
+		t.Fatalf("want 3 sent and 1 failure, got: %d sent, %v", result.Sent, result.Failures)
+	}
+	if stat := result.Failures[0].Status; stat.Msg() != "rejected" {
+		t.Fatalf("want rejected, got: %v", stat)
+	}
+	if n := atomic.LoadInt32(&groupCodec.marshals); n != 1 {
+		t.Fatalf("want the body encoded once, got: %d", n)
+	}
+
+	// the filter excludes the session c
+	excluded := sessions["c"].LocalAddr().String()
+	result = room.BroadcastFilter(func(sess erpc.Session) bool {
+		return sess.RemoteAddr().String() != excluded
+	}, "/func1", "filtered")
+	if result.Sent != 2 {
+		t.Fatalf("want 2 sent, got: %d, %v", result.Sent, result.Failures)
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	want := map[string][]string{
+		"a":      {"filtered", "hello"},
+		"b":      {"filtered", "hello"},
+		"c":      {"hello"},
+		"reject": nil,
+	}
+	for name, msgs := range want {
+		got := receivers[name].received()
+		if len(got) != len(msgs) {
+			t.Fatalf("%s: want %v, got: %v", name, msgs, got)
+		}
+		for i := range msgs {
+			if got[i] != msgs[i] {
+				t.Fatalf("%s: want %v, got: %v", name, msgs, got)
+			}
+		}
+	}
+
+	// leave the group automatically after disconnection
+	sessions["a"].Close()
+	time.Sleep(time.Millisecond * 100)
+	if n := room.Len(); n != len(names)-1 {
+		t.Fatalf("want %d sessions after disconnection, got: %d", len(names)-1, n)
+	}
+	srv.RemoveGroup("room")
+	if n := room.Len(); n != 0 {
+		t.Fatalf("want empty group after removal, got: %d", n)
+	}
+}
@@ -25,9 +25,11 @@ import (
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/andeya/erpc/v7/codec"
+	"github.com/andeya/erpc/v7/kcp"
 	"github.com/andeya/erpc/v7/socket"
 	"github.com/andeya/erpc/v7/utils"
 	"github.com/andeya/goutil/pool"
@@ -49,9 +51,36 @@ func SetGopool(maxGoroutinesAmount int, maxGoroutineIdleDuration time.Duration)
 	_gopool = pool.NewGoPool(_maxGoroutinesAmount, _maxGoroutineIdleDuration)
 }
 
+// GopoolStat the runtime statistics of the goroutine pool.
+type GopoolStat struct {
+	Running             int64         `json:"running"` // the number of the functions being executed
+	MaxGoroutinesAmount int           `json:"max_goroutines_amount"`
+	MaxGoroutineIdle    time.Duration `json:"max_goroutine_idle"`
+}
+
+// GetGopoolStat returns the runtime statistics of the goroutine pool set by SetGopool.
+func GetGopoolStat() GopoolStat {
+	return GopoolStat{
+		Running:             atomic.LoadInt64(&_gopoolRunning),
+		MaxGoroutinesAmount: _gopool.MaxGoroutinesAmount(),
+		MaxGoroutineIdle:    _gopool.MaxGoroutineIdle(),
+	}
+}
+
+var _gopoolRunning int64
+
+// countRunning counts the running function of the goroutine pool.
+func countRunning(fn func()) func() {
+	return func() {
+		atomic.AddInt64(&_gopoolRunning, 1)
+		defer atomic.AddInt64(&_gopoolRunning, -1)
+		fn()
+	}
+}
+
 // Go similar to go func, but return false if insufficient resources.
 func Go(fn func()) bool {
-	if err := _gopool.Go(fn); err != nil {
+	if err := _gopool.Go(countRunning(fn)); err != nil {
 		Warnf("%s", err.Error())
 		return false
 	}
@@ -60,19 +89,19 @@ func Go(fn func()) bool {
 
 // AnywayGo similar to go func, but concurrent resources are limited.
 func AnywayGo(fn func()) {
-	_gopool.MustGo(fn)
+	_gopool.MustGo(countRunning(fn))
 }
 
 // MustGo always try to use goroutine callbacks
 // until execution is complete or the context is canceled.
 func MustGo(fn func(), ctx ...context.Context) error {
-	return _gopool.MustGo(fn, ctx...)
+	return _gopool.MustGo(countRunning(fn), ctx...)
 }
 
 // TryGo tries to execute the function via goroutine.
 // If there are no concurrent resources, execute it synchronously.
 func TryGo(fn func()) {
-	_gopool.TryGo(fn)
+	_gopool.TryGo(countRunning(fn))
 }
 
 var printPidOnce sync.Once
@@ -335,3 +364,18 @@ func (f *FakeAddr) Host() string {
 func (f *FakeAddr) Port() string {
 	return f.port
 }
+
+// KCPStats returns the statistics of the session on KCP network.
+// NOTE:
+//
+//	The packet and retransmission counters of the session require KCPConfig.SessionStats;
+//	The process-wide counters are returned by kcp.GlobalStats().
+func KCPStats(sess BaseSession) (*kcp.Stats, bool) {
+	s, ok := sess.(*session)
+	if !ok {
+		return nil, false
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return kcp.SessionStats(s.getConn())
+}
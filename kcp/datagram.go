@@ -0,0 +1,237 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	kcp "github.com/xtaci/kcp-go/v5"
+)
+
+// datagramMagic prefixes the raw UDP datagrams sent next to the KCP packets.
+// NOTE:
+//
+//	Its 5th byte is neither a KCP command nor a FEC packet type,
+//	so it never matches a plain KCP or FEC packet.
+var datagramMagic = []byte{0xff, 0xfe, 0xfd, 0xfc, 'E', 'R', 'P', 'C'}
+
+const (
+	nonceSize       = 16
+	crcSize         = 4
+	cryptHeaderSize = nonceSize + crcSize
+	datagramQueue   = 128
+)
+
+var (
+	errDatagramTooLarge = errors.New("kcp: datagram too large")
+	errBadDatagram      = errors.New("kcp: bad datagram")
+)
+
+// datagramPacketConn filters the datagrams out of the KCP packets,
+// and dispatches them to the sessions by the remote address.
+type datagramPacketConn struct {
+	net.PacketConn
+	block  BlockCrypt
+	mu     sync.RWMutex
+	queues map[string]chan []byte
+}
+
+func newDatagramPacketConn(conn net.PacketConn, block BlockCrypt) *datagramPacketConn {
+	return &datagramPacketConn{
+		PacketConn: conn,
+		block:      block,
+		queues:     make(map[string]chan []byte),
+	}
+}
+
+// ReadFrom reads the next KCP packet.
+func (c *datagramPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil || !bytes.HasPrefix(b[:n], datagramMagic) {
+			return n, addr, err
+		}
+		c.dispatch(addr, b[len(datagramMagic):n])
+	}
+}
+
+func (c *datagramPacketConn) dispatch(addr net.Addr, data []byte) {
+	c.mu.RLock()
+	queue := c.queues[addr.String()]
+	c.mu.RUnlock()
+	if queue == nil {
+		return
+	}
+	data, err := c.decrypt(data)
+	if err != nil {
+		return
+	}
+	select {
+	case queue <- append([]byte(nil), data...):
+	default: // drop it like the network does
+	}
+}
+
+func (c *datagramPacketConn) decrypt(data []byte) ([]byte, error) {
+	if c.block == nil {
+		return data, nil
+	}
+	if len(data) < cryptHeaderSize {
+		return nil, errBadDatagram
+	}
+	c.block.Decrypt(data, data)
+	payload := data[cryptHeaderSize:]
+	if binary.LittleEndian.Uint32(data[nonceSize:]) != crc32.ChecksumIEEE(payload) {
+		return nil, errBadDatagram
+	}
+	return payload, nil
+}
+
+func (c *datagramPacketConn) send(addr net.Addr, b []byte) error {
+	headerSize := len(datagramMagic)
+	if c.block != nil {
+		headerSize += cryptHeaderSize
+	}
+	buf := make([]byte, headerSize+len(b))
+	copy(buf, datagramMagic)
+	copy(buf[headerSize:], b)
+	if c.block != nil {
+		header := buf[len(datagramMagic):]
+		if _, err := rand.Read(header[:nonceSize]); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(header[nonceSize:], crc32.ChecksumIEEE(b))
+		c.block.Encrypt(header, header)
+	}
+	_, err := c.PacketConn.WriteTo(buf, addr)
+	return err
+}
+
+func (c *datagramPacketConn) register(addr net.Addr) chan []byte {
+	queue := make(chan []byte, datagramQueue)
+	c.mu.Lock()
+	c.queues[addr.String()] = queue
+	c.mu.Unlock()
+	return queue
+}
+
+func (c *datagramPacketConn) unregister(addr net.Addr, queue chan []byte) {
+	key := addr.String()
+	c.mu.Lock()
+	if c.queues[key] == queue {
+		delete(c.queues, key)
+	}
+	c.mu.Unlock()
+}
+
+// DatagramSession is a KCP session that can also send unreliable datagrams.
+type DatagramSession struct {
+	*Session
+	conn      *datagramPacketConn
+	queue     chan []byte
+	maxSize   int
+	ownConn   bool
+	announced int32 // 1 after the session is known by the peer
+	die       chan struct{}
+	closeOnce sync.Once
+}
+
+func newDatagramSession(sess *Session, conn *datagramPacketConn, opts *Options, ownConn bool) *DatagramSession {
+	mtu := opts.MTU
+	if mtu <= 0 {
+		mtu = kcp.IKCP_MTU_DEF
+	}
+	maxSize := mtu - len(datagramMagic)
+	if conn.block != nil {
+		maxSize -= cryptHeaderSize
+	}
+	s := &DatagramSession{
+		Session: sess,
+		conn:    conn,
+		queue:   conn.register(sess.RemoteAddr()),
+		maxSize: maxSize,
+		ownConn: ownConn,
+		die:     make(chan struct{}),
+	}
+	if !ownConn {
+		s.announced = 1
+	}
+	return s
+}
+
+// Write writes data to the connection.
+func (s *DatagramSession) Write(b []byte) (n int, err error) {
+	n, err = s.UDPSession.Write(b)
+	if n > 0 && atomic.LoadInt32(&s.announced) == 0 {
+		atomic.StoreInt32(&s.announced, 1)
+	}
+	return n, err
+}
+
+// SendDatagram sends b as an unreliable datagram.
+func (s *DatagramSession) SendDatagram(b []byte) error {
+	if len(b) > s.maxSize {
+		return errDatagramTooLarge
+	}
+	select {
+	case <-s.die:
+		return net.ErrClosed
+	default:
+	}
+	return s.conn.send(s.RemoteAddr(), b)
+}
+
+// ReceiveDatagram receives the next datagram.
+func (s *DatagramSession) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	select {
+	case b := <-s.queue:
+		return b, nil
+	case <-s.die:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// MaxDatagramSize returns the maximum payload size of a datagram, it is below the KCP MTU.
+// NOTE:
+//
+//	The server accepts the session after the first KCP packet,
+//	so it returns 0 on the client side before the first write.
+func (s *DatagramSession) MaxDatagramSize() int {
+	if atomic.LoadInt32(&s.announced) == 0 {
+		return 0
+	}
+	return s.maxSize
+}
+
+// Close closes the session.
+func (s *DatagramSession) Close() error {
+	err := net.ErrClosed
+	s.closeOnce.Do(func() {
+		close(s.die)
+		s.conn.unregister(s.RemoteAddr(), s.queue)
+		err = s.Session.Close()
+	})
+	return err
+}
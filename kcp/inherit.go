@@ -16,11 +16,19 @@ import (
 // It returns an inherited net.Listener for the matching network and address, or
 // creates a new one using net.Listen.
 func InheritedListen(network, laddr string, tlsConf *tls.Config, dataShards, parityShards int) (net.Listener, error) {
+	return InheritedListenWithOptions(network, laddr, tlsConf, &Options{DataShards: dataShards, ParityShards: parityShards})
+}
+
+// InheritedListenWithOptions announces on the local network address laddr with the session options.
+// The network net is "KCP".
+// It returns an inherited net.Listener for the matching network and address, or
+// creates a new one using net.Listen.
+func InheritedListenWithOptions(network, laddr string, tlsConf *tls.Config, opts *Options) (net.Listener, error) {
 	udpAddr, err := net.ResolveUDPAddr(network, laddr)
 	if err != nil {
 		return nil, err
 	}
-	return globalInheritKCP.InheritedListen(network, udpAddr, tlsConf, dataShards, parityShards)
+	return globalInheritKCP.InheritedListen(network, udpAddr, tlsConf, opts)
 }
 
 // SetInherited adds the files and envs to be inherited by the new process.
@@ -101,7 +109,7 @@ func (n *inheritKCP) inherit() error {
 // InheritedListen announces on the local network address laddr.
 // It returns an inherited net.Listener for the matching address,
 // or creates a new one.
-func (n *inheritKCP) InheritedListen(network string, udpAddr *net.UDPAddr, tlsConf *tls.Config, dataShards, parityShards int) (*Listener, error) {
+func (n *inheritKCP) InheritedListen(network string, udpAddr *net.UDPAddr, tlsConf *tls.Config, opts *Options) (*Listener, error) {
 	if err := n.inherit(); err != nil {
 		return nil, err
 	}
@@ -126,9 +134,9 @@ func (n *inheritKCP) InheritedListen(network string, udpAddr *net.UDPAddr, tlsCo
 	var l *Listener
 	var err error
 	if udpConn == nil {
-		l, err = ListenUDPAddr(network, udpAddr, tlsConf, dataShards, parityShards)
+		l, err = ListenUDPAddrWithOptions(network, udpAddr, tlsConf, opts)
 	} else {
-		l, err = Listen(udpConn, tlsConf, dataShards, parityShards)
+		l, err = ListenWithOptions(udpConn, tlsConf, opts)
 	}
 	if err != nil {
 		return nil, err
@@ -16,6 +16,17 @@ type (
 // It uses a new UDP connection and closes this connection when the KCP session is closed.
 // The hostname for SNI is taken from the given address.
 func DialAddrContext(network string, laddr *net.UDPAddr, raddr string, tlsConf *tls.Config, dataShards, parityShards int) (net.Conn, error) {
+	return DialAddrContextWithOptions(network, laddr, raddr, tlsConf, &Options{DataShards: dataShards, ParityShards: parityShards})
+}
+
+// DialAddrContextWithOptions establishes a new KCP connection to a server with the session options.
+// It uses a new UDP connection and closes this connection when the KCP session is closed.
+// The hostname for SNI is taken from the given address.
+// NOTE: The connection is a *Session, *DatagramSession or a TLS connection over *Session.
+func DialAddrContextWithOptions(network string, laddr *net.UDPAddr, raddr string, tlsConf *tls.Config, opts *Options) (net.Conn, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
 	host, port, err := net.SplitHostPort(raddr)
 	if err != nil {
 		return nil, err
@@ -31,35 +42,70 @@ func DialAddrContext(network string, laddr *net.UDPAddr, raddr string, tlsConf *
 	if err != nil {
 		return nil, err
 	}
-	conn, err := kcp.NewConn2(addr, nil, dataShards, parityShards, udpConn)
+	var (
+		packetConn net.PacketConn = udpConn
+		counters   *sessionCounters
+		dgConn     *datagramPacketConn
+	)
+	if opts.SessionStats {
+		statsConn := newStatsPacketConn(udpConn, opts)
+		counters = statsConn.register(addr)
+		packetConn = statsConn
+	}
+	if opts.Datagram && tlsConf == nil {
+		dgConn = newDatagramPacketConn(packetConn, opts.Block)
+		packetConn = dgConn
+	}
+	conn, err := kcp.NewConn2(addr, opts.Block, opts.DataShards, opts.ParityShards, packetConn)
 	if err != nil {
+		udpConn.Close()
 		return nil, err
 	}
+	opts.apply(conn)
+	sess := newSession(conn, counters, func() { udpConn.Close() })
+	if dgConn != nil {
+		return newDatagramSession(sess, dgConn, opts, true), nil
+	}
 	if tlsConf != nil {
-		return tls.Client(conn, tlsConf), nil
+		return tls.Client(sess, tlsConf), nil
 	}
-	return conn, nil
+	return sess, nil
 }
 
 // Listener defines a server which will be waiting to accept incoming connections
 type Listener struct {
 	*kcp.Listener
-	tlsConf *tls.Config
-	conn    net.PacketConn
+	tlsConf   *tls.Config
+	conn      net.PacketConn
+	statsConn *statsPacketConn
+	dgConn    *datagramPacketConn
+	opts      *Options
 }
 
 var _ net.Listener = (*Listener)(nil)
 
 // Accept implements the Accept method in the Listener interface; it waits for the next call and returns a generic Conn.
 func (l *Listener) Accept() (net.Conn, error) {
-	conn, err := l.Listener.Accept()
+	conn, err := l.Listener.AcceptKCP()
 	if err != nil {
 		return nil, err
 	}
+	l.opts.apply(conn)
+	var sess *Session
+	if l.statsConn != nil {
+		addr := conn.RemoteAddr()
+		counters := l.statsConn.register(addr)
+		sess = newSession(conn, counters, func() { l.statsConn.unregister(addr, counters) })
+	} else {
+		sess = newSession(conn, nil, nil)
+	}
+	if l.dgConn != nil {
+		return newDatagramSession(sess, l.dgConn, l.opts, false), nil
+	}
 	if l.tlsConf == nil {
-		return conn, nil
+		return sess, nil
 	}
-	return tls.Server(conn, l.tlsConf), nil
+	return tls.Server(sess, l.tlsConf), nil
 }
 
 // PacketConn returns the net.PacketConn.
@@ -69,28 +115,59 @@ func (l *Listener) PacketConn() net.PacketConn {
 
 // ListenAddr announces on the local network address addr.
 func ListenAddr(network, addr string, tlsConf *tls.Config, dataShards, parityShards int) (*Listener, error) {
+	return ListenAddrWithOptions(network, addr, tlsConf, &Options{DataShards: dataShards, ParityShards: parityShards})
+}
+
+// ListenAddrWithOptions announces on the local network address addr with the session options.
+func ListenAddrWithOptions(network, addr string, tlsConf *tls.Config, opts *Options) (*Listener, error) {
 	udpAddr, err := net.ResolveUDPAddr(network, addr)
 	if err != nil {
 		return nil, err
 	}
-	return ListenUDPAddr(network, udpAddr, tlsConf, dataShards, parityShards)
+	return ListenUDPAddrWithOptions(network, udpAddr, tlsConf, opts)
 }
 
 // ListenUDPAddr announces on the local network address udpAddr.
 func ListenUDPAddr(network string, udpAddr *net.UDPAddr, tlsConf *tls.Config, dataShards, parityShards int) (*Listener, error) {
+	return ListenUDPAddrWithOptions(network, udpAddr, tlsConf, &Options{DataShards: dataShards, ParityShards: parityShards})
+}
+
+// ListenUDPAddrWithOptions announces on the local network address udpAddr with the session options.
+func ListenUDPAddrWithOptions(network string, udpAddr *net.UDPAddr, tlsConf *tls.Config, opts *Options) (*Listener, error) {
 	var conn net.PacketConn
 	conn, err := net.ListenUDP(network, udpAddr)
 	if err != nil {
 		return nil, err
 	}
-	return Listen(conn, tlsConf, dataShards, parityShards)
+	return ListenWithOptions(conn, tlsConf, opts)
 }
 
 // Listen listens for KCP connections on a given net.PacketConn.
 func Listen(conn net.PacketConn, tlsConf *tls.Config, dataShards, parityShards int) (*Listener, error) {
-	lis, err := kcp.ServeConn(nil, dataShards, parityShards, conn)
+	return ListenWithOptions(conn, tlsConf, &Options{DataShards: dataShards, ParityShards: parityShards})
+}
+
+// ListenWithOptions listens for KCP connections on a given net.PacketConn with the session options.
+func ListenWithOptions(conn net.PacketConn, tlsConf *tls.Config, opts *Options) (*Listener, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	var (
+		packetConn = conn
+		statsConn  *statsPacketConn
+		dgConn     *datagramPacketConn
+	)
+	if opts.SessionStats {
+		statsConn = newStatsPacketConn(conn, opts)
+		packetConn = statsConn
+	}
+	if opts.Datagram && tlsConf == nil {
+		dgConn = newDatagramPacketConn(packetConn, opts.Block)
+		packetConn = dgConn
+	}
+	lis, err := kcp.ServeConn(opts.Block, opts.DataShards, opts.ParityShards, packetConn)
 	if err != nil {
 		return nil, err
 	}
-	return &Listener{Listener: lis, tlsConf: tlsConf, conn: conn}, nil
+	return &Listener{Listener: lis, tlsConf: tlsConf, conn: conn, statsConn: statsConn, dgConn: dgConn, opts: opts}, nil
 }
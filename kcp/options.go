@@ -0,0 +1,157 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kcp
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	kcp "github.com/xtaci/kcp-go/v5"
+)
+
+type (
+	// BlockCrypt defines the encryption/decryption method of the KCP packets
+	BlockCrypt = kcp.BlockCrypt
+	// Snmp defines the process-wide KCP counters
+	Snmp = kcp.Snmp
+)
+
+// Options KCP session options
+// NOTE:
+//
+//	The zero value of the window sizes, MTU and Interval means using the kcp-go defaults;
+//	DataShards=0 and ParityShards=0 means disabling FEC.
+type Options struct {
+	// DataShards and ParityShards are the forward error correction shards.
+	DataShards, ParityShards int
+	// NoDelay enables the nodelay mode.
+	NoDelay bool
+	// Interval is the internal update timer interval in millisecond.
+	Interval int
+	// Resend enables the fast resend after the number of ACK skips, 0 means off.
+	Resend int
+	// NoCongestion disables the congestion control.
+	NoCongestion bool
+	// SndWnd and RcvWnd are the maximum window sizes in packets.
+	SndWnd, RcvWnd int
+	// MTU is the maximum transmission unit, it does not include the UDP header.
+	MTU int
+	// AckNoDelay flushes the ACK immediately when a packet is received.
+	AckNoDelay bool
+	// Block is the packet encryption method, nil means no encryption.
+	Block BlockCrypt
+	// Datagram enables the unreliable datagrams sent as raw UDP packets next to the KCP packets.
+	// NOTE: It is ignored when TLS is used, since the datagrams can not be protected by TLS.
+	Datagram bool
+	// SessionStats enables the packet and segment counters of each session, see Session.Stats.
+	// NOTE: It costs the batched UDP I/O, and one more decryption of each sent packet if Block is set.
+	SessionStats bool
+}
+
+// DefaultOptions returns the default KCP options.
+func DefaultOptions() *Options {
+	return &Options{DataShards: 10, ParityShards: 3}
+}
+
+var presets = map[string]Options{
+	"normal": {DataShards: 10, ParityShards: 3, Interval: 40, Resend: 2, NoCongestion: true},
+	"fast":   {DataShards: 10, ParityShards: 3, Interval: 30, Resend: 2, NoCongestion: true},
+	"fast2":  {DataShards: 10, ParityShards: 3, NoDelay: true, Interval: 20, Resend: 2, NoCongestion: true},
+	"fast3":  {DataShards: 10, ParityShards: 3, NoDelay: true, Interval: 10, Resend: 2, NoCongestion: true},
+	// turbo is for the lossy networks, such as the mobile networks
+	"turbo": {DataShards: 10, ParityShards: 3, NoDelay: true, Interval: 10, Resend: 2, NoCongestion: true,
+		SndWnd: 1024, RcvWnd: 1024, AckNoDelay: true},
+	// lan is for the reliable low-latency links, FEC is off
+	"lan": {NoDelay: true, Interval: 20, Resend: 2, NoCongestion: true, SndWnd: 512, RcvWnd: 512},
+}
+
+// Preset returns a copy of the named options preset.
+// The presets are: normal, fast, fast2, fast3, turbo and lan.
+func Preset(name string) (*Options, bool) {
+	o, ok := presets[name]
+	if !ok {
+		return nil, false
+	}
+	return &o, true
+}
+
+// NewBlockCrypt creates the packet encryption method by name, the key is derived from the passphrase.
+// The names are: aes, aes-128, aes-192, salsa20, blowfish, twofish, cast5, 3des, tea, xtea, xor, sm4 and none.
+func NewBlockCrypt(name, passphrase string) (BlockCrypt, error) {
+	key := sha256.Sum256([]byte(passphrase))
+	switch name {
+	case "aes":
+		return kcp.NewAESBlockCrypt(key[:])
+	case "aes-128":
+		return kcp.NewAESBlockCrypt(key[:16])
+	case "aes-192":
+		return kcp.NewAESBlockCrypt(key[:24])
+	case "salsa20":
+		return kcp.NewSalsa20BlockCrypt(key[:])
+	case "blowfish":
+		return kcp.NewBlowfishBlockCrypt(key[:])
+	case "twofish":
+		return kcp.NewTwofishBlockCrypt(key[:])
+	case "cast5":
+		return kcp.NewCast5BlockCrypt(key[:16])
+	case "3des":
+		return kcp.NewTripleDESBlockCrypt(key[:24])
+	case "tea":
+		return kcp.NewTEABlockCrypt(key[:16])
+	case "xtea":
+		return kcp.NewXTEABlockCrypt(key[:16])
+	case "xor":
+		return kcp.NewSimpleXORBlockCrypt(key[:])
+	case "sm4":
+		return kcp.NewSM4BlockCrypt(key[:16])
+	case "none":
+		return kcp.NewNoneBlockCrypt(key[:])
+	default:
+		return nil, errors.New("kcp: unknown crypt " + name)
+	}
+}
+
+func (o *Options) apply(sess *kcp.UDPSession) {
+	if o.NoDelay || o.Interval > 0 || o.Resend > 0 || o.NoCongestion {
+		interval := o.Interval
+		if interval <= 0 {
+			interval = 100
+		}
+		sess.SetNoDelay(boolToInt(o.NoDelay), interval, o.Resend, boolToInt(o.NoCongestion))
+	}
+	if o.SndWnd > 0 || o.RcvWnd > 0 {
+		sndwnd, rcvwnd := o.SndWnd, o.RcvWnd
+		if sndwnd <= 0 {
+			sndwnd = kcp.IKCP_WND_SND
+		}
+		if rcvwnd <= 0 {
+			rcvwnd = kcp.IKCP_WND_RCV
+		}
+		sess.SetWindowSize(sndwnd, rcvwnd)
+	}
+	if o.MTU > 0 {
+		sess.SetMtu(o.MTU)
+	}
+	if o.AckNoDelay {
+		sess.SetACKNoDelay(true)
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,189 @@
+package kcp_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7/kcp"
+)
+
+func TestOptions(t *testing.T) {
+	for _, name := range []string{"turbo", "lan"} {
+		opts, ok := kcp.Preset(name)
+		if !ok {
+			t.Fatalf("preset %s not found", name)
+		}
+		block, err := kcp.NewBlockCrypt("aes-128", "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts.Block = block
+		lis, err := kcp.ListenAddrWithOptions("udp", "127.0.0.1:0", nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+		conn, err := kcp.DialAddrContextWithOptions("udp", nil, lis.Addr().String(), nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := bytes.Repeat([]byte(name), 1000)
+		if _, err = conn.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		got := make([]byte, len(msg))
+		if _, err = io.ReadFull(conn, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("%s: unexpected echo", name)
+		}
+		stats, ok := kcp.SessionStats(conn)
+		if !ok || stats.RTO <= 0 {
+			t.Fatalf("%s: unexpected stats: %+v", name, stats)
+		}
+		conn.Close()
+		lis.Close()
+	}
+	if _, ok := kcp.Preset("unknown"); ok {
+		t.Fatal("expect unknown preset")
+	}
+	if _, err := kcp.NewBlockCrypt("unknown", ""); err == nil {
+		t.Fatal("expect unknown crypt error")
+	}
+}
+
+func TestDatagram(t *testing.T) {
+	block, err := kcp.NewBlockCrypt("salsa20", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &kcp.Options{Datagram: true, Block: block}
+	lis, err := kcp.ListenAddrWithOptions("udp", "127.0.0.1:0", nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	accepted := make(chan *kcp.DatagramSession, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn.(*kcp.DatagramSession)
+		io.Copy(conn, conn)
+	}()
+	conn, err := kcp.DialAddrContextWithOptions("udp", nil, lis.Addr().String(), nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cli := conn.(*kcp.DatagramSession)
+	// the KCP session is created by the first packet
+	if _, err = cli.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	srv := <-accepted
+	defer srv.Close()
+
+	if err = cli.SendDatagram(make([]byte, cli.MaxDatagramSize()+1)); err == nil {
+		t.Fatal("expect datagram too large error")
+	}
+	msg := bytes.Repeat([]byte("d"), cli.MaxDatagramSize())
+	if err = cli.SendDatagram(msg); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	got, err := srv.ReceiveDatagram(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatal("unexpected datagram")
+	}
+	// the reliable stream is not disturbed
+	echo := make([]byte, 5)
+	cli.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err = io.ReadFull(cli, echo); err != nil || string(echo) != "hello" {
+		t.Fatalf("unexpected echo: %q, %v", echo, err)
+	}
+}
+
+// lossyPacketConn drops every 5th packet written.
+type lossyPacketConn struct {
+	net.PacketConn
+	count int64
+}
+
+func (c *lossyPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if atomic.AddInt64(&c.count, 1)%5 == 0 {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func TestSessionStats(t *testing.T) {
+	for _, crypt := range []string{"", "aes"} {
+		var block kcp.BlockCrypt
+		if crypt != "" {
+			var err error
+			if block, err = kcp.NewBlockCrypt(crypt, "secret"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		opts := &kcp.Options{DataShards: 10, ParityShards: 3, NoDelay: true, Interval: 10, Resend: 2, Block: block, SessionStats: true}
+		udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lis, err := kcp.ListenWithOptions(&lossyPacketConn{PacketConn: udpConn}, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+			io.Copy(conn, conn)
+		}()
+		conn, err := kcp.DialAddrContextWithOptions("udp", nil, lis.Addr().String(), nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := bytes.Repeat([]byte("stats"), 1<<15)
+		if _, err = conn.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+		if _, err = io.ReadFull(conn, make([]byte, len(msg))); err != nil {
+			t.Fatal(err)
+		}
+		cliStats := conn.(*kcp.Session).Stats()
+		if cliStats.OutPkts == 0 || cliStats.InPkts == 0 || cliStats.OutSegs == 0 {
+			t.Fatalf("%s: unexpected client stats: %+v", crypt, cliStats)
+		}
+		srvStats, ok := kcp.SessionStats(<-accepted)
+		if !ok || srvStats.OutSegs == 0 || srvStats.RetransSegs == 0 || srvStats.RetransSegs >= srvStats.OutSegs {
+			t.Fatalf("%s: unexpected server stats: %+v", crypt, srvStats)
+		}
+		conn.Close()
+		lis.Close()
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kcp "github.com/xtaci/kcp-go/v5"
+)
+
+// Stats KCP session statistics
+// NOTE:
+//
+//	The packet and segment counters are zero unless Options.SessionStats is true;
+//	The server session counts the packets since it is accepted.
+type Stats struct {
+	// Conv is the conversation id of the session.
+	Conv uint32
+	// RTT is the smoothed round-trip time.
+	RTT time.Duration
+	// RTTVar is the round-trip time variation.
+	RTTVar time.Duration
+	// RTO is the retransmission timeout.
+	RTO time.Duration
+	// InPkts and InBytes are the UDP packets and bytes received by the session.
+	InPkts, InBytes uint64
+	// OutPkts and OutBytes are the UDP packets and bytes sent by the session,
+	// including the ACK, FEC parity and retransmitted ones.
+	OutPkts, OutBytes uint64
+	// OutSegs is the number of the data segments sent, including the retransmitted ones.
+	OutSegs uint64
+	// RetransSegs is the number of the data segments retransmitted after timeout or fast resend,
+	// RetransSegs/OutSegs is the loss rate seen by the session.
+	RetransSegs uint64
+}
+
+// Session is a KCP session with its own statistics.
+type Session struct {
+	*kcp.UDPSession
+	counters  *sessionCounters
+	release   func()
+	closeOnce sync.Once
+}
+
+// newSession creates a session, release is called once it is closed.
+func newSession(sess *kcp.UDPSession, counters *sessionCounters, release func()) *Session {
+	return &Session{
+		UDPSession: sess,
+		counters:   counters,
+		release:    release,
+	}
+}
+
+// Close closes the session.
+func (s *Session) Close() error {
+	err := s.UDPSession.Close()
+	s.closeOnce.Do(func() {
+		if s.release != nil {
+			s.release()
+		}
+	})
+	return err
+}
+
+// Stats returns the statistics of the session.
+func (s *Session) Stats() *Stats {
+	stats := &Stats{
+		Conv:   s.GetConv(),
+		RTT:    time.Duration(s.GetSRTT()) * time.Millisecond,
+		RTTVar: time.Duration(s.GetSRTTVar()) * time.Millisecond,
+		RTO:    time.Duration(s.GetRTO()) * time.Millisecond,
+	}
+	if c := s.counters; c != nil {
+		stats.InPkts = atomic.LoadUint64(&c.inPkts)
+		stats.InBytes = atomic.LoadUint64(&c.inBytes)
+		stats.OutPkts = atomic.LoadUint64(&c.outPkts)
+		stats.OutBytes = atomic.LoadUint64(&c.outBytes)
+		c.mu.Lock()
+		stats.OutSegs, stats.RetransSegs = c.outSegs, c.retransSegs
+		c.mu.Unlock()
+	}
+	return stats
+}
+
+// SessionStats returns the statistics of the KCP session, conn may be wrapped by TLS or the sniffing.
+func SessionStats(conn net.Conn) (*Stats, bool) {
+	for {
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = wrapper.NetConn()
+	}
+	switch c := conn.(type) {
+	case *Session:
+		return c.Stats(), true
+	case *DatagramSession:
+		return c.Stats(), true
+	case *kcp.UDPSession:
+		return newSession(c, nil, nil).Stats(), true
+	default:
+		return nil, false
+	}
+}
+
+// GlobalStats returns a snapshot of the process-wide KCP counters,
+// such as RetransSegs, FastRetransSegs and LostSegs.
+func GlobalStats() *Snmp {
+	return kcp.DefaultSnmp.Copy()
+}
+
+// The wire format of kcp-go.
+const (
+	segmentHeaderSize  = kcp.IKCP_OVERHEAD
+	fecHeaderSizePlus2 = 8
+	fecTypeData        = 0xf1
+)
+
+// sessionCounters the packet and segment counters of a session.
+type sessionCounters struct {
+	inPkts, inBytes, outPkts, outBytes uint64
+	mu                                 sync.Mutex
+	outSegs, retransSegs               uint64
+	nextSN                             uint32 // the sequence number of the next new segment
+}
+
+// statsPacketConn counts the packets of the sessions by the remote address,
+// and the data segments in the sent packets.
+type statsPacketConn struct {
+	net.PacketConn
+	block    BlockCrypt
+	fec      bool
+	mu       sync.RWMutex
+	sessions map[string]*sessionCounters
+	bufPool  sync.Pool
+}
+
+func newStatsPacketConn(conn net.PacketConn, opts *Options) *statsPacketConn {
+	return &statsPacketConn{
+		PacketConn: conn,
+		block:      opts.Block,
+		fec:        opts.DataShards > 0 && opts.ParityShards > 0,
+		sessions:   make(map[string]*sessionCounters),
+		bufPool: sync.Pool{New: func() interface{} {
+			return new([]byte)
+		}},
+	}
+}
+
+func (c *statsPacketConn) register(addr net.Addr) *sessionCounters {
+	counters := new(sessionCounters)
+	c.mu.Lock()
+	c.sessions[addr.String()] = counters
+	c.mu.Unlock()
+	return counters
+}
+
+func (c *statsPacketConn) unregister(addr net.Addr, counters *sessionCounters) {
+	key := addr.String()
+	c.mu.Lock()
+	if c.sessions[key] == counters {
+		delete(c.sessions, key)
+	}
+	c.mu.Unlock()
+}
+
+func (c *statsPacketConn) lookup(addr net.Addr) *sessionCounters {
+	c.mu.RLock()
+	counters := c.sessions[addr.String()]
+	c.mu.RUnlock()
+	return counters
+}
+
+// ReadFrom reads a packet from the connection.
+func (c *statsPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err == nil {
+		if counters := c.lookup(addr); counters != nil {
+			atomic.AddUint64(&counters.inPkts, 1)
+			atomic.AddUint64(&counters.inBytes, uint64(n))
+		}
+	}
+	return n, addr, err
+}
+
+// WriteTo writes a packet to addr.
+func (c *statsPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if counters := c.lookup(addr); counters != nil {
+		atomic.AddUint64(&counters.outPkts, 1)
+		atomic.AddUint64(&counters.outBytes, uint64(len(b)))
+		if !bytes.HasPrefix(b, datagramMagic) {
+			c.countSegments(counters, b)
+		}
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+// countSegments counts the data segments in the sent packet;
+// a segment is retransmitted if its sequence number is below the ones sent before,
+// since kcp-go sends the new segments in order.
+func (c *statsPacketConn) countSegments(counters *sessionCounters, b []byte) {
+	if c.block != nil {
+		if len(b) < cryptHeaderSize {
+			return
+		}
+		buf := c.bufPool.Get().(*[]byte)
+		defer c.bufPool.Put(buf)
+		*buf = append((*buf)[:0], b...)
+		c.block.Decrypt(*buf, *buf)
+		b = (*buf)[cryptHeaderSize:]
+	}
+	if c.fec {
+		if len(b) < fecHeaderSizePlus2 || binary.LittleEndian.Uint16(b[4:]) != fecTypeData {
+			return
+		}
+		b = b[fecHeaderSizePlus2:]
+	}
+	counters.mu.Lock()
+	defer counters.mu.Unlock()
+	for len(b) >= segmentHeaderSize {
+		sn := binary.LittleEndian.Uint32(b[12:])
+		length := binary.LittleEndian.Uint32(b[20:])
+		if b[4] == kcp.IKCP_CMD_PUSH {
+			counters.outSegs++
+			if int32(sn-counters.nextSN) < 0 {
+				counters.retransSegs++
+			} else {
+				counters.nextSN = sn + 1
+			}
+		}
+		if uint64(length) > uint64(len(b)-segmentHeaderSize) {
+			return
+		}
+		b = b[segmentHeaderSize+int(length):]
+	}
+}
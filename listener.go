@@ -17,19 +17,102 @@ package erpc
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
 
 	"github.com/andeya/erpc/v7/kcp"
+	"github.com/andeya/erpc/v7/mem"
 	"github.com/andeya/erpc/v7/quic"
 	"github.com/andeya/goutil/graceful/inherit_net"
 )
 
 var testTLSConfig = GenerateTLSConfigForServer()
 
+// ListenerConfig an extra listener of the peer, which shares the router and sessions with the others.
+// NOTE:
+//
+//	yaml tag is used for github.com/andeya/cfgo
+//	ini tag is used for github.com/andeya/ini
+type ListenerConfig struct {
+	Network     string `yaml:"network"        ini:"network"        comment:"Network; tcp, tcp4, tcp6, unix, unixpacket, kcp, quic or mem; default the network of the peer"`
+	Addr        string `yaml:"addr"           ini:"addr"           comment:"Listen address; host:port, or the socket file path for unix networks"`
+	TLSCertFile string `yaml:"tls_cert_file"  ini:"tls_cert_file"  comment:"TLS certificate file; TLS is disabled if empty"`
+	TLSKeyFile  string `yaml:"tls_key_file"   ini:"tls_key_file"   comment:"TLS key file"`
+	// TLSConfig is the TLS config of the listener, it takes precedence over the certificate files.
+	TLSConfig *tls.Config `yaml:"-" ini:"-"`
+	// ProtoFunc is the protocol of the listener, the protocol passed to ListenAndServe is used if empty.
+	ProtoFunc []ProtoFunc `yaml:"-" ini:"-"`
+	// Plugins are the accept-time plugins of the listener,
+	// the PostListenPlugin and PostAcceptPlugin plugins are executed after the global ones.
+	Plugins []Plugin `yaml:"-" ini:"-"`
+
+	listenAddr net.Addr
+}
+
+func (l *ListenerConfig) check(defaultNetwork string) (err error) {
+	if l.Network == "" {
+		l.Network = defaultNetwork
+	}
+	switch l.Network {
+	case "unix", "unixpacket":
+		if l.Addr == "" {
+			return errors.New("the socket file path of the unix listener is required")
+		}
+		l.listenAddr = &net.UnixAddr{Net: l.Network, Name: l.Addr}
+	case "tcp", "tcp4", "tcp6", "kcp", "udp", "udp4", "udp6", "quic", mem.Network:
+		if l.listenAddr, err = NewFakeAddr2(l.Network, l.Addr); err != nil {
+			return fmt.Errorf("invalid listener address %q: %s", l.Addr, err.Error())
+		}
+	default:
+		return errors.New("Invalid listener network config, refer to the following: tcp, tcp4, tcp6, unix, unixpacket, kcp, quic or mem")
+	}
+	if l.TLSConfig == nil && l.TLSCertFile != "" {
+		if l.TLSConfig, err = NewTLSConfigFromFile(l.TLSCertFile, l.TLSKeyFile); err != nil {
+			return err
+		}
+	}
+	for _, plugin := range l.Plugins {
+		if plugin == nil {
+			return errors.New("listener plugin cannot be nil")
+		}
+	}
+	return nil
+}
+
+// ListenAddr returns the listener address.
+func (l *ListenerConfig) ListenAddr() net.Addr {
+	return l.listenAddr
+}
+
 // NewInheritedListener creates a inherited listener.
 func NewInheritedListener(addr net.Addr, tlsConfig *tls.Config) (lis net.Listener, err error) {
+	return newInheritedListener(addr, tlsConfig, nil, nil, nil)
+}
+
+// newInheritedListener creates a inherited listener with the transport options.
+func newInheritedListener(addr net.Addr, tlsConfig *tls.Config, quicConfig *QUICConfig, kcpConfig *KCPConfig, memConfig *MemConfig) (lis net.Listener, err error) {
 	laddr := addr.String()
 	network := addr.Network()
+	if network == mem.Network {
+		// NOTE: the in-process network can not be inherited, and TLS is needless
+		var opts *mem.Options
+		if memConfig != nil {
+			opts = memConfig.options()
+		}
+		memLis, err := mem.Listen(laddr, opts)
+		if err != nil {
+			return nil, err
+		}
+		return memLis, nil
+	}
+	if _, ok := addr.(*net.UnixAddr); ok {
+		// NOTE: the socket file path is inherited as is
+		lis, err = inherit_net.Listen(network, laddr)
+		if err == nil && tlsConfig != nil {
+			lis = tls.NewListener(lis, tlsConfig)
+		}
+		return
+	}
 	var host, port string
 	switch raddr := addr.(type) {
 	case *FakeAddr:
@@ -49,10 +132,18 @@ func NewInheritedListener(addr net.Addr, tlsConfig *tls.Config) (lis net.Listene
 		if tlsConfig == nil {
 			tlsConfig = testTLSConfig
 		}
-		lis, err = quic.InheritedListen(_network, laddr, tlsConfig, nil)
+		if quicConfig != nil {
+			lis, err = quic.InheritedListenMux(_network, laddr, tlsConfig, quicConfig.config, quicConfig.muxOptions)
+		} else {
+			lis, err = quic.InheritedListen(_network, laddr, tlsConfig, nil)
+		}
 
 	} else if _network := asKCP(network); _network != "" {
-		lis, err = kcp.InheritedListen(_network, laddr, tlsConfig, dataShards, parityShards)
+		var opts *kcp.Options
+		if kcpConfig != nil {
+			opts = kcpConfig.options
+		}
+		lis, err = kcp.InheritedListenWithOptions(_network, laddr, tlsConfig, opts)
 
 	} else {
 		lis, err = inherit_net.Listen(network, laddr)
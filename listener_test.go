@@ -0,0 +1,132 @@
+package erpc_test
+
+import (
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+	"github.com/andeya/erpc/v7/proto/jsonproto"
+)
+
+// listenerPlugin records the address and the accepted sessions of a listener.
+type listenerPlugin struct {
+	name     string
+	mu       sync.Mutex
+	addr     net.Addr
+	accepted int
+	ready    chan struct{}
+}
+
+func newListenerPlugin(name string) *listenerPlugin {
+	return &listenerPlugin{name: name, ready: make(chan struct{})}
+}
+
+func (p *listenerPlugin) Name() string {
+	return p.name
+}
+
+func (p *listenerPlugin) PostListen(addr net.Addr) error {
+	p.mu.Lock()
+	p.addr = addr
+	p.mu.Unlock()
+	close(p.ready)
+	return nil
+}
+
+func (p *listenerPlugin) PostAccept(sess erpc.PreSession) *erpc.Status {
+	p.mu.Lock()
+	p.accepted++
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *listenerPlugin) Accepted() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.accepted
+}
+
+func listenerEcho(ctx erpc.CallCtx, arg *string) (string, *erpc.Status) {
+	return *arg, nil
+}
+
+func TestMultipleListeners(t *testing.T) {
+	var (
+		tlsPlugin  = newListenerPlugin("tls_listener")
+		unixPlugin = newListenerPlugin("unix_listener")
+		sockFile   = filepath.Join(t.TempDir(), "erpc.sock")
+	)
+	srv := erpc.NewPeer(erpc.PeerConfig{
+		Network: mem.Network,
+		Listeners: []erpc.ListenerConfig{
+			{
+				Network:   "tcp",
+				Addr:      "127.0.0.1:0",
+				TLSConfig: erpc.GenerateTLSConfigForServer(),
+				ProtoFunc: []erpc.ProtoFunc{jsonproto.NewJSONProtoFunc()},
+				Plugins:   []erpc.Plugin{tlsPlugin},
+			},
+			{
+				Network: "unix",
+				Addr:    sockFile,
+				Plugins: []erpc.Plugin{unixPlugin},
+			},
+		},
+	})
+	srv.RouteCallFunc(listenerEcho)
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+	for _, p := range []*listenerPlugin{tlsPlugin, unixPlugin} {
+		select {
+		case <-p.ready:
+		case <-time.After(time.Second * 3):
+			t.Fatalf("%s: listen timeout", p.name)
+		}
+	}
+
+	call := func(sess erpc.Session, stat *erpc.Status) {
+		t.Helper()
+		if !stat.OK() {
+			t.Fatal(stat)
+		}
+		var result string
+		if stat = sess.Call("/listener_echo", "hello", &result).Status(); !stat.OK() || result != "hello" {
+			t.Fatalf("want hello, got: %q, %v", result, stat)
+		}
+	}
+
+	// the main listener
+	memCli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+	defer memCli.Close()
+	call(memCli.Dial(addr))
+
+	// the TLS listener with its own protocol
+	tlsCli := erpc.NewPeer(erpc.PeerConfig{})
+	defer tlsCli.Close()
+	tlsCli.SetTLSConfig(erpc.GenerateTLSConfigForClient())
+	call(tlsCli.Dial(tlsPlugin.addr.String(), jsonproto.NewJSONProtoFunc()))
+
+	// the unix socket listener
+	conn, err := net.Dial("unix", sockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unixCli := erpc.NewPeer(erpc.PeerConfig{})
+	defer unixCli.Close()
+	call(unixCli.ServeConn(conn))
+
+	if n := tlsPlugin.Accepted(); n != 1 {
+		t.Fatalf("want 1 session accepted by the TLS listener, got: %d", n)
+	}
+	if n := unixPlugin.Accepted(); n != 1 {
+		t.Fatalf("want 1 session accepted by the unix listener, got: %d", n)
+	}
+	if n := srv.CountSession(); n != 3 {
+		t.Fatalf("want 3 sessions in the shared hub, got: %d", n)
+	}
+}
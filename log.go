@@ -21,6 +21,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/andeya/goutil/graceful"
@@ -111,7 +112,7 @@ var loggerLevelMap = map[LoggerLevel]string{
 	TRACE:    "TRACE",
 }
 
-var loggerLevel = DEBUG
+var loggerLevel = int32(DEBUG) // atomic, the level can be changed at runtime
 
 func (l LoggerLevel) String() string {
 	s, ok := loggerLevelMap[l]
@@ -215,7 +216,7 @@ func SetLoggerOutputter(outputter LoggerOutputter) (flusher func() error) {
 func SetLoggerLevel(level string) (flusher func() error) {
 	for k, v := range loggerLevelMap {
 		if v == level {
-			loggerLevel = k
+			atomic.StoreInt32(&loggerLevel, int32(k))
 			return FlushLogger
 		}
 	}
@@ -230,18 +231,18 @@ func SetLoggerLevel2(level LoggerLevel) (flusher func() error) {
 		log.Printf("Unknown level number: %d", level)
 		return FlushLogger
 	}
-	loggerLevel = level
+	atomic.StoreInt32(&loggerLevel, int32(level))
 	return FlushLogger
 }
 
 // GetLoggerLevel gets the logger's level.
 func GetLoggerLevel() LoggerLevel {
-	return loggerLevel
+	return LoggerLevel(atomic.LoadInt32(&loggerLevel))
 }
 
 // EnableLoggerLevel returns if can print the level of log.
 func EnableLoggerLevel(level LoggerLevel) bool {
-	if level <= loggerLevel {
+	if level <= GetLoggerLevel() {
 		return level != OFF
 	}
 	return false
@@ -0,0 +1,334 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrConnBroken is returned by the operations of the connection broken by the Options.
+var ErrConnBroken = errors.New("mem: connection broken")
+
+// Conn is an in-process network connection.
+//
+// Multiple goroutines may invoke methods on a Conn simultaneously.
+type Conn struct {
+	local, remote Addr
+	in, out       *queue
+	opts          Options
+	written       int64
+	breakTimer    *time.Timer
+	closeOnce     sync.Once
+}
+
+var _ net.Conn = (*Conn)(nil)
+
+// newPipe creates a pair of connected connections.
+func newPipe(clientAddr, serverAddr Addr, clientOpts, serverOpts *Options) (client, server *Conn) {
+	c2s, s2c := newQueue(), newQueue()
+	client = &Conn{local: clientAddr, remote: serverAddr, in: s2c, out: c2s}
+	server = &Conn{local: serverAddr, remote: clientAddr, in: c2s, out: s2c}
+	for _, c := range []struct {
+		conn *Conn
+		opts *Options
+	}{{client, clientOpts}, {server, serverOpts}} {
+		if c.opts == nil {
+			continue
+		}
+		c.conn.opts = *c.opts
+		if c.opts.BreakAfter > 0 {
+			c.conn.breakTimer = time.AfterFunc(c.opts.BreakAfter, c.conn.breakLink)
+		}
+	}
+	return client, server
+}
+
+// breakLink breaks both directions of the connection.
+func (c *Conn) breakLink() {
+	c.in.fail(ErrConnBroken)
+	c.out.fail(ErrConnBroken)
+}
+
+// Read reads data from the connection.
+// Read can be made to time out and return an error after a fixed
+// time limit; see SetDeadline and SetReadDeadline.
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.in.read(b)
+}
+
+// Write writes data to the connection.
+// Write can be made to time out and return an error after a fixed
+// time limit; see SetDeadline and SetWriteDeadline.
+// NOTE: Write blocks only when the buffer is full, the latency and bandwidth delay the data visible to the reader.
+func (c *Conn) Write(b []byte) (int, error) {
+	if limit := c.opts.BreakAfterBytes; limit > 0 {
+		total := atomic.AddInt64(&c.written, int64(len(b)))
+		if total > limit {
+			allowed := limit - (total - int64(len(b)))
+			if allowed < 0 {
+				allowed = 0
+			}
+			n, _ := c.out.write(b[:allowed], &c.opts)
+			c.breakLink()
+			return n, ErrConnBroken
+		}
+	}
+	return c.out.write(b, &c.opts)
+}
+
+// Close closes the connection.
+// The peer reads the remaining data and then io.EOF.
+func (c *Conn) Close() error {
+	err := io.ErrClosedPipe
+	c.closeOnce.Do(func() {
+		err = nil
+		if c.breakTimer != nil {
+			c.breakTimer.Stop()
+		}
+		c.in.closeRead()
+		c.out.closeWrite()
+	})
+	return err
+}
+
+// CloseWrite shuts down the writing side of the connection.
+// The peer reads the remaining data and then io.EOF.
+func (c *Conn) CloseWrite() error {
+	c.out.closeWrite()
+	return nil
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetDeadline sets the read and write deadlines associated with the connection.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for future Read calls and any currently-blocked Read call.
+// A zero value for t means Read will not time out.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.in.mu.Lock()
+	c.in.readDeadline = t
+	c.in.broadcastLocked()
+	c.in.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for future Write calls.
+// A zero value for t means Write will not time out.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.out.mu.Lock()
+	c.out.writeDeadline = t
+	c.out.mu.Unlock()
+	return nil
+}
+
+type chunk struct {
+	data []byte
+	at   time.Time // visible to the reader since
+}
+
+// queue is one direction of the connection.
+type queue struct {
+	mu            sync.Mutex
+	chunks        []chunk
+	wake          chan struct{} // closed and replaced when the state changes
+	readDeadline  time.Time
+	writeDeadline time.Time
+	nextFree      time.Time // for bandwidth pacing
+	buffered      int       // bytes written but not read yet
+	blockedWrites int
+	readClosed    bool
+	writeClosed   bool
+	err           error
+}
+
+func newQueue() *queue {
+	return &queue{wake: make(chan struct{})}
+}
+
+func (q *queue) broadcastLocked() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+func (q *queue) fail(err error) {
+	q.mu.Lock()
+	if q.err == nil {
+		q.err = err
+		q.broadcastLocked()
+	}
+	q.mu.Unlock()
+}
+
+func (q *queue) closeRead() {
+	q.mu.Lock()
+	q.readClosed = true
+	q.chunks = nil
+	q.buffered = 0
+	q.broadcastLocked()
+	q.mu.Unlock()
+}
+
+func (q *queue) closeWrite() {
+	q.mu.Lock()
+	q.writeClosed = true
+	q.broadcastLocked()
+	q.mu.Unlock()
+}
+
+// write appends b to the queue, and blocks while the buffer is full.
+func (q *queue) write(b []byte, opts *Options) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var n int
+	for {
+		switch {
+		case q.writeClosed, q.readClosed:
+			return n, io.ErrClosedPipe
+		case q.err != nil:
+			return n, q.err
+		case n == len(b) && n > 0:
+			return n, nil
+		}
+		now := time.Now()
+		switch {
+		case !q.writeDeadline.IsZero() && !now.Before(q.writeDeadline):
+			return n, os.ErrDeadlineExceeded
+		case len(b) == 0:
+			return 0, nil
+		}
+		if free := opts.bufferSize() - q.buffered; free > 0 {
+			m := len(b) - n
+			if m > free {
+				m = free
+			}
+			q.pushLocked(b[n:n+m], opts, now)
+			n += m
+			continue
+		}
+		wake, deadline := q.wake, q.writeDeadline
+		q.blockedWrites++
+		q.mu.Unlock()
+		if deadline.IsZero() {
+			<-wake
+		} else {
+			timer := time.NewTimer(deadline.Sub(now))
+			select {
+			case <-wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+		q.mu.Lock()
+		q.blockedWrites--
+	}
+}
+
+func (q *queue) pushLocked(b []byte, opts *Options, now time.Time) {
+	at := now
+	if opts.Bandwidth > 0 {
+		if q.nextFree.After(at) {
+			at = q.nextFree
+		}
+		at = at.Add(time.Duration(int64(len(b)) * int64(time.Second) / opts.Bandwidth))
+		q.nextFree = at
+	}
+	at = at.Add(opts.Latency)
+	if n := len(q.chunks); n > 0 && q.chunks[n-1].at.After(at) {
+		at = q.chunks[n-1].at
+	}
+	q.chunks = append(q.chunks, chunk{data: append([]byte(nil), b...), at: at})
+	q.buffered += len(b)
+	q.broadcastLocked()
+}
+
+func (q *queue) read(b []byte) (int, error) {
+	q.mu.Lock()
+	for {
+		switch {
+		case q.readClosed:
+			q.mu.Unlock()
+			return 0, io.ErrClosedPipe
+		case q.err != nil:
+			err := q.err
+			q.mu.Unlock()
+			return 0, err
+		}
+		now := time.Now()
+		var n int
+		for n < len(b) && len(q.chunks) > 0 && !q.chunks[0].at.After(now) {
+			head := &q.chunks[0]
+			m := copy(b[n:], head.data)
+			n += m
+			if head.data = head.data[m:]; len(head.data) == 0 {
+				q.chunks[0] = chunk{}
+				q.chunks = q.chunks[1:]
+			}
+		}
+		if n > 0 || len(b) == 0 {
+			q.buffered -= n
+			if n > 0 && q.blockedWrites > 0 {
+				q.broadcastLocked()
+			}
+			q.mu.Unlock()
+			return n, nil
+		}
+		if len(q.chunks) == 0 && q.writeClosed {
+			q.mu.Unlock()
+			return 0, io.EOF
+		}
+		if !q.readDeadline.IsZero() && !now.Before(q.readDeadline) {
+			q.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+		var wakeAt time.Time
+		if len(q.chunks) > 0 {
+			wakeAt = q.chunks[0].at
+		}
+		if !q.readDeadline.IsZero() && (wakeAt.IsZero() || q.readDeadline.Before(wakeAt)) {
+			wakeAt = q.readDeadline
+		}
+		wake := q.wake
+		q.mu.Unlock()
+		if wakeAt.IsZero() {
+			<-wake
+		} else {
+			timer := time.NewTimer(wakeAt.Sub(now))
+			select {
+			case <-wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+		q.mu.Lock()
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mem implements an in-process network for tests and co-located services.
+//
+// The connections are pipes between the peers of the same process,
+// the written data is copied once into the reader's queue without any system call.
+package mem
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Network is the network name of the in-process network.
+const Network = "mem"
+
+// DefaultDialTimeout is the maximum duration to wait for the address to be listened,
+// when the dialing context has no deadline.
+var DefaultDialTimeout = time.Second * 5
+
+// DefaultBufferSize is the default maximum bytes written but not read yet of a connection direction.
+var DefaultBufferSize = 4 << 20
+
+// Options link options of the written direction of a connection
+type Options struct {
+	// Latency is the one-way delay added to every write.
+	Latency time.Duration
+	// Bandwidth is the maximum bytes per second, 0 means unlimited.
+	Bandwidth int64
+	// BreakAfterBytes breaks the connection after writing the bytes, 0 means never.
+	BreakAfterBytes int64
+	// BreakAfter breaks the connection after the duration since it is established, 0 means never.
+	BreakAfter time.Duration
+	// BufferSize is the maximum bytes written but not read yet like the socket buffer,
+	// Write blocks when it is full; 0 means DefaultBufferSize.
+	BufferSize int
+}
+
+func (o *Options) bufferSize() int {
+	if o.BufferSize > 0 {
+		return o.BufferSize
+	}
+	return DefaultBufferSize
+}
+
+// Addr is the address of the in-process network.
+type Addr string
+
+// Network returns the network name "mem".
+func (a Addr) Network() string { return Network }
+
+// String returns the address.
+func (a Addr) String() string { return string(a) }
+
+var (
+	// ErrConnRefused is returned when dialing an address that is not listened until the deadline.
+	ErrConnRefused = errors.New("mem: connection refused")
+	// ErrAddrInUse is returned when listening an address that is already listened.
+	ErrAddrInUse = errors.New("mem: address already in use")
+
+	registry = struct {
+		sync.Mutex
+		listeners map[Addr]*Listener
+		// changed is closed and replaced when a listener is added
+		changed chan struct{}
+	}{
+		listeners: make(map[Addr]*Listener),
+		changed:   make(chan struct{}),
+	}
+	lastPort uint32 = 32767
+)
+
+// ResolveAddr returns the normalized address,
+// the unspecified and loopback hosts are all treated as 127.0.0.1.
+func ResolveAddr(addr string) (Addr, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if _, err = strconv.ParseUint(port, 10, 16); err != nil {
+		return "", errors.New("mem: invalid port " + port)
+	}
+	switch host {
+	case "", "0.0.0.0", "::", "127.0.0.1", "::1", "localhost":
+		host = "127.0.0.1"
+	}
+	return Addr(net.JoinHostPort(host, port)), nil
+}
+
+// nextAddr returns a free address of the host, the caller must hold the registry lock.
+func nextAddr(host string) Addr {
+	for {
+		port := atomic.AddUint32(&lastPort, 1)
+		if port > 65535 {
+			atomic.CompareAndSwapUint32(&lastPort, port, 32767)
+			continue
+		}
+		addr := Addr(net.JoinHostPort(host, strconv.FormatUint(uint64(port), 10)))
+		if _, ok := registry.listeners[addr]; !ok {
+			return addr
+		}
+	}
+}
+
+// Listen announces on the in-process address addr,
+// if the port is 0, a free port is chosen.
+// The options apply to the data written by the server side of the accepted connections.
+func Listen(addr string, opts *Options) (*Listener, error) {
+	a, err := ResolveAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if host, port, _ := net.SplitHostPort(string(a)); port == "0" {
+		a = nextAddr(host)
+	} else if _, ok := registry.listeners[a]; ok {
+		return nil, ErrAddrInUse
+	}
+	l := &Listener{
+		addr:    a,
+		opts:    opts,
+		backlog: make(chan *Conn, 128),
+		closed:  make(chan struct{}),
+	}
+	registry.listeners[a] = l
+	close(registry.changed)
+	registry.changed = make(chan struct{})
+	return l, nil
+}
+
+// DialContext connects to the in-process address addr.
+// If the address is not listened yet, it waits until it is listened or the context is done,
+// or DefaultDialTimeout expires if the context has no deadline.
+// The options apply to the data written by the client side.
+func DialContext(ctx context.Context, addr string, opts *Options) (net.Conn, error) {
+	a, err := ResolveAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); !ok && DefaultDialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultDialTimeout)
+		defer cancel()
+	}
+	for {
+		registry.Lock()
+		l := registry.listeners[a]
+		changed := registry.changed
+		var local Addr
+		if l != nil {
+			host, _, _ := net.SplitHostPort(string(a))
+			local = nextAddr(host)
+		}
+		registry.Unlock()
+		if l != nil {
+			return l.connect(ctx, local, opts)
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, &net.OpError{Op: "dial", Net: Network, Addr: a, Err: ErrConnRefused}
+		}
+	}
+}
+
+// Listener is an in-process network listener.
+type Listener struct {
+	addr      Addr
+	opts      *Options
+	backlog   chan *Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var _ net.Listener = (*Listener)(nil)
+
+func (l *Listener) connect(ctx context.Context, local Addr, opts *Options) (net.Conn, error) {
+	client, server := newPipe(local, l.addr, opts, l.opts)
+	select {
+	case l.backlog <- server:
+		return client, nil
+	case <-l.closed:
+	case <-ctx.Done():
+	}
+	return nil, &net.OpError{Op: "dial", Net: Network, Addr: l.addr, Err: ErrConnRefused}
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.backlog:
+		return conn, nil
+	case <-l.closed:
+		return nil, &net.OpError{Op: "accept", Net: Network, Addr: l.addr, Err: net.ErrClosed}
+	}
+}
+
+// Close closes the listener, the established connections are not closed.
+func (l *Listener) Close() error {
+	err := net.ErrClosed
+	l.closeOnce.Do(func() {
+		err = nil
+		close(l.closed)
+		registry.Lock()
+		if registry.listeners[l.addr] == l {
+			delete(registry.listeners, l.addr)
+		}
+		registry.Unlock()
+		for {
+			select {
+			case conn := <-l.backlog:
+				conn.Close()
+			default:
+				return
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the listener's network address.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,157 @@
+package mem_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+)
+
+func TestConn(t *testing.T) {
+	lis, err := mem.Listen(":0", &mem.Options{Latency: time.Millisecond * 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	if _, err = mem.Listen(lis.Addr().String(), nil); err != mem.ErrAddrInUse {
+		t.Fatalf("expect address in use, got: %v", err)
+	}
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	conn, err := mem.DialContext(context.Background(), lis.Addr().String(), &mem.Options{Bandwidth: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	msg := make([]byte, 100)
+	if _, err = conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadFull(conn, msg); err != nil {
+		t.Fatal(err)
+	}
+	// 100ms for the bandwidth and 50ms for the latency
+	if cost := time.Since(start); cost < time.Millisecond*150 {
+		t.Fatalf("expect the link shaping, cost: %v", cost)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond * 10))
+	if _, err = conn.Read(msg); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expect timeout, got: %v", err)
+	}
+	conn.Close()
+}
+
+func TestBuffer(t *testing.T) {
+	lis, err := mem.Listen(":0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}()
+	conn, err := mem.DialContext(context.Background(), lis.Addr().String(), &mem.Options{BufferSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	srv := <-accepted
+	defer srv.Close()
+	if _, err = conn.Write(make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	// the buffer is full until the peer reads
+	conn.SetWriteDeadline(time.Now().Add(time.Millisecond * 10))
+	if n, err := conn.Write(make([]byte, 10)); n != 0 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expect timeout, got: %d, %v", n, err)
+	}
+	conn.SetWriteDeadline(time.Time{})
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, 2048))
+		written <- err
+	}()
+	if _, err = io.ReadFull(srv, make([]byte, 1024+2048)); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-written; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBreak(t *testing.T) {
+	lis, err := mem.Listen(":0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := lis.Accept()
+		accepted <- conn
+	}()
+	conn, err := mem.DialContext(context.Background(), lis.Addr().String(), &mem.Options{BreakAfterBytes: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := <-accepted
+	if n, err := conn.Write(make([]byte, 15)); n != 10 || err != mem.ErrConnBroken {
+		t.Fatalf("expect broken after 10 bytes, got: %d, %v", n, err)
+	}
+	if _, err = srv.Read(make([]byte, 10)); err != mem.ErrConnBroken {
+		t.Fatalf("expect broken, got: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if _, err = mem.DialContext(ctx, ":1", nil); !errors.Is(err, mem.ErrConnRefused) {
+		t.Fatalf("expect connection refused, got: %v", err)
+	}
+}
+
+type Home struct {
+	erpc.CallCtx
+}
+
+func (h *Home) Test(arg *string) (string, *erpc.Status) {
+	return "hello " + *arg, nil
+}
+
+func TestPeer(t *testing.T) {
+	srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+	defer srv.Close()
+	srv.RouteCall(new(Home))
+	addr := memtest.Serve(srv)
+
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network, Mem: erpc.MemConfig{Latency: time.Millisecond}})
+	defer cli.Close()
+	sess, stat := cli.Dial(addr)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	var result string
+	if stat = sess.Call("/home/test", "mem", &result).Status(); !stat.OK() {
+		t.Fatal(stat)
+	}
+	if result != "hello mem" {
+		t.Fatalf("unexpected result: %s", result)
+	}
+}
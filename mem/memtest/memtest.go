@@ -0,0 +1,82 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package memtest provides the utilities for serving the peers on the in-process network in tests.
+package memtest
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+)
+
+// listenedPlugin reports the in-process listening address of the peer.
+type listenedPlugin chan net.Addr
+
+var _ erpc.PostListenPlugin = listenedPlugin(nil)
+
+func (listenedPlugin) Name() string {
+	return "memtest_listened"
+}
+
+func (p listenedPlugin) PostListen(addr net.Addr) error {
+	if addr.Network() != mem.Network {
+		return nil
+	}
+	select {
+	case p <- addr:
+	default:
+	}
+	return nil
+}
+
+// Serve serves the peer of the in-process network in the background,
+// and returns the address of its main listener once it is accepting.
+// NOTE:
+//
+//	Leave PeerConfig.ListenPort 0 to listen on a free port;
+//	The extra listeners of the other networks are not waited for;
+//	It panics if the peer fails to listen.
+func Serve(peer erpc.Peer, protoFunc ...erpc.ProtoFunc) string {
+	listened := make(listenedPlugin, 1)
+	peer.PluginContainer().AppendRight(listened)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- peer.ListenAndServe(protoFunc...)
+	}()
+	select {
+	case addr := <-listened:
+		return addr.String()
+	case err := <-errCh:
+		panic(err)
+	}
+}
+
+// FreePort returns a free port of the in-process network,
+// for the peers which need to know their address before listening.
+// NOTE:
+//
+//	The chosen port is not chosen again until the free ports wrap around.
+func FreePort() uint16 {
+	lis, err := mem.Listen(":0", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer lis.Close()
+	_, port, _ := net.SplitHostPort(lis.Addr().String())
+	n, _ := strconv.ParseUint(port, 10, 16)
+	return uint16(n)
+}
@@ -1,6 +1,7 @@
 package erpc
 
 import (
+	"context"
 	"strconv"
 
 	"github.com/andeya/erpc/v7/codec"
@@ -45,6 +46,8 @@ type (
 	Socket = socket.Socket
 	// Proto pack/unpack protocol scheme of socket message.
 	Proto = socket.Proto
+	// StatefulProto is the Proto whose messages depend on the ones sent before.
+	StatefulProto = socket.StatefulProto
 	// ProtoFunc function used to create a custom Proto interface.
 	ProtoFunc = socket.ProtoFunc
 	// IOWithReadBuffer implements buffered I/O with buffered reader.
@@ -141,6 +144,26 @@ func WithAcceptBodyCodec(bodyCodec byte) MessageSetting {
 	return socket.WithAddMeta(MetaAcceptBodyCodec, strconv.FormatUint(uint64(bodyCodec), 10))
 }
 
+// WithDatagram sends the PUSH message as an unreliable datagram,
+// the reliable stream is used if the transport does not support datagrams, or the protocol is a StatefulProto.
+// NOTE:
+//
+//	Only for PUSH;
+//	The message must not exceed the datagram size limit of the transport, which is below the MTU.
+func WithDatagram() MessageSetting {
+	return func(m Message) {
+		socket.WithContext(context.WithValue(m.Context(), datagramKey{}, true))(m)
+	}
+}
+
+// isDatagram returns whether the message is sent as an unreliable datagram.
+func isDatagram(m Message) bool {
+	v, _ := m.Context().Value(datagramKey{}).(bool)
+	return v
+}
+
+type datagramKey struct{}
+
 // withMtype sets the message type.
 func withMtype(mtype byte) MessageSetting {
 	return func(m Message) {
@@ -0,0 +1,235 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package admin is an embedded admin/debug HTTP console for a running peer.
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/plugin/overloader"
+)
+
+// DefaultPrefix is the default path prefix of the console.
+const DefaultPrefix = "/debug/erpc"
+
+// Handler the admin console of a peer, it serves the JSON API:
+//
+//	GET  {prefix}/                  the overview
+//	GET  {prefix}/sessions          the sessions
+//	POST {prefix}/sessions/close    closes the session, form: id
+//	GET  {prefix}/routes            the routes with their plugin chains
+//	GET  {prefix}/plugins           the global plugins, with the overloader limits
+//	GET  {prefix}/gopool            the goroutine pool usage
+//	POST {prefix}/logger            changes the logger level, form: level
+//	POST {prefix}/print_detail      toggles PrintDetail, form: enable
+//
+// NOTE: It has no authentication, mount it on a private address or behind an authenticating handler.
+type Handler struct {
+	peer   erpc.Peer
+	prefix string
+	mux    *http.ServeMux
+}
+
+// NewHandler creates the admin console of the peer under the path prefix, default DefaultPrefix.
+func NewHandler(peer erpc.Peer, prefix ...string) *Handler {
+	h := &Handler{peer: peer, prefix: DefaultPrefix, mux: http.NewServeMux()}
+	if len(prefix) > 0 {
+		h.prefix = strings.TrimRight(prefix[0], "/")
+	}
+	h.handle("/", http.MethodGet, h.overview)
+	h.handle("/sessions", http.MethodGet, h.sessions)
+	h.handle("/sessions/close", http.MethodPost, h.closeSession)
+	h.handle("/routes", http.MethodGet, h.routes)
+	h.handle("/plugins", http.MethodGet, h.plugins)
+	h.handle("/gopool", http.MethodGet, h.gopool)
+	h.handle("/logger", http.MethodPost, h.logger)
+	h.handle("/print_detail", http.MethodPost, h.printDetail)
+	return h
+}
+
+// Mount mounts the admin console of the peer on the mux under the path prefix, default DefaultPrefix.
+func Mount(mux *http.ServeMux, peer erpc.Peer, prefix ...string) *Handler {
+	h := NewHandler(peer, prefix...)
+	mux.Handle(h.prefix+"/", h)
+	return h
+}
+
+// Prefix returns the path prefix.
+func (h *Handler) Prefix() string {
+	return h.prefix
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) handle(path, method string, fn func(*http.Request) (interface{}, int)) {
+	pattern := h.prefix + path
+	h.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if path == "/" && r.URL.Path != pattern {
+			writeJSON(w, errorBody("not found"), http.StatusNotFound)
+			return
+		}
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, errorBody("method not allowed"), http.StatusMethodNotAllowed)
+			return
+		}
+		body, code := fn(r)
+		writeJSON(w, body, code)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}, code int) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(body)
+}
+
+func errorBody(msg string) interface{} {
+	return map[string]string{"error": msg}
+}
+
+// Overview the summary of the peer.
+type Overview struct {
+	Sessions    int             `json:"sessions"`
+	Routes      int             `json:"routes"`
+	Plugins     []string        `json:"plugins"`
+	Gopool      erpc.GopoolStat `json:"gopool"`
+	LoggerLevel string          `json:"logger_level"`
+	PrintDetail bool            `json:"print_detail"`
+}
+
+// Route a registered handler with its plugin chain.
+type Route struct {
+	ServiceMethod string   `json:"service_method"`
+	Type          string   `json:"type"`
+	Plugins       []string `json:"plugins"`
+}
+
+// PluginInfo a global plugin.
+type PluginInfo struct {
+	Name       string      `json:"name"`
+	Overloader *Overloader `json:"overloader,omitempty"`
+}
+
+// Overloader the limits and the usage of the overloader plugin.
+type Overloader struct {
+	Limit   overloader.LimitConfig `json:"limit"`
+	ConnNum int32                  `json:"conn_num"`
+}
+
+func (h *Handler) overview(*http.Request) (interface{}, int) {
+	return &Overview{
+		Sessions:    h.peer.CountSession(),
+		Routes:      len(h.peer.Router().Handlers()),
+		Plugins:     pluginNames(h.peer.PluginContainer().GetAll()),
+		Gopool:      erpc.GetGopoolStat(),
+		LoggerLevel: erpc.GetLoggerLevel().String(),
+		PrintDetail: h.peer.PrintDetail(),
+	}, http.StatusOK
+}
+
+func (h *Handler) sessions(*http.Request) (interface{}, int) {
+	stats := h.peer.SessionStats()
+	if stats == nil {
+		stats = []erpc.SessionStat{}
+	}
+	return stats, http.StatusOK
+}
+
+func (h *Handler) closeSession(r *http.Request) (interface{}, int) {
+	id := r.FormValue("id")
+	sess, ok := h.peer.GetSession(id)
+	if !ok {
+		return errorBody("session not found"), http.StatusNotFound
+	}
+	erpc.Infof("admin: close the session %s from %s", id, r.RemoteAddr)
+	if err := sess.Close(); err != nil {
+		return errorBody(err.Error()), http.StatusInternalServerError
+	}
+	return map[string]string{"closed": id}, http.StatusOK
+}
+
+func (h *Handler) routes(*http.Request) (interface{}, int) {
+	handlers := h.peer.Router().Handlers()
+	routes := make([]Route, 0, len(handlers))
+	for _, hd := range handlers {
+		routes = append(routes, Route{
+			ServiceMethod: hd.Name(),
+			Type:          hd.RouterTypeName(),
+			Plugins:       pluginNames(hd.PluginContainer().GetAll()),
+		})
+	}
+	return routes, http.StatusOK
+}
+
+func (h *Handler) plugins(*http.Request) (interface{}, int) {
+	all := h.peer.PluginContainer().GetAll()
+	infos := make([]PluginInfo, 0, len(all))
+	for _, p := range all {
+		info := PluginInfo{Name: p.Name()}
+		if o, ok := p.(*overloader.Overloader); ok {
+			info.Overloader = &Overloader{Limit: o.LimitConfig(), ConnNum: o.ConnNum()}
+		}
+		infos = append(infos, info)
+	}
+	return infos, http.StatusOK
+}
+
+func (h *Handler) gopool(*http.Request) (interface{}, int) {
+	return erpc.GetGopoolStat(), http.StatusOK
+}
+
+func (h *Handler) logger(r *http.Request) (interface{}, int) {
+	level := strings.ToUpper(r.FormValue("level"))
+	valid := false
+	for l := erpc.OFF; l <= erpc.TRACE; l++ {
+		if l.String() == level {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return errorBody("unknown logger level: " + r.FormValue("level")), http.StatusBadRequest
+	}
+	erpc.Infof("admin: set the logger level %s from %s", level, r.RemoteAddr)
+	erpc.SetLoggerLevel(level)
+	return map[string]string{"logger_level": erpc.GetLoggerLevel().String()}, http.StatusOK
+}
+
+func (h *Handler) printDetail(r *http.Request) (interface{}, int) {
+	enable, err := strconv.ParseBool(r.FormValue("enable"))
+	if err != nil {
+		return errorBody("invalid enable: " + r.FormValue("enable")), http.StatusBadRequest
+	}
+	h.peer.SetPrintDetail(enable)
+	return map[string]bool{"print_detail": h.peer.PrintDetail()}, http.StatusOK
+}
+
+func pluginNames(plugins []erpc.Plugin) []string {
+	names := make([]string, 0, len(plugins))
+	for _, p := range plugins {
+		names = append(names, p.Name())
+	}
+	return names
+}
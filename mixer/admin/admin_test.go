@@ -0,0 +1,118 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+	"github.com/andeya/erpc/v7/mixer/admin"
+	"github.com/andeya/erpc/v7/plugin/overloader"
+)
+
+func ping(ctx erpc.CallCtx, arg *string) (string, *erpc.Status) {
+	return *arg, nil
+}
+
+func TestAdmin(t *testing.T) {
+	defer erpc.SetLoggerLevel(erpc.GetLoggerLevel().String())
+	// serve without memtest.Serve, whose plugin would be listed,
+	// and no need to wait, the dialer waits for the listener
+	port := memtest.FreePort()
+	srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network, ListenPort: port}, overloader.New(overloader.LimitConfig{MaxConn: 10}))
+	srv.RouteCallFunc(ping)
+	go srv.ListenAndServe()
+	defer srv.Close()
+
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+	defer cli.Close()
+	sess, stat := cli.Dial(fmt.Sprintf(":%d", port))
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	var reply string
+	if stat = sess.Call("/ping", "hi", &reply).Status(); !stat.OK() {
+		t.Fatal(stat)
+	}
+
+	mux := http.NewServeMux()
+	admin.Mount(mux, srv)
+	hs := httptest.NewServer(mux)
+	defer hs.Close()
+
+	do := func(method, path string, form url.Values, wantCode int, result interface{}) {
+		t.Helper()
+		var resp *http.Response
+		var err error
+		if method == http.MethodGet {
+			resp, err = http.Get(hs.URL + admin.DefaultPrefix + path)
+		} else {
+			resp, err = http.PostForm(hs.URL+admin.DefaultPrefix+path, form)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != wantCode {
+			t.Fatalf("%s %s: want %d, got %d", method, path, wantCode, resp.StatusCode)
+		}
+		if result != nil {
+			if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var overview admin.Overview
+	do(http.MethodGet, "/", nil, http.StatusOK, &overview)
+	if overview.Sessions != 1 || overview.Routes != 1 || len(overview.Plugins) != 1 {
+		t.Fatalf("unexpected overview: %+v", overview)
+	}
+
+	var sessions []erpc.SessionStat
+	do(http.MethodGet, "/sessions", nil, http.StatusOK, &sessions)
+	if len(sessions) != 1 || sessions[0].Status != "ok" || sessions[0].Age <= 0 {
+		t.Fatalf("unexpected sessions: %+v", sessions)
+	}
+
+	var routes []admin.Route
+	do(http.MethodGet, "/routes", nil, http.StatusOK, &routes)
+	if len(routes) != 1 || routes[0].ServiceMethod != "/ping" || routes[0].Type != "CALL" || len(routes[0].Plugins) != 1 {
+		t.Fatalf("unexpected routes: %+v", routes)
+	}
+
+	var plugins []admin.PluginInfo
+	do(http.MethodGet, "/plugins", nil, http.StatusOK, &plugins)
+	if len(plugins) != 1 || plugins[0].Overloader == nil || plugins[0].Overloader.Limit.MaxConn != 10 || plugins[0].Overloader.ConnNum != 1 {
+		t.Fatalf("unexpected plugins: %+v", plugins)
+	}
+
+	var gopool erpc.GopoolStat
+	do(http.MethodGet, "/gopool", nil, http.StatusOK, &gopool)
+	if gopool.Running <= 0 || gopool.MaxGoroutinesAmount <= 0 {
+		t.Fatalf("unexpected gopool: %+v", gopool)
+	}
+
+	do(http.MethodPost, "/logger", url.Values{"level": {"warning"}}, http.StatusOK, nil)
+	if erpc.GetLoggerLevel() != erpc.WARNING {
+		t.Fatalf("want the logger level WARNING, got: %s", erpc.GetLoggerLevel())
+	}
+	do(http.MethodPost, "/logger", url.Values{"level": {"verbose"}}, http.StatusBadRequest, nil)
+
+	do(http.MethodPost, "/print_detail", url.Values{"enable": {"true"}}, http.StatusOK, nil)
+	if !srv.PrintDetail() {
+		t.Fatal("want PrintDetail enabled")
+	}
+
+	do(http.MethodGet, "/sessions/close", nil, http.StatusMethodNotAllowed, nil)
+	do(http.MethodPost, "/sessions/close", url.Values{"id": {"unknown"}}, http.StatusNotFound, nil)
+	do(http.MethodPost, "/sessions/close", url.Values{"id": {sessions[0].ID}}, http.StatusOK, nil)
+	if n := srv.CountSession(); n != 0 {
+		t.Fatalf("want the session closed, got %d sessions", n)
+	}
+}
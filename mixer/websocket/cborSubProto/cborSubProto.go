@@ -0,0 +1,138 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cborSubProto is implemented CBOR socket communication protocol.
+package cborSubProto
+
+import (
+	"io/ioutil"
+	"sync"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/codec"
+	"github.com/andeya/goutil"
+)
+
+// NewCBORSubProtoFunc is creation function of CBOR socket protocol.
+func NewCBORSubProtoFunc() erpc.ProtoFunc {
+	return func(rw erpc.IOWithReadBuffer) erpc.Proto {
+		return &cborSubProto{
+			id:   codec.ID_CBOR,
+			name: codec.NAME_CBOR,
+			rw:   rw,
+		}
+	}
+}
+
+type cborSubProto struct {
+	id   byte
+	name string
+	rw   erpc.IOWithReadBuffer
+	rMu  sync.Mutex
+}
+
+// payload the message frame.
+type payload struct {
+	Seq           int32  `cbor:"seq"`
+	Mtype         byte   `cbor:"mtype"`
+	ServiceMethod string `cbor:"serviceMethod"`
+	Status        string `cbor:"status"`
+	Meta          []byte `cbor:"meta"`
+	BodyCodec     byte   `cbor:"bodyCodec"`
+	Body          []byte `cbor:"body"`
+	XferPipe      []byte `cbor:"xferPipe"`
+}
+
+var mc codec.CBORCodec
+
+// Version returns the protocol's id and name.
+func (csp *cborSubProto) Version() (byte, string) {
+	return csp.id, csp.name
+}
+
+// Pack writes the Message into the connection.
+// NOTE: Make sure to write only once or there will be package contamination!
+func (csp *cborSubProto) Pack(m erpc.Message) error {
+	// marshal body
+	bodyBytes, err := m.MarshalBody()
+	if err != nil {
+		return err
+	}
+	// do transfer pipe
+	bodyBytes, err = m.XferPipe().OnPack(bodyBytes)
+	if err != nil {
+		return err
+	}
+
+	b, err := mc.Marshal(&payload{
+		Seq:           m.Seq(),
+		Mtype:         m.Mtype(),
+		ServiceMethod: m.ServiceMethod(),
+		Status:        m.Status(true).QueryString(),
+		Meta:          m.Meta().QueryString(),
+		BodyCodec:     m.BodyCodec(),
+		Body:          bodyBytes,
+		XferPipe:      m.XferPipe().IDs(),
+	})
+	if err != nil {
+		return err
+	}
+
+	m.SetSize(uint32(len(b)))
+
+	_, err = csp.rw.Write(b)
+	return err
+}
+
+// Unpack reads bytes from the connection to the Message.
+// NOTE: Concurrent unsafe!
+func (csp *cborSubProto) Unpack(m erpc.Message) error {
+	csp.rMu.Lock()
+	defer csp.rMu.Unlock()
+	b, err := ioutil.ReadAll(csp.rw)
+	if err != nil {
+		return err
+	}
+
+	m.SetSize(uint32(len(b)))
+
+	s := &payload{}
+	err = mc.Unmarshal(b, s)
+	if err != nil {
+		return err
+	}
+
+	// read transfer pipe
+	for _, r := range s.XferPipe {
+		m.XferPipe().Append(r)
+	}
+
+	// read body
+	m.SetBodyCodec(s.BodyCodec)
+	bodyBytes, err := m.XferPipe().OnUnpack(s.Body)
+	if err != nil {
+		return err
+	}
+
+	// read other
+	m.SetSeq(s.Seq)
+	m.SetMtype(s.Mtype)
+	m.SetServiceMethod(s.ServiceMethod)
+	m.Status(true).DecodeQuery(goutil.StringToBytes(s.Status))
+	m.Meta().ParseBytes(s.Meta)
+
+	// unmarshal new body
+	err = m.UnmarshalBody(bodyBytes)
+	return err
+}
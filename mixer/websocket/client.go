@@ -22,7 +22,9 @@ import (
 	"strings"
 
 	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mixer/websocket/cborSubProto"
 	"github.com/andeya/erpc/v7/mixer/websocket/jsonSubProto"
+	"github.com/andeya/erpc/v7/mixer/websocket/msgpackSubProto"
 	"github.com/andeya/erpc/v7/mixer/websocket/pbSubProto"
 	ws "github.com/andeya/erpc/v7/mixer/websocket/websocket"
 )
@@ -51,6 +53,16 @@ func (c *Client) DialProtobuf(addr string) (erpc.Session, *erpc.Status) {
 	return c.Dial(addr, pbSubProto.NewPbSubProtoFunc())
 }
 
+// DialMsgpack connects with the MessagePack protocol.
+func (c *Client) DialMsgpack(addr string) (erpc.Session, *erpc.Status) {
+	return c.Dial(addr, msgpackSubProto.NewMsgpackSubProtoFunc())
+}
+
+// DialCBOR connects with the CBOR protocol.
+func (c *Client) DialCBOR(addr string) (erpc.Session, *erpc.Status) {
+	return c.Dial(addr, cborSubProto.NewCBORSubProtoFunc())
+}
+
 // Dial connects with the peer of the destination address.
 func (c *Client) Dial(addr string, protoFunc ...erpc.ProtoFunc) (erpc.Session, *erpc.Status) {
 	if len(protoFunc) == 0 {
@@ -0,0 +1,138 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package msgpackSubProto is implemented MessagePack socket communication protocol.
+package msgpackSubProto
+
+import (
+	"io/ioutil"
+	"sync"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/codec"
+	"github.com/andeya/goutil"
+)
+
+// NewMsgpackSubProtoFunc is creation function of MessagePack socket protocol.
+func NewMsgpackSubProtoFunc() erpc.ProtoFunc {
+	return func(rw erpc.IOWithReadBuffer) erpc.Proto {
+		return &msgpackSubProto{
+			id:   codec.ID_MSGPACK,
+			name: codec.NAME_MSGPACK,
+			rw:   rw,
+		}
+	}
+}
+
+type msgpackSubProto struct {
+	id   byte
+	name string
+	rw   erpc.IOWithReadBuffer
+	rMu  sync.Mutex
+}
+
+// payload the message frame.
+type payload struct {
+	Seq           int32  `msgpack:"seq"`
+	Mtype         byte   `msgpack:"mtype"`
+	ServiceMethod string `msgpack:"serviceMethod"`
+	Status        string `msgpack:"status"`
+	Meta          []byte `msgpack:"meta"`
+	BodyCodec     byte   `msgpack:"bodyCodec"`
+	Body          []byte `msgpack:"body"`
+	XferPipe      []byte `msgpack:"xferPipe"`
+}
+
+var mc codec.MsgpackCodec
+
+// Version returns the protocol's id and name.
+func (msp *msgpackSubProto) Version() (byte, string) {
+	return msp.id, msp.name
+}
+
+// Pack writes the Message into the connection.
+// NOTE: Make sure to write only once or there will be package contamination!
+func (msp *msgpackSubProto) Pack(m erpc.Message) error {
+	// marshal body
+	bodyBytes, err := m.MarshalBody()
+	if err != nil {
+		return err
+	}
+	// do transfer pipe
+	bodyBytes, err = m.XferPipe().OnPack(bodyBytes)
+	if err != nil {
+		return err
+	}
+
+	b, err := mc.Marshal(&payload{
+		Seq:           m.Seq(),
+		Mtype:         m.Mtype(),
+		ServiceMethod: m.ServiceMethod(),
+		Status:        m.Status(true).QueryString(),
+		Meta:          m.Meta().QueryString(),
+		BodyCodec:     m.BodyCodec(),
+		Body:          bodyBytes,
+		XferPipe:      m.XferPipe().IDs(),
+	})
+	if err != nil {
+		return err
+	}
+
+	m.SetSize(uint32(len(b)))
+
+	_, err = msp.rw.Write(b)
+	return err
+}
+
+// Unpack reads bytes from the connection to the Message.
+// NOTE: Concurrent unsafe!
+func (msp *msgpackSubProto) Unpack(m erpc.Message) error {
+	msp.rMu.Lock()
+	defer msp.rMu.Unlock()
+	b, err := ioutil.ReadAll(msp.rw)
+	if err != nil {
+		return err
+	}
+
+	m.SetSize(uint32(len(b)))
+
+	s := &payload{}
+	err = mc.Unmarshal(b, s)
+	if err != nil {
+		return err
+	}
+
+	// read transfer pipe
+	for _, r := range s.XferPipe {
+		m.XferPipe().Append(r)
+	}
+
+	// read body
+	m.SetBodyCodec(s.BodyCodec)
+	bodyBytes, err := m.XferPipe().OnUnpack(s.Body)
+	if err != nil {
+		return err
+	}
+
+	// read other
+	m.SetSeq(s.Seq)
+	m.SetMtype(s.Mtype)
+	m.SetServiceMethod(s.ServiceMethod)
+	m.Status(true).DecodeQuery(goutil.StringToBytes(s.Status))
+	m.Meta().ParseBytes(s.Meta)
+
+	// unmarshal new body
+	err = m.UnmarshalBody(bodyBytes)
+	return err
+}
@@ -24,7 +24,9 @@ import (
 	"github.com/andeya/erpc/v7"
 	"github.com/andeya/goutil"
 
+	"github.com/andeya/erpc/v7/mixer/websocket/cborSubProto"
 	"github.com/andeya/erpc/v7/mixer/websocket/jsonSubProto"
+	"github.com/andeya/erpc/v7/mixer/websocket/msgpackSubProto"
 	"github.com/andeya/erpc/v7/mixer/websocket/pbSubProto"
 	ws "github.com/andeya/erpc/v7/mixer/websocket/websocket"
 )
@@ -75,6 +77,16 @@ func (srv *Server) ListenAndServeProtobuf() error {
 	return srv.ListenAndServe(pbSubProto.NewPbSubProtoFunc())
 }
 
+// ListenAndServeMsgpack listen and serve with the MessagePack protocol.
+func (srv *Server) ListenAndServeMsgpack() error {
+	return srv.ListenAndServe(msgpackSubProto.NewMsgpackSubProtoFunc())
+}
+
+// ListenAndServeCBOR listen and serve with the CBOR protocol.
+func (srv *Server) ListenAndServeCBOR() error {
+	return srv.ListenAndServe(cborSubProto.NewCBORSubProtoFunc())
+}
+
 // ListenAndServe listens on the TCP network address addr and then calls
 // Serve with handler to handle requests on incoming connections.
 // Accepted connections are configured to enable TCP keep-alives.
@@ -142,6 +154,16 @@ func NewPbServeHandler(peer erpc.Peer, handshake func(*ws.Config, *http.Request)
 	return NewServeHandler(peer, handshake, pbSubProto.NewPbSubProtoFunc())
 }
 
+// NewMsgpackServeHandler creates a websocket MessagePack handler.
+func NewMsgpackServeHandler(peer erpc.Peer, handshake func(*ws.Config, *http.Request) error) http.Handler {
+	return NewServeHandler(peer, handshake, msgpackSubProto.NewMsgpackSubProtoFunc())
+}
+
+// NewCBORServeHandler creates a websocket CBOR handler.
+func NewCBORServeHandler(peer erpc.Peer, handshake func(*ws.Config, *http.Request) error) http.Handler {
+	return NewServeHandler(peer, handshake, cborSubProto.NewCBORSubProtoFunc())
+}
+
 // NewServeHandler creates a websocket handler.
 func NewServeHandler(peer erpc.Peer, handshake func(*ws.Config, *http.Request) error, protoFunc ...erpc.ProtoFunc) http.Handler {
 	w := &serverHandler{
@@ -0,0 +1,78 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package websocket
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+
+	"github.com/andeya/erpc/v7"
+	ws "github.com/andeya/erpc/v7/mixer/websocket/websocket"
+)
+
+func init() {
+	erpc.RegSniffer(NewSniffer(nil))
+}
+
+// NewSniffer creates the sniffer of the websocket handshake requests,
+// so that the websocket clients share the listening port of the peer with the other protocols.
+// The default one with the JSON sub-protocol is registered when the package is imported.
+// NOTE:
+//
+//	The handshake is accepted on any path;
+//	The PostWebsocketAcceptPlugins are not executed, the PostAcceptPlugins are.
+func NewSniffer(handshake func(*ws.Config, *http.Request) error, protoFunc ...erpc.ProtoFunc) *erpc.Sniffer {
+	return &erpc.Sniffer{
+		Name:      "websocket",
+		Match:     matchHandshake,
+		ProtoFunc: NewWsProtoFunc(protoFunc...),
+		Upgrade: func(peer erpc.Peer, conn net.Conn) (net.Conn, error) {
+			h := NewServeHandler(peer, handshake, protoFunc...).(*serverHandler)
+			return h.Server.Upgrade(conn)
+		},
+	}
+}
+
+var (
+	getBytes     = []byte("GET ")
+	crlfBytes    = []byte("\r\n")
+	headerEnd    = []byte("\r\n\r\n")
+	upgradeBytes = []byte("upgrade")
+	wsBytes      = []byte("websocket")
+)
+
+// matchHandshake matches the GET request with the header `Upgrade: websocket`.
+func matchHandshake(peek []byte) (ok, more bool) {
+	if len(peek) < len(getBytes) {
+		return false, bytes.HasPrefix(getBytes, peek)
+	}
+	if !bytes.HasPrefix(peek, getBytes) {
+		return false, false
+	}
+	end := bytes.Index(peek, headerEnd)
+	if end < 0 {
+		return false, true
+	}
+	lines := bytes.Split(peek[:end], crlfBytes)
+	for _, line := range lines[1:] {
+		key, value, found := bytes.Cut(line, []byte{':'})
+		if found && bytes.EqualFold(bytes.TrimSpace(key), upgradeBytes) &&
+			bytes.EqualFold(bytes.TrimSpace(value), wsBytes) {
+			return true, false
+		}
+	}
+	return false, false
+}
@@ -6,8 +6,10 @@ package websocket
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 )
 
@@ -89,6 +91,23 @@ func (s Server) serveWebSocket(w http.ResponseWriter, req *http.Request) {
 	s.Handler(conn)
 }
 
+// Upgrade reads the handshake request from the connection that is not served by
+// an http.Server, and returns the WebSocket connection after the handshake.
+func (s Server) Upgrade(rwc net.Conn) (*Conn, error) {
+	br := bufio.NewReader(rwc)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, err
+	}
+	req.RemoteAddr = rwc.RemoteAddr().String()
+	buf := bufio.NewReadWriter(br, bufio.NewWriter(rwc))
+	conn, err := newServerConn(rwc, buf, req, &s.Config, s.Handshake)
+	if err == nil && conn == nil {
+		err = errors.New("websocket: unexpected nil conn")
+	}
+	return conn, err
+}
+
 // Handler is a simple interface to a WebSocket browser client.
 // It checks if Origin header is valid URL by default.
 // You might want to verify websocket.Conn.Config().Origin in the func.
@@ -7,8 +7,12 @@ import (
 	"time"
 
 	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
 	ws "github.com/andeya/erpc/v7/mixer/websocket"
+	"github.com/andeya/erpc/v7/mixer/websocket/cborSubProto"
 	"github.com/andeya/erpc/v7/mixer/websocket/jsonSubProto"
+	"github.com/andeya/erpc/v7/mixer/websocket/msgpackSubProto"
 	"github.com/andeya/erpc/v7/mixer/websocket/pbSubProto"
 	"github.com/andeya/erpc/v7/plugin/auth"
 	"github.com/andeya/goutil"
@@ -246,3 +250,68 @@ var handshakePlugin = ws.NewHandshakeAuthPlugin(
 		return nil
 	},
 )
+
+func TestSniffWebsocket(t *testing.T) {
+	srv := erpc.NewPeer(erpc.PeerConfig{
+		Network: mem.Network,
+		Sniff:   erpc.SniffConfig{Enable: true, Protos: []string{"websocket"}},
+	})
+	srv.RouteCall(new(P))
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+
+	wsCli := ws.NewClient("/", erpc.PeerConfig{Network: mem.Network, DialTimeout: time.Second})
+	defer wsCli.Close()
+	rawCli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network, DialTimeout: time.Second})
+	defer rawCli.Close()
+	for name, dial := range map[string]func() (erpc.Session, *erpc.Status){
+		"websocket": func() (erpc.Session, *erpc.Status) { return wsCli.Dial(addr) },
+		"raw":       func() (erpc.Session, *erpc.Status) { return rawCli.Dial(addr) },
+	} {
+		sess, stat := dial()
+		if !stat.OK() {
+			t.Fatalf("%s: %v", name, stat)
+		}
+		var result int
+		if stat = sess.Call("/p/divide", &Arg{A: 10, B: 2}, &result).Status(); !stat.OK() || result != 5 {
+			t.Fatalf("%s: unexpected result: %d, %v", name, result, stat)
+		}
+	}
+}
+
+func TestBinarySubProto(t *testing.T) {
+	for _, x := range []struct {
+		name      string
+		protoFunc erpc.ProtoFunc
+		dial      func(*ws.Client, string) (erpc.Session, *erpc.Status)
+	}{
+		{"msgpack", msgpackSubProto.NewMsgpackSubProtoFunc(), (*ws.Client).DialMsgpack},
+		{"cbor", cborSubProto.NewCBORSubProtoFunc(), (*ws.Client).DialCBOR},
+	} {
+		srv := erpc.NewPeer(erpc.PeerConfig{
+			Network: mem.Network,
+			Sniff: erpc.SniffConfig{
+				Enable:   true,
+				Protos:   []string{},
+				Sniffers: []*erpc.Sniffer{ws.NewSniffer(nil, x.protoFunc)},
+			},
+		})
+		srv.RouteCall(new(P))
+		addr := memtest.Serve(srv)
+
+		cli := ws.NewClient("/", erpc.PeerConfig{Network: mem.Network, DialTimeout: time.Second})
+		sess, stat := x.dial(cli, addr)
+		if !stat.OK() {
+			t.Fatalf("%s: %v", x.name, stat)
+		}
+		var result int
+		if stat = sess.Call("/p/divide", &Arg{A: 10, B: 2}, &result).Status(); !stat.OK() || result != 5 {
+			t.Fatalf("%s: unexpected result: %d, %v", x.name, result, stat)
+		}
+		if stat = sess.Call("/p/none", &Arg{A: 10, B: 2}, &result).Status(); stat.Code() != erpc.CodeNotFound {
+			t.Fatalf("%s: unexpected status: %v", x.name, stat)
+		}
+		cli.Close()
+		srv.Close()
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erpc
+
+import (
+	"fmt"
+
+	"github.com/andeya/erpc/v7/codec"
+	"github.com/andeya/erpc/v7/socket"
+	"github.com/andeya/erpc/v7/xfer"
+)
+
+// NegotiateConfig body codec and xfer filter negotiation options
+type NegotiateConfig struct {
+	Enable      bool     `yaml:"enable"        ini:"enable"        comment:"Exchange the supported body codecs and xfer filters when the session is connected, and use the best mutually supported ones; the remote peers without it are tolerated"`
+	Codecs      []string `yaml:"codecs"        ini:"codecs"        comment:"Names of the supported body codecs in the order of preference, the preference of the client wins; the default body codec if empty"`
+	XferFilters []string `yaml:"xfer_filters"  ini:"xfer_filters"  comment:"Names of the supported xfer filters, the mutually supported ones are applied in the order of the client"`
+
+	codecs      []byte
+	xferFilters []byte
+}
+
+const negotiateServiceMethod = "/erpc/negotiate"
+
+func (n *NegotiateConfig) check(defaultBodyCodec string) error {
+	if !n.Enable {
+		return nil
+	}
+	if len(n.Codecs) == 0 {
+		n.Codecs = []string{defaultBodyCodec}
+	}
+	n.codecs = make([]byte, len(n.Codecs))
+	for i, name := range n.Codecs {
+		c, err := codec.GetByName(name)
+		if err != nil {
+			return fmt.Errorf("negotiate: %s", err.Error())
+		}
+		n.codecs[i] = c.ID()
+	}
+	n.xferFilters = make([]byte, len(n.XferFilters))
+	for i, name := range n.XferFilters {
+		f, err := xfer.GetByName(name)
+		if err != nil {
+			return fmt.Errorf("negotiate: %s", err.Error())
+		}
+		n.xferFilters[i] = f.ID()
+	}
+	return nil
+}
+
+// Negotiation the body codec and xfer filters negotiated by the session.
+type Negotiation struct {
+	BodyCodec         string   `json:"body_codec"`          // the body codec of the CALLs and PUSHes, the default one if empty
+	XferFilters       []string `json:"xfer_filters"`        // the xfer filters of the CALLs and PUSHes
+	RemoteCodecs      []string `json:"remote_codecs"`       // the body codecs supported by the remote peer
+	RemoteXferFilters []string `json:"remote_xfer_filters"` // the xfer filters supported by the remote peer
+}
+
+// negotiation the resolved Negotiation.
+type negotiation struct {
+	Negotiation
+	bodyCodec byte // codec.NilCodecID if there is no mutually supported one
+	xferPipe  []byte
+}
+
+// negotiateArgs is the body of the negotiation CALL.
+type negotiateArgs struct {
+	Codecs      []string `json:"codecs"`
+	XferFilters []string `json:"xfer_filters,omitempty"`
+}
+
+// negotiateReply is the body of the negotiation REPLY.
+type negotiateReply struct {
+	BodyCodec   string        `json:"body_codec,omitempty"`
+	XferFilters []string      `json:"xfer_filters,omitempty"`
+	Supported   negotiateArgs `json:"supported"` // the ones supported by the server
+}
+
+func (n *NegotiateConfig) args() *negotiateArgs {
+	return &negotiateArgs{Codecs: n.Codecs, XferFilters: n.XferFilters}
+}
+
+// choose returns the first of the remote preferences which is supported.
+func (n *NegotiateConfig) choose(remote *negotiateArgs) *negotiateReply {
+	rep := &negotiateReply{Supported: *n.args()}
+	for _, name := range remote.Codecs {
+		if indexString(n.Codecs, name) >= 0 {
+			rep.BodyCodec = name
+			break
+		}
+	}
+	for _, name := range remote.XferFilters {
+		if indexString(n.XferFilters, name) >= 0 {
+			rep.XferFilters = append(rep.XferFilters, name)
+		}
+	}
+	return rep
+}
+
+// negotiation resolves the reply of the negotiation with the precomputed IDs,
+// the chosen body codec and xfer filters must be supported by the local peer.
+func (n *NegotiateConfig) negotiation(rep *negotiateReply, remote *negotiateArgs) (*negotiation, error) {
+	ng := &negotiation{
+		Negotiation: Negotiation{
+			BodyCodec:         rep.BodyCodec,
+			XferFilters:       rep.XferFilters,
+			RemoteCodecs:      remote.Codecs,
+			RemoteXferFilters: remote.XferFilters,
+		},
+	}
+	if rep.BodyCodec != "" {
+		i := indexString(n.Codecs, rep.BodyCodec)
+		if i < 0 {
+			return nil, fmt.Errorf("negotiate: unsupported body codec: %s", rep.BodyCodec)
+		}
+		ng.bodyCodec = n.codecs[i]
+	}
+	for _, name := range rep.XferFilters {
+		i := indexString(n.XferFilters, name)
+		if i < 0 {
+			return nil, fmt.Errorf("negotiate: unsupported xfer filter: %s", name)
+		}
+		ng.xferPipe = append(ng.xferPipe, n.xferFilters[i])
+	}
+	return ng, nil
+}
+
+func indexString(a []string, s string) int {
+	for i, v := range a {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// Negotiation returns the body codec and xfer filters negotiated with the remote peer.
+func (s *session) Negotiation() (Negotiation, bool) {
+	n, _ := s.negotiated.Load().(*negotiation)
+	if n == nil {
+		return Negotiation{}, false
+	}
+	return n.Negotiation, true
+}
+
+// setNegotiated sets the negotiated body codec and xfer filters of the CALL or PUSH,
+// unless they are specified by the message settings.
+// NOTE: The handshake messages written in the preparing status use the defaults.
+func (s *session) setNegotiated(output Message) {
+	n, _ := s.negotiated.Load().(*negotiation)
+	if n == nil || s.getStatus() == statusPreparing {
+		if output.BodyCodec() == codec.NilCodecID {
+			output.SetBodyCodec(s.peer.defaultBodyCodec)
+		}
+		return
+	}
+	if output.BodyCodec() == codec.NilCodecID {
+		if n.bodyCodec != codec.NilCodecID {
+			output.SetBodyCodec(n.bodyCodec)
+		} else {
+			output.SetBodyCodec(s.peer.defaultBodyCodec)
+		}
+	}
+	if output.Mtype() != TypeReply && output.XferPipe().Len() == 0 {
+		output.XferPipe().Append(n.xferPipe...)
+	}
+}
+
+// negotiate sends the negotiation of the client,
+// it must be called during the PostDial phase.
+func (s *session) negotiate() *Status {
+	s.negotiated.Store((*negotiation)(nil))
+	var rep negotiateReply
+	stat := s.PreCall(negotiateServiceMethod, s.peer.negotiate.args(), &rep, WithBodyCodec(codec.ID_JSON))
+	if !stat.OK() {
+		if stat.Code() == CodeNotFound {
+			Warnf("negotiation is not supported by the server (network:%s, addr:%s)", s.peer.network, s.RemoteAddr().String())
+			return nil
+		}
+		return stat
+	}
+	n, err := s.peer.negotiate.negotiation(&rep, &rep.Supported)
+	if err != nil {
+		return statBadMessage.Copy(err)
+	}
+	s.negotiated.Store(n)
+	Debugf("negotiated (network:%s, addr:%s, body_codec:%s, xfer_filters:%v)", s.peer.network, s.RemoteAddr().String(), rep.BodyCodec, rep.XferFilters)
+	return nil
+}
+
+// filterNegotiate handles the negotiation CALL of the client, and returns true if the message is consumed;
+// readErr is the error of reading the message, the client fails on the bad message reply of it.
+// NOTE: It is executed synchronously when reading message, so that the following messages are negotiated.
+func (s *session) filterNegotiate(input Message, readErr error) bool {
+	if s.peer.negotiate == nil || input.Mtype() != TypeCall || input.ServiceMethod() != negotiateServiceMethod {
+		return false
+	}
+	output := socket.GetMessage()
+	defer socket.PutMessage(output)
+	output.SetMtype(TypeReply)
+	output.SetSeq(input.Seq())
+	output.SetServiceMethod(input.ServiceMethod())
+	output.SetBodyCodec(codec.ID_JSON)
+	args, _ := input.Body().(*negotiateArgs)
+	if readErr != nil {
+		output.SetStatus(statBadMessage.Copy(readErr))
+	} else if args == nil {
+		output.SetStatus(statBadMessage.Copy("invalid negotiation"))
+	} else {
+		rep := s.peer.negotiate.choose(args)
+		n, err := s.peer.negotiate.negotiation(rep, args)
+		if err != nil {
+			output.SetStatus(statBadMessage.Copy(err))
+		} else {
+			s.negotiated.Store(n)
+			output.SetBody(rep)
+			Debugf("negotiated (network:%s, addr:%s, body_codec:%s, xfer_filters:%v)", s.peer.network, s.RemoteAddr().String(), rep.BodyCodec, rep.XferFilters)
+		}
+	}
+	if _, stat := s.write(output); !stat.OK() {
+		Debugf("reply the negotiation fail (network:%s, addr:%s, id:%s): %v", s.peer.network, s.RemoteAddr().String(), s.ID(), stat)
+	}
+	return true
+}
+
+// NewNegotiateRoutePlugin creates a route plugin that overrides the negotiated body codec and xfer filters
+// of the replies, with the first of codecs and the ones of xferFilters that the remote peer supports.
+// NOTE:
+//
+//	It takes effect on the negotiated sessions only;
+//	It panics if a codec or xfer filter is not registered.
+func NewNegotiateRoutePlugin(codecs []string, xferFilters ...string) Plugin {
+	for _, name := range codecs {
+		if _, err := codec.GetByName(name); err != nil {
+			panic(err)
+		}
+	}
+	for _, name := range xferFilters {
+		if _, err := xfer.GetByName(name); err != nil {
+			panic(err)
+		}
+	}
+	return &negotiateRoutePlugin{codecs: codecs, xferFilters: xferFilters}
+}
+
+type negotiateRoutePlugin struct {
+	codecs      []string
+	xferFilters []string
+}
+
+var _ PreWriteReplyPlugin = new(negotiateRoutePlugin)
+
+func (p *negotiateRoutePlugin) Name() string {
+	return "negotiate-route"
+}
+
+func (p *negotiateRoutePlugin) PreWriteReply(ctx WriteCtx) *Status {
+	n, ok := ctx.Session().Negotiation()
+	if !ok {
+		return nil
+	}
+	output := ctx.Output()
+	if output.BodyCodec() == codec.NilCodecID {
+		// the error reply
+		return nil
+	}
+	for _, name := range p.codecs {
+		if indexString(n.RemoteCodecs, name) >= 0 {
+			c, _ := codec.GetByName(name)
+			output.SetBodyCodec(c.ID())
+			break
+		}
+	}
+	output.XferPipe().Reset()
+	for _, name := range p.xferFilters {
+		if indexString(n.RemoteXferFilters, name) >= 0 {
+			f, _ := xfer.GetByName(name)
+			output.XferPipe().Append(f.ID())
+		}
+	}
+	return nil
+}
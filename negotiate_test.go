@@ -0,0 +1,99 @@
+package erpc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/codec"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+	"github.com/andeya/erpc/v7/xfer"
+	"github.com/andeya/erpc/v7/xfer/gzip"
+)
+
+func negotiateEcho(ctx erpc.CallCtx, arg *string) ([]string, *erpc.Status) {
+	c, _ := codec.Get(ctx.GetBodyCodec())
+	return append([]string{*arg, c.Name()}, ctx.Input().XferPipe().Names()...), nil
+}
+
+func TestNegotiate(t *testing.T) {
+	if _, err := xfer.GetByName("gzip"); err != nil {
+		gzip.Reg('g', "gzip", 5)
+	}
+	srv := erpc.NewPeer(erpc.PeerConfig{
+		Network:   mem.Network,
+		Negotiate: erpc.NegotiateConfig{Enable: true, Codecs: []string{"json", "msgpack"}, XferFilters: []string{"gzip"}},
+	})
+	srv.RouteCallFunc(negotiateEcho)
+	srv.SubRoute("/json", erpc.NewNegotiateRoutePlugin([]string{"json"})).RouteCallFunc(negotiateEcho)
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+	plainSrv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+	plainSrv.RouteCallFunc(negotiateEcho)
+	plainAddr := memtest.Serve(plainSrv)
+	defer plainSrv.Close()
+
+	cli := erpc.NewPeer(erpc.PeerConfig{
+		Network:     mem.Network,
+		DialTimeout: time.Second,
+		Negotiate:   erpc.NegotiateConfig{Enable: true, Codecs: []string{"cbor", "msgpack", "json"}, XferFilters: []string{"gzip"}},
+	})
+	defer cli.Close()
+	sess, stat := cli.Dial(addr)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	n, ok := sess.Negotiation()
+	if !ok || n.BodyCodec != "msgpack" || len(n.XferFilters) != 1 || n.XferFilters[0] != "gzip" {
+		t.Fatalf("unexpected negotiation: %+v, %v", n, ok)
+	}
+
+	var reply []string
+	call := sess.Call("/negotiate_echo", "a", &reply)
+	if stat = call.Status(); !stat.OK() {
+		t.Fatal(stat)
+	}
+	if len(reply) != 3 || reply[1] != "msgpack" || reply[2] != "gzip" || call.InputBodyCodec() != codec.ID_MSGPACK {
+		t.Fatalf("unexpected reply: %v, body codec: %c", reply, call.InputBodyCodec())
+	}
+	// the message settings override the negotiation
+	call = sess.Call("/negotiate_echo", "b", &reply, erpc.WithBodyCodec(codec.ID_JSON))
+	if stat = call.Status(); !stat.OK() || reply[1] != "json" {
+		t.Fatalf("unexpected reply: %v, %v", reply, stat)
+	}
+	// the route overrides the negotiation of the replies
+	call = sess.Call("/json/negotiate_echo", "c", &reply)
+	if stat = call.Status(); !stat.OK() || reply[1] != "msgpack" || call.InputBodyCodec() != codec.ID_JSON {
+		t.Fatalf("unexpected reply: %v, body codec: %c, %v", reply, call.InputBodyCodec(), stat)
+	}
+	srv.RangeSession(func(s erpc.Session) bool {
+		if n, ok := s.Negotiation(); !ok || n.BodyCodec != "msgpack" || len(n.RemoteCodecs) != 3 {
+			t.Fatalf("unexpected server negotiation: %+v, %v", n, ok)
+		}
+		return true
+	})
+
+	// the bad negotiation body is replied instead of falling through to the routes
+	plainCli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network, DialTimeout: time.Second})
+	defer plainCli.Close()
+	plainSess, stat := plainCli.Dial(addr)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	if stat = plainSess.Call("/erpc/negotiate", "bad", nil, erpc.WithBodyCodec(codec.ID_JSON)).Status(); stat.Code() != erpc.CodeBadMessage {
+		t.Fatalf("unexpected status of the bad negotiation: %v", stat)
+	}
+
+	// the server without the negotiation
+	sess, stat = cli.Dial(plainAddr)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	if _, ok := sess.Negotiation(); ok {
+		t.Fatal("unexpected negotiation")
+	}
+	if stat = sess.Call("/negotiate_echo", "d", &reply).Status(); !stat.OK() || reply[1] != "json" {
+		t.Fatalf("unexpected reply: %v, %v", reply, stat)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/andeya/erpc/v7/codec"
@@ -34,8 +35,17 @@ type (
 	BasePeer interface {
 		// Close closes peer.
 		Close() (err error)
+		// GoAway notifies the sessions to migrate by the GOAWAY PUSH, and stops accepting their new CALLs;
+		// altAddr is the optional alternate address for the clients to redial.
+		GoAway(altAddr string)
 		// CountSession returns the number of sessions.
 		CountSession() int
+		// SessionStats returns the runtime statistics of all sessions.
+		SessionStats() []SessionStat
+		// PrintDetail returns whether the run log prints the body and metadata.
+		PrintDetail() bool
+		// SetPrintDetail sets whether the run log prints the body and metadata, at runtime.
+		SetPrintDetail(printDetail bool)
 		// GetSession gets the session by id.
 		GetSession(sessionID string) (Session, bool)
 		// RangeSession ranges all sessions. If fn returns false, stop traversing.
@@ -48,6 +58,13 @@ type (
 		TLSConfig() *tls.Config
 		// PluginContainer returns the global plugin container.
 		PluginContainer() *PluginContainer
+		// Group returns the session group by name, and creates it if it does not exist.
+		Group(name string) *SessionGroup
+		// RemoveGroup removes the session group, and its sessions leave it.
+		RemoveGroup(name string)
+		// Broadcast sends a TypePush message to the sessions of the group,
+		// the body is encoded once per body codec.
+		Broadcast(group, serviceMethod string, body interface{}, setting ...MessageSetting) *BroadcastResult
 	}
 	// EarlyPeer the communication peer that has just been created
 	EarlyPeer interface {
@@ -76,6 +93,11 @@ type (
 		ListenAndServe(protoFunc ...ProtoFunc) error
 		// Dial connects with the peer of the destination address.
 		Dial(addr string, protoFunc ...ProtoFunc) (Session, *Status)
+		// DialAddrs connects with the first reachable address of the list,
+		// and fails over to the other addresses when redialing.
+		// NOTE:
+		//  The addresses are raced in happy eyeballs style, refer to PeerConfig.Failover.
+		DialAddrs(addrs []string, protoFunc ...ProtoFunc) (Session, *Status)
 		// ServeConn serves the connection and returns a session.
 		// NOTE:
 		//  Not support automatically redials after disconnection;
@@ -104,12 +126,25 @@ type peer struct {
 	mu                sync.Mutex
 	network           string
 	defaultBodyCodec  byte
-	printDetail       bool
+	printDetail       int32
 	countTime         bool
+	quicConfig        *QUICConfig
+	kcpConfig         *KCPConfig
+	memConfig         *MemConfig
+	writeBatch        *WriteBatchConfig
+	resume            *ResumeConfig
+	sniffConfig       *SniffConfig
+	negotiate         *NegotiateConfig
+	resumables        sync.Map // resume token -> *session, only for server role
+	groups            sync.Map // group name -> *SessionGroup
+	goAwayAddr        string
 
 	// only for server role
-	listenAddr net.Addr
-	listeners  map[net.Listener]struct{}
+	listenAddr     net.Addr
+	extraListeners []ListenerConfig
+	listeners      map[net.Listener]struct{}
+	stopListenCh   chan struct{} // closed when the peer stops accepting new connections
+	stopListenOnce sync.Once
 
 	// only for client role
 	dialer *Dialer
@@ -125,6 +160,23 @@ func NewPeer(cfg PeerConfig, globalLeftPlugin ...Plugin) Peer {
 		Fatalf("%v", err)
 	}
 
+	quicConfig := cfg.QUIC
+	kcpConfig := cfg.KCP
+	memConfig := cfg.Mem
+	writeBatch := cfg.WriteBatch
+	resume := cfg.Resume
+	failover := cfg.Failover
+	proxy := cfg.Proxy
+	var sniffConfig *SniffConfig
+	if cfg.Sniff.Enable {
+		sniff := cfg.Sniff
+		sniffConfig = &sniff
+	}
+	var negotiate *NegotiateConfig
+	if cfg.Negotiate.Enable {
+		n := cfg.Negotiate
+		negotiate = &n
+	}
 	var p = &peer{
 		router:            newRouter(pluginContainer),
 		pluginContainer:   pluginContainer,
@@ -135,15 +187,30 @@ func NewPeer(cfg PeerConfig, globalLeftPlugin ...Plugin) Peer {
 		slowCometDuration: cfg.slowCometDuration,
 		network:           cfg.Network,
 		listenAddr:        cfg.listenAddr,
-		printDetail:       cfg.PrintDetail,
+		extraListeners:    append([]ListenerConfig(nil), cfg.Listeners...),
 		countTime:         cfg.CountTime,
+		quicConfig:        &quicConfig,
+		kcpConfig:         &kcpConfig,
+		memConfig:         &memConfig,
+		writeBatch:        &writeBatch,
+		resume:            &resume,
+		sniffConfig:       sniffConfig,
+		negotiate:         negotiate,
+		goAwayAddr:        cfg.GoAwayAddr,
 		listeners:         make(map[net.Listener]struct{}),
+		stopListenCh:      make(chan struct{}),
 		dialer: &Dialer{
 			network:        cfg.Network,
 			dialTimeout:    cfg.DialTimeout,
 			localAddr:      cfg.localAddr,
 			redialInterval: cfg.RedialInterval,
 			redialTimes:    cfg.RedialTimes,
+			backoff:        cfg.Backoff.backoff(cfg.RedialInterval),
+			failover:       &failover,
+			proxy:          &proxy,
+			quicConfig:     &quicConfig,
+			kcpConfig:      &kcpConfig,
+			memConfig:      &memConfig,
 		},
 	}
 
@@ -152,16 +219,34 @@ func NewPeer(cfg PeerConfig, globalLeftPlugin ...Plugin) Peer {
 	} else {
 		p.defaultBodyCodec = c.ID()
 	}
+	p.SetPrintDetail(cfg.PrintDetail)
 	if p.countTime {
 		p.timeNow = func() int64 { return time.Now().UnixNano() }
 	} else {
 		p.timeNow = func() int64 { return 0 }
 	}
+	p.dialer.postAttempt = func(remoteAddr string, attempt int, err error) {
+		p.pluginContainer.postDialAttempt(p.dialer.localAddr, remoteAddr, attempt, err)
+	}
 	addPeer(p)
 	p.pluginContainer.postNewPeer(p)
 	return p
 }
 
+// PrintDetail returns whether the run log prints the body and metadata.
+func (p *peer) PrintDetail() bool {
+	return atomic.LoadInt32(&p.printDetail) == 1
+}
+
+// SetPrintDetail sets whether the run log prints the body and metadata, at runtime.
+func (p *peer) SetPrintDetail(printDetail bool) {
+	if printDetail {
+		atomic.StoreInt32(&p.printDetail, 1)
+	} else {
+		atomic.StoreInt32(&p.printDetail, 0)
+	}
+}
+
 // PluginContainer returns the global plugin container.
 func (p *peer) PluginContainer() *PluginContainer {
 	return p.pluginContainer
@@ -207,14 +292,59 @@ func (p *peer) CountSession() int {
 
 // Dial connects with the peer of the destination address.
 func (p *peer) Dial(addr string, protoFunc ...ProtoFunc) (Session, *Status) {
-	stat := p.pluginContainer.preDial(p.dialer.localAddr, addr)
+	return p.dial([]string{addr}, protoFunc)
+}
+
+// DialAddrs connects with the first reachable address of the list,
+// and fails over to the other addresses when redialing.
+func (p *peer) DialAddrs(addrs []string, protoFunc ...ProtoFunc) (Session, *Status) {
+	if len(addrs) == 0 {
+		return nil, statDialFailed.Copy("no address to dial")
+	}
+	return p.dial(addrs, protoFunc)
+}
+
+// preDialAddrs executes the PreDialPlugin plugins for each address,
+// and returns the allowed addresses.
+func (p *peer) preDialAddrs(addrs []string) ([]string, *Status) {
+	var (
+		allowed = make([]string, 0, len(addrs))
+		stat    *Status
+	)
+	for _, addr := range addrs {
+		if s := p.pluginContainer.preDial(p.dialer.localAddr, addr); !s.OK() {
+			stat = s
+			continue
+		}
+		allowed = append(allowed, addr)
+	}
+	if len(allowed) == 0 {
+		return nil, stat
+	}
+	return allowed, nil
+}
+
+func (p *peer) dial(addrs []string, protoFunc []ProtoFunc) (Session, *Status) {
+	addrs, stat := p.preDialAddrs(addrs)
 	if !stat.OK() {
 		return nil, stat
 	}
 	var sess = newSession(p, nil, protoFunc)
-	_, err := p.dialer.dialWithRetry(addr, "", func(conn net.Conn) error {
-		sess.socket.Reset(conn, protoFunc...)
+	_, addr, err := p.dialer.dialWithRetry(addrs, "", func(conn net.Conn) error {
+		sess.socket.Reset(conn, sess.socketProtoFuncs(protoFunc)...)
 		sess.socket.SetID(sess.LocalAddr().String())
+		if sess.resume != nil {
+			if stat = sess.resumeHandshake(); !stat.OK() {
+				conn.Close()
+				return stat.Cause()
+			}
+		}
+		if p.negotiate != nil {
+			if stat = sess.negotiate(); !stat.OK() {
+				conn.Close()
+				return stat.Cause()
+			}
+		}
 		if stat = p.pluginContainer.postDial(sess, false); !stat.OK() {
 			conn.Close()
 			return stat.Cause()
@@ -233,15 +363,35 @@ func (p *peer) Dial(addr string, protoFunc ...ProtoFunc) (Session, *Status) {
 			oldIP := sess.LocalAddr().String()
 			oldConn := sess.getConn()
 			var err error
-			if stat := p.pluginContainer.preDial(p.dialer.localAddr, addr); stat.OK() {
-				_, err = p.dialer.dialWithRetry(addr, oldID, func(conn net.Conn) error {
-					sess.socket.Reset(conn, protoFunc...)
+			redialAddrs := failoverAddrs(addrs, addr)
+			if alt := sess.takeRedirect(); alt != "" {
+				// migrate to the alternate address of the GOAWAY first
+				redialAddrs = append([]string{alt}, redialAddrs...)
+			}
+			if redialAddrs, stat := p.preDialAddrs(redialAddrs); stat.OK() {
+				var newAddr string
+				_, newAddr, err = p.dialer.dialWithRetry(redialAddrs, oldID, func(conn net.Conn) error {
+					sess.socket.Reset(conn, sess.socketProtoFuncs(protoFunc)...)
 					if oldIP == oldID {
 						sess.socket.SetID(sess.LocalAddr().String())
 					} else {
 						sess.socket.SetID(oldID)
 					}
 					sess.changeStatus(statusPreparing)
+					if sess.resume != nil {
+						if stat := sess.resumeHandshake(); !stat.OK() {
+							conn.Close()
+							sess.changeStatus(statusRedialing)
+							return stat.Cause()
+						}
+					}
+					if p.negotiate != nil {
+						if stat := sess.negotiate(); !stat.OK() {
+							conn.Close()
+							sess.changeStatus(statusRedialing)
+							return stat.Cause()
+						}
+					}
 					if stat := p.pluginContainer.postDial(sess, true); !stat.OK() {
 						conn.Close()
 						sess.changeStatus(statusRedialing)
@@ -249,6 +399,9 @@ func (p *peer) Dial(addr string, protoFunc ...ProtoFunc) (Session, *Status) {
 					}
 					return nil
 				})
+				if err == nil {
+					addr = newAddr
+				}
 			} else {
 				err = stat.Cause()
 			}
@@ -264,6 +417,9 @@ func (p *peer) Dial(addr string, protoFunc ...ProtoFunc) (Session, *Status) {
 			}
 			sess.changeStatus(statusOk)
 			AnywayGo(sess.startReadAndHandle)
+			if sess.resume != nil {
+				AnywayGo(sess.resendCalls)
+			}
 			p.sessHub.set(sess)
 			Infof("redial ok (network:%s, addr:%s, id:%s)", p.network, addr, sess.ID())
 			return true
@@ -285,16 +441,15 @@ func (p *peer) Dial(addr string, protoFunc ...ProtoFunc) (Session, *Status) {
 //	Execute the PostAcceptPlugin plugins.
 func (p *peer) ServeConn(conn net.Conn, protoFunc ...ProtoFunc) (Session, *Status) {
 	network := conn.LocalAddr().Network()
-	if asQUIC(network) != "" {
-		if _, ok := conn.(*quic.Conn); !ok {
-			return nil, NewStatus(CodeWrongConn, "not support "+network, "network must be one of the following: tcp, tcp4, tcp6, unix, unixpacket, kcp or quic")
-		}
+	switch conn.(type) {
+	case *quic.Conn, *quic.StreamConn:
 		network = "quic"
-	} else if asKCP(network) != "" {
-		if _, ok := conn.(*kcp.UDPSession); !ok {
+	case *kcp.Session, *kcp.DatagramSession, *kcp.UDPSession:
+		network = "kcp"
+	default:
+		if asQUIC(network) != "" || asKCP(network) != "" {
 			return nil, NewStatus(CodeWrongConn, "not support "+network, "network must be one of the following: tcp, tcp4, tcp6, unix, unixpacket, kcp or quic")
 		}
-		network = "kcp"
 	}
 	var sess = newSession(p, conn, protoFunc)
 	if stat := p.pluginContainer.postAccept(sess); !stat.OK() {
@@ -313,9 +468,13 @@ var ErrListenClosed = errors.New("listener is closed")
 
 // serveListener serves the listener.
 // NOTE: The caller ensures that the listener supports graceful shutdown.
-func (p *peer) serveListener(lis net.Listener, protoFunc ...ProtoFunc) error {
+// lisPlugins are the accept-time plugins of the listener, executed after the global ones.
+// sniffTLS is the TLS config detected per connection when sniffing, see splitTLS.
+func (p *peer) serveListener(lis net.Listener, lisPlugins *pluginSingleContainer, sniffTLS *tls.Config, protoFunc ...ProtoFunc) error {
 	defer lis.Close()
+	p.mu.Lock()
 	p.listeners[lis] = struct{}{}
+	p.mu.Unlock()
 
 	network := lis.Addr().Network()
 	switch lis.(type) {
@@ -329,16 +488,19 @@ func (p *peer) serveListener(lis net.Listener, protoFunc ...ProtoFunc) error {
 	Printf("listen and serve (network:%s, addr:%s)", network, addr)
 
 	p.pluginContainer.postListen(lis.Addr())
+	if lisPlugins != nil {
+		lisPlugins.postListen(lis.Addr())
+	}
 
 	var (
-		tempDelay time.Duration // how long to sleep on accept failure
-		closeCh   = p.closeCh
+		tempDelay    time.Duration // how long to sleep on accept failure
+		stopListenCh = p.stopListenCh
 	)
 	for {
 		conn, e := lis.Accept()
 		if e != nil {
 			select {
-			case <-closeCh:
+			case <-stopListenCh:
 				return ErrListenClosed
 			default:
 			}
@@ -360,8 +522,25 @@ func (p *peer) serveListener(lis net.Listener, protoFunc ...ProtoFunc) error {
 			return e
 		}
 		tempDelay = 0
+		select {
+		case <-stopListenCh:
+			// the QUIC listener is kept open until the sessions are closed
+			conn.Close()
+			continue
+		default:
+		}
 		AnywayGo(func() {
-			if c, ok := conn.(*tls.Conn); ok {
+			protoFunc := protoFunc
+			if p.sniffConfig != nil {
+				c, fn, err := p.sniff(conn, sniffTLS, protoFunc)
+				if err != nil {
+					Errorf("sniff error from %s: %s", conn.RemoteAddr(), err.Error())
+					conn.Close()
+					return
+				}
+				conn, protoFunc = c, fn
+			}
+			if c, ok := tlsConnOf(conn); ok {
 				if p.defaultSessionAge > 0 {
 					c.SetReadDeadline(coarsetime.CeilingTimeNow().Add(p.defaultSessionAge))
 				}
@@ -374,10 +553,19 @@ func (p *peer) serveListener(lis net.Listener, protoFunc ...ProtoFunc) error {
 				}
 			}
 			var sess = newSession(p, conn, protoFunc)
+			if sess.resume != nil && p.acceptResume(sess) {
+				return
+			}
 			if stat := p.pluginContainer.postAccept(sess); !stat.OK() {
 				sess.Close()
 				return
 			}
+			if lisPlugins != nil {
+				if stat := lisPlugins.postAccept(sess); !stat.OK() {
+					sess.Close()
+					return
+				}
+			}
 			Infof("accept ok (network:%s, addr:%s, id:%s)", network, sess.RemoteAddr().String(), sess.ID())
 			p.sessHub.set(sess)
 			sess.changeStatus(statusOk)
@@ -387,12 +575,45 @@ func (p *peer) serveListener(lis net.Listener, protoFunc ...ProtoFunc) error {
 }
 
 // ListenAndServe turns on the listening service.
+// NOTE: The extra listeners of PeerConfig.Listeners are served at the same time.
 func (p *peer) ListenAndServe(protoFunc ...ProtoFunc) error {
-	lis, err := NewInheritedListener(p.listenAddr, p.tlsConfig)
+	lisTLS, sniffTLS := p.splitTLS(p.listenAddr, p.tlsConfig)
+	lis, err := newInheritedListener(p.listenAddr, lisTLS, p.quicConfig, p.kcpConfig, p.memConfig)
 	if err != nil {
 		Fatalf("%v", err)
 	}
-	return p.serveListener(lis, protoFunc...)
+	extraLis := make([]net.Listener, len(p.extraListeners))
+	extraSniffTLS := make([]*tls.Config, len(p.extraListeners))
+	for i, cfg := range p.extraListeners {
+		var lisTLS *tls.Config
+		lisTLS, extraSniffTLS[i] = p.splitTLS(cfg.listenAddr, cfg.TLSConfig)
+		extraLis[i], err = newInheritedListener(cfg.listenAddr, lisTLS, p.quicConfig, p.kcpConfig, p.memConfig)
+		if err != nil {
+			Fatalf("%v", err)
+		}
+	}
+	for i, cfg := range p.extraListeners {
+		var (
+			lis        = extraLis[i]
+			sniffTLS   = extraSniffTLS[i]
+			lisPlugins *pluginSingleContainer
+			protoFuncs = cfg.ProtoFunc
+		)
+		if len(cfg.Plugins) > 0 {
+			container := newPluginContainer()
+			container.AppendRight(cfg.Plugins...)
+			lisPlugins = container.pluginSingleContainer
+		}
+		if len(protoFuncs) == 0 {
+			protoFuncs = protoFunc
+		}
+		AnywayGo(func() {
+			if err := p.serveListener(lis, lisPlugins, sniffTLS, protoFuncs...); err != ErrListenClosed {
+				Errorf("listen and serve (network:%s, addr:%s): %v", lis.Addr().Network(), lis.Addr().String(), err)
+			}
+		})
+	}
+	return p.serveListener(lis, nil, sniffTLS, protoFunc...)
 }
 
 // Close closes peer.
@@ -403,11 +624,7 @@ func (p *peer) Close() (err error) {
 		}
 	}()
 	close(p.closeCh)
-	for lis := range p.listeners {
-		if _, ok := lis.(*quic.Listener); !ok {
-			lis.Close()
-		}
-	}
+	listeners := p.stopListening()
 	deletePeer(p)
 	var (
 		count int
@@ -424,7 +641,7 @@ func (p *peer) Close() (err error) {
 		err = errors.Merge(err, <-errCh)
 	}
 	close(errCh)
-	for lis := range p.listeners {
+	for _, lis := range listeners {
 		if qlis, ok := lis.(*quic.Listener); ok {
 			err = errors.Merge(err, qlis.Close())
 		}
@@ -432,6 +649,26 @@ func (p *peer) Close() (err error) {
 	return err
 }
 
+// stopListening stops accepting new connections, and returns the listeners.
+// NOTE: The QUIC listeners are not closed, since closing them closes their connections.
+func (p *peer) stopListening() []net.Listener {
+	p.stopListenOnce.Do(func() {
+		close(p.stopListenCh)
+	})
+	p.mu.Lock()
+	listeners := make([]net.Listener, 0, len(p.listeners))
+	for lis := range p.listeners {
+		listeners = append(listeners, lis)
+	}
+	p.mu.Unlock()
+	for _, lis := range listeners {
+		if _, ok := lis.(*quic.Listener); !ok {
+			lis.Close()
+		}
+	}
+	return listeners
+}
+
 var ctxPool = sync.Pool{
 	New: func() interface{} {
 		return newReadHandleCtx()
@@ -59,6 +59,14 @@ type (
 		Plugin
 		PostDial(sess PreSession, isRedial bool) *Status
 	}
+	// PostDialAttemptPlugin is executed after each attempt to dial an address.
+	// NOTE:
+	//  attempt is 0 for the first round, and increases with each redial round;
+	//  err is nil if the address is chosen.
+	PostDialAttemptPlugin interface {
+		Plugin
+		PostDialAttempt(localAddr net.Addr, remoteAddr string, attempt int, err error)
+	}
 	// PostAcceptPlugin is executed after accepting connection.
 	PostAcceptPlugin interface {
 		Plugin
@@ -149,6 +157,11 @@ type (
 		Plugin
 		PostDisconnect(BaseSession) *Status
 	}
+	// PreGoAwayPlugin is executed before sending the GOAWAY, when the peer starts draining.
+	PreGoAwayPlugin interface {
+		Plugin
+		PreGoAway(altAddr string)
+	}
 )
 
 // PluginContainer a plugin container
@@ -417,6 +430,38 @@ func (p *pluginSingleContainer) postDial(sess PreSession, isRedial bool) (stat *
 	return nil
 }
 
+// preGoAway executes the defined plugins before sending the GOAWAY.
+func (p *pluginSingleContainer) preGoAway(altAddr string) {
+	var pluginName string
+	defer func() {
+		if p := recover(); p != nil {
+			Errorf("[PreGoAwayPlugin:%s] altAddr:%s, panic:%v\n%s", pluginName, altAddr, p, goutil.PanicTrace(2))
+		}
+	}()
+	for _, plugin := range p.plugins {
+		if _plugin, ok := plugin.(PreGoAwayPlugin); ok {
+			pluginName = plugin.Name()
+			_plugin.PreGoAway(altAddr)
+		}
+	}
+}
+
+// postDialAttempt executes the defined plugins after each attempt to dial an address.
+func (p *pluginSingleContainer) postDialAttempt(localAddr net.Addr, remoteAddr string, attempt int, err error) {
+	var pluginName string
+	defer func() {
+		if p := recover(); p != nil {
+			Errorf("[PostDialAttemptPlugin:%s] network:%s, localAddr%s ,remoteAddr:%s, panic:%v\n%s", pluginName, localAddr.Network(), localAddr.String(), remoteAddr, p, goutil.PanicTrace(2))
+		}
+	}()
+	for _, plugin := range p.plugins {
+		if _plugin, ok := plugin.(PostDialAttemptPlugin); ok {
+			pluginName = plugin.Name()
+			_plugin.PostDialAttempt(localAddr, remoteAddr, attempt, err)
+		}
+	}
+}
+
 // PostAccept executes the defined plugins after accepting connection.
 func (p *pluginSingleContainer) postAccept(sess PreSession) (stat *Status) {
 	var pluginName string
@@ -697,6 +742,10 @@ func warnInvalidHandlerHooks(plugin []Plugin) {
 			LazyDebugf(func() string {
 				return fmt.Sprintf("invalid PostDialPlugin in router: %s", p.Name())
 			})
+		case PostDialAttemptPlugin:
+			LazyDebugf(func() string {
+				return fmt.Sprintf("invalid PostDialAttemptPlugin in router: %s", p.Name())
+			})
 		case PostAcceptPlugin:
 			LazyDebugf(func() string {
 				return fmt.Sprintf("invalid PostAcceptPlugin in router: %s", p.Name())
@@ -0,0 +1,180 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fault
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+)
+
+const (
+	stateOk int32 = iota
+	stateHalfClosed
+	stateReset
+)
+
+// Conn is a net.Conn that injects faults into the writes.
+type Conn struct {
+	net.Conn
+	inj     *Injector
+	mu      sync.Mutex
+	current *Message
+	state   int32
+}
+
+// NewConn wraps the connection with the fault injector.
+func NewConn(conn net.Conn, inj *Injector) *Conn {
+	return &Conn{Conn: conn, inj: inj}
+}
+
+func (c *Conn) setCurrent(msg *Message) {
+	c.mu.Lock()
+	c.current = msg
+	c.mu.Unlock()
+}
+
+// Write writes data to the connection, it may inject a fault.
+func (c *Conn) Write(b []byte) (int, error) {
+	switch atomic.LoadInt32(&c.state) {
+	case stateHalfClosed:
+		return 0, ErrHalfClosed
+	case stateReset:
+		return 0, ErrReset
+	}
+	c.mu.Lock()
+	msg := c.current
+	c.mu.Unlock()
+	rule, ok := c.inj.decide(msg)
+	if !ok {
+		return c.Conn.Write(b)
+	}
+	switch rule.Action {
+	case ActionDelay:
+		time.Sleep(rule.Delay)
+	case ActionDrop:
+		return len(b), nil
+	case ActionTruncate:
+		keep := rule.Keep
+		if keep <= 0 {
+			keep = len(b) / 2
+		} else if keep > len(b) {
+			keep = len(b)
+		}
+		if _, err := c.Conn.Write(b[:keep]); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	case ActionCorrupt:
+		if len(b) == 0 {
+			break
+		}
+		buf := append([]byte(nil), b...)
+		count := rule.Corrupt
+		if count <= 0 {
+			count = 1
+		}
+		for i := 0; i < count; i++ {
+			buf[c.inj.intn(len(buf))] ^= 0xff
+		}
+		return c.Conn.Write(buf)
+	case ActionHalfClose:
+		atomic.StoreInt32(&c.state, stateHalfClosed)
+		if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			c.Conn.Close()
+		}
+		return 0, ErrHalfClosed
+	case ActionReset:
+		atomic.StoreInt32(&c.state, stateReset)
+		if tcpConn, ok := c.Conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0) // send RST
+		}
+		c.Conn.Close()
+		return 0, ErrReset
+	}
+	return c.Conn.Write(b)
+}
+
+// Listener is a net.Listener that injects faults into the accepted connections.
+type Listener struct {
+	net.Listener
+	inj *Injector
+}
+
+// NewListener wraps the listener with the fault injector.
+func NewListener(lis net.Listener, inj *Injector) *Listener {
+	return &Listener{Listener: lis, inj: inj}
+}
+
+// Accept waits for and returns the next connection wrapped with the fault injector.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn, l.inj), nil
+}
+
+// WrapProtoFunc makes the messages written by the proto known to the fault connection,
+// so that the rules keyed by service method can match.
+func WrapProtoFunc(protoFunc erpc.ProtoFunc) erpc.ProtoFunc {
+	return func(rw erpc.IOWithReadBuffer) erpc.Proto {
+		p := &proto{Proto: protoFunc(rw)}
+		if s, ok := rw.(interface{ RawLocked() net.Conn }); ok {
+			p.conn, _ = s.RawLocked().(*Conn)
+		}
+		return p
+	}
+}
+
+type proto struct {
+	erpc.Proto
+	conn *Conn
+	// service method of the received CALLs, the REPLY is written without it
+	calls sync.Map
+}
+
+// Pack writes the Message into the connection.
+func (p *proto) Pack(m erpc.Message) error {
+	if p.conn == nil {
+		return p.Proto.Pack(m)
+	}
+	msg := &Message{ServiceMethod: m.ServiceMethod(), Mtype: m.Mtype(), Seq: m.Seq()}
+	if msg.Mtype == erpc.TypeReply {
+		if v, ok := p.calls.Load(msg.Seq); ok {
+			p.calls.Delete(msg.Seq)
+			if msg.ServiceMethod == "" {
+				msg.ServiceMethod = v.(string)
+			}
+		}
+	}
+	p.conn.setCurrent(msg)
+	defer p.conn.setCurrent(nil)
+	return p.Proto.Pack(m)
+}
+
+// Unpack reads bytes from the connection to the Message.
+func (p *proto) Unpack(m erpc.Message) error {
+	err := p.Proto.Unpack(m)
+	if err == nil && p.conn != nil && m.Mtype() == erpc.TypeCall {
+		p.calls.Store(m.Seq(), m.ServiceMethod())
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fault injects network faults into the sessions for chaos testing.
+//
+// The faults are decided per write by a deterministic seeded schedule,
+// and rules can be keyed by the service method of the message being written.
+package fault
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Action is a kind of fault.
+type Action int
+
+// Fault actions
+const (
+	// ActionNone writes normally.
+	ActionNone Action = iota
+	// ActionDelay sleeps Rule.Delay before writing.
+	ActionDelay
+	// ActionDrop discards the write but reports success.
+	ActionDrop
+	// ActionTruncate writes only the first Rule.Keep bytes (half if 0) but reports success.
+	ActionTruncate
+	// ActionCorrupt flips Rule.Corrupt bytes (1 if 0) at random positions.
+	ActionCorrupt
+	// ActionHalfClose shuts down the writing side of the connection, the reading side still works.
+	ActionHalfClose
+	// ActionReset closes the connection abruptly.
+	ActionReset
+)
+
+var actionNames = [...]string{"none", "delay", "drop", "truncate", "corrupt", "half-close", "reset"}
+
+// String returns the action name.
+func (a Action) String() string {
+	if a >= 0 && int(a) < len(actionNames) {
+		return actionNames[a]
+	}
+	return "unknown"
+}
+
+var (
+	// ErrHalfClosed is returned by the writes after ActionHalfClose.
+	ErrHalfClosed = errors.New("fault: connection half-closed")
+	// ErrReset is returned by the writes after ActionReset.
+	ErrReset = errors.New("fault: connection reset")
+)
+
+// Rule selects the writes to inject a fault into.
+type Rule struct {
+	// ServiceMethod matches the service method of the message, empty matches all.
+	ServiceMethod string
+	// Mtype matches the message type, 0 matches all.
+	Mtype byte
+	// Skip skips the first matched writes.
+	Skip int
+	// Times is the maximum number of injections, 0 means unlimited.
+	Times int
+	// Probability injects with the probability from the seeded random source, 0 means always.
+	Probability float64
+	// Action is the fault to inject.
+	Action Action
+	// Delay is the sleep duration of ActionDelay.
+	Delay time.Duration
+	// Keep is the number of bytes written by ActionTruncate, 0 means half.
+	Keep int
+	// Corrupt is the number of bytes flipped by ActionCorrupt, 0 means 1.
+	Corrupt int
+}
+
+// Message describes the message being written.
+// NOTE: It is unknown for the raw connection that is not installed by the plugin or Wrap.
+type Message struct {
+	ServiceMethod string
+	Mtype         byte
+	Seq           int32
+}
+
+// Event records an injected fault.
+type Event struct {
+	Message
+	Action Action
+	Rule   int // index of the rule
+}
+
+// Injector decides the faults by the rules and a seeded random source.
+// NOTE: The same seed, rules and write order always result in the same faults.
+type Injector struct {
+	mu      sync.Mutex
+	rng     *rand.Rand
+	rules   []Rule
+	matched []int
+	applied []int
+	events  []Event
+}
+
+// NewInjector creates a fault injector, the first matched rule of a write wins.
+func NewInjector(seed int64, rules ...Rule) *Injector {
+	return &Injector{
+		rng:     rand.New(rand.NewSource(seed)),
+		rules:   rules,
+		matched: make([]int, len(rules)),
+		applied: make([]int, len(rules)),
+	}
+}
+
+// decide returns the rule to apply to the write, msg is nil if unknown.
+func (i *Injector) decide(msg *Message) (Rule, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for idx, r := range i.rules {
+		if r.ServiceMethod != "" && (msg == nil || msg.ServiceMethod != r.ServiceMethod) {
+			continue
+		}
+		if r.Mtype != 0 && (msg == nil || msg.Mtype != r.Mtype) {
+			continue
+		}
+		i.matched[idx]++
+		if i.matched[idx] <= r.Skip {
+			continue
+		}
+		if r.Times > 0 && i.applied[idx] >= r.Times {
+			continue
+		}
+		if r.Probability > 0 && i.rng.Float64() >= r.Probability {
+			continue
+		}
+		i.applied[idx]++
+		e := Event{Action: r.Action, Rule: idx}
+		if msg != nil {
+			e.Message = *msg
+		}
+		i.events = append(i.events, e)
+		return r, true
+	}
+	return Rule{}, false
+}
+
+// intn returns a deterministic random number in [0,n).
+func (i *Injector) intn(n int) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rng.Intn(n)
+}
+
+// Events returns a copy of the injected faults in order.
+func (i *Injector) Events() []Event {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]Event(nil), i.events...)
+}
+
+// Reset clears the counters and events, and reseeds the random source.
+func (i *Injector) Reset(seed int64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.rng = rand.New(rand.NewSource(seed))
+	i.matched = make([]int, len(i.rules))
+	i.applied = make([]int, len(i.rules))
+	i.events = nil
+}
@@ -0,0 +1,118 @@
+package fault_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/plugin/fault"
+)
+
+type Echo struct {
+	erpc.CallCtx
+}
+
+func (e *Echo) Say(arg *string) (string, *erpc.Status) {
+	return *arg, nil
+}
+
+func (e *Echo) Other(arg *string) (string, *erpc.Status) {
+	return *arg, nil
+}
+
+func route(srv, cli erpc.Peer) {
+	srv.RouteCall(new(Echo))
+}
+
+func TestDrop(t *testing.T) {
+	inj := fault.NewInjector(1, fault.Rule{ServiceMethod: "/echo/say", Action: fault.ActionDrop, Times: 1})
+	h := fault.NewHarness(t, fault.HarnessConfig{
+		ClientInjector: inj,
+		Route:          route,
+		Timeout:        time.Millisecond * 500,
+	})
+	defer h.Close()
+	var result string
+	h.ExpectCall("/echo/other", "a", &result, erpc.CodeOK)
+	h.ExpectCall("/echo/say", "b", &result, erpc.CodeHandleTimeout)
+	h.ExpectCall("/echo/say", "c", &result, erpc.CodeOK)
+	if result != "c" {
+		t.Fatalf("want c, got: %s", result)
+	}
+	h.ExpectEvents(inj, fault.ActionDrop)
+	if e := inj.Events()[0]; e.ServiceMethod != "/echo/say" || e.Mtype != erpc.TypeCall {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+}
+
+func TestReplyFaults(t *testing.T) {
+	inj := fault.NewInjector(1,
+		fault.Rule{ServiceMethod: "/echo/say", Action: fault.ActionDelay, Delay: time.Millisecond * 100, Times: 1},
+		fault.Rule{ServiceMethod: "/echo/say", Action: fault.ActionTruncate, Times: 1},
+	)
+	h := fault.NewHarness(t, fault.HarnessConfig{
+		ServerInjector: inj,
+		Route:          route,
+		Timeout:        time.Millisecond * 500,
+	})
+	defer h.Close()
+	var result string
+	start := time.Now()
+	h.ExpectCall("/echo/say", "a", &result, erpc.CodeOK)
+	if cost := time.Since(start); cost < time.Millisecond*100 {
+		t.Fatalf("want delay, cost: %v", cost)
+	}
+	// the client waits for the rest of the truncated reply
+	h.ExpectCall("/echo/say", "b", &result, erpc.CodeHandleTimeout)
+	h.ExpectEvents(inj, fault.ActionDelay, fault.ActionTruncate)
+	if e := inj.Events()[1]; e.Mtype != erpc.TypeReply {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+}
+
+func TestReset(t *testing.T) {
+	inj := fault.NewInjector(1, fault.Rule{ServiceMethod: "/echo/say", Action: fault.ActionReset})
+	h := fault.NewHarness(t, fault.HarnessConfig{
+		ClientInjector: inj,
+		Route:          route,
+	})
+	defer h.Close()
+	var result string
+	h.ExpectCall("/echo/say", "a", &result, erpc.CodeWriteFailed)
+	h.ExpectEvents(inj, fault.ActionReset)
+}
+
+func TestSchedule(t *testing.T) {
+	run := func() []fault.Event {
+		inj := fault.NewInjector(7,
+			fault.Rule{Action: fault.ActionDelay, Probability: 0.3, Skip: 1},
+			fault.Rule{Action: fault.ActionCorrupt, Probability: 0.3},
+		)
+		h := fault.NewHarness(t, fault.HarnessConfig{
+			ClientInjector: inj,
+			Route:          route,
+			Timeout:        time.Millisecond * 300,
+		})
+		defer h.Close()
+		var result string
+		for i := 0; i < 10; i++ {
+			h.Call("/echo/say", "a", &result)
+			if !h.Session.Health() {
+				break
+			}
+		}
+		return inj.Events()
+	}
+	a, b := run(), run()
+	if len(a) == 0 {
+		t.Fatal("want faults")
+	}
+	if len(a) != len(b) {
+		t.Fatalf("not deterministic:\n%+v\n%+v", a, b)
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("not deterministic:\n%+v\n%+v", a, b)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fault
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+)
+
+// TB is the subset of testing.TB used by the harness,
+// so that the package does not import the testing package.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// HarnessConfig config of the fault harness.
+type HarnessConfig struct {
+	// Server and Client are the peer configs, Network and ListenPort are set by the harness.
+	Server, Client erpc.PeerConfig
+	// ServerInjector and ClientInjector inject faults into the writes of each side, nil means no fault.
+	ServerInjector, ClientInjector *Injector
+	// ServerPlugins and ClientPlugins are the other plugins of each side.
+	ServerPlugins, ClientPlugins []erpc.Plugin
+	// Route registers the handlers.
+	Route func(server, client erpc.Peer)
+	// Timeout is the maximum duration to wait for a reply, default 3s.
+	Timeout time.Duration
+}
+
+// Harness is a server and a client connected by the in-process network,
+// the faults are injected and the resulting statuses are asserted.
+type Harness struct {
+	t       TB
+	timeout time.Duration
+	Server  erpc.Peer
+	Client  erpc.Peer
+	Session erpc.Session
+}
+
+// NewHarness starts the server, and dials it by the client.
+func NewHarness(t TB, cfg HarnessConfig) *Harness {
+	t.Helper()
+	// take a free port of the in-process network
+	lis, err := mem.Listen(":0", nil)
+	if err != nil {
+		t.Fatalf("fault harness: listen: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(lis.Addr().String())
+	lis.Close()
+	listenPort, _ := strconv.ParseUint(port, 10, 16)
+
+	srvCfg, cliCfg := cfg.Server, cfg.Client
+	srvCfg.Network, cliCfg.Network = mem.Network, mem.Network
+	srvCfg.ListenPort = uint16(listenPort)
+	srvPlugins, cliPlugins := cfg.ServerPlugins, cfg.ClientPlugins
+	if cfg.ServerInjector != nil {
+		srvPlugins = append(srvPlugins[:len(srvPlugins):len(srvPlugins)], NewPlugin(cfg.ServerInjector))
+	}
+	if cfg.ClientInjector != nil {
+		cliPlugins = append(cliPlugins[:len(cliPlugins):len(cliPlugins)], NewPlugin(cfg.ClientInjector))
+	}
+	h := &Harness{
+		t:       t,
+		timeout: cfg.Timeout,
+		Server:  erpc.NewPeer(srvCfg, srvPlugins...),
+		Client:  erpc.NewPeer(cliCfg, cliPlugins...),
+	}
+	if h.timeout <= 0 {
+		h.timeout = time.Second * 3
+	}
+	if cfg.Route != nil {
+		cfg.Route(h.Server, h.Client)
+	}
+	go h.Server.ListenAndServe()
+	var stat *erpc.Status
+	h.Session, stat = h.Client.Dial(":" + port)
+	if !stat.OK() {
+		h.Close()
+		t.Fatalf("fault harness: dial: %v", stat)
+	}
+	return h
+}
+
+// Call sends a CALL and waits for the reply,
+// it returns a CodeHandleTimeout status if there is no reply within the timeout.
+func (h *Harness) Call(serviceMethod string, arg, result interface{}, setting ...erpc.MessageSetting) *erpc.Status {
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	callCmd := h.Session.AsyncCall(serviceMethod, arg, result, make(chan erpc.CallCmd, 1), setting...)
+	select {
+	case <-callCmd.Done():
+		return callCmd.Status()
+	case <-ctx.Done():
+		return erpc.NewStatus(erpc.CodeHandleTimeout, erpc.CodeText(erpc.CodeHandleTimeout), "fault harness: no reply within "+h.timeout.String())
+	}
+}
+
+// ExpectCall sends a CALL and asserts the status code of the reply.
+func (h *Harness) ExpectCall(serviceMethod string, arg, result interface{}, wantCode int32, setting ...erpc.MessageSetting) *erpc.Status {
+	h.t.Helper()
+	stat := h.Call(serviceMethod, arg, result, setting...)
+	if stat.Code() != wantCode {
+		h.t.Errorf("fault harness: CALL %s: want code %d, got: %v", serviceMethod, wantCode, stat)
+	}
+	return stat
+}
+
+// ExpectPush sends a PUSH and asserts the status code of the writing.
+func (h *Harness) ExpectPush(serviceMethod string, arg interface{}, wantCode int32, setting ...erpc.MessageSetting) *erpc.Status {
+	h.t.Helper()
+	stat := h.Session.Push(serviceMethod, arg, setting...)
+	if stat.Code() != wantCode {
+		h.t.Errorf("fault harness: PUSH %s: want code %d, got: %v", serviceMethod, wantCode, stat)
+	}
+	return stat
+}
+
+// ExpectEvents asserts the actions of the injected faults in order.
+func (h *Harness) ExpectEvents(inj *Injector, want ...Action) {
+	h.t.Helper()
+	events := inj.Events()
+	if len(events) != len(want) {
+		h.t.Errorf("fault harness: want %d faults, got: %+v", len(want), events)
+		return
+	}
+	for i, e := range events {
+		if e.Action != want[i] {
+			h.t.Errorf("fault harness: fault %d: want %s, got: %s", i, want[i], e.Action)
+		}
+	}
+}
+
+// Close closes the server and the client.
+// NOTE: The server is closed first, so that the CALLs without reply are canceled.
+func (h *Harness) Close() {
+	h.Server.Close()
+	h.Client.Close()
+}
@@ -0,0 +1,76 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fault
+
+import (
+	"net"
+	"sync"
+
+	"github.com/andeya/erpc/v7"
+)
+
+// NewPlugin returns a plugin that injects faults into all the sessions of the peer,
+// including the redialed ones.
+func NewPlugin(inj *Injector) erpc.Plugin {
+	return &faultPlugin{inj: inj}
+}
+
+type faultPlugin struct {
+	inj *Injector
+	// original ProtoFunc of the sessions, the socket is reset with it when redialing
+	protoFuncs sync.Map
+}
+
+var (
+	_ erpc.PostDialPlugin       = new(faultPlugin)
+	_ erpc.PostAcceptPlugin     = new(faultPlugin)
+	_ erpc.PostDisconnectPlugin = new(faultPlugin)
+)
+
+func (p *faultPlugin) Name() string {
+	return "fault"
+}
+
+func (p *faultPlugin) PostDial(sess erpc.PreSession, isRedial bool) *erpc.Status {
+	p.wrap(sess)
+	return nil
+}
+
+func (p *faultPlugin) PostAccept(sess erpc.PreSession) *erpc.Status {
+	p.wrap(sess)
+	return nil
+}
+
+func (p *faultPlugin) PostDisconnect(sess erpc.BaseSession) *erpc.Status {
+	p.protoFuncs.Delete(sess)
+	return nil
+}
+
+func (p *faultPlugin) wrap(sess erpc.PreSession) {
+	protoFunc, _ := p.protoFuncs.LoadOrStore(sess, sess.GetProtoFunc())
+	modify(sess, p.inj, protoFunc.(erpc.ProtoFunc))
+}
+
+// Wrap injects faults into the session via Session.ModifySocket.
+// NOTE: The redialed connection is not wrapped, use NewPlugin for it.
+func Wrap(sess erpc.PreSession, inj *Injector) {
+	modify(sess, inj, sess.GetProtoFunc())
+}
+
+func modify(sess erpc.PreSession, inj *Injector, protoFunc erpc.ProtoFunc) {
+	sess.ModifySocket(func(conn net.Conn) (net.Conn, erpc.ProtoFunc) {
+		return NewConn(conn, inj), WrapProtoFunc(protoFunc)
+	})
+}
@@ -0,0 +1,164 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/codec"
+	"gopkg.in/yaml.v2"
+)
+
+// Config the declarative route table of the gateway.
+// NOTE: The config file is in YAML, and JSON is also accepted.
+type Config struct {
+	Upstreams []UpstreamConfig `yaml:"upstreams"`
+	Routes    []RouteConfig    `yaml:"routes"`
+}
+
+// UpstreamConfig an upstream pool.
+type UpstreamConfig struct {
+	Name    string   `yaml:"name"`
+	Network string   `yaml:"network"` // network of the upstream, default tcp
+	Addrs   []string `yaml:"addrs"`
+	// Proto is the protocol to the upstream: raw (default), json, pb, http, or the one registered by RegProto.
+	Proto string `yaml:"proto"`
+	// Balance is the balancing strategy: round_robin (default), random, least_pending, or hash.
+	Balance string `yaml:"balance"`
+	// HashMeta is the metadata key of the hash strategy, default the real IP.
+	HashMeta string `yaml:"hash_meta"`
+	// Retries is the times to retry another address on the connection errors.
+	Retries int `yaml:"retries"`
+}
+
+// RouteConfig a route from the service methods to an upstream pool.
+// NOTE: The routes are matched in order, and the first matched one is used.
+type RouteConfig struct {
+	// Prefix matches the service method prefix.
+	Prefix string `yaml:"prefix"`
+	// Regex matches the service method by the regular expression.
+	Regex string `yaml:"regex"`
+	// Meta matches the metadata of the input message, and "*" matches any existing value.
+	Meta     map[string]string `yaml:"meta"`
+	Upstream string            `yaml:"upstream"`
+	// Rewrite replaces the matched prefix, or the match of the regular expression with the $1 expansions.
+	Rewrite   string            `yaml:"rewrite"`
+	SetMeta   map[string]string `yaml:"set_meta"`
+	StripMeta []string          `yaml:"strip_meta"`
+	Timeout   time.Duration     `yaml:"timeout"`
+	// BodyCodec is the body codec name to the upstream, such as protobuf,
+	// and the body is translated by the Request and Reply types registered by RegType.
+	BodyCodec string `yaml:"body_codec"`
+	Request   string `yaml:"request"`
+	Reply     string `yaml:"reply"`
+}
+
+// LoadConfig loads the route table from the file.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	err = yaml.UnmarshalStrict(b, &cfg)
+	return cfg, err
+}
+
+// route the compiled route.
+type route struct {
+	cfg        *RouteConfig
+	regex      *regexp.Regexp
+	upstream   *upstream
+	bodyCodec  byte
+	newRequest func() interface{}
+	newReply   func() interface{}
+	settings   []erpc.MessageSetting
+}
+
+func compileRoute(cfg *RouteConfig, upstreams map[string]*upstream) (*route, error) {
+	r := &route{cfg: cfg}
+	if (cfg.Prefix == "") == (cfg.Regex == "") {
+		return nil, errors.New("one of prefix and regex is required")
+	}
+	if cfg.Regex != "" {
+		var err error
+		if r.regex, err = regexp.Compile(cfg.Regex); err != nil {
+			return nil, err
+		}
+	}
+	var ok bool
+	if r.upstream, ok = upstreams[cfg.Upstream]; !ok {
+		return nil, fmt.Errorf("unknown upstream %q", cfg.Upstream)
+	}
+	if cfg.BodyCodec != "" {
+		c, err := codec.GetByName(cfg.BodyCodec)
+		if err != nil {
+			return nil, err
+		}
+		r.bodyCodec = c.ID()
+		if r.newRequest, ok = getType(cfg.Request); !ok {
+			return nil, fmt.Errorf("unknown request type %q", cfg.Request)
+		}
+		if r.newReply, ok = getType(cfg.Reply); !ok {
+			return nil, fmt.Errorf("unknown reply type %q", cfg.Reply)
+		}
+	}
+	for _, key := range cfg.StripMeta {
+		r.settings = append(r.settings, erpc.WithDelMeta(key))
+	}
+	for key, value := range cfg.SetMeta {
+		r.settings = append(r.settings, erpc.WithSetMeta(key, value))
+	}
+	return r, nil
+}
+
+// match returns whether the route matches the input message.
+func (r *route) match(serviceMethod string, peekMeta func(key string) []byte) bool {
+	if r.regex != nil {
+		if !r.regex.MatchString(serviceMethod) {
+			return false
+		}
+	} else if !strings.HasPrefix(serviceMethod, r.cfg.Prefix) {
+		return false
+	}
+	for key, want := range r.cfg.Meta {
+		value := peekMeta(key)
+		if want == "*" {
+			if len(value) == 0 {
+				return false
+			}
+		} else if string(value) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// rewrite returns the service method to the upstream.
+func (r *route) rewrite(serviceMethod string) string {
+	if r.cfg.Rewrite == "" {
+		return serviceMethod
+	}
+	if r.regex != nil {
+		return r.regex.ReplaceAllString(serviceMethod, r.cfg.Rewrite)
+	}
+	return r.cfg.Rewrite + strings.TrimPrefix(serviceMethod, r.cfg.Prefix)
+}
@@ -0,0 +1,309 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gateway provides the L7 gateway with a declarative route table, built on plugin/proxy.
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/codec"
+	"github.com/andeya/erpc/v7/plugin/proxy"
+)
+
+// Gateway the plugin forwarding the unknown CALL and PUSH by the route table.
+type Gateway struct {
+	proxy erpc.Plugin
+	table atomic.Value // *table
+	mu    sync.Mutex   // serializes the reloads
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// table the compiled route table.
+type table struct {
+	routes    []*route
+	upstreams map[string]*upstream
+}
+
+var _ erpc.PostNewPeerPlugin = (*Gateway)(nil)
+
+// New creates a gateway plugin with the route table.
+func New(cfg Config) (*Gateway, error) {
+	g := &Gateway{stop: make(chan struct{})}
+	g.proxy = proxy.NewPlugin(g.forwarder)
+	if err := g.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Load creates a gateway plugin with the route table file.
+func Load(path string) (*Gateway, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg)
+}
+
+// Name returns the plugin name.
+func (g *Gateway) Name() string {
+	return "gateway"
+}
+
+// PostNewPeer handles the unknown CALL and PUSH by the proxy forwarding.
+func (g *Gateway) PostNewPeer(peer erpc.EarlyPeer) error {
+	return g.proxy.(erpc.PostNewPeerPlugin).PostNewPeer(peer)
+}
+
+// Reload replaces the route table.
+// NOTE:
+//
+//	The upstreams of the same config are reused, and the removed ones are closed;
+//	The old route table is kept if the new one is invalid.
+func (g *Gateway) Reload(cfg Config) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var old map[string]*upstream
+	if t, ok := g.table.Load().(*table); ok {
+		old = t.upstreams
+	}
+	t := &table{upstreams: make(map[string]*upstream, len(cfg.Upstreams))}
+	var created []*upstream
+	fail := func(err error) error {
+		for _, u := range created {
+			u.close()
+		}
+		return err
+	}
+	for _, c := range cfg.Upstreams {
+		if _, ok := t.upstreams[c.Name]; ok {
+			return fail(fmt.Errorf("duplicate upstream %q", c.Name))
+		}
+		if u, ok := old[c.Name]; ok && u.sameAs(c) {
+			t.upstreams[c.Name] = u
+			continue
+		}
+		u, err := newUpstream(c)
+		if err != nil {
+			return fail(err)
+		}
+		created = append(created, u)
+		t.upstreams[c.Name] = u
+	}
+	for i := range cfg.Routes {
+		r, err := compileRoute(&cfg.Routes[i], t.upstreams)
+		if err != nil {
+			return fail(fmt.Errorf("route %d: %v", i, err))
+		}
+		t.routes = append(t.routes, r)
+	}
+	g.table.Store(t)
+	for name, u := range old {
+		if t.upstreams[name] != u {
+			u.close()
+		}
+	}
+	return nil
+}
+
+// ReloadFile replaces the route table by the file.
+func (g *Gateway) ReloadFile(path string) error {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	return g.Reload(cfg)
+}
+
+// Watch reloads the route table file when it is modified, checking at the interval until Close.
+func (g *Gateway) Watch(path string, interval time.Duration) {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+	erpc.AnywayGo(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-g.stop:
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			if err = g.ReloadFile(path); err != nil {
+				erpc.Errorf("gateway: reload %s: %v", path, err)
+			} else {
+				erpc.Infof("gateway: reloaded %s", path)
+			}
+		}
+	})
+}
+
+// Close stops watching, and closes the upstreams.
+func (g *Gateway) Close() {
+	g.once.Do(func() {
+		close(g.stop)
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if t, ok := g.table.Load().(*table); ok {
+			for _, u := range t.upstreams {
+				u.close()
+			}
+		}
+	})
+}
+
+func (g *Gateway) forwarder(label *proxy.Label) proxy.Forwarder {
+	t := g.table.Load().(*table)
+	for _, r := range t.routes {
+		if r.match(label.ServiceMethod, label.PeekMeta) {
+			return &forwarder{route: r, label: label}
+		}
+	}
+	return errForwarder{erpc.CodeNotFound, "no gateway route", label.ServiceMethod}
+}
+
+// forwarder forwards the input message by the route.
+type forwarder struct {
+	route *route
+	label *proxy.Label
+}
+
+func (f *forwarder) Call(uri string, arg interface{}, result interface{}, setting ...erpc.MessageSetting) erpc.CallCmd {
+	r := f.route
+	uri = r.rewrite(uri)
+	setting, translate, stat := f.prepare(&arg, setting)
+	if !stat.OK() {
+		return erpc.NewFakeCallCmd(uri, arg, result, stat)
+	}
+	ctx := context.Background()
+	if r.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.cfg.Timeout)
+		defer cancel()
+		setting = append(setting, erpc.WithContext(ctx))
+	}
+	var (
+		cmd   erpc.CallCmd
+		reply []byte
+	)
+	stat, sent := r.upstream.do(f.hashKey(), func(sess erpc.Session) *erpc.Status {
+		cmd = sess.AsyncCall(uri, arg, &reply, make(chan erpc.CallCmd, 1), setting...)
+		select {
+		case <-cmd.Done():
+			return cmd.Status()
+		case <-ctx.Done():
+			// NOTE: The late reply is dropped.
+			cmd = nil
+			return erpc.NewStatus(erpc.CodeHandleTimeout, "gateway timeout", uri)
+		}
+	})
+	if !sent || cmd == nil {
+		return erpc.NewFakeCallCmd(uri, arg, result, stat)
+	}
+	if cmd.StatusOK() && translate && cmd.InputBodyCodec() != f.label.BodyCodec {
+		var err error
+		if reply, err = convert(reply, cmd.InputBodyCodec(), f.label.BodyCodec, r.newReply()); err != nil {
+			return erpc.NewFakeCallCmd(uri, arg, result, erpc.NewStatus(erpc.CodeBadGateway, "translate reply", err.Error()))
+		}
+	}
+	if p, ok := result.(*[]byte); ok {
+		*p = reply
+	}
+	return cmd
+}
+
+func (f *forwarder) Push(uri string, arg interface{}, setting ...erpc.MessageSetting) *erpc.Status {
+	r := f.route
+	uri = r.rewrite(uri)
+	setting, _, stat := f.prepare(&arg, setting)
+	if !stat.OK() {
+		return stat
+	}
+	stat, _ = r.upstream.do(f.hashKey(), func(sess erpc.Session) *erpc.Status {
+		return sess.Push(uri, arg, setting...)
+	})
+	return stat
+}
+
+// prepare rewrites the metadata, and translates the body to the upstream codec.
+func (f *forwarder) prepare(arg *interface{}, setting []erpc.MessageSetting) ([]erpc.MessageSetting, bool, *erpc.Status) {
+	r := f.route
+	setting = append(setting, r.settings...)
+	bodyCodec := f.label.BodyCodec
+	translate := r.bodyCodec != codec.NilCodecID && r.bodyCodec != bodyCodec
+	if translate {
+		body, ok := (*arg).([]byte)
+		if !ok {
+			return nil, false, erpc.NewStatus(erpc.CodeBadMessage, "translate request", "unexpected body type")
+		}
+		b, err := convert(body, bodyCodec, r.bodyCodec, r.newRequest())
+		if err != nil {
+			return nil, false, erpc.NewStatus(erpc.CodeBadMessage, "translate request", err.Error())
+		}
+		*arg = b
+		bodyCodec = r.bodyCodec
+	}
+	return append(setting, erpc.WithBodyCodec(bodyCodec)), translate, nil
+}
+
+func (f *forwarder) hashKey() []byte {
+	if key := f.route.upstream.cfg.HashMeta; key != "" {
+		return f.label.PeekMeta(key)
+	}
+	return []byte(f.label.RealIP)
+}
+
+// convert decodes the body by the source codec into v, and encodes it by the target codec.
+func convert(body []byte, from, to byte, v interface{}) ([]byte, error) {
+	src, err := codec.Get(from)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := codec.Get(to)
+	if err != nil {
+		return nil, err
+	}
+	if err = src.Unmarshal(body, v); err != nil {
+		return nil, err
+	}
+	return dst.Marshal(v)
+}
+
+// errForwarder replies the status without forwarding.
+type errForwarder struct {
+	code       int32
+	msg, cause string
+}
+
+func (e errForwarder) Call(uri string, arg interface{}, result interface{}, setting ...erpc.MessageSetting) erpc.CallCmd {
+	return erpc.NewFakeCallCmd(uri, arg, result, erpc.NewStatus(e.code, e.msg, e.cause))
+}
+
+func (e errForwarder) Push(uri string, arg interface{}, setting ...erpc.MessageSetting) *erpc.Status {
+	return erpc.NewStatus(e.code, e.msg, e.cause)
+}
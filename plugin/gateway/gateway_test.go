@@ -0,0 +1,169 @@
+package gateway_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+	"github.com/andeya/erpc/v7/plugin/gateway"
+	"github.com/andeya/erpc/v7/proto/httproto"
+	"github.com/andeya/erpc/v7/socket/example/pb"
+)
+
+// port returns the listening port of the upstream.
+func port(ctx erpc.CallCtx) int {
+	_, p, _ := net.SplitHostPort(ctx.Session().LocalAddr().String())
+	n, _ := strconv.Atoi(p)
+	return n
+}
+
+type calc struct {
+	erpc.CallCtx
+}
+
+// Add returns the sum in A, and the upstream port in B.
+func (c *calc) Add(arg *pb.PbTest) (*pb.PbTest, *erpc.Status) {
+	return &pb.PbTest{A: arg.A + arg.B, B: int32(port(c))}, nil
+}
+
+type echo struct {
+	erpc.CallCtx
+}
+
+// Meta returns the metadata received by the upstream.
+func (e *echo) Meta(*struct{}) (map[string]string, *erpc.Status) {
+	return map[string]string{
+		"env":    string(e.PeekMeta("X-Env")),
+		"secret": string(e.PeekMeta("X-Secret")),
+		"port":   strconv.Itoa(port(e)),
+	}, nil
+}
+
+// Slow replies after the gateway timeout.
+func (e *echo) Slow(*struct{}) (string, *erpc.Status) {
+	time.Sleep(time.Millisecond * 200)
+	return "late", nil
+}
+
+func init() {
+	gateway.RegType("PbTest", func() interface{} { return new(pb.PbTest) })
+}
+
+// routeTable is formatted with the addresses of the two upstreams.
+const routeTable = `
+upstreams:
+  - name: calc
+    network: mem
+    addrs: [%[1]q, %[2]q]
+  - name: echo
+    network: mem
+    addrs: [%[1]q]
+routes:
+  - regex: "^/api/calc/(.*)$"
+    rewrite: "/calc/$1"
+    upstream: calc
+    body_codec: protobuf
+    request: PbTest
+    reply: PbTest
+  - prefix: /api/echo
+    rewrite: /echo
+    meta: {X-Tenant: "*"}
+    upstream: echo
+    set_meta: {X-Env: prod}
+    strip_meta: [X-Secret]
+    timeout: 50ms
+`
+
+func TestGateway(t *testing.T) {
+	var upAddrs, upPorts [2]string
+	for i := range upAddrs {
+		up := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+		up.RouteCall(new(calc))
+		up.RouteCall(new(echo))
+		upAddrs[i] = memtest.Serve(up)
+		_, upPorts[i], _ = net.SplitHostPort(upAddrs[i])
+		defer up.Close()
+	}
+	table := fmt.Sprintf(routeTable, upAddrs[0], upAddrs[1])
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	if err := os.WriteFile(path, []byte(table), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gw, err := gateway.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gw.Close()
+	gw.Watch(path, time.Millisecond*20)
+	srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network}, gw)
+	addr := memtest.Serve(srv, httproto.NewHTTProtoFunc())
+	defer srv.Close()
+
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+	defer cli.Close()
+	sess, stat := cli.Dial(addr, httproto.NewHTTProtoFunc())
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+
+	// HTTP JSON is translated to protobuf, and balanced by round robin
+	ports := make(map[int]bool)
+	for i := 0; i < 2; i++ {
+		var result map[string]int
+		stat = sess.Call("http://gateway/api/calc/add", map[string]int{"a": 1, "b": 2}, &result).Status()
+		if !stat.OK() || result["a"] != 3 {
+			t.Fatalf("want a=3, got: %v, %v", result, stat)
+		}
+		ports[result["b"]] = true
+	}
+	if len(ports) != 2 {
+		t.Fatalf("want balanced to 2 upstreams, got: %v", ports)
+	}
+
+	// the metadata matching, injection and stripping
+	var meta map[string]string
+	stat = sess.Call("http://gateway/api/echo/meta", struct{}{}, &meta).Status()
+	if stat.Code() != erpc.CodeNotFound {
+		t.Fatalf("want no route without X-Tenant, got: %v", stat)
+	}
+	stat = sess.Call("http://gateway/api/echo/meta", struct{}{}, &meta,
+		erpc.WithSetMeta("X-Tenant", "t1"), erpc.WithSetMeta("X-Secret", "s"),
+	).Status()
+	if !stat.OK() || meta["env"] != "prod" || meta["secret"] != "" {
+		t.Fatalf("want the injected X-Env and the stripped X-Secret, got: %v, %v", meta, stat)
+	}
+	if meta["port"] != upPorts[0] {
+		t.Fatalf("want the upstream %s, got: %s", upAddrs[0], meta["port"])
+	}
+
+	// the route timeout
+	var s string
+	if stat = sess.Call("http://gateway/api/echo/slow", struct{}{}, &s, erpc.WithSetMeta("X-Tenant", "t1")).Status(); stat.OK() {
+		t.Fatalf("want the timeout, got: %q", s)
+	}
+
+	// hot reload routes the echo to the other upstream
+	reloaded := strings.Replace(table, fmt.Sprintf("addrs: [%q]", upAddrs[0]), fmt.Sprintf("addrs: [%q]", upAddrs[1]), 1)
+	if err := os.WriteFile(path, []byte(reloaded), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second * 3)
+	for {
+		stat = sess.Call("http://gateway/api/echo/meta", struct{}{}, &meta, erpc.WithSetMeta("X-Tenant", "t1")).Status()
+		if stat.OK() && meta["port"] == upPorts[1] {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want the reloaded upstream %s, got: %v, %v", upAddrs[1], meta, stat)
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gateway
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/proto/httproto"
+	"github.com/andeya/erpc/v7/proto/jsonproto"
+	"github.com/andeya/erpc/v7/proto/pbproto"
+	"github.com/andeya/erpc/v7/proto/rawproto"
+)
+
+var (
+	protoLock  sync.RWMutex
+	protoFuncs = map[string]func() erpc.ProtoFunc{
+		"raw":  rawproto.NewRawProtoFunc,
+		"json": jsonproto.NewJSONProtoFunc,
+		"pb":   pbproto.NewPbProtoFunc,
+		"http": func() erpc.ProtoFunc { return httproto.NewHTTProtoFunc() },
+	}
+	typeLock sync.RWMutex
+	types    = make(map[string]func() interface{})
+)
+
+// RegProto registers the protocol to the upstreams by name.
+func RegProto(name string, fn func() erpc.ProtoFunc) {
+	protoLock.Lock()
+	protoFuncs[name] = fn
+	protoLock.Unlock()
+}
+
+// RegType registers the body type for the translation by name.
+func RegType(name string, newFunc func() interface{}) {
+	typeLock.Lock()
+	types[name] = newFunc
+	typeLock.Unlock()
+}
+
+func getType(name string) (func() interface{}, bool) {
+	typeLock.RLock()
+	defer typeLock.RUnlock()
+	fn, ok := types[name]
+	return fn, ok
+}
+
+// Balancing strategies.
+const (
+	RoundRobin   = "round_robin"
+	Random       = "random"
+	LeastPending = "least_pending"
+	Hash         = "hash"
+)
+
+// upstream the pool of the upstream addresses.
+type upstream struct {
+	cfg       UpstreamConfig
+	peer      erpc.Peer
+	protoFunc erpc.ProtoFunc
+	next      uint64
+	pending   []int64 // the pending requests per address
+	mu        sync.Mutex
+	sessions  map[string]erpc.Session
+}
+
+func newUpstream(cfg UpstreamConfig) (*upstream, error) {
+	if len(cfg.Addrs) == 0 {
+		return nil, fmt.Errorf("upstream %q: no address", cfg.Name)
+	}
+	switch cfg.Balance {
+	case "":
+		cfg.Balance = RoundRobin
+	case RoundRobin, Random, LeastPending, Hash:
+	default:
+		return nil, fmt.Errorf("upstream %q: unknown balancing strategy %q", cfg.Name, cfg.Balance)
+	}
+	if cfg.Proto == "" {
+		cfg.Proto = "raw"
+	}
+	protoLock.RLock()
+	newProto, ok := protoFuncs[cfg.Proto]
+	protoLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("upstream %q: unknown protocol %q", cfg.Name, cfg.Proto)
+	}
+	return &upstream{
+		cfg:       cfg,
+		peer:      erpc.NewPeer(erpc.PeerConfig{Network: cfg.Network}),
+		protoFunc: newProto(),
+		pending:   make([]int64, len(cfg.Addrs)),
+		sessions:  make(map[string]erpc.Session),
+	}, nil
+}
+
+// sameAs returns whether the upstream can be reused for the config.
+func (u *upstream) sameAs(cfg UpstreamConfig) bool {
+	if cfg.Balance == "" {
+		cfg.Balance = RoundRobin
+	}
+	if cfg.Proto == "" {
+		cfg.Proto = "raw"
+	}
+	return reflect.DeepEqual(u.cfg, cfg)
+}
+
+func (u *upstream) close() {
+	u.peer.Close()
+}
+
+// pick returns the address index for the attempt.
+func (u *upstream) pick(hashKey []byte, attempt int) int {
+	n := len(u.cfg.Addrs)
+	switch u.cfg.Balance {
+	case Random:
+		return rand.Intn(n)
+	case LeastPending:
+		start := int(atomic.AddUint64(&u.next, 1) % uint64(n))
+		best := start
+		for k := 1; k < n; k++ {
+			if i := (start + k) % n; atomic.LoadInt64(&u.pending[i]) < atomic.LoadInt64(&u.pending[best]) {
+				best = i
+			}
+		}
+		return (best + attempt) % n
+	case Hash:
+		h := fnv.New32a()
+		h.Write(hashKey)
+		return (int(h.Sum32()%uint32(n)) + attempt) % n
+	default:
+		return int(atomic.AddUint64(&u.next, 1) % uint64(n))
+	}
+}
+
+// session returns the session to the address, and dials it if necessary.
+func (u *upstream) session(addr string) (erpc.Session, *erpc.Status) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if sess, ok := u.sessions[addr]; ok && sess.Health() {
+		return sess, nil
+	}
+	sess, stat := u.peer.Dial(addr, u.protoFunc)
+	if !stat.OK() {
+		return nil, stat
+	}
+	u.sessions[addr] = sess
+	return sess, nil
+}
+
+// do sends the request to the picked address, and retries another one on the connection errors.
+// sent is whether the request of the last attempt has been sent.
+func (u *upstream) do(hashKey []byte, send func(sess erpc.Session) *erpc.Status) (stat *erpc.Status, sent bool) {
+	for attempt := 0; attempt <= u.cfg.Retries; attempt++ {
+		i := u.pick(hashKey, attempt)
+		var sess erpc.Session
+		sess, stat = u.session(u.cfg.Addrs[i])
+		if sent = stat.OK(); sent {
+			atomic.AddInt64(&u.pending[i], 1)
+			stat = send(sess)
+			atomic.AddInt64(&u.pending[i], -1)
+		}
+		if !retriable(stat) {
+			break
+		}
+	}
+	return stat, sent
+}
+
+func retriable(stat *erpc.Status) bool {
+	switch stat.Code() {
+	case erpc.CodeDialFailed, erpc.CodeConnClosed, erpc.CodeWriteFailed:
+		return true
+	}
+	return false
+}
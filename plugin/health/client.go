@@ -0,0 +1,380 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+)
+
+// Check returns the serving status of the service by the health check CALL.
+// NOTE: The peer not ready replies NOT_SERVING.
+func Check(sess erpc.Session, service string, setting ...erpc.MessageSetting) (ServingStatus, *erpc.Status) {
+	var reply CheckReply
+	stat := sess.Call(CheckServiceMethod, &CheckArgs{Service: service}, &reply, setting...).Status()
+	if !stat.OK() {
+		return Unknown, stat
+	}
+	return reply.Status, nil
+}
+
+// WatchFunc handles the serving status of the watched service.
+type WatchFunc func(service string, status ServingStatus)
+
+// Watcher the client plugin receiving the status changes of the watched services.
+type Watcher struct {
+	once     sync.Once
+	mu       sync.Mutex
+	watching map[interface{}]map[string]*watching // session -> service -> watching
+}
+
+type watching struct {
+	fn      WatchFunc
+	pending bool // the watch CALL is in flight
+	updated bool // an update is received while the watch CALL is in flight
+}
+
+var (
+	_ erpc.PostNewPeerPlugin    = (*Watcher)(nil)
+	_ erpc.PostDialPlugin       = (*Watcher)(nil)
+	_ erpc.PostDisconnectPlugin = (*Watcher)(nil)
+)
+
+// WatcherName is the plugin name of the watcher.
+const WatcherName = "health-watcher"
+
+// NewWatcher creates a watcher plugin.
+func NewWatcher() *Watcher {
+	return &Watcher{watching: make(map[interface{}]map[string]*watching)}
+}
+
+// Name returns the plugin name.
+func (w *Watcher) Name() string {
+	return WatcherName
+}
+
+// PostNewPeer registers the update handler.
+func (w *Watcher) PostNewPeer(peer erpc.EarlyPeer) error {
+	w.once.Do(func() {
+		peer.SubRoute("health").RoutePushFunc(update)
+	})
+	return nil
+}
+
+// PostDial watches the services again after redialing.
+func (w *Watcher) PostDial(sess erpc.PreSession, isRedial bool) *erpc.Status {
+	if !isRedial {
+		return nil
+	}
+	w.mu.Lock()
+	var services []string
+	for service := range w.watching[sess] {
+		services = append(services, service)
+	}
+	w.mu.Unlock()
+	if len(services) == 0 {
+		return nil
+	}
+	s, ok := sess.(erpc.Session)
+	if !ok {
+		return nil
+	}
+	erpc.AnywayGo(func() {
+		// wait for the redialed session to be ready
+		for !s.Health() {
+			select {
+			case <-s.CloseNotify():
+				return
+			case <-time.After(time.Millisecond * 10):
+			}
+		}
+		for _, service := range services {
+			if stat := w.call(s, service); !stat.OK() {
+				erpc.Warnf("health: watch %q again: %v", service, stat)
+			}
+		}
+	})
+	return nil
+}
+
+// PostDisconnect forgets the watched services of the session.
+func (w *Watcher) PostDisconnect(sess erpc.BaseSession) *erpc.Status {
+	w.mu.Lock()
+	delete(w.watching, sess)
+	w.mu.Unlock()
+	return nil
+}
+
+// Watch watches the serving status of the service through the session,
+// fn is called with the current status, and then with each change.
+// NOTE: It is watched again after redialing.
+func (w *Watcher) Watch(sess erpc.Session, service string, fn WatchFunc) *erpc.Status {
+	w.mu.Lock()
+	m, ok := w.watching[sess]
+	if !ok {
+		m = make(map[string]*watching)
+		w.watching[sess] = m
+	}
+	m[service] = &watching{fn: fn}
+	w.mu.Unlock()
+	stat := w.call(sess, service)
+	if !stat.OK() {
+		w.forget(sess, service)
+	}
+	return stat
+}
+
+// Unwatch stops watching the service through the session.
+func (w *Watcher) Unwatch(sess erpc.Session, service string) *erpc.Status {
+	w.forget(sess, service)
+	return sess.Call(UnwatchServiceMethod, &CheckArgs{Service: service}, nil).Status()
+}
+
+func (w *Watcher) forget(sess erpc.Session, service string) {
+	w.mu.Lock()
+	if m, ok := w.watching[sess]; ok {
+		delete(m, service)
+		if len(m) == 0 {
+			delete(w.watching, sess)
+		}
+	}
+	w.mu.Unlock()
+}
+
+// call sends the watch CALL, and handles the current status unless it is outdated by an update.
+func (w *Watcher) call(sess erpc.CtxSession, service string) *erpc.Status {
+	w.mu.Lock()
+	wt, ok := w.watching[sess][service]
+	if ok {
+		wt.pending, wt.updated = true, false
+	}
+	w.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	var reply CheckReply
+	stat := sess.Call(WatchServiceMethod, &CheckArgs{Service: service}, &reply).Status()
+	w.mu.Lock()
+	wt.pending = false
+	outdated := wt.updated
+	w.mu.Unlock()
+	if stat.OK() && !outdated {
+		wt.fn(service, reply.Status)
+	}
+	return stat
+}
+
+func (w *Watcher) handle(sess erpc.CtxSession, u *Update) {
+	w.mu.Lock()
+	wt, ok := w.watching[sess][u.Service]
+	if ok && wt.pending {
+		wt.updated = true
+	}
+	w.mu.Unlock()
+	if ok {
+		wt.fn(u.Service, u.Status)
+	}
+}
+
+func update(ctx erpc.PushCtx, u *Update) *erpc.Status {
+	w, ok := ctx.Peer().PluginContainer().GetByName(WatcherName).(*Watcher)
+	if !ok {
+		return erpc.NewStatus(erpc.CodeInternalServerError, "health watcher plugin is not found", "")
+	}
+	w.handle(ctx.Session(), u)
+	return nil
+}
+
+// ProberConfig prober options
+type ProberConfig struct {
+	// Service is the checked service, default the overall status.
+	Service string
+	// Interval is the probing interval, default 5s.
+	Interval time.Duration
+	// Timeout is the timeout of each probe, default 1s.
+	Timeout time.Duration
+}
+
+// Prober probes the backends periodically for the balancers.
+// NOTE: A backend is SERVING only if the check succeeds, the unreachable one is UNKNOWN.
+type Prober struct {
+	peer     erpc.Peer
+	cfg      ProberConfig
+	mu       sync.RWMutex
+	backends map[string]*backend
+	stop     chan struct{}
+	once     sync.Once
+}
+
+type backend struct {
+	sess   erpc.Session
+	status ServingStatus
+}
+
+// NewProber creates a prober of the addresses, and probes them at once.
+func NewProber(peer erpc.Peer, addrs []string, cfg ProberConfig) *Prober {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second * 5
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Second
+	}
+	p := &Prober{
+		peer:     peer,
+		cfg:      cfg,
+		backends: make(map[string]*backend, len(addrs)),
+		stop:     make(chan struct{}),
+	}
+	p.SetAddrs(addrs)
+	p.Probe()
+	erpc.AnywayGo(p.loop)
+	return p
+}
+
+// SetAddrs replaces the probed addresses, the new ones are UNKNOWN until probed.
+func (p *Prober) SetAddrs(addrs []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keep := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		keep[addr] = true
+		if _, ok := p.backends[addr]; !ok {
+			p.backends[addr] = &backend{}
+		}
+	}
+	for addr, b := range p.backends {
+		if !keep[addr] {
+			if b.sess != nil {
+				b.sess.Close()
+			}
+			delete(p.backends, addr)
+		}
+	}
+}
+
+// Status returns the last probed status of the address.
+func (p *Prober) Status(addr string) ServingStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if b, ok := p.backends[addr]; ok {
+		return b.status
+	}
+	return Unknown
+}
+
+// Serving returns the SERVING addresses.
+func (p *Prober) Serving() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var addrs []string
+	for addr, b := range p.backends {
+		if b.status == Serving {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// Probe probes all the addresses concurrently, and waits for the results.
+func (p *Prober) Probe() {
+	p.mu.RLock()
+	addrs := make([]string, 0, len(p.backends))
+	for addr := range p.backends {
+		addrs = append(addrs, addr)
+	}
+	p.mu.RUnlock()
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		addr := addr
+		erpc.AnywayGo(func() {
+			defer wg.Done()
+			p.probe(addr)
+		})
+	}
+	wg.Wait()
+}
+
+func (p *Prober) probe(addr string) {
+	p.mu.RLock()
+	b, ok := p.backends[addr]
+	var sess erpc.Session
+	if ok {
+		sess = b.sess
+	}
+	p.mu.RUnlock()
+	if !ok {
+		return
+	}
+	status := Unknown
+	if sess == nil || !sess.Health() {
+		var stat *erpc.Status
+		if sess, stat = p.peer.Dial(addr); !stat.OK() {
+			sess = nil
+		}
+	}
+	if sess != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), p.cfg.Timeout)
+		reply := new(CheckReply)
+		cmd := sess.AsyncCall(CheckServiceMethod, &CheckArgs{Service: p.cfg.Service}, reply, make(chan erpc.CallCmd, 1), erpc.WithContext(ctx))
+		select {
+		case <-cmd.Done():
+			if cmd.StatusOK() {
+				status = reply.Status
+			} else if cmd.Status().Code() == erpc.CodeServiceUnavailable || cmd.Status().Code() == erpc.CodeNotFound {
+				status = NotServing
+			}
+		case <-ctx.Done():
+			// the draining or stuck connection is dialed again next time
+			sess.Close()
+			sess = nil
+		}
+		cancel()
+	}
+	p.mu.Lock()
+	if b, ok = p.backends[addr]; ok {
+		if b.sess != nil && b.sess != sess {
+			b.sess.Close()
+		}
+		b.sess, b.status = sess, status
+	} else if sess != nil {
+		sess.Close()
+	}
+	p.mu.Unlock()
+}
+
+func (p *Prober) loop() {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.Probe()
+		}
+	}
+}
+
+// Close stops probing, and closes the sessions.
+func (p *Prober) Close() {
+	p.once.Do(func() {
+		close(p.stop)
+		p.SetAddrs(nil)
+	})
+}
@@ -0,0 +1,367 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health provides the standard health check service with the serving status and readiness.
+package health
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andeya/erpc/v7"
+)
+
+// ServingStatus the serving status of a service.
+type ServingStatus int32
+
+// Serving statuses.
+const (
+	Unknown ServingStatus = iota
+	Serving
+	NotServing
+)
+
+var statusNames = [...]string{"UNKNOWN", "SERVING", "NOT_SERVING"}
+
+// String returns the status name.
+func (s ServingStatus) String() string {
+	if s < 0 || int(s) >= len(statusNames) {
+		return statusNames[Unknown]
+	}
+	return statusNames[s]
+}
+
+// MarshalText encodes the status name.
+func (s ServingStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes the status name, and the unknown name is Unknown.
+func (s *ServingStatus) UnmarshalText(b []byte) error {
+	*s = Unknown
+	for i, name := range statusNames {
+		if name == string(b) {
+			*s = ServingStatus(i)
+		}
+	}
+	return nil
+}
+
+// CheckArgs the arguments of the check and watch CALLs.
+// NOTE: The empty service is the overall status of the peer.
+type CheckArgs struct {
+	Service string `json:"service"`
+}
+
+// CheckReply the reply of the check and watch CALLs.
+type CheckReply struct {
+	Status ServingStatus `json:"status"`
+}
+
+// Update the PUSH of the watched status change.
+type Update struct {
+	Service string        `json:"service"`
+	Status  ServingStatus `json:"status"`
+}
+
+// The service methods of the health check service.
+const (
+	CheckServiceMethod   = "/health/check"
+	WatchServiceMethod   = "/health/watch"
+	UnwatchServiceMethod = "/health/unwatch"
+	UpdateServiceMethod  = "/health/update"
+)
+
+// Name is the plugin name.
+const Name = "health"
+
+// Config health options
+type Config struct {
+	// ManualReady keeps the peer not ready after listening, until SetReady(true) is called.
+	ManualReady bool
+}
+
+// Health the health check service plugin.
+// NOTE:
+//
+//	After the peer listens, the new CALLs except the health check ones are rejected with CodeServiceUnavailable,
+//	until it is ready (SetReady(true) if ManualReady);
+//	While not ready, the registered services report NOT_SERVING;
+//	After draining starts, the peer is not ready, but the CALLs are still served until the sessions are closed,
+//	since the ones accepted by the GOAWAY must be handled.
+type Health struct {
+	cfg      Config
+	ready    int32
+	gating   int32 // whether the CALLs are rejected while not ready, set by listening and cleared by draining
+	once     sync.Once
+	mu       sync.Mutex
+	shutdown bool
+	listened bool
+	statuses map[string]ServingStatus
+	watchers map[string]map[interface{}]erpc.CtxSession // service -> session -> session
+	sent     map[string]ServingStatus                   // the last notified status of the watched services
+	notifyMu sync.Mutex                                 // serializes the notifications
+}
+
+var (
+	_ erpc.PostNewPeerPlugin        = (*Health)(nil)
+	_ erpc.PostListenPlugin         = (*Health)(nil)
+	_ erpc.PostReadCallHeaderPlugin = (*Health)(nil)
+	_ erpc.PreGoAwayPlugin          = (*Health)(nil)
+	_ erpc.PostDisconnectPlugin     = (*Health)(nil)
+)
+
+// New creates a health check service plugin, the overall status is SERVING.
+func New(cfg ...Config) *Health {
+	h := &Health{
+		statuses: map[string]ServingStatus{"": Serving},
+		watchers: make(map[string]map[interface{}]erpc.CtxSession),
+		sent:     make(map[string]ServingStatus),
+	}
+	if len(cfg) > 0 {
+		h.cfg = cfg[0]
+	}
+	return h
+}
+
+// Name returns the plugin name.
+func (h *Health) Name() string {
+	return Name
+}
+
+// PostNewPeer registers the health check handlers.
+func (h *Health) PostNewPeer(peer erpc.EarlyPeer) error {
+	h.once.Do(func() {
+		group := peer.SubRoute("health")
+		group.RouteCallFunc(check)
+		group.RouteCallFunc(watch)
+		group.RouteCallFunc(unwatch)
+	})
+	return nil
+}
+
+// PostListen makes the peer ready, unless ManualReady.
+// NOTE: The CALLs are gated by the readiness only if the peer listens.
+func (h *Health) PostListen(_ net.Addr) error {
+	h.mu.Lock()
+	h.listened = true
+	if !h.shutdown {
+		atomic.StoreInt32(&h.gating, 1)
+	}
+	h.mu.Unlock()
+	if !h.cfg.ManualReady {
+		h.SetReady(true)
+	}
+	return nil
+}
+
+// PostReadCallHeader rejects the CALL if the listening peer is not ready, except while draining.
+func (h *Health) PostReadCallHeader(ctx erpc.ReadCtx) *erpc.Status {
+	if atomic.LoadInt32(&h.gating) == 0 || h.Ready() || strings.HasPrefix(ctx.ServiceMethod(), "/health/") {
+		return nil
+	}
+	return erpc.NewStatus(erpc.CodeServiceUnavailable, erpc.CodeText(erpc.CodeServiceUnavailable), "not ready")
+}
+
+// PreGoAway shuts down the health check service when the peer starts draining,
+// the CALLs are still served, and the ones not accepted are dropped by the GOAWAY.
+func (h *Health) PreGoAway(string) {
+	h.Shutdown()
+}
+
+// PostDisconnect forgets the watchers of the session.
+func (h *Health) PostDisconnect(sess erpc.BaseSession) *erpc.Status {
+	h.mu.Lock()
+	for _, m := range h.watchers {
+		delete(m, sess)
+	}
+	h.mu.Unlock()
+	return nil
+}
+
+// Ready returns whether the peer is ready to accept the new CALLs.
+func (h *Health) Ready() bool {
+	return atomic.LoadInt32(&h.ready) == 1
+}
+
+// SetReady sets the readiness, it is ignored after Shutdown.
+func (h *Health) SetReady(ready bool) {
+	h.mu.Lock()
+	if h.shutdown {
+		h.mu.Unlock()
+		return
+	}
+	if ready {
+		atomic.StoreInt32(&h.ready, 1)
+	} else {
+		atomic.StoreInt32(&h.ready, 0)
+	}
+	h.mu.Unlock()
+	h.notify()
+}
+
+// SetServingStatus sets the serving status of the service, it is ignored after Shutdown.
+// NOTE: The empty service is the overall status of the peer.
+func (h *Health) SetServingStatus(service string, status ServingStatus) {
+	h.mu.Lock()
+	if h.shutdown {
+		h.mu.Unlock()
+		erpc.Infof("health: the status %s of %q is ignored after shutdown", status, service)
+		return
+	}
+	h.statuses[service] = status
+	h.mu.Unlock()
+	h.notify()
+}
+
+// ServingStatus returns the reported serving status of the service,
+// and false if the service is not registered.
+func (h *Health) ServingStatus(service string) (ServingStatus, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.statusLocked(service)
+}
+
+func (h *Health) statusLocked(service string) (ServingStatus, bool) {
+	status, ok := h.statuses[service]
+	if !ok {
+		return Unknown, false
+	}
+	if !h.Ready() {
+		return NotServing, true
+	}
+	return status, true
+}
+
+// Shutdown sets all the services NOT_SERVING and the peer not ready, the later changes are ignored.
+// NOTE: It only stops advertising the readiness, the CALLs are not rejected.
+func (h *Health) Shutdown() {
+	h.mu.Lock()
+	h.shutdown = true
+	atomic.StoreInt32(&h.ready, 0)
+	atomic.StoreInt32(&h.gating, 0)
+	for service := range h.statuses {
+		h.statuses[service] = NotServing
+	}
+	h.mu.Unlock()
+	h.notify()
+}
+
+// Resume sets all the services SERVING and the peer ready, and accepts the later changes.
+func (h *Health) Resume() {
+	h.mu.Lock()
+	h.shutdown = false
+	atomic.StoreInt32(&h.ready, 1)
+	if h.listened {
+		atomic.StoreInt32(&h.gating, 1)
+	}
+	for service := range h.statuses {
+		h.statuses[service] = Serving
+	}
+	h.mu.Unlock()
+	h.notify()
+}
+
+// notify PUSHes the changed statuses to the watchers.
+func (h *Health) notify() {
+	h.notifyMu.Lock()
+	defer h.notifyMu.Unlock()
+	type target struct {
+		update Update
+		sesses []erpc.CtxSession
+	}
+	var targets []target
+	h.mu.Lock()
+	for service, m := range h.watchers {
+		status, _ := h.statusLocked(service)
+		if last, ok := h.sent[service]; ok && last == status {
+			continue
+		}
+		h.sent[service] = status
+		t := target{update: Update{Service: service, Status: status}}
+		for _, sess := range m {
+			t.sesses = append(t.sesses, sess)
+		}
+		targets = append(targets, t)
+	}
+	h.mu.Unlock()
+	for _, t := range targets {
+		for _, sess := range t.sesses {
+			if stat := sess.Push(UpdateServiceMethod, &t.update); !stat.OK() {
+				erpc.Debugf("health: notify %s: %v", sess.ID(), stat)
+			}
+		}
+	}
+}
+
+// fromPeer returns the health plugin of the peer.
+func fromPeer(peer erpc.Peer) (*Health, *erpc.Status) {
+	if h, ok := peer.PluginContainer().GetByName(Name).(*Health); ok {
+		return h, nil
+	}
+	return nil, erpc.NewStatus(erpc.CodeInternalServerError, "health plugin is not found", "")
+}
+
+func check(ctx erpc.CallCtx, args *CheckArgs) (*CheckReply, *erpc.Status) {
+	h, stat := fromPeer(ctx.Peer())
+	if stat != nil {
+		return nil, stat
+	}
+	status, ok := h.ServingStatus(args.Service)
+	if !ok {
+		return nil, erpc.NewStatus(erpc.CodeNotFound, "unknown service", args.Service)
+	}
+	return &CheckReply{Status: status}, nil
+}
+
+func watch(ctx erpc.CallCtx, args *CheckArgs) (*CheckReply, *erpc.Status) {
+	h, stat := fromPeer(ctx.Peer())
+	if stat != nil {
+		return nil, stat
+	}
+	sess := ctx.Session()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, ok := h.watchers[args.Service]
+	if !ok {
+		m = make(map[interface{}]erpc.CtxSession)
+		h.watchers[args.Service] = m
+	}
+	m[sess] = sess
+	status, _ := h.statusLocked(args.Service)
+	if _, ok := h.sent[args.Service]; !ok {
+		// the pending change is notified to all the watchers
+		h.sent[args.Service] = status
+	}
+	return &CheckReply{Status: status}, nil
+}
+
+func unwatch(ctx erpc.CallCtx, args *CheckArgs) (*struct{}, *erpc.Status) {
+	h, stat := fromPeer(ctx.Peer())
+	if stat != nil {
+		return nil, stat
+	}
+	h.mu.Lock()
+	if m, ok := h.watchers[args.Service]; ok {
+		delete(m, ctx.Session())
+		if len(m) == 0 {
+			delete(h.watchers, args.Service)
+			delete(h.sent, args.Service)
+		}
+	}
+	h.mu.Unlock()
+	return nil, nil
+}
@@ -0,0 +1,147 @@
+package health_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+	"github.com/andeya/erpc/v7/plugin/health"
+)
+
+func echo(ctx erpc.CallCtx, arg *string) (string, *erpc.Status) {
+	return *arg, nil
+}
+
+func TestHealth(t *testing.T) {
+	h := health.New(health.Config{ManualReady: true})
+	srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network}, h)
+	srv.RouteCallFunc(echo)
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+
+	w := health.NewWatcher()
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network, DialTimeout: time.Millisecond * 100}, w)
+	defer cli.Close()
+	sess, stat := cli.Dial(addr)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+
+	// not ready
+	var reply string
+	if stat = sess.Call("/echo", "hi", &reply).Status(); stat.Code() != erpc.CodeServiceUnavailable {
+		t.Fatalf("want CodeServiceUnavailable before ready, got: %v", stat)
+	}
+	if status, stat := health.Check(sess, ""); !stat.OK() || status != health.NotServing {
+		t.Fatalf("want NOT_SERVING before ready, got: %s, %v", status, stat)
+	}
+	if _, stat := health.Check(sess, "unknown"); stat.Code() != erpc.CodeNotFound {
+		t.Fatalf("want CodeNotFound of the unknown service, got: %v", stat)
+	}
+
+	var mu sync.Mutex
+	var got []health.ServingStatus
+	last := func() health.ServingStatus {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(got) == 0 {
+			return health.Unknown
+		}
+		return got[len(got)-1]
+	}
+	h.SetServingStatus("echo", health.Serving)
+	stat = w.Watch(sess, "echo", func(_ string, status health.ServingStatus) {
+		mu.Lock()
+		got = append(got, status)
+		mu.Unlock()
+	})
+	if !stat.OK() || last() != health.NotServing {
+		t.Fatalf("want the current NOT_SERVING, got: %s, %v", last(), stat)
+	}
+
+	// ready
+	h.SetReady(true)
+	if stat = sess.Call("/echo", "hi", &reply).Status(); !stat.OK() || reply != "hi" {
+		t.Fatalf("want the CALL accepted after ready, got: %q, %v", reply, stat)
+	}
+	time.Sleep(time.Millisecond * 50)
+	if last() != health.Serving {
+		t.Fatalf("want the update SERVING, got: %s", last())
+	}
+	h.SetServingStatus("echo", health.NotServing)
+	time.Sleep(time.Millisecond * 50)
+	if last() != health.NotServing {
+		t.Fatalf("want the update NOT_SERVING, got: %s", last())
+	}
+	h.SetServingStatus("echo", health.Serving)
+	time.Sleep(time.Millisecond * 50)
+
+	// prober
+	// no listener on the port 1 of the in-process network
+	const unreachable = ":1"
+	p := health.NewProber(cli, []string{addr, unreachable}, health.ProberConfig{Interval: time.Hour, Timeout: time.Millisecond * 200})
+	defer p.Close()
+	if addrs := p.Serving(); len(addrs) != 1 || addrs[0] != addr {
+		t.Fatalf("want the SERVING backend %s, got: %v", addr, addrs)
+	}
+	if status := p.Status(unreachable); status != health.Unknown {
+		t.Fatalf("want the unreachable backend UNKNOWN, got: %s", status)
+	}
+
+	// draining
+	srv.GoAway("")
+	time.Sleep(time.Millisecond * 50)
+	if last() != health.NotServing {
+		t.Fatalf("want the update NOT_SERVING after GOAWAY, got: %s", last())
+	}
+	h.SetServingStatus("echo", health.Serving)
+	if status, _ := h.ServingStatus("echo"); status != health.NotServing {
+		t.Fatalf("want the change ignored after shutdown, got: %s", status)
+	}
+	p.Probe()
+	if addrs := p.Serving(); len(addrs) != 0 {
+		t.Fatalf("want no SERVING backend after GOAWAY, got: %v", addrs)
+	}
+}
+
+func TestHealthDraining(t *testing.T) {
+	h := health.New()
+	srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network}, h)
+	srv.RouteCallFunc(echo)
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+
+	// the client-only peer is not gated, since it never listens
+	cliHealth := health.New()
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network, DialTimeout: time.Millisecond * 100}, cliHealth)
+	defer cli.Close()
+	cli.RouteCallFunc(echo)
+	sess, stat := cli.Dial(addr)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	time.Sleep(time.Millisecond * 50)
+	var reply string
+	srv.RangeSession(func(s erpc.Session) bool {
+		stat = s.Call("/echo", "to client", &reply).Status()
+		return false
+	})
+	if !stat.OK() || reply != "to client" {
+		t.Fatalf("want the CALL to the client accepted, got: %q, %v", reply, stat)
+	}
+
+	// the CALLs are still served while draining
+	h.Shutdown()
+	if h.Ready() {
+		t.Fatal("want not ready after shutdown")
+	}
+	if stat = sess.Call("/echo", "hi", &reply).Status(); !stat.OK() || reply != "hi" {
+		t.Fatalf("want the CALL served while draining, got: %q, %v", reply, stat)
+	}
+	if status, stat := health.Check(sess, ""); !stat.OK() || status != health.NotServing {
+		t.Fatalf("want NOT_SERVING while draining, got: %s, %v", status, stat)
+	}
+}
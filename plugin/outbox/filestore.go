@@ -0,0 +1,264 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// compactThreshold is the minimum number of the dead records to compact the log.
+const compactThreshold = 1024
+
+// fileStore the local file store of the append-only log.
+type fileStore struct {
+	mu   sync.Mutex
+	mem  *memStore
+	path string
+	file *os.File
+	sync bool
+	// records is the number of the records in the log.
+	records int
+}
+
+type record struct {
+	Op  string   `json:"op"` // "enq" or "ack"
+	ID  string   `json:"id"`
+	Seq uint64   `json:"seq,omitempty"`
+	Msg *Message `json:"msg,omitempty"`
+}
+
+const (
+	opEnqueue = "enq"
+	opAck     = "ack"
+)
+
+// NewFileStore opens the local file store of the append-only log, and restores the queued messages.
+// If sync is true, the file is synced to the disk after every write.
+// NOTE:
+//
+//	The incomplete record at the end of the log caused by a crash is discarded;
+//	It returns an error if a record before the end is corrupt, instead of discarding the records after it.
+func NewFileStore(path string, sync bool) (Store, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &fileStore{
+		mem:  NewMemStore().(*memStore),
+		path: path,
+		file: file,
+		sync: sync,
+	}
+	if err = s.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// replay restores the queues from the log, and truncates the incomplete last record.
+func (s *fileStore) replay() error {
+	var (
+		r      = bufio.NewReader(s.file)
+		offset int64
+	)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break // the line without '\n' is incomplete
+		}
+		if err != nil {
+			return err
+		}
+		var rec record
+		if err = json.Unmarshal(line, &rec); err != nil {
+			if _, peekErr := r.Peek(1); peekErr == io.EOF {
+				break // the torn last record
+			}
+			return fmt.Errorf("outbox: %s: corrupt record at offset %d: %s", s.path, offset, err.Error())
+		}
+		offset += int64(len(line))
+		s.records++
+		s.apply(&rec)
+	}
+	if err := s.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := s.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func (s *fileStore) apply(rec *record) {
+	m := s.mem
+	switch rec.Op {
+	case opEnqueue:
+		if rec.Msg == nil {
+			return
+		}
+		m.queues[rec.ID] = append(m.queues[rec.ID], rec.Msg)
+		if rec.Msg.Seq > m.seq {
+			m.seq = rec.Msg.Seq
+		}
+	case opAck:
+		m.Ack(rec.ID, rec.Seq)
+	}
+}
+
+func (s *fileStore) Enqueue(id string, msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.mem
+	m.mu.Lock()
+	m.seq++
+	msg.Seq = m.seq
+	m.mu.Unlock()
+	if err := s.write(&record{Op: opEnqueue, ID: id, Msg: msg}); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.queues[id] = append(m.queues[id], msg)
+	m.mu.Unlock()
+	return nil
+}
+
+func (s *fileStore) Pending(id string, now time.Time) ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mem.mu.Lock()
+	var expired []uint64
+	for _, msg := range s.mem.queues[id] {
+		if msg.Expired(now) {
+			expired = append(expired, msg.Seq)
+		}
+	}
+	s.mem.mu.Unlock()
+	for _, seq := range expired {
+		if err := s.write(&record{Op: opAck, ID: id, Seq: seq}); err != nil {
+			return nil, err
+		}
+	}
+	return s.mem.Pending(id, now)
+}
+
+func (s *fileStore) Ack(id string, seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.mem.has(id, seq) {
+		return nil
+	}
+	if err := s.write(&record{Op: opAck, ID: id, Seq: seq}); err != nil {
+		return err
+	}
+	s.mem.Ack(id, seq)
+	return s.maybeCompact()
+}
+
+func (s *fileStore) Len(id string) int {
+	return s.mem.Len(id)
+}
+
+func (s *fileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// write appends the record to the log.
+// NOTE: It must be called under the lock.
+func (s *fileStore) write(rec *record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err = s.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	s.records++
+	if s.sync {
+		return s.file.Sync()
+	}
+	return nil
+}
+
+// maybeCompact rewrites the log with the live records if the dead ones are the majority.
+// NOTE: It must be called under the lock.
+func (s *fileStore) maybeCompact() error {
+	m := s.mem
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	live := 0
+	for _, queue := range m.queues {
+		live += len(queue)
+	}
+	if dead := s.records - live; dead < compactThreshold || dead < live {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for id, queue := range m.queues {
+		for _, msg := range queue {
+			if err := enc.Encode(&record{Op: opEnqueue, ID: id, Msg: msg}); err != nil {
+				return err
+			}
+		}
+	}
+	tmp := s.path + ".tmp"
+	if err := writeFileSync(tmp, buf.Bytes()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.file.Close()
+	s.file = file
+	s.records = live
+	return nil
+}
+
+func (m *memStore) has(id string, seq uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, msg := range m.queues[id] {
+		if msg.Seq == seq {
+			return true
+		}
+	}
+	return false
+}
+
+func writeFileSync(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
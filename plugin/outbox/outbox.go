@@ -0,0 +1,326 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package outbox provides the durable per-session-ID queue of the PUSH messages to the offline sessions.
+package outbox
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/codec"
+)
+
+const (
+	// SeqMetaKey is the metadata key of the sequence of the queued message.
+	SeqMetaKey = "X-Outbox-Seq"
+	// AckMetaKey is the metadata key of the service method to acknowledge the queued message.
+	AckMetaKey = "X-Outbox-Ack"
+)
+
+// Config outbox options
+type Config struct {
+	// TTL is the default time to live of the queued messages, default 24h.
+	TTL time.Duration
+	// BodyCodec is the codec to encode the queued message body, default erpc.DefaultBodyCodec().
+	BodyCodec byte
+	// Known returns whether the offline session ID is allowed to queue the messages.
+	// The IDs of the sessions have connected and the IDs with the queued messages are always known.
+	Known func(id string) bool
+}
+
+// Outbox the plugin queuing the PUSH messages to the offline sessions.
+type Outbox struct {
+	cfg     Config
+	store   Store
+	peer    erpc.Peer
+	ackPath string
+
+	mu    sync.Mutex
+	known map[string]struct{}
+	// sent is the last sequence sent to the online session of the ID.
+	sent map[string]*sentState
+}
+
+type sentState struct {
+	sess     interface{} // the session value
+	seq      uint64
+	flushing bool
+	dirty    bool // flush again after the current one
+}
+
+var (
+	_ erpc.PostNewPeerPlugin    = (*Outbox)(nil)
+	_ erpc.PostAcceptPlugin     = (*Outbox)(nil)
+	_ erpc.PostDialPlugin       = (*Outbox)(nil)
+	_ erpc.PostDisconnectPlugin = (*Outbox)(nil)
+)
+
+// readyTimeout is the maximum time to wait for the new session to be ready for the delivery.
+const readyTimeout = time.Second * 10
+
+// New creates an outbox plugin with the store.
+// NOTE: The store is closed by Close.
+func New(store Store, cfg ...Config) *Outbox {
+	var c Config
+	if len(cfg) > 0 {
+		c = cfg[0]
+	}
+	if c.TTL <= 0 {
+		c.TTL = time.Hour * 24
+	}
+	if c.BodyCodec == codec.NilCodecID {
+		c.BodyCodec = erpc.DefaultBodyCodec().ID()
+	}
+	return &Outbox{
+		cfg:   c,
+		store: store,
+		known: make(map[string]struct{}),
+		sent:  make(map[string]*sentState),
+	}
+}
+
+// Name returns the plugin name.
+func (o *Outbox) Name() string {
+	return "outbox"
+}
+
+// PostNewPeer registers the acknowledgement handler.
+func (o *Outbox) PostNewPeer(peer erpc.EarlyPeer) error {
+	o.peer = peer.(erpc.Peer)
+	o.ackPath = peer.SubRoute("outbox").RoutePushFunc(ack)
+	return nil
+}
+
+// PostAccept delivers the queued messages to the accepted session.
+// NOTE: It should be registered after the plugin setting the session ID, such as the auth checker.
+func (o *Outbox) PostAccept(sess erpc.PreSession) *erpc.Status {
+	o.appear(sess.ID())
+	return nil
+}
+
+// PostDial delivers the queued messages to the dialed session.
+func (o *Outbox) PostDial(sess erpc.PreSession, _ bool) *erpc.Status {
+	o.appear(sess.ID())
+	return nil
+}
+
+// PostDisconnect forgets the delivery state of the session.
+func (o *Outbox) PostDisconnect(sess erpc.BaseSession) *erpc.Status {
+	o.mu.Lock()
+	if st, ok := o.sent[sess.ID()]; ok && st.sess == sess {
+		delete(o.sent, sess.ID())
+	}
+	o.mu.Unlock()
+	return nil
+}
+
+// Close closes the store.
+func (o *Outbox) Close() error {
+	return o.store.Close()
+}
+
+// Register marks the session ID as known, so that the messages to it are queued while it is offline.
+func (o *Outbox) Register(id string) {
+	o.mu.Lock()
+	o.known[id] = struct{}{}
+	o.mu.Unlock()
+}
+
+// Push sends a TypePush message to the session of the ID, and queues it with the default TTL if the session is offline.
+func (o *Outbox) Push(id, serviceMethod string, body interface{}) *erpc.Status {
+	return o.PushTTL(id, serviceMethod, body, o.cfg.TTL)
+}
+
+// PushTTL sends a TypePush message to the session of the ID, and queues it with the TTL if the session is offline.
+// NOTE:
+//
+//	It returns CodeNotFound if the ID is unknown and offline;
+//	The message is also queued if there are messages of the ID not acknowledged, to keep the order.
+func (o *Outbox) PushTTL(id, serviceMethod string, body interface{}, ttl time.Duration) *erpc.Status {
+	sess, online := o.peer.GetSession(id)
+	if online && o.store.Len(id) == 0 {
+		stat := sess.Push(serviceMethod, body)
+		if stat.Code() != erpc.CodeConnClosed {
+			return stat
+		}
+		online = false
+	}
+	if !online && !o.isKnown(id) {
+		return erpc.NewStatus(erpc.CodeNotFound, "unknown session id", id)
+	}
+	b, err := o.encode(body)
+	if err != nil {
+		return erpc.NewStatus(erpc.CodeBadMessage, "encode body", err.Error())
+	}
+	msg := &Message{
+		ServiceMethod: serviceMethod,
+		BodyCodec:     o.cfg.BodyCodec,
+		Body:          b,
+		Expire:        time.Now().Add(ttl),
+	}
+	if err = o.store.Enqueue(id, msg); err != nil {
+		return erpc.NewStatus(erpc.CodeInternalServerError, "enqueue message", err.Error())
+	}
+	if online {
+		o.flush(id, sess)
+	}
+	return nil
+}
+
+// Flush delivers the queued messages to the online session of the ID,
+// for example after changing the session ID in a handler.
+func (o *Outbox) Flush(id string) {
+	if sess, ok := o.peer.GetSession(id); ok {
+		o.flush(id, sess)
+	}
+}
+
+// Len returns the number of the queued messages of the ID.
+func (o *Outbox) Len(id string) int {
+	return o.store.Len(id)
+}
+
+func (o *Outbox) isKnown(id string) bool {
+	o.mu.Lock()
+	_, ok := o.known[id]
+	o.mu.Unlock()
+	return ok || o.store.Len(id) > 0 || (o.cfg.Known != nil && o.cfg.Known(id))
+}
+
+func (o *Outbox) encode(body interface{}) ([]byte, error) {
+	switch b := body.(type) {
+	case []byte:
+		return b, nil
+	case *[]byte:
+		return *b, nil
+	}
+	c, err := codec.Get(o.cfg.BodyCodec)
+	if err != nil {
+		return nil, err
+	}
+	return c.Marshal(body)
+}
+
+// appear records the session ID, and delivers the queued messages after the session is ready.
+func (o *Outbox) appear(id string) {
+	o.Register(id)
+	if o.store.Len(id) == 0 {
+		return
+	}
+	erpc.AnywayGo(func() {
+		sess, ok := o.peer.GetSession(id)
+		for deadline := time.Now().Add(readyTimeout); !ok || !sess.Health(); {
+			if time.Now().After(deadline) {
+				return
+			}
+			time.Sleep(time.Millisecond * 10)
+			sess, ok = o.peer.GetSession(id)
+		}
+		o.flush(id, sess)
+	})
+}
+
+// flush sends the queued messages not sent to the session in order.
+func (o *Outbox) flush(id string, sess erpc.Session) {
+	o.mu.Lock()
+	st, ok := o.sent[id]
+	if !ok || st.sess != sess {
+		st = &sentState{sess: sess}
+		o.sent[id] = st
+	}
+	if st.flushing {
+		st.dirty = true
+		o.mu.Unlock()
+		return
+	}
+	st.flushing = true
+	o.mu.Unlock()
+
+	for {
+		msgs, err := o.store.Pending(id, time.Now())
+		if err != nil {
+			sess.Errorf("outbox: load the messages of %s: %v", id, err)
+		}
+		for _, msg := range msgs {
+			if msg.Seq <= st.seq {
+				continue
+			}
+			stat := sess.Push(msg.ServiceMethod, msg.Body,
+				erpc.WithBodyCodec(msg.BodyCodec),
+				erpc.WithSetMeta(SeqMetaKey, strconv.FormatUint(msg.Seq, 10)),
+				erpc.WithSetMeta(AckMetaKey, o.ackPath),
+			)
+			if !stat.OK() {
+				sess.Debugf("outbox: deliver the message %d to %s: %v", msg.Seq, id, stat)
+				break
+			}
+			st.seq = msg.Seq
+		}
+		o.mu.Lock()
+		if !st.dirty {
+			st.flushing = false
+			o.mu.Unlock()
+			return
+		}
+		st.dirty = false
+		o.mu.Unlock()
+	}
+}
+
+// ack handles the acknowledgement of the queued message.
+func ack(ctx erpc.PushCtx, seq *uint64) *erpc.Status {
+	o, ok := ctx.Peer().PluginContainer().GetByName("outbox").(*Outbox)
+	if !ok {
+		return nil
+	}
+	if err := o.store.Ack(ctx.Session().ID(), *seq); err != nil {
+		ctx.Errorf("outbox: ack the message %d of %s: %v", *seq, ctx.Session().ID(), err)
+	}
+	return nil
+}
+
+// Acker the client plugin acknowledging the queued messages after reading them.
+type Acker struct{}
+
+var _ erpc.PostReadPushBodyPlugin = Acker{}
+
+// NewAcker creates the client plugin acknowledging the queued messages.
+func NewAcker() Acker {
+	return Acker{}
+}
+
+// Name returns the plugin name.
+func (Acker) Name() string {
+	return "outbox_acker"
+}
+
+// PostReadPushBody acknowledges the queued message.
+func (Acker) PostReadPushBody(ctx erpc.ReadCtx) *erpc.Status {
+	seqValue := ctx.PeekMeta(SeqMetaKey)
+	ackPath := ctx.PeekMeta(AckMetaKey)
+	if len(seqValue) == 0 || len(ackPath) == 0 {
+		return nil
+	}
+	seq, err := strconv.ParseUint(string(seqValue), 10, 64)
+	if err != nil {
+		return nil
+	}
+	if stat := ctx.Session().Push(string(ackPath), seq); !stat.OK() {
+		ctx.Debugf("outbox: ack the message %d: %v", seq, stat)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package outbox_test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+	"github.com/andeya/erpc/v7/plugin/auth"
+	"github.com/andeya/erpc/v7/plugin/outbox"
+)
+
+// setUserID sets the session ID to the user name sent by the client.
+var setUserID = auth.NewCheckerPlugin(func(sess auth.Session, fn auth.RecvOnce) (interface{}, *erpc.Status) {
+	var user string
+	if stat := fn(&user); !stat.OK() {
+		return nil, stat
+	}
+	sess.SetID(user)
+	return "ok", nil
+})
+
+func sendUser(user string) erpc.Plugin {
+	return auth.NewBearerPlugin(func(sess auth.Session, fn auth.SendOnce) *erpc.Status {
+		var ret string
+		return fn(user, &ret)
+	})
+}
+
+// recorder records the queued message sequences in the reading order.
+type recorder struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (r *recorder) Name() string { return "recorder" }
+
+// PostReadPushHeader is called by the reading goroutine, while PostReadPushBody is called concurrently.
+func (r *recorder) PostReadPushHeader(ctx erpc.ReadCtx) *erpc.Status {
+	r.mu.Lock()
+	r.msgs = append(r.msgs, string(ctx.PeekMeta(outbox.SeqMetaKey)))
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *recorder) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.msgs...)
+}
+
+func notify(ctx erpc.PushCtx, arg *string) *erpc.Status {
+	return nil
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second * 3); time.Now().Before(deadline); time.Sleep(time.Millisecond * 10) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal("timeout")
+}
+
+func TestOutbox(t *testing.T) {
+	box := outbox.New(outbox.NewMemStore())
+	defer box.Close()
+	srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network}, setUserID, box)
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+
+	if stat := box.Push("bob", "/notify", "hi"); stat.Code() != erpc.CodeNotFound {
+		t.Fatalf("want CodeNotFound for the unknown ID, got: %v", stat)
+	}
+	box.Register("alice")
+	for _, msg := range []string{"m1", "m2", "m3"} {
+		if stat := box.Push("alice", "/notify", msg); !stat.OK() {
+			t.Fatal(stat)
+		}
+	}
+	box.PushTTL("alice", "/notify", "expired", time.Millisecond)
+	if n := box.Len("alice"); n != 4 {
+		t.Fatalf("want 4 queued messages, got: %d", n)
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	// delivered in order after the session ID is set by the auth checker
+	rec := new(recorder)
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network}, sendUser("alice"), rec, outbox.NewAcker())
+	defer cli.Close()
+	cli.RoutePushFunc(notify)
+	if _, stat := cli.Dial(addr); !stat.OK() {
+		t.Fatal(stat)
+	}
+	waitFor(t, func() bool { return box.Len("alice") == 0 })
+	if got := rec.received(); len(got) != 3 || got[0] != "1" || got[1] != "2" || got[2] != "3" {
+		t.Fatalf("want the sequences [1 2 3], got: %v", got)
+	}
+
+	// pushed directly while online
+	if stat := box.Push("alice", "/notify", "m4"); !stat.OK() {
+		t.Fatal(stat)
+	}
+	waitFor(t, func() bool { return len(rec.received()) == 4 })
+	if n := box.Len("alice"); n != 0 {
+		t.Fatalf("want no queued message while online, got: %d", n)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.log")
+	store, err := outbox.NewFileStore(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i, body := range []string{"a", "b", "c"} {
+		msg := &outbox.Message{ServiceMethod: "/notify", Body: []byte(body), Expire: now.Add(time.Hour)}
+		if i == 2 {
+			msg.Expire = now.Add(-time.Second)
+		}
+		if err = store.Enqueue("alice", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = store.Ack("alice", 1); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// simulate the incomplete record of a crash
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"enq","id":"alice","msg":{"seq":`)
+	f.Close()
+
+	store, err = outbox.NewFileStore(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	msgs, err := store.Pending("alice", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || string(msgs[0].Body) != "b" || msgs[0].Seq != 2 {
+		t.Fatalf("want the message b, got: %+v", msgs)
+	}
+	msg := &outbox.Message{ServiceMethod: "/notify", Body: []byte("d")}
+	if err = store.Enqueue("alice", msg); err != nil || msg.Seq != 4 {
+		t.Fatalf("want the sequence 4, got: %d, %v", msg.Seq, err)
+	}
+	store.Close()
+
+	// the corrupt record before the end is not discarded with the records after it
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, append([]byte("{corrupt\n"), data...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = outbox.NewFileStore(path, false); err == nil {
+		t.Fatal("want the corrupt record error")
+	}
+	if after, _ := os.ReadFile(path); len(after) != len(data)+len("{corrupt\n") {
+		t.Fatalf("want the log unchanged, got %d bytes", len(after))
+	}
+}
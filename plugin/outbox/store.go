@@ -0,0 +1,116 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package outbox
+
+import (
+	"sync"
+	"time"
+)
+
+// Message a queued PUSH message.
+type Message struct {
+	// Seq is the sequence assigned by the store, increasing in the order of enqueueing.
+	Seq           uint64    `json:"seq"`
+	ServiceMethod string    `json:"service_method"`
+	BodyCodec     byte      `json:"body_codec"`
+	Body          []byte    `json:"body"`
+	Expire        time.Time `json:"expire"`
+}
+
+// Expired returns whether the message is expired at the time.
+func (m *Message) Expired(now time.Time) bool {
+	return !m.Expire.IsZero() && !now.Before(m.Expire)
+}
+
+// Store the storage of the queued messages per session ID.
+// NOTE: The implementation must be safe for concurrent use.
+type Store interface {
+	// Enqueue appends the message to the queue of the session ID, and assigns its Seq.
+	Enqueue(id string, msg *Message) error
+	// Pending returns the unexpired messages of the session ID in order, and removes the expired ones.
+	Pending(id string, now time.Time) ([]*Message, error)
+	// Ack removes the message of the session ID.
+	Ack(id string, seq uint64) error
+	// Len returns the number of the queued messages of the session ID, including the expired ones.
+	Len(id string) int
+	// Close closes the store.
+	Close() error
+}
+
+// memStore the in-memory store.
+type memStore struct {
+	mu     sync.Mutex
+	seq    uint64
+	queues map[string][]*Message
+}
+
+// NewMemStore creates an in-memory store.
+func NewMemStore() Store {
+	return &memStore{queues: make(map[string][]*Message)}
+}
+
+func (s *memStore) Enqueue(id string, msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	msg.Seq = s.seq
+	s.queues[id] = append(s.queues[id], msg)
+	return nil
+}
+
+func (s *memStore) Pending(id string, now time.Time) ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.queues[id]
+	pending := queue[:0]
+	for _, msg := range queue {
+		if !msg.Expired(now) {
+			pending = append(pending, msg)
+		}
+	}
+	s.set(id, pending)
+	return append([]*Message(nil), pending...), nil
+}
+
+func (s *memStore) Ack(id string, seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.queues[id]
+	for i, msg := range queue {
+		if msg.Seq == seq {
+			s.set(id, append(queue[:i:i], queue[i+1:]...))
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memStore) Len(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queues[id])
+}
+
+func (s *memStore) Close() error {
+	return nil
+}
+
+func (s *memStore) set(id string, queue []*Message) {
+	if len(queue) == 0 {
+		delete(s.queues, id)
+	} else {
+		s.queues[id] = queue
+	}
+}
@@ -111,9 +111,22 @@ func (o *Overloader) PostReadPushHeader(ctx erpc.ReadCtx) *erpc.Status {
 
 // LimitConfig returns the overload limitation condition.
 func (o *Overloader) LimitConfig() LimitConfig {
+	o.limitConfigLock.RLock()
+	defer o.limitConfigLock.RUnlock()
 	return *o.limitConfig
 }
 
+// ConnNum returns the number of the connections counted by the MaxConn limitation,
+// it is always 0 if MaxConn is unlimited.
+func (o *Overloader) ConnNum() int32 {
+	o.connLimiterLock.RLock()
+	defer o.connLimiterLock.RUnlock()
+	if o.connLimiter == nil {
+		return 0
+	}
+	return o.connLimiter.getNow()
+}
+
 // Update updates the overload limitation condition.
 func (o *Overloader) Update(newLimitConfig LimitConfig) {
 	limitConfig := &newLimitConfig
@@ -66,6 +66,14 @@ type (
 	// Label proxy label information
 	Label struct {
 		SessionID, RealIP, ServiceMethod string
+		// Target is the forwarding target specified by the caller with the MetaTarget metadata,
+		// such as the session ID of a relayed client.
+		Target string
+		// BodyCodec is the body codec of the input message.
+		BodyCodec byte
+		// PeekMeta peeks the header metadata of the input message.
+		// NOTE: It is only valid until the forwarding returns.
+		PeekMeta func(key string) []byte
 	}
 	proxy struct {
 		callForwarder func(*Label) CallForwarder
@@ -73,6 +81,9 @@ type (
 	}
 )
 
+// MetaTarget is the metadata key of the forwarding target, which is not forwarded.
+const MetaTarget = "X-Proxy-Target"
+
 var (
 	_ erpc.PostNewPeerPlugin = new(proxy)
 )
@@ -97,7 +108,13 @@ func (p *proxy) call(ctx erpc.UnknownCallCtx) (interface{}, *erpc.Status) {
 		settings = make([]erpc.MessageSetting, 0, 16)
 	)
 	label.SessionID = ctx.Session().ID()
+	label.BodyCodec = ctx.GetBodyCodec()
+	label.PeekMeta = ctx.PeekMeta
 	ctx.VisitMeta(func(key, value []byte) {
+		if string(key) == MetaTarget {
+			label.Target = string(value)
+			return
+		}
 		settings = append(settings, erpc.WithAddMeta(string(key), string(value)))
 	})
 	var (
@@ -129,7 +146,13 @@ func (p *proxy) push(ctx erpc.UnknownPushCtx) *erpc.Status {
 		settings = make([]erpc.MessageSetting, 0, 16)
 	)
 	label.SessionID = ctx.Session().ID()
+	label.BodyCodec = ctx.GetBodyCodec()
+	label.PeekMeta = ctx.PeekMeta
 	ctx.VisitMeta(func(key, value []byte) {
+		if string(key) == MetaTarget {
+			label.Target = string(value)
+			return
+		}
 		settings = append(settings, erpc.WithAddMeta(string(key), string(value)))
 	})
 	if realIPBytes := ctx.PeekMeta(erpc.MetaRealIP); len(realIPBytes) == 0 {
@@ -0,0 +1,394 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+)
+
+// ErrInflightFull the error that the at-least-once subscribers have too many unacknowledged messages to accept the publication.
+var ErrInflightFull = errors.New("pubsub: too many unacknowledged messages")
+
+// subscriber the remote subscriber of the broker.
+type subscriber struct {
+	sess erpc.CtxSession
+	// brokerID is the ID of the remote broker if the subscriber is a bridge.
+	brokerID string
+	subs     map[string]QoS // topic pattern -> QoS
+	inflight map[inflightKey]*inflight
+	order    []inflightKey // the delivery order of the inflight messages
+}
+
+type inflightKey struct {
+	id  uint64
+	sub string
+}
+
+type inflight struct {
+	msg      *Message
+	sentAt   time.Time
+	attempts int
+}
+
+// localSub the subscription of the local handler.
+type localSub struct {
+	pattern string
+	handler Handler
+}
+
+// SubscribeArgs the arguments of the subscription and the unsubscription.
+type SubscribeArgs struct {
+	Topic string `json:"topic"`
+	QoS   QoS    `json:"qos,omitempty"`
+	// BrokerID is the ID of the subscribing broker if it is a bridge.
+	BrokerID string `json:"broker_id,omitempty"`
+}
+
+// SubscribeReply the reply of the subscription.
+type SubscribeReply struct {
+	BrokerID string `json:"broker_id"`
+}
+
+// AckArgs the acknowledgement of the at-least-once message.
+type AckArgs struct {
+	ID  uint64 `json:"id"`
+	Sub string `json:"sub"`
+}
+
+// subscribe handles the subscription of the remote peer.
+func subscribe(ctx erpc.CallCtx, args *SubscribeArgs) (*SubscribeReply, *erpc.Status) {
+	ps, stat := fromPeer(ctx.Peer())
+	if stat != nil {
+		return nil, stat
+	}
+	if err := checkPattern(args.Topic); err != nil {
+		return nil, erpc.NewStatus(erpc.CodeBadMessage, "invalid topic pattern", err.Error())
+	}
+	if args.QoS > AtLeastOnce {
+		return nil, erpc.NewStatus(erpc.CodeBadMessage, "invalid QoS", "")
+	}
+	sess := ctx.Session()
+	ps.mu.Lock()
+	s, ok := ps.subscribers[sess]
+	if !ok {
+		s = &subscriber{
+			sess:     sess,
+			subs:     make(map[string]QoS),
+			inflight: make(map[inflightKey]*inflight),
+		}
+		ps.subscribers[sess] = s
+	}
+	if args.BrokerID != "" {
+		s.brokerID = args.BrokerID
+	}
+	s.subs[args.Topic] = args.QoS
+	var retained []*Message
+	for topic, msg := range ps.retained {
+		if Match(args.Topic, topic) && (s.brokerID == "" || !containsID(msg.Path, s.brokerID)) {
+			retained = append(retained, msg)
+		}
+	}
+	ps.mu.Unlock()
+	if len(retained) > 0 {
+		// deliver after the reply, so that the subscriber is ready to handle them
+		erpc.AnywayGo(func() {
+			for _, msg := range retained {
+				ps.deliver(s, msg, args.Topic, args.QoS)
+			}
+		})
+	}
+	return &SubscribeReply{BrokerID: ps.id}, nil
+}
+
+// unsubscribe handles the unsubscription of the remote peer.
+func unsubscribe(ctx erpc.CallCtx, args *SubscribeArgs) (*struct{}, *erpc.Status) {
+	ps, stat := fromPeer(ctx.Peer())
+	if stat != nil {
+		return nil, stat
+	}
+	ps.mu.Lock()
+	if s, ok := ps.subscribers[ctx.Session()]; ok {
+		delete(s.subs, args.Topic)
+		if len(s.subs) == 0 {
+			delete(ps.subscribers, ctx.Session())
+		}
+	}
+	ps.mu.Unlock()
+	return new(struct{}), nil
+}
+
+// publish handles the publication of the remote peer.
+func publish(ctx erpc.CallCtx, msg *Message) (*struct{}, *erpc.Status) {
+	ps, stat := fromPeer(ctx.Peer())
+	if stat != nil {
+		return nil, stat
+	}
+	if err := checkTopic(msg.Topic); err != nil {
+		return nil, erpc.NewStatus(erpc.CodeBadMessage, "invalid topic", err.Error())
+	}
+	if err := ps.publish(msg); err != nil {
+		return nil, erpc.NewStatus(erpc.CodeResourceExhausted, erpc.CodeText(erpc.CodeResourceExhausted), err.Error())
+	}
+	return new(struct{}), nil
+}
+
+// ack handles the acknowledgement of the at-least-once message.
+func ack(ctx erpc.PushCtx, args *AckArgs) *erpc.Status {
+	ps, stat := fromPeer(ctx.Peer())
+	if stat != nil {
+		return stat
+	}
+	ps.mu.Lock()
+	if s, ok := ps.subscribers[ctx.Session()]; ok {
+		delete(s.inflight, inflightKey{id: args.ID, sub: args.Sub})
+	}
+	ps.mu.Unlock()
+	return nil
+}
+
+// Publish publishes the message to the subscribers of the peer, the local handlers and the bridges.
+// NOTE:
+//
+//	It returns an error wrapping ErrInflightFull if some at-least-once subscribers reject the message,
+//	which is still delivered to the others.
+func (ps *PubSub) Publish(topic string, payload []byte, retain bool) error {
+	if err := checkTopic(topic); err != nil {
+		return err
+	}
+	return ps.publish(&Message{Topic: topic, Payload: payload, Retain: retain})
+}
+
+// Dropped returns the number of the at-least-once messages dropped by the broker,
+// which are rejected by the full subscribers or exceed the redelivery times.
+func (ps *PubSub) Dropped() uint64 {
+	return atomic.LoadUint64(&ps.dropped)
+}
+
+// SubscribeLocal subscribes the topic pattern of the peer with the local handler.
+// NOTE: The handler is called synchronously by the publication.
+func (ps *PubSub) SubscribeLocal(pattern string, handler Handler) (cancel func(), err error) {
+	if err = checkPattern(pattern); err != nil {
+		return nil, err
+	}
+	l := &localSub{pattern: pattern, handler: handler}
+	ps.mu.Lock()
+	ps.locals[l] = struct{}{}
+	var retained []*Message
+	for topic, msg := range ps.retained {
+		if Match(pattern, topic) {
+			retained = append(retained, msg)
+		}
+	}
+	ps.mu.Unlock()
+	for _, msg := range retained {
+		ps.callLocal(l, msg)
+	}
+	return func() {
+		ps.mu.Lock()
+		delete(ps.locals, l)
+		ps.mu.Unlock()
+	}, nil
+}
+
+// Retained returns the retained message of the topic.
+func (ps *PubSub) Retained(topic string) (*Message, bool) {
+	ps.mu.Lock()
+	msg, ok := ps.retained[topic]
+	ps.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	m := *msg
+	return &m, true
+}
+
+type delivery struct {
+	s   *subscriber
+	sub string
+	qos QoS
+}
+
+func (ps *PubSub) publish(msg *Message) error {
+	if containsID(msg.Path, ps.id) {
+		return nil // loop
+	}
+	m := &Message{
+		ID:      atomic.AddUint64(&ps.seq, 1),
+		Topic:   msg.Topic,
+		Payload: msg.Payload,
+		Retain:  msg.Retain,
+		Path:    append(msg.Path[:len(msg.Path):len(msg.Path)], ps.id),
+	}
+	var (
+		deliveries []delivery
+		locals     []*localSub
+		bridges    []*bridge
+	)
+	ps.mu.Lock()
+	if m.Retain {
+		if len(m.Payload) == 0 {
+			delete(ps.retained, m.Topic)
+		} else {
+			ps.retained[m.Topic] = m
+		}
+	}
+	for _, s := range ps.subscribers {
+		if s.brokerID != "" && containsID(m.Path, s.brokerID) {
+			continue
+		}
+		for pattern, qos := range s.subs {
+			if Match(pattern, m.Topic) {
+				deliveries = append(deliveries, delivery{s: s, sub: pattern, qos: qos})
+			}
+		}
+	}
+	for l := range ps.locals {
+		if Match(l.pattern, m.Topic) {
+			locals = append(locals, l)
+		}
+	}
+	for _, b := range ps.bridges {
+		if b.forwards(m) {
+			bridges = append(bridges, b)
+		}
+	}
+	ps.mu.Unlock()
+	var rejected int
+	for _, d := range deliveries {
+		if ps.deliver(d.s, m, d.sub, d.qos) != nil {
+			rejected++
+		}
+	}
+	for _, l := range locals {
+		ps.callLocal(l, m)
+	}
+	for _, b := range bridges {
+		b.forward(m)
+	}
+	if rejected > 0 {
+		return fmt.Errorf("%w: %q is rejected by %d subscriptions", ErrInflightFull, m.Topic, rejected)
+	}
+	return nil
+}
+
+func (ps *PubSub) callLocal(l *localSub, msg *Message) {
+	m := *msg
+	m.Sub = l.pattern
+	if err := l.handler(&m); err != nil {
+		erpc.Debugf("pubsub: local handler of %q: %v", l.pattern, err)
+	}
+}
+
+// deliver sends the message to the subscriber.
+// NOTE: It returns ErrInflightFull without sending, if the at-least-once subscriber has MaxInflight unacknowledged messages.
+func (ps *PubSub) deliver(s *subscriber, msg *Message, sub string, qos QoS) error {
+	m := *msg
+	m.Sub = sub
+	m.QoS = qos
+	if qos == AtLeastOnce {
+		key := inflightKey{id: m.ID, sub: sub}
+		pending := m
+		ps.mu.Lock()
+		if len(s.inflight) >= ps.cfg.MaxInflight {
+			ps.mu.Unlock()
+			atomic.AddUint64(&ps.dropped, 1)
+			erpc.Warnf("pubsub: drop %q for %s with %d unacknowledged messages", m.Topic, s.sess.RemoteAddr(), ps.cfg.MaxInflight)
+			return ErrInflightFull
+		}
+		s.inflight[key] = &inflight{msg: &pending, sentAt: time.Now(), attempts: 1}
+		s.order = append(s.order, key)
+		s.prune()
+		ps.startRedelivery()
+		ps.mu.Unlock()
+	}
+	if stat := s.sess.Push(ps.messagePath, &m); !stat.OK() {
+		erpc.Debugf("pubsub: deliver %q to %s: %v", m.Topic, s.sess.RemoteAddr(), stat)
+	}
+	return nil
+}
+
+// prune drops the keys of the acknowledged messages from the delivery order.
+// NOTE: It must be called under the lock.
+func (s *subscriber) prune() {
+	order := s.order[:0]
+	for _, key := range s.order {
+		if _, ok := s.inflight[key]; ok {
+			order = append(order, key)
+		}
+	}
+	s.order = order
+}
+
+// startRedelivery starts the redelivery goroutine if it is not running.
+// NOTE: It must be called under the lock.
+func (ps *PubSub) startRedelivery() {
+	if ps.redelivery {
+		return
+	}
+	ps.redelivery = true
+	erpc.AnywayGo(ps.redeliverLoop)
+}
+
+// redeliverLoop redelivers the unacknowledged messages, and exits when there are none.
+func (ps *PubSub) redeliverLoop() {
+	ticker := time.NewTicker(ps.cfg.RedeliverInterval / 2)
+	defer ticker.Stop()
+	type resend struct {
+		s   *subscriber
+		msg Message
+	}
+	for range ticker.C {
+		var resends []resend
+		ps.mu.Lock()
+		pending := 0
+		deadline := time.Now().Add(-ps.cfg.RedeliverInterval)
+		for _, s := range ps.subscribers {
+			for key, f := range s.inflight {
+				if f.sentAt.After(deadline) {
+					pending++
+					continue
+				}
+				if ps.cfg.MaxRedeliver > 0 && f.attempts > ps.cfg.MaxRedeliver {
+					delete(s.inflight, key)
+					atomic.AddUint64(&ps.dropped, 1)
+					erpc.Debugf("pubsub: drop %q for %s after %d redeliveries", f.msg.Topic, s.sess.RemoteAddr(), ps.cfg.MaxRedeliver)
+					continue
+				}
+				f.attempts++
+				f.sentAt = time.Now()
+				f.msg.Dup = true
+				pending++
+				resends = append(resends, resend{s: s, msg: *f.msg})
+			}
+			s.prune()
+		}
+		if pending == 0 {
+			ps.redelivery = false
+			ps.mu.Unlock()
+			return
+		}
+		ps.mu.Unlock()
+		for _, r := range resends {
+			if stat := r.s.sess.Push(ps.messagePath, &r.msg); !stat.OK() {
+				erpc.Debugf("pubsub: redeliver %q to %s: %v", r.msg.Topic, r.s.sess.RemoteAddr(), stat)
+			}
+		}
+	}
+}
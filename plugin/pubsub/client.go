@@ -0,0 +1,234 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+)
+
+// client the subscriptions of the peer to a remote broker.
+type client struct {
+	ps   *PubSub
+	sess erpc.CtxSession
+	// bridged is whether the subscriptions come from a bridge, the broker ID is sent with them.
+	bridged bool
+	subs    map[string]*remoteSub // topic pattern -> subscription
+}
+
+type remoteSub struct {
+	qos     QoS
+	handler Handler
+}
+
+// bridge forwards the local publications to the remote broker.
+type bridge struct {
+	ps       *PubSub
+	sess     erpc.CtxSession
+	patterns []string
+	mu       sync.RWMutex
+	remoteID string
+}
+
+// resubscribeTimeout is the maximum time to wait for the redialed session to be ready.
+const resubscribeTimeout = time.Second * 10
+
+// message handles the message delivered by the remote broker.
+func message(ctx erpc.PushCtx, msg *Message) *erpc.Status {
+	ps, stat := fromPeer(ctx.Peer())
+	if stat != nil {
+		return stat
+	}
+	var handler Handler
+	ps.mu.Lock()
+	if c, ok := ps.clients[ctx.Session()]; ok {
+		if r, ok := c.subs[msg.Sub]; ok {
+			handler = r.handler
+		}
+	}
+	ps.mu.Unlock()
+	if handler != nil {
+		if err := handler(msg); err != nil {
+			ctx.Debugf("pubsub: handler of %q: %v", msg.Sub, err)
+			return nil // not acknowledged, wait for the redelivery
+		}
+	}
+	// NOTE: The message of the canceled subscription is also acknowledged to stop the redelivery.
+	if msg.QoS == AtLeastOnce {
+		if stat := ctx.Session().Push(ps.ackPath, &AckArgs{ID: msg.ID, Sub: msg.Sub}); !stat.OK() {
+			ctx.Debugf("pubsub: ack %q: %v", msg.Topic, stat)
+		}
+	}
+	return nil
+}
+
+// Subscribe subscribes the topic pattern of the remote broker through the session.
+// NOTE:
+//
+//	The subscriptions are restored automatically after redialing;
+//	The handler of the same pattern is replaced.
+func (ps *PubSub) Subscribe(sess erpc.CtxSession, pattern string, qos QoS, handler Handler) *erpc.Status {
+	return ps.subscribe(sess, pattern, qos, handler, false)
+}
+
+func (ps *PubSub) subscribe(sess erpc.CtxSession, pattern string, qos QoS, handler Handler, bridged bool) *erpc.Status {
+	if err := checkPattern(pattern); err != nil {
+		return erpc.NewStatus(erpc.CodeBadMessage, "invalid topic pattern", err.Error())
+	}
+	ps.mu.Lock()
+	c, ok := ps.clients[sess]
+	if !ok {
+		c = &client{ps: ps, sess: sess, subs: make(map[string]*remoteSub)}
+		ps.clients[sess] = c
+	}
+	c.bridged = c.bridged || bridged
+	old := c.subs[pattern]
+	c.subs[pattern] = &remoteSub{qos: qos, handler: handler}
+	ps.mu.Unlock()
+
+	reply, stat := c.call(pattern, qos)
+	if !stat.OK() {
+		ps.mu.Lock()
+		if old != nil {
+			c.subs[pattern] = old
+		} else {
+			delete(c.subs, pattern)
+		}
+		ps.mu.Unlock()
+		return stat
+	}
+	ps.setRemoteID(sess, reply.BrokerID)
+	return nil
+}
+
+// Unsubscribe cancels the subscription of the topic pattern through the session.
+func (ps *PubSub) Unsubscribe(sess erpc.CtxSession, pattern string) *erpc.Status {
+	ps.mu.Lock()
+	if c, ok := ps.clients[sess]; ok {
+		delete(c.subs, pattern)
+	}
+	ps.mu.Unlock()
+	return sess.Call(ps.unsubscribePath, &SubscribeArgs{Topic: pattern}, new(struct{})).Status()
+}
+
+// PublishTo publishes the message to the remote broker through the session.
+func (ps *PubSub) PublishTo(sess erpc.CtxSession, topic string, payload []byte, retain bool) *erpc.Status {
+	if err := checkTopic(topic); err != nil {
+		return erpc.NewStatus(erpc.CodeBadMessage, "invalid topic", err.Error())
+	}
+	return sess.Call(ps.publishPath, &Message{Topic: topic, Payload: payload, Retain: retain}, new(struct{})).Status()
+}
+
+// Bridge bridges the topic patterns between the peer and the remote broker of the session in both directions:
+// the remote messages are republished to the peer, and the local publications are forwarded to the remote broker.
+// NOTE: The message passing through a broker twice is dropped, so the bridges can form a loop.
+func (ps *PubSub) Bridge(sess erpc.CtxSession, patterns ...string) *erpc.Status {
+	for _, pattern := range patterns {
+		if err := checkPattern(pattern); err != nil {
+			return erpc.NewStatus(erpc.CodeBadMessage, "invalid topic pattern", err.Error())
+		}
+	}
+	ps.mu.Lock()
+	b, ok := ps.bridges[sess]
+	if !ok {
+		b = &bridge{ps: ps, sess: sess}
+		ps.bridges[sess] = b
+	}
+	b.patterns = append(b.patterns[:len(b.patterns):len(b.patterns)], patterns...)
+	ps.mu.Unlock()
+	// the message rejected by the full subscribers is not acknowledged, so that the remote broker redelivers it
+	republish := func(msg *Message) error {
+		return ps.publish(msg)
+	}
+	for _, pattern := range patterns {
+		if stat := ps.subscribe(sess, pattern, AtLeastOnce, republish, true); !stat.OK() {
+			return stat
+		}
+	}
+	return nil
+}
+
+func (ps *PubSub) setRemoteID(sess erpc.CtxSession, id string) {
+	ps.mu.Lock()
+	b, ok := ps.bridges[sess]
+	ps.mu.Unlock()
+	if ok {
+		b.mu.Lock()
+		b.remoteID = id
+		b.mu.Unlock()
+	}
+}
+
+// call sends the subscription to the remote broker.
+func (c *client) call(pattern string, qos QoS) (*SubscribeReply, *erpc.Status) {
+	args := &SubscribeArgs{Topic: pattern, QoS: qos}
+	if c.bridged {
+		args.BrokerID = c.ps.id
+	}
+	reply := new(SubscribeReply)
+	stat := c.sess.Call(c.ps.subscribePath, args, reply).Status()
+	return reply, stat
+}
+
+// resubscribe restores the subscriptions after redialing.
+func (c *client) resubscribe() {
+	for deadline := time.Now().Add(resubscribeTimeout); !c.sess.Health(); {
+		if time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	c.ps.mu.Lock()
+	subs := make(map[string]QoS, len(c.subs))
+	for pattern, r := range c.subs {
+		subs[pattern] = r.qos
+	}
+	c.ps.mu.Unlock()
+	for pattern, qos := range subs {
+		reply, stat := c.call(pattern, qos)
+		if !stat.OK() {
+			c.sess.Warnf("pubsub: resubscribe %q: %v", pattern, stat)
+			continue
+		}
+		c.ps.setRemoteID(c.sess, reply.BrokerID)
+	}
+}
+
+// forwards returns whether the message should be forwarded to the remote broker.
+func (b *bridge) forwards(msg *Message) bool {
+	b.mu.RLock()
+	remoteID := b.remoteID
+	b.mu.RUnlock()
+	if remoteID == "" || containsID(msg.Path, remoteID) {
+		return false
+	}
+	for _, pattern := range b.patterns {
+		if Match(pattern, msg.Topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// forward publishes the message to the remote broker asynchronously.
+func (b *bridge) forward(msg *Message) {
+	erpc.AnywayGo(func() {
+		if stat := b.sess.Call(b.ps.publishPath, msg, new(struct{})).Status(); !stat.OK() {
+			b.sess.Warnf("pubsub: forward %q: %v", msg.Topic, stat)
+		}
+	})
+}
@@ -0,0 +1,195 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pubsub provides topic-based publish/subscribe over PUSH.
+//
+// The same plugin works as the broker for the subscribers of the peer,
+// and as the client subscribing the remote brokers through the sessions.
+package pubsub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+)
+
+// QoS the delivery guarantee of a subscription.
+type QoS byte
+
+const (
+	// AtMostOnce delivers the message by a single PUSH.
+	AtMostOnce QoS = 0
+	// AtLeastOnce redelivers the message until the subscriber acknowledges it.
+	AtLeastOnce QoS = 1
+)
+
+// Message a published message.
+type Message struct {
+	// ID is the delivery ID assigned by the broker.
+	ID      uint64 `json:"id"`
+	Topic   string `json:"topic"`
+	Payload []byte `json:"payload,omitempty"`
+	// Retain is whether the broker keeps the message as the last one of the topic,
+	// and a retained message with empty payload clears it.
+	Retain bool `json:"retain,omitempty"`
+	// Sub is the topic pattern of the subscription the message is delivered to.
+	Sub string `json:"sub,omitempty"`
+	QoS QoS    `json:"qos,omitempty"`
+	// Dup is whether the message is redelivered.
+	Dup bool `json:"dup,omitempty"`
+	// Path is the IDs of the brokers the message has passed through, for the loop prevention of the bridges.
+	Path []string `json:"path,omitempty"`
+}
+
+// Handler handles the messages of a subscription.
+// NOTE: For the at-least-once subscription, the message is acknowledged only if it returns nil.
+type Handler func(msg *Message) error
+
+// Config pub/sub options
+type Config struct {
+	// RedeliverInterval is the interval to redeliver the unacknowledged messages, default 1s.
+	RedeliverInterval time.Duration
+	// MaxRedeliver is the maximum redelivery times of a message, default 10; <0 means unlimited.
+	MaxRedeliver int
+	// MaxInflight is the maximum unacknowledged messages per subscriber, default 1024;
+	// the new messages are rejected with ErrInflightFull when it is reached.
+	MaxInflight int
+}
+
+func (c *Config) check() {
+	if c.RedeliverInterval <= 0 {
+		c.RedeliverInterval = time.Second
+	}
+	if c.MaxRedeliver == 0 {
+		c.MaxRedeliver = 10
+	}
+	if c.MaxInflight <= 0 {
+		c.MaxInflight = 1024
+	}
+}
+
+// PubSub the publish/subscribe plugin.
+type PubSub struct {
+	cfg     Config
+	id      string
+	seq     uint64
+	dropped uint64 // the number of the dropped at-least-once messages
+	once    sync.Once
+
+	mu          sync.Mutex
+	subscribers map[interface{}]*subscriber // session -> subscriber, the broker side
+	locals      map[*localSub]struct{}
+	retained    map[string]*Message
+	clients     map[interface{}]*client // session -> client, the client side
+	bridges     map[interface{}]*bridge // session -> bridge
+	redelivery  bool                    // whether the redelivery goroutine is running
+
+	subscribePath, unsubscribePath, publishPath, messagePath, ackPath string
+}
+
+// Name is the plugin name.
+const Name = "pubsub"
+
+var (
+	_ erpc.PostNewPeerPlugin    = (*PubSub)(nil)
+	_ erpc.PostDialPlugin       = (*PubSub)(nil)
+	_ erpc.PostDisconnectPlugin = (*PubSub)(nil)
+)
+
+// New creates a pub/sub plugin.
+func New(cfg ...Config) *PubSub {
+	var c Config
+	if len(cfg) > 0 {
+		c = cfg[0]
+	}
+	c.check()
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &PubSub{
+		cfg:         c,
+		id:          hex.EncodeToString(id),
+		subscribers: make(map[interface{}]*subscriber),
+		locals:      make(map[*localSub]struct{}),
+		retained:    make(map[string]*Message),
+		clients:     make(map[interface{}]*client),
+		bridges:     make(map[interface{}]*bridge),
+	}
+}
+
+// Name returns the plugin name.
+func (ps *PubSub) Name() string {
+	return Name
+}
+
+// ID returns the broker ID.
+func (ps *PubSub) ID() string {
+	return ps.id
+}
+
+// PostNewPeer registers the pub/sub handlers.
+func (ps *PubSub) PostNewPeer(peer erpc.EarlyPeer) error {
+	ps.once.Do(func() {
+		group := peer.SubRoute("pubsub")
+		ps.subscribePath = group.RouteCallFunc(subscribe)
+		ps.unsubscribePath = group.RouteCallFunc(unsubscribe)
+		ps.publishPath = group.RouteCallFunc(publish)
+		ps.messagePath = group.RoutePushFunc(message)
+		ps.ackPath = group.RoutePushFunc(ack)
+	})
+	return nil
+}
+
+// PostDial resubscribes the topics after redialing.
+func (ps *PubSub) PostDial(sess erpc.PreSession, isRedial bool) *erpc.Status {
+	if !isRedial {
+		return nil
+	}
+	ps.mu.Lock()
+	c, ok := ps.clients[sess]
+	ps.mu.Unlock()
+	if ok {
+		erpc.AnywayGo(c.resubscribe)
+	}
+	return nil
+}
+
+// PostDisconnect forgets the subscriptions of the session.
+func (ps *PubSub) PostDisconnect(sess erpc.BaseSession) *erpc.Status {
+	ps.mu.Lock()
+	delete(ps.subscribers, sess)
+	delete(ps.clients, sess)
+	delete(ps.bridges, sess)
+	ps.mu.Unlock()
+	return nil
+}
+
+// fromPeer returns the pub/sub plugin of the peer.
+func fromPeer(peer erpc.Peer) (*PubSub, *erpc.Status) {
+	if ps, ok := peer.PluginContainer().GetByName(Name).(*PubSub); ok {
+		return ps, nil
+	}
+	return nil, erpc.NewStatus(erpc.CodeInternalServerError, "pubsub plugin is not found", "")
+}
+
+func containsID(path []string, id string) bool {
+	for _, p := range path {
+		if p == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package pubsub_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+	"github.com/andeya/erpc/v7/plugin/pubsub"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, topic string
+		match          bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/b/d/c", false},
+		{"a/+", "a", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b", true},
+		{"+/+", "a/b", true},
+		{"+", "a/b", false},
+	}
+	for _, c := range cases {
+		if got := pubsub.Match(c.pattern, c.topic); got != c.match {
+			t.Errorf("Match(%q, %q): want %v, got %v", c.pattern, c.topic, c.match, got)
+		}
+	}
+}
+
+type inbox struct {
+	mu   sync.Mutex
+	msgs []*pubsub.Message
+}
+
+func (b *inbox) handle(msg *pubsub.Message) error {
+	b.mu.Lock()
+	b.msgs = append(b.msgs, msg)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *inbox) wait(t *testing.T, n int) []*pubsub.Message {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second * 3); time.Now().Before(deadline); time.Sleep(time.Millisecond * 10) {
+		b.mu.Lock()
+		if len(b.msgs) >= n {
+			msgs := append([]*pubsub.Message(nil), b.msgs...)
+			b.mu.Unlock()
+			return msgs
+		}
+		b.mu.Unlock()
+	}
+	t.Fatalf("want %d messages, timeout", n)
+	return nil
+}
+
+func (b *inbox) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.msgs)
+}
+
+func newPeers(t *testing.T, cfg pubsub.Config) (broker, client *pubsub.PubSub, sess erpc.Session, closeFn func()) {
+	broker = pubsub.New(cfg)
+	srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network}, broker)
+	addr := memtest.Serve(srv)
+	client = pubsub.New()
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network}, client)
+	sess, stat := cli.Dial(addr)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	return broker, client, sess, func() {
+		cli.Close()
+		srv.Close()
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	broker, client, sess, closeFn := newPeers(t, pubsub.Config{RedeliverInterval: time.Millisecond * 100})
+	defer closeFn()
+
+	// at-most-once with the wildcard
+	var temps inbox
+	if stat := client.Subscribe(sess, "sensor/+/temp", pubsub.AtMostOnce, temps.handle); !stat.OK() {
+		t.Fatal(stat)
+	}
+	broker.Publish("sensor/a/hum", []byte("50"), false)
+	broker.Publish("sensor/a/temp", []byte("20"), false)
+	if msgs := temps.wait(t, 1); msgs[0].Topic != "sensor/a/temp" || string(msgs[0].Payload) != "20" {
+		t.Fatalf("unexpected message: %+v", msgs[0])
+	}
+
+	// at-least-once is redelivered until the handler succeeds
+	var (
+		alerts inbox
+		failed bool
+	)
+	stat := client.Subscribe(sess, "alert/#", pubsub.AtLeastOnce, func(msg *pubsub.Message) error {
+		if !failed {
+			failed = true
+			return errors.New("not ready")
+		}
+		return alerts.handle(msg)
+	})
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	broker.Publish("alert/fire", []byte("!"), false)
+	if msgs := alerts.wait(t, 1); !msgs[0].Dup || msgs[0].QoS != pubsub.AtLeastOnce {
+		t.Fatalf("want the redelivered message, got: %+v", msgs[0])
+	}
+	time.Sleep(time.Millisecond * 300)
+	if n := alerts.len(); n != 1 {
+		t.Fatalf("want no redelivery after the acknowledgement, got: %d", n)
+	}
+
+	// the retained message is delivered on subscription, and cleared by the empty payload
+	if stat := client.PublishTo(sess, "config/mode", []byte("auto"), true); !stat.OK() {
+		t.Fatal(stat)
+	}
+	var configs inbox
+	if stat := client.Subscribe(sess, "config/#", pubsub.AtMostOnce, configs.handle); !stat.OK() {
+		t.Fatal(stat)
+	}
+	if msgs := configs.wait(t, 1); string(msgs[0].Payload) != "auto" || !msgs[0].Retain {
+		t.Fatalf("want the retained message, got: %+v", msgs[0])
+	}
+	broker.Publish("config/mode", nil, true)
+	if _, ok := broker.Retained("config/mode"); ok {
+		t.Fatal("want the retained message cleared")
+	}
+
+	// no more messages after unsubscription
+	if stat := client.Unsubscribe(sess, "sensor/+/temp"); !stat.OK() {
+		t.Fatal(stat)
+	}
+	broker.Publish("sensor/b/temp", []byte("21"), false)
+	time.Sleep(time.Millisecond * 100)
+	if n := temps.len(); n != 1 {
+		t.Fatalf("want no message after unsubscription, got: %d", n)
+	}
+}
+
+func TestBridge(t *testing.T) {
+	remote, local, sess, closeFn := newPeers(t, pubsub.Config{RedeliverInterval: time.Millisecond * 100})
+	defer closeFn()
+	if stat := local.Bridge(sess, "chat/#"); !stat.OK() {
+		t.Fatal(stat)
+	}
+	var remoteInbox, localInbox inbox
+	remote.SubscribeLocal("chat/#", remoteInbox.handle)
+	local.SubscribeLocal("chat/#", localInbox.handle)
+
+	remote.Publish("chat/1", []byte("from remote"), false)
+	local.Publish("chat/2", []byte("from local"), false)
+	local.Publish("other", []byte("not bridged"), false)
+	remoteInbox.wait(t, 2)
+	localInbox.wait(t, 2)
+
+	// the messages do not loop back
+	time.Sleep(time.Millisecond * 200)
+	if n := remoteInbox.len(); n != 2 {
+		t.Fatalf("remote: want 2 messages, got: %d", n)
+	}
+	if n := localInbox.len(); n != 2 {
+		t.Fatalf("local: want 2 messages, got: %d", n)
+	}
+}
+
+func TestInflightFull(t *testing.T) {
+	broker, client, sess, closeFn := newPeers(t, pubsub.Config{RedeliverInterval: time.Second, MaxInflight: 2})
+	defer closeFn()
+	var received inbox
+	stat := client.Subscribe(sess, "job/#", pubsub.AtLeastOnce, func(msg *pubsub.Message) error {
+		received.handle(msg)
+		return errors.New("busy")
+	})
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	for i := 0; i < 2; i++ {
+		if err := broker.Publish("job/a", []byte("x"), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := broker.Publish("job/a", []byte("x"), false); !errors.Is(err, pubsub.ErrInflightFull) {
+		t.Fatalf("want ErrInflightFull, got: %v", err)
+	}
+	if n := broker.Dropped(); n != 1 {
+		t.Fatalf("want 1 dropped message, got: %d", n)
+	}
+	// the remote publisher is rejected too
+	if stat := client.PublishTo(sess, "job/b", []byte("y"), false); stat.Code() != erpc.CodeResourceExhausted {
+		t.Fatalf("want resource exhausted, got: %v", stat)
+	}
+	// the unacknowledged messages are kept
+	received.wait(t, 2)
+}
@@ -0,0 +1,79 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"errors"
+	"strings"
+)
+
+// Topic levels are separated by '/', and the wildcards of the topic pattern are:
+//
+//	'+' matches exactly one level, e.g. "sensor/+/temp" matches "sensor/a/temp";
+//	'#' matches any number of levels, and must be the last level, e.g. "sensor/#" matches "sensor" and "sensor/a/temp".
+const (
+	levelSeparator = "/"
+	singleWildcard = "+"
+	multiWildcard  = "#"
+)
+
+// Match reports whether the topic matches the topic pattern.
+func Match(pattern, topic string) bool {
+	p := strings.Split(pattern, levelSeparator)
+	t := strings.Split(topic, levelSeparator)
+	for i, level := range p {
+		if level == multiWildcard {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if level != singleWildcard && level != t[i] {
+			return false
+		}
+	}
+	return len(p) == len(t)
+}
+
+// checkPattern checks the topic pattern.
+func checkPattern(pattern string) error {
+	if pattern == "" {
+		return errors.New("empty topic pattern")
+	}
+	levels := strings.Split(pattern, levelSeparator)
+	for i, level := range levels {
+		switch {
+		case level == multiWildcard:
+			if i != len(levels)-1 {
+				return errors.New("'#' must be the last level of the topic pattern: " + pattern)
+			}
+		case level == singleWildcard:
+		case strings.ContainsAny(level, singleWildcard+multiWildcard):
+			return errors.New("the wildcard must occupy an entire level of the topic pattern: " + pattern)
+		}
+	}
+	return nil
+}
+
+// checkTopic checks the topic name of a publication.
+func checkTopic(topic string) error {
+	if topic == "" {
+		return errors.New("empty topic")
+	}
+	if strings.ContainsAny(topic, singleWildcard+multiWildcard) {
+		return errors.New("the wildcards are not allowed in the topic name: " + topic)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package relay provides the reverse tunnel to the clients behind NAT.
+//
+// The edge peers accept the client sessions and register them in the routing table,
+// and the internal services CALL or PUSH the clients by their session IDs through the edges.
+package relay
+
+import (
+	"sync"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/plugin/proxy"
+)
+
+// missMsg is the status message of the edge the target client is not connected to.
+const missMsg = "relay target not connected"
+
+// EdgeConfig edge options
+type EdgeConfig struct {
+	// Addr is the address of the edge dialed by the internal services.
+	Addr string
+	// IsClient returns whether the accepted session is a client to register,
+	// default is whether the session ID has been set, such as by the auth checker.
+	IsClient func(sess erpc.PreSession) bool
+	// Allow returns whether the relay request is allowed, such as by the session ID of the internal service;
+	// it is required, and all the relay requests are denied if it is nil.
+	// NOTE: It should deny the client sessions, so that a client can not relay to another one.
+	Allow func(label *proxy.Label) bool
+}
+
+// Edge the plugin of the edge peer relaying the requests to the client sessions.
+// NOTE: It should be registered after the plugin setting the session ID, such as the auth checker.
+type Edge struct {
+	cfg      EdgeConfig
+	registry Registry
+	peer     erpc.Peer
+	proxy    erpc.Plugin
+	mu       sync.Mutex
+	clients  map[string]erpc.BaseSession // client ID -> the registered session
+}
+
+var (
+	_ erpc.PostNewPeerPlugin    = (*Edge)(nil)
+	_ erpc.PostAcceptPlugin     = (*Edge)(nil)
+	_ erpc.PostDisconnectPlugin = (*Edge)(nil)
+)
+
+// NewEdge creates an edge plugin with the routing table.
+func NewEdge(registry Registry, cfg EdgeConfig) *Edge {
+	if cfg.Allow == nil {
+		erpc.Warnf("relay: EdgeConfig.Allow is nil, all the relay requests are denied")
+	}
+	e := &Edge{cfg: cfg, registry: registry, clients: make(map[string]erpc.BaseSession)}
+	e.proxy = proxy.NewPlugin(e.forwarder)
+	return e
+}
+
+// Name returns the plugin name.
+func (e *Edge) Name() string {
+	return "relay_edge"
+}
+
+// PostNewPeer handles the unknown CALL and PUSH by the proxy forwarding.
+func (e *Edge) PostNewPeer(peer erpc.EarlyPeer) error {
+	e.peer = peer.(erpc.Peer)
+	return e.proxy.(erpc.PostNewPeerPlugin).PostNewPeer(peer)
+}
+
+// PostAccept registers the client session.
+func (e *Edge) PostAccept(sess erpc.PreSession) *erpc.Status {
+	if !e.isClient(sess) {
+		return nil
+	}
+	id := sess.ID()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.registry.Register(id, e.cfg.Addr); err != nil {
+		return erpc.NewStatus(erpc.CodeInternalServerError, "relay register", err.Error())
+	}
+	e.clients[id] = sess
+	sess.Swap().Store(registeredKey{}, id)
+	return nil
+}
+
+// PostDisconnect deregisters the client session,
+// unless the client has reconnected to the edge by a new session.
+func (e *Edge) PostDisconnect(sess erpc.BaseSession) *erpc.Status {
+	v, ok := sess.Swap().Load(registeredKey{})
+	if !ok {
+		return nil
+	}
+	id := v.(string)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.clients[id] != sess {
+		return nil
+	}
+	delete(e.clients, id)
+	if err := e.registry.Deregister(id, e.cfg.Addr); err != nil {
+		sess.Warnf("relay deregister %s: %v", id, err)
+	}
+	return nil
+}
+
+// registeredKey is the swap key of the registered client ID.
+type registeredKey struct{}
+
+func (e *Edge) forwarder(label *proxy.Label) proxy.Forwarder {
+	if label.Target == "" {
+		return missForwarder{code: erpc.CodeNotFound, msg: erpc.CodeText(erpc.CodeNotFound)}
+	}
+	if !e.allow(label) {
+		return missForwarder{code: erpc.CodeUnauthorized, msg: "relay not allowed"}
+	}
+	sess, ok := e.peer.GetSession(label.Target)
+	if !ok || !sess.Health() {
+		return missForwarder{code: erpc.CodeNotFound, msg: missMsg, cause: label.Target}
+	}
+	return sess
+}
+
+func (e *Edge) isClient(sess erpc.PreSession) bool {
+	if e.cfg.IsClient != nil {
+		return e.cfg.IsClient(sess)
+	}
+	return sess.ID() != sess.RemoteAddr().String()
+}
+
+func (e *Edge) allow(label *proxy.Label) bool {
+	return e.cfg.Allow != nil && e.cfg.Allow(label)
+}
+
+// missForwarder replies the status without forwarding.
+type missForwarder struct {
+	code       int32
+	msg, cause string
+}
+
+func (m missForwarder) Call(uri string, arg interface{}, result interface{}, setting ...erpc.MessageSetting) erpc.CallCmd {
+	return erpc.NewFakeCallCmd(uri, arg, result, erpc.NewStatus(m.code, m.msg, m.cause))
+}
+
+func (m missForwarder) Push(uri string, arg interface{}, setting ...erpc.MessageSetting) *erpc.Status {
+	return erpc.NewStatus(m.code, m.msg, m.cause)
+}
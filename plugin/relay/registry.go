@@ -0,0 +1,72 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrNotFound the client is not connected to any edge.
+var ErrNotFound = errors.New("relay: client not found")
+
+// Registry the routing table of the clients to the edges connected by them.
+// NOTE: The implementation shared by the edges and the internal services must be safe for concurrent use.
+type Registry interface {
+	// Register sets the edge of the client, replacing the previous one.
+	Register(clientID, edge string) error
+	// Deregister removes the client only if its edge is still the given one,
+	// so that the late disconnection from the previous edge does not remove the new location.
+	Deregister(clientID, edge string) error
+	// Lookup returns the edge of the client, or ErrNotFound.
+	Lookup(clientID string) (edge string, err error)
+}
+
+// memRegistry the in-memory registry.
+type memRegistry struct {
+	mu    sync.RWMutex
+	edges map[string]string
+}
+
+// NewMemRegistry creates an in-memory registry, for the edges and the internal services in the same process.
+func NewMemRegistry() Registry {
+	return &memRegistry{edges: make(map[string]string)}
+}
+
+func (r *memRegistry) Register(clientID, edge string) error {
+	r.mu.Lock()
+	r.edges[clientID] = edge
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *memRegistry) Deregister(clientID, edge string) error {
+	r.mu.Lock()
+	if r.edges[clientID] == edge {
+		delete(r.edges, clientID)
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *memRegistry) Lookup(clientID string) (string, error) {
+	r.mu.RLock()
+	edge, ok := r.edges[clientID]
+	r.mu.RUnlock()
+	if !ok {
+		return "", ErrNotFound
+	}
+	return edge, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"sync"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/plugin/proxy"
+)
+
+// Relay the caller of the internal service to CALL or PUSH the clients through the edges.
+type Relay struct {
+	peer     erpc.Peer
+	registry Registry
+	mu       sync.Mutex
+	edges    map[string]erpc.Session // edge address -> session
+}
+
+// New creates a relay caller dialing the edges by the peer.
+func New(peer erpc.Peer, registry Registry) *Relay {
+	return &Relay{
+		peer:     peer,
+		registry: registry,
+		edges:    make(map[string]erpc.Session),
+	}
+}
+
+// Call sends a CALL message to the client through its edge, and receives the reply.
+// NOTE: If the client has moved to another edge, it looks up the location and retries once.
+func (r *Relay) Call(clientID, serviceMethod string, arg interface{}, result interface{}, setting ...erpc.MessageSetting) erpc.CallCmd {
+	setting = append(setting[:len(setting):len(setting)], erpc.WithSetMeta(proxy.MetaTarget, clientID))
+	var cmd erpc.CallCmd
+	r.try(clientID, func(sess erpc.Session) *erpc.Status {
+		cmd = sess.Call(serviceMethod, arg, result, setting...)
+		return cmd.Status()
+	}, func(stat *erpc.Status) {
+		cmd = erpc.NewFakeCallCmd(serviceMethod, arg, result, stat)
+	})
+	return cmd
+}
+
+// Push sends a PUSH message to the client through its edge.
+// NOTE: The edge replies nothing for PUSH, so it is not retried when the client has moved.
+func (r *Relay) Push(clientID, serviceMethod string, arg interface{}, setting ...erpc.MessageSetting) *erpc.Status {
+	setting = append(setting[:len(setting):len(setting)], erpc.WithSetMeta(proxy.MetaTarget, clientID))
+	var stat *erpc.Status
+	r.try(clientID, func(sess erpc.Session) *erpc.Status {
+		stat = sess.Push(serviceMethod, arg, setting...)
+		return stat
+	}, func(s *erpc.Status) {
+		stat = s
+	})
+	return stat
+}
+
+// try sends the request to the edge of the client, and retries once if the edge does not have the client.
+func (r *Relay) try(clientID string, send func(erpc.Session) *erpc.Status, fail func(*erpc.Status)) {
+	var lastEdge string
+	for i := 0; i < 2; i++ {
+		edge, err := r.registry.Lookup(clientID)
+		if err != nil {
+			if err == ErrNotFound {
+				fail(erpc.NewStatus(erpc.CodeNotFound, missMsg, clientID))
+			} else {
+				fail(erpc.NewStatus(erpc.CodeInternalServerError, "relay lookup", err.Error()))
+			}
+			return
+		}
+		if edge == lastEdge {
+			return // the location is not updated yet
+		}
+		lastEdge = edge
+		sess, stat := r.edge(edge)
+		if !stat.OK() {
+			fail(stat)
+			return
+		}
+		if stat = send(sess); !isMiss(stat) {
+			return
+		}
+	}
+}
+
+// edge returns the session to the edge, and dials it if necessary.
+// NOTE: It dials without the lock, so that an unreachable edge does not block the requests to the others.
+func (r *Relay) edge(addr string) (erpc.Session, *erpc.Status) {
+	r.mu.Lock()
+	sess, ok := r.edges[addr]
+	r.mu.Unlock()
+	if ok && sess.Health() {
+		return sess, nil
+	}
+	sess, stat := r.peer.Dial(addr)
+	if !stat.OK() {
+		return nil, stat
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.edges[addr]; ok && old.Health() {
+		// dialed concurrently
+		sess.Close()
+		return old, nil
+	}
+	r.edges[addr] = sess
+	return sess, nil
+}
+
+// isMiss returns whether the edge does not have the client.
+func isMiss(stat *erpc.Status) bool {
+	return stat.Code() == erpc.CodeNotFound && stat.Msg() == missMsg
+}
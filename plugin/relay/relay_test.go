@@ -0,0 +1,178 @@
+package relay_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+	"github.com/andeya/erpc/v7/plugin/auth"
+	"github.com/andeya/erpc/v7/plugin/proxy"
+	"github.com/andeya/erpc/v7/plugin/relay"
+)
+
+// serviceToken is the auth info of the internal services.
+const serviceToken = "internal-service"
+
+// setClientID sets the session ID to the client ID sent by the client, and keeps the ID of the internal services.
+var setClientID = auth.NewCheckerPlugin(func(sess auth.Session, fn auth.RecvOnce) (interface{}, *erpc.Status) {
+	var id string
+	if stat := fn(&id); !stat.OK() {
+		return nil, stat
+	}
+	if id != serviceToken {
+		sess.SetID(id)
+	}
+	return "ok", nil
+})
+
+// staleRegistry returns the stale location once.
+type staleRegistry struct {
+	relay.Registry
+	stale string
+}
+
+func (r *staleRegistry) Lookup(clientID string) (string, error) {
+	if stale := r.stale; stale != "" {
+		r.stale = ""
+		return stale, nil
+	}
+	return r.Registry.Lookup(clientID)
+}
+
+func sendClientID(id string) erpc.Plugin {
+	return auth.NewBearerPlugin(func(sess auth.Session, fn auth.SendOnce) *erpc.Status {
+		var ret string
+		return fn(id, &ret)
+	})
+}
+
+// newEdge serves an edge, and returns its address.
+func newEdge(t *testing.T, registry relay.Registry) (erpc.Peer, string) {
+	// the address is registered as the location of the clients, so it is chosen before listening
+	port := memtest.FreePort()
+	addr := fmt.Sprintf(":%d", port)
+	edge := erpc.NewPeer(
+		erpc.PeerConfig{Network: mem.Network, ListenPort: port},
+		setClientID,
+		relay.NewEdge(registry, relay.EdgeConfig{
+			Addr: addr,
+			// only the internal services, whose IDs are not set, are allowed to relay
+			Allow: func(label *proxy.Label) bool {
+				_, err := registry.Lookup(label.SessionID)
+				return err == relay.ErrNotFound
+			},
+		}),
+	)
+	memtest.Serve(edge)
+	return edge, addr
+}
+
+func newClient(t *testing.T, id, edge string, pushed chan<- string) (erpc.Peer, erpc.Session) {
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network}, sendClientID(id))
+	cli.RouteCallFunc(func(ctx erpc.CallCtx, arg *string) (string, *erpc.Status) {
+		return id + "@" + edge + ": " + *arg, nil
+	})
+	cli.RoutePushFunc(func(ctx erpc.PushCtx, arg *string) *erpc.Status {
+		pushed <- *arg
+		return nil
+	})
+	sess, stat := cli.Dial(edge)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	return cli, sess
+}
+
+func TestRelay(t *testing.T) {
+	registry := &staleRegistry{Registry: relay.NewMemRegistry()}
+	edge1, addr1 := newEdge(t, registry)
+	defer edge1.Close()
+	edge2, addr2 := newEdge(t, registry)
+	defer edge2.Close()
+
+	pushed := make(chan string, 1)
+	cli1, _ := newClient(t, "alice", addr1, pushed)
+	defer cli1.Close()
+
+	svc := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network}, sendClientID(serviceToken))
+	defer svc.Close()
+	r := relay.New(svc, registry)
+
+	var result string
+	if stat := r.Call("alice", "/func1", "hi", &result).Status(); !stat.OK() || result != "alice@"+addr1+": hi" {
+		t.Fatalf("want the reply through edge1, got: %q, %v", result, stat)
+	}
+	if stat := r.Push("alice", "/func2", "ping"); !stat.OK() {
+		t.Fatal(stat)
+	}
+	select {
+	case msg := <-pushed:
+		if msg != "ping" {
+			t.Fatalf("want ping, got: %q", msg)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("push timeout")
+	}
+	if stat := r.Call("bob", "/func1", "hi", &result).Status(); stat.Code() != erpc.CodeNotFound {
+		t.Fatalf("want CodeNotFound for the unknown client, got: %v", stat)
+	}
+
+	// a client can not relay to another one
+	_, bobSess := newClient(t, "bob", addr1, make(chan string, 1))
+	stat := bobSess.Call("/func1", "hi", &result, erpc.WithSetMeta(proxy.MetaTarget, "alice")).Status()
+	if stat.Code() != erpc.CodeUnauthorized {
+		t.Fatalf("want CodeUnauthorized, got: %v", stat)
+	}
+
+	// the client reconnects to edge2, and the late disconnection from edge1 keeps the new location
+	cli2, _ := newClient(t, "alice", addr2, pushed)
+	defer cli2.Close()
+	cli1.Close()
+	time.Sleep(time.Millisecond * 100)
+	if edge, err := registry.Lookup("alice"); err != nil || edge != addr2 {
+		t.Fatalf("want the location edge2, got: %q, %v", edge, err)
+	}
+	if stat := r.Call("alice", "/func1", "hi", &result).Status(); !stat.OK() || result != "alice@"+addr2+": hi" {
+		t.Fatalf("want the reply through edge2, got: %q, %v", result, stat)
+	}
+
+	// the request to the stale location is retried with the new one
+	registry.stale = addr1
+	if stat := r.Call("alice", "/func1", "hi", &result).Status(); !stat.OK() || result != "alice@"+addr2+": hi" {
+		t.Fatalf("want the retry through edge2, got: %q, %v", result, stat)
+	}
+
+	// the client reconnects to the same edge, and the replaced session does not remove the location
+	cli3, _ := newClient(t, "alice", addr2, pushed)
+	defer cli3.Close()
+	time.Sleep(time.Millisecond * 100)
+	if edge, err := registry.Lookup("alice"); err != nil || edge != addr2 {
+		t.Fatalf("want the location edge2 after reconnection, got: %q, %v", edge, err)
+	}
+}
+
+func TestRelayDenied(t *testing.T) {
+	registry := relay.NewMemRegistry()
+	port := memtest.FreePort()
+	addr := fmt.Sprintf(":%d", port)
+	edge := erpc.NewPeer(
+		erpc.PeerConfig{Network: mem.Network, ListenPort: port},
+		setClientID,
+		relay.NewEdge(registry, relay.EdgeConfig{Addr: addr}),
+	)
+	defer edge.Close()
+	memtest.Serve(edge)
+	cli, _ := newClient(t, "alice", addr, make(chan string, 1))
+	defer cli.Close()
+
+	svc := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network}, sendClientID(serviceToken))
+	defer svc.Close()
+	var result string
+	// the relay requests are denied without EdgeConfig.Allow
+	if stat := relay.New(svc, registry).Call("alice", "/func1", "hi", &result).Status(); stat.Code() != erpc.CodeUnauthorized {
+		t.Fatalf("want CodeUnauthorized, got: %v", stat)
+	}
+}
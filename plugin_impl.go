@@ -10,6 +10,7 @@ var (
 	_ PostListenPlugin          = (*PluginImpl)(nil)
 	_ PreDialPlugin             = (*PluginImpl)(nil)
 	_ PostDialPlugin            = (*PluginImpl)(nil)
+	_ PostDialAttemptPlugin     = (*PluginImpl)(nil)
 	_ PostAcceptPlugin          = (*PluginImpl)(nil)
 	_ PreWriteCallPlugin        = (*PluginImpl)(nil)
 	_ PostWriteCallPlugin       = (*PluginImpl)(nil)
@@ -28,6 +29,7 @@ var (
 	_ PreReadReplyBodyPlugin    = (*PluginImpl)(nil)
 	_ PostReadReplyBodyPlugin   = (*PluginImpl)(nil)
 	_ PostDisconnectPlugin      = (*PluginImpl)(nil)
+	_ PreGoAwayPlugin           = (*PluginImpl)(nil)
 )
 
 // PluginImpl implemented all plug-in interfaces.
@@ -46,6 +48,8 @@ type PluginImpl struct {
 	OnPreDial func(localAddr net.Addr, remoteAddr string) *Status
 	// OnPostDial is called after a dial is created.
 	OnPostDial func(sess PreSession, isRedial bool) *Status
+	// OnPostDialAttempt is called after each attempt to dial an address.
+	OnPostDialAttempt func(localAddr net.Addr, remoteAddr string, attempt int, err error)
 	// OnPostAccept is called after a session is accepted.
 	OnPostAccept func(PreSession) *Status
 	// OnPreWriteCall is called before a call is written.
@@ -82,6 +86,8 @@ type PluginImpl struct {
 	OnPostReadReplyBody func(ReadCtx) *Status
 	// OnPostDisconnect is called after a session is disconnected.
 	OnPostDisconnect func(BaseSession) *Status
+	// OnPreGoAway is called before the GOAWAY is sent.
+	OnPreGoAway func(altAddr string)
 }
 
 // Name returns the name of the plugin.
@@ -137,6 +143,13 @@ func (p *PluginImpl) PostDial(sess PreSession, isRedial bool) *Status {
 	return p.OnPostDial(sess, isRedial)
 }
 
+// PostDialAttempt is called after each attempt to dial an address.
+func (p *PluginImpl) PostDialAttempt(localAddr net.Addr, remoteAddr string, attempt int, err error) {
+	if p.OnPostDialAttempt != nil {
+		p.OnPostDialAttempt(localAddr, remoteAddr, attempt, err)
+	}
+}
+
 // PostAccept is called after a session is accepted.
 func (p *PluginImpl) PostAccept(sess PreSession) *Status {
 	if p.OnPostAccept == nil {
@@ -280,3 +293,10 @@ func (p *PluginImpl) PostDisconnect(sess BaseSession) *Status {
 	}
 	return p.OnPostDisconnect(sess)
 }
+
+// PreGoAway is called before the GOAWAY is sent.
+func (p *PluginImpl) PreGoAway(altAddr string) {
+	if p.OnPreGoAway != nil {
+		p.OnPreGoAway(altAddr)
+	}
+}
@@ -42,6 +42,9 @@ var (
 		"application/x-www-form-urlencoded": codec.ID_FORM,
 		"text/plain":                        codec.ID_PLAIN,
 		"text/xml":                          codec.ID_XML,
+		"application/msgpack":               codec.ID_MSGPACK,
+		"application/x-msgpack":             codec.ID_MSGPACK,
+		"application/cbor":                  codec.ID_CBOR,
 	}
 	contentTypeMapping = map[byte]string{
 		codec.ID_PROTOBUF: "application/x-protobuf;charset=utf-8",
@@ -49,6 +52,8 @@ var (
 		codec.ID_FORM:     "application/x-www-form-urlencoded;charset=utf-8",
 		codec.ID_PLAIN:    "text/plain;charset=utf-8",
 		codec.ID_XML:      "text/xml;charset=utf-8",
+		codec.ID_MSGPACK:  "application/msgpack",
+		codec.ID_CBOR:     "application/cbor",
 	}
 )
 
@@ -80,33 +85,135 @@ func GetContentType(codecID byte, defContentType string) string {
 	return contentType
 }
 
+// HTTPStatus returns the HTTP status code of the erpc status code, by its canonical code.
+func HTTPStatus(statCode int32) int {
+	switch code := erpc.CanonicalCode(statCode); code {
+	case erpc.CodeOK:
+		return http.StatusOK
+	case erpc.CodeDeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case erpc.CodeAborted:
+		return http.StatusConflict
+	case erpc.CodeUnknown, erpc.CodeDataLoss:
+		return http.StatusInternalServerError
+	default:
+		return int(code)
+	}
+}
+
+// StatusCode returns the canonical erpc status code of the HTTP status code.
+func StatusCode(httpStatus int) int32 {
+	switch {
+	case httpStatus >= 200 && httpStatus < 300:
+		return erpc.CodeOK
+	case httpStatus == http.StatusBadRequest:
+		return erpc.CodeInvalidArgument
+	case httpStatus == http.StatusUnauthorized:
+		return erpc.CodeUnauthenticated
+	case httpStatus == http.StatusForbidden:
+		return erpc.CodePermissionDenied
+	case httpStatus == http.StatusNotFound:
+		return erpc.CodeNotFound
+	case httpStatus == http.StatusMethodNotAllowed, httpStatus == http.StatusNotImplemented:
+		return erpc.CodeUnimplemented
+	case httpStatus == http.StatusRequestTimeout, httpStatus == http.StatusGatewayTimeout:
+		return erpc.CodeDeadlineExceeded
+	case httpStatus == http.StatusConflict:
+		return erpc.CodeAlreadyExists
+	case httpStatus == http.StatusPreconditionFailed:
+		return erpc.CodeFailedPrecondition
+	case httpStatus == http.StatusRequestedRangeNotSatisfiable:
+		return erpc.CodeOutOfRange
+	case httpStatus == http.StatusUnprocessableEntity:
+		return erpc.CodeInvalidArgument
+	case httpStatus == http.StatusTooManyRequests:
+		return erpc.CodeResourceExhausted
+	case httpStatus == 499:
+		return erpc.CodeCancelled
+	case httpStatus == http.StatusBadGateway, httpStatus == http.StatusServiceUnavailable:
+		return erpc.CodeUnavailable
+	case httpStatus == http.StatusInternalServerError:
+		return erpc.CodeInternal
+	default:
+		return erpc.CodeUnknown
+	}
+}
+
+// Config HTTP style socket protocol options
+type Config struct {
+	// PrintMessage prints the packed and unpacked messages.
+	PrintMessage bool
+	// StatusLine writes the error reply of a canonical or framework status code with the mapped HTTP status line,
+	// otherwise all the error replies use "299 Business Error", which the old peers require.
+	StatusLine bool
+}
+
 // NewHTTProtoFunc is creation function of HTTP style socket protocol.
 // NOTE:
 //
 //	Only support xfer filter: gzip
 //	Must use HTTP service method mapper
 func NewHTTProtoFunc(printMessage ...bool) erpc.ProtoFunc {
-	erpc.SetServiceMethodMapper(erpc.HTTPServiceMethodMapper)
-	var printable bool
+	var cfg Config
 	if len(printMessage) > 0 {
-		printable = printMessage[0]
+		cfg.PrintMessage = printMessage[0]
 	}
+	return NewHTTProtoFuncWithConfig(cfg)
+}
+
+// NewHTTProtoFuncWithConfig is creation function of HTTP style socket protocol with the options.
+// NOTE:
+//
+//	Only support xfer filter: gzip
+//	Must use HTTP service method mapper
+func NewHTTProtoFuncWithConfig(cfg Config) erpc.ProtoFunc {
+	erpc.SetServiceMethodMapper(erpc.HTTPServiceMethodMapper)
 	return func(rw erpc.IOWithReadBuffer) erpc.Proto {
 		return &httproto{
-			id:           'h',
-			name:         "http",
-			rw:           rw,
-			printMessage: printable,
+			id:   'h',
+			name: "http",
+			rw:   rw,
+			cfg:  cfg,
 		}
 	}
 }
 
+// NewSniffer creates the sniffer of HTTP style socket protocol by the request methods.
+// NOTE:
+//
+//	It is not registered when the package is imported, since it sets the HTTP service method mapper;
+//	Register it by erpc.RegSniffer, or set it to erpc.SniffConfig.Sniffers.
+func NewSniffer(printMessage ...bool) *erpc.Sniffer {
+	var cfg Config
+	if len(printMessage) > 0 {
+		cfg.PrintMessage = printMessage[0]
+	}
+	return NewSnifferWithConfig(cfg)
+}
+
+// NewSnifferWithConfig creates the sniffer of HTTP style socket protocol with the options.
+func NewSnifferWithConfig(cfg Config) *erpc.Sniffer {
+	methods := []string{
+		http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions,
+	}
+	magic := make([][]byte, len(methods))
+	for i, method := range methods {
+		magic[i] = []byte(method + " ")
+	}
+	return &erpc.Sniffer{
+		Name:      "http",
+		Magic:     magic,
+		ProtoFunc: NewHTTProtoFuncWithConfig(cfg),
+	}
+}
+
 type httproto struct {
-	rw           erpc.IOWithReadBuffer
-	rMu          sync.Mutex
-	name         string
-	id           byte
-	printMessage bool
+	rw   erpc.IOWithReadBuffer
+	rMu  sync.Mutex
+	name string
+	id   byte
+	cfg  Config
 }
 
 // Version returns the protocol's id and name.
@@ -164,7 +271,7 @@ func (h *httproto) Pack(m erpc.Message) (err error) {
 		return err
 	}
 	m.SetSize(uint32(bb.Len()))
-	if h.printMessage {
+	if h.cfg.PrintMessage {
 		erpc.Printf("Send HTTP Message:\n%s", goutil.BytesToString(bb.B))
 	}
 	_, err = h.rw.Write(bb.B)
@@ -215,7 +322,15 @@ func (h *httproto) packResponse(m erpc.Message, header http.Header, bb *utils.By
 	bb.WriteByte(' ')
 	if stat := m.Status(); !stat.OK() {
 		statBytes, _ := stat.MarshalJSON()
-		bb.Write(bizErrBytes)
+		if !h.cfg.StatusLine || erpc.CanonicalCode(stat.Code()) == erpc.CodeUnknown {
+			// the custom business code, or the old peers
+			bb.Write(bizErrBytes)
+		} else {
+			httpStatus := HTTPStatus(stat.Code())
+			bb.WriteString(strconv.Itoa(httpStatus))
+			bb.WriteByte(' ')
+			bb.WriteString(statusText(httpStatus))
+		}
 		bb.Write(crlfBytes)
 		if gzipName := header.Get("X-Content-Encoding"); gzipName != "" {
 			gz, _ := xfer.GetByName(gzipName)
@@ -267,22 +382,21 @@ func (h *httproto) Unpack(m erpc.Message) error {
 	if bytes.Equal(prefixBytes, respPrefix) {
 		m.SetMtype(erpc.TypeReply)
 		// status line
-		var ok bool
-		a := bytes.SplitN(firstLine, spaceBytes, 2)
-		if len(a) != 2 {
+		a := bytes.SplitN(firstLine, spaceBytes, 3)
+		if len(a) < 2 {
 			return errBadHTTPMsg
 		}
-		if bytes.Equal(a[1], okBytes) {
-			ok = true
-		} else if !bytes.Equal(a[1], bizErrBytes) {
-			// TODO support
-			return errUnsupportHTTPCode
+		httpStatus, err := strconv.Atoi(goutil.BytesToString(a[1]))
+		if err != nil {
+			return errBadHTTPMsg
 		}
+		bizErr := bytes.HasSuffix(firstLine, bizErrBytes)
+		ok := !bizErr && StatusCode(httpStatus) == erpc.CodeOK
 		size, msg, err = h.unpack(m, bb)
 		if err != nil {
 			return err
 		}
-		if h.printMessage {
+		if h.cfg.PrintMessage {
 			erpc.Printf("Recv HTTP Message:\n%s\r\n%s",
 				goutil.BytesToString(firstLine), goutil.BytesToString(msg))
 		}
@@ -292,7 +406,20 @@ func (h *httproto) Unpack(m erpc.Message) error {
 			return m.UnmarshalBody(bb.B)
 		}
 		m.UnmarshalBody(nil)
-		return m.Status(true).UnmarshalJSON(bb.B)
+		stat := m.Status(true)
+		if bizErr {
+			return stat.UnmarshalJSON(bb.B)
+		}
+		// the status of erpc, or the one converted from the HTTP status
+		if stat.UnmarshalJSON(bb.B) != nil || stat.OK() {
+			stat.Clear()
+			stat.SetCode(StatusCode(httpStatus))
+			stat.SetMsg(statusText(httpStatus))
+			if len(bb.B) > 0 {
+				stat.SetCause(string(bb.B))
+			}
+		}
+		return nil
 	}
 
 	// request
@@ -313,7 +440,7 @@ func (h *httproto) Unpack(m erpc.Message) error {
 	if err != nil {
 		return err
 	}
-	if h.printMessage {
+	if h.cfg.PrintMessage {
 		erpc.Printf("Recv HTTP Message:\n%s\r\n%s",
 			goutil.BytesToString(firstLine), goutil.BytesToString(msg))
 	}
@@ -331,9 +458,16 @@ var (
 	xSeqBytes             = []byte("X-Seq")
 	xMtypeBytes           = []byte("X-Mtype")
 	errBadHTTPMsg         = errors.New("bad HTTP message")
-	errUnsupportHTTPCode  = errors.New("unsupport HTTP status code")
 )
 
+// statusText returns the text of the HTTP status code, including the non-standard 499.
+func statusText(httpStatus int) string {
+	if httpStatus == 499 {
+		return "Client Closed Request"
+	}
+	return http.StatusText(httpStatus)
+}
+
 func (h *httproto) unpack(m erpc.Message, bb *utils.ByteBuffer) (size int, msg []byte, err error) {
 	var bodySize int
 	var a [][]byte
@@ -342,7 +476,7 @@ func (h *httproto) unpack(m erpc.Message, bb *utils.ByteBuffer) (size int, msg [
 		if err != nil {
 			return 0, nil, err
 		}
-		if h.printMessage {
+		if h.cfg.PrintMessage {
 			msg = append(msg, bb.B...)
 			msg = append(msg, '\r', '\n')
 		}
@@ -405,7 +539,7 @@ func (h *httproto) unpack(m erpc.Message, bb *utils.ByteBuffer) (size int, msg [
 	if err != nil {
 		return 0, nil, err
 	}
-	if h.printMessage {
+	if h.cfg.PrintMessage {
 		msg = append(msg, bb.B...)
 		msg = append(msg, '\r', '\n')
 	}
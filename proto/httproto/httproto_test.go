@@ -1,8 +1,12 @@
 package httproto_test
 
 import (
+	"bufio"
+	"context"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,6 +14,8 @@ import (
 	"github.com/andeya/goutil/httpbody"
 
 	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
 	"github.com/andeya/erpc/v7/proto/httproto"
 )
 
@@ -97,3 +103,135 @@ func TestHTTProto(t *testing.T) {
 		t.Logf("http client response: %s", b)
 	}
 }
+
+func TestSniff(t *testing.T) {
+	srv := erpc.NewPeer(erpc.PeerConfig{
+		Network: mem.Network,
+		Sniff:   erpc.SniffConfig{Enable: true, Sniffers: []*erpc.Sniffer{httproto.NewSniffer()}},
+	})
+	srv.RouteCall(new(Home))
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network, DialTimeout: time.Second})
+	defer cli.Close()
+	var arg = map[string]string{"author": "andeya"}
+	for serviceMethod, protoFunc := range map[string]erpc.ProtoFunc{
+		"http://localhost/home/test?peer_id=110": httproto.NewHTTProtoFunc(),
+		"/home/test":                             nil,
+	} {
+		sess, stat := cli.Dial(addr, protoFunc)
+		if !stat.OK() {
+			t.Fatal(stat)
+		}
+		var result map[string]interface{}
+		if stat = sess.Call(serviceMethod, arg, &result).Status(); !stat.OK() {
+			t.Fatalf("%s: %v", serviceMethod, stat)
+		}
+		if a, _ := result["arg"].(map[string]interface{}); a["author"] != "andeya" {
+			t.Fatalf("%s: unexpected result: %v", serviceMethod, result)
+		}
+	}
+}
+
+func (h *Home) TestStatus(arg *map[string]string) (map[string]interface{}, *erpc.Status) {
+	switch (*arg)["code"] {
+	case "not_found":
+		return nil, erpc.NewStatusWithDetails(erpc.CodeNotFound, "", &erpc.StatusDetails{
+			Cause:           "no such user",
+			FieldViolations: []erpc.FieldViolation{{Field: "user_id", Description: "unknown"}},
+		})
+	case "aborted":
+		return nil, erpc.NewStatus(erpc.CodeAborted, "", "conflict")
+	default:
+		return nil, erpc.NewStatus(1001, "business error", "")
+	}
+}
+
+func TestStatusMapping(t *testing.T) {
+	for _, code := range []int32{
+		erpc.CodeOK, erpc.CodeCancelled, erpc.CodeInvalidArgument, erpc.CodeDeadlineExceeded,
+		erpc.CodeNotFound, erpc.CodeAlreadyExists, erpc.CodePermissionDenied, erpc.CodeUnauthenticated,
+		erpc.CodeResourceExhausted, erpc.CodeFailedPrecondition, erpc.CodeOutOfRange,
+		erpc.CodeUnimplemented, erpc.CodeInternal, erpc.CodeUnavailable,
+	} {
+		if have := httproto.StatusCode(httproto.HTTPStatus(code)); have != code {
+			t.Fatalf("code %d: round trip to %d", code, have)
+		}
+	}
+	for code, httpStatus := range map[int32]int{
+		erpc.CodeAborted:       http.StatusConflict,
+		erpc.CodeDataLoss:      http.StatusInternalServerError,
+		erpc.CodeUnknown:       http.StatusInternalServerError,
+		erpc.CodeConnClosed:    http.StatusServiceUnavailable,
+		erpc.CodeBadGateway:    http.StatusServiceUnavailable,
+		erpc.CodeInvalidOp:     http.StatusPreconditionFailed,
+		1001:                   http.StatusInternalServerError,
+		erpc.CodeHandleTimeout: http.StatusGatewayTimeout,
+	} {
+		if have := httproto.HTTPStatus(code); have != httpStatus {
+			t.Fatalf("code %d: want HTTP %d, have %d", code, httpStatus, have)
+		}
+	}
+	if have := httproto.StatusCode(http.StatusMovedPermanently); have != erpc.CodeUnknown {
+		t.Fatalf("HTTP 301: want %d, have %d", erpc.CodeUnknown, have)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+	srv.RouteCall(new(Home))
+	addr := memtest.Serve(srv, httproto.NewHTTProtoFuncWithConfig(httproto.Config{StatusLine: true}))
+	defer srv.Close()
+
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network, DialTimeout: time.Second})
+	defer cli.Close()
+	sess, stat := cli.Dial(addr, httproto.NewHTTProtoFunc())
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	var result interface{}
+	stat = sess.Call("http://localhost/home/test_status", map[string]string{"code": "not_found"}, &result).Status()
+	if stat.Code() != erpc.CodeNotFound || stat.Msg() != "Not Found" {
+		t.Fatalf("unexpected status: %v", stat)
+	}
+	details, ok := erpc.GetStatusDetails(stat)
+	if !ok || details.Cause != "no such user" || len(details.FieldViolations) != 1 || details.FieldViolations[0].Field != "user_id" {
+		t.Fatalf("unexpected details: %+v, %v", details, ok)
+	}
+	stat = sess.Call("http://localhost/home/test_status", map[string]string{"code": "aborted"}, &result).Status()
+	if stat.Code() != erpc.CodeAborted {
+		t.Fatalf("unexpected status: %v", stat)
+	}
+	stat = sess.Call("http://localhost/home/test_status", map[string]string{}, &result).Status()
+	if stat.Code() != 1001 || stat.Msg() != "business error" {
+		t.Fatalf("unexpected status: %v", stat)
+	}
+}
+
+func TestStatusLine(t *testing.T) {
+	for statusLine, want := range map[bool]string{
+		true:  "HTTP/1.1 404 Not Found",
+		false: "HTTP/1.1 299 Business Error",
+	} {
+		srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+		srv.RouteCall(new(Home))
+		addr := memtest.Serve(srv, httproto.NewHTTProtoFuncWithConfig(httproto.Config{StatusLine: statusLine}))
+		defer srv.Close()
+
+		conn, err := mem.DialContext(context.Background(), addr, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := `{"code":"not_found"}`
+		fmt.Fprintf(conn, "POST /home/test_status HTTP/1.1\r\nContent-Type: application/json\r\nContent-Length: %d\r\nX-Mtype: 1\r\nX-Seq: 1\r\n\r\n%s", len(body), body)
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimSpace(line); line != want {
+			t.Fatalf("status line: want %q, have %q", want, line)
+		}
+	}
+}
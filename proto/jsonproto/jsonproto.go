@@ -45,6 +45,36 @@ func NewJSONProtoFunc() erpc.ProtoFunc {
 	}
 }
 
+func init() {
+	erpc.RegSniffer(NewSniffer())
+}
+
+// NewSniffer creates the sniffer of JSON socket protocol,
+// the default one is registered when the package is imported.
+// NOTE: Only the messages without the transfer pipe are recognized.
+func NewSniffer() *erpc.Sniffer {
+	return &erpc.Sniffer{
+		Name:      "json",
+		Match:     matchJSON,
+		ProtoFunc: NewJSONProtoFunc(),
+	}
+}
+
+// matchJSON matches {4 bytes length}{xfer_pipe length byte: 0}{"seq":
+func matchJSON(peek []byte) (ok, more bool) {
+	const prefixLen = 5
+	if len(peek) < prefixLen {
+		return false, true
+	}
+	if peek[prefixLen-1] != 0 {
+		return false, false
+	}
+	if len(peek) < prefixLen+len(msg1) {
+		return false, bytes.HasPrefix(msg1, peek[prefixLen:])
+	}
+	return bytes.HasPrefix(peek[prefixLen:], msg1), false
+}
+
 type jsonproto struct {
 	rw   erpc.IOWithReadBuffer
 	rMu  sync.Mutex
@@ -0,0 +1,633 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package raw2proto is implemented the compact binary framing protocol v2 of the raw protocol.
+package raw2proto
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/socket"
+	"github.com/andeya/erpc/v7/utils"
+)
+
+/*
+# raw2 protocol format(Big Endian):
+
+{1 byte magic} # 0xB2
+{1 byte flags} # bit0: 64-bit size; bit1: settings
+{4 or 8 bytes message length}
+{settings, only in the first message: uvarint max methods, uvarint meta table size}
+{1 byte transfer pipe length}
+{transfer pipe IDs}
+# The following is handled data by transfer pipe
+{varint sequence}
+{1 byte message type} # e.g. CALL:1; REPLY:2; PUSH:3
+{uvarint service method id} # 0: followed by the literal {uvarint length}{service method}
+{1 byte status flag} # 0: OK; 1: followed by {varint code}{uvarint length}{msg}{uvarint length}{cause}
+{uvarint metadata count}
+{metadata fields} # {1 byte representation}{uvarint index or literal key}{literal value}
+{1 byte body codec id}
+{body}
+
+# literal: {uvarint length}{bytes}
+*/
+
+const (
+	magic = 0xB2
+
+	flagSize64   = 1 << 0
+	flagSettings = 1 << 1
+
+	// NegotiateMetaKey is the metadata key announcing that the raw2 protocol is supported,
+	// it is carried by the raw messages until the remote peer is known to support raw2.
+	NegotiateMetaKey = "X-Raw2"
+)
+
+// metadata field representations
+const (
+	metaLiteralIndexed    byte = iota // literal key and value, added to the table
+	metaLiteral                       // literal key and value, not added
+	metaKeyIndexedIndexed             // indexed key and literal value, added to the table
+	metaKeyIndexed                    // indexed key and literal value, not added
+	metaIndexed                       // indexed key and value
+)
+
+var (
+	errBadMessage   = errors.New("raw2 proto: bad message")
+	errNoSettings   = errors.New("raw2 proto: no settings before the message")
+	errBrokenEncode = errors.New("raw2 proto: the encoder state is broken by a failed write")
+)
+
+// Config raw2 protocol options
+type Config struct {
+	// MaxMethods is the maximum number of the interned service methods per direction, default 1024.
+	MaxMethods int
+	// MetaTableSize is the maximum size of the metadata dynamic table per direction in bytes, default 4096.
+	MetaTableSize int
+	// Size64 always writes the 64-bit message length, otherwise it is only used for the message over 4GB.
+	// NOTE: The message size is still limited by socket.SetMessageSizeLimit.
+	Size64 bool
+	// NoNegotiation writes the raw2 messages at once,
+	// only when the remote peers are known to support raw2.
+	NoNegotiation bool
+}
+
+func init() {
+	erpc.RegSniffer(NewSniffer())
+}
+
+// NewSniffer creates the sniffer of the raw2 protocol by its magic byte,
+// the default one is registered when the package is imported.
+// NOTE: The clients with negotiation write the raw messages first, they are served as the fallback protocol.
+func NewSniffer(cfg ...Config) *erpc.Sniffer {
+	return &erpc.Sniffer{
+		Name:      "raw2",
+		Magic:     [][]byte{{magic}},
+		ProtoFunc: NewRaw2ProtoFunc(cfg...),
+	}
+}
+
+// NewRaw2ProtoFunc is creation function of the compact binary framing protocol v2.
+// NOTE:
+//
+//	id:7, name:"raw2"
+//	The raw messages are sent until the remote peer is known to support raw2,
+//	so that it interoperates with the raw protocol peers.
+func NewRaw2ProtoFunc(cfg ...Config) erpc.ProtoFunc {
+	var c Config
+	if len(cfg) > 0 {
+		c = cfg[0]
+	}
+	if c.MaxMethods <= 0 {
+		c.MaxMethods = 1024
+	}
+	if c.MetaTableSize <= 0 {
+		c.MetaTableSize = 4096
+	}
+	return func(rw erpc.IOWithReadBuffer) erpc.Proto {
+		p := &raw2Proto{
+			cfg:     c,
+			id:      7,
+			name:    "raw2",
+			r:       &pushbackReader{r: rw},
+			w:       rw,
+			methods: newMethodTable(c.MaxMethods),
+			meta:    newMetaTable(c.MetaTableSize, true),
+		}
+		p.raw = socket.RawProtoFunc(struct {
+			io.Reader
+			io.Writer
+		}{p.r, rw})
+		if c.NoNegotiation {
+			p.remoteV2 = 1
+		}
+		return p
+	}
+}
+
+type raw2Proto struct {
+	cfg  Config
+	id   byte
+	name string
+	r    *pushbackReader
+	w    io.Writer
+	raw  socket.Proto
+
+	// remoteV2 is 1 if the remote peer supports raw2
+	remoteV2 int32
+
+	// encoder
+	wMu          sync.Mutex
+	sentSettings bool
+	broken       bool
+	methods      *methodTable
+	meta         *metaTable
+
+	// decoder
+	rMu      sync.Mutex
+	rMethods *methodTable
+	rMeta    *metaTable
+}
+
+// Version returns the protocol's id and name.
+func (p *raw2Proto) Version() (byte, string) {
+	return p.id, p.name
+}
+
+// Stateful returns true, since the header tables are shared by the messages of the connection.
+func (p *raw2Proto) Stateful() bool {
+	return true
+}
+
+// Pack writes the Message into the connection.
+// NOTE: Make sure to write only once or there will be package contamination!
+func (p *raw2Proto) Pack(m erpc.Message) error {
+	if atomic.LoadInt32(&p.remoteV2) == 0 {
+		return p.packRaw(m)
+	}
+	p.wMu.Lock()
+	defer p.wMu.Unlock()
+	if p.broken {
+		return errBrokenEncode
+	}
+
+	// body is marshaled first, the failure does not change the tables
+	bodyBytes, err := m.MarshalBody()
+	if err != nil {
+		return err
+	}
+	if uint64(len(bodyBytes)) > uint64(socket.MessageSizeLimit()) {
+		return socket.ErrExceedMessageSizeLimit
+	}
+
+	bb := utils.AcquireByteBuffer()
+	defer utils.ReleaseByteBuffer(bb)
+
+	// the table changes by the header are undone unless the message is written
+	p.methods.begin()
+	p.meta.begin()
+	var written bool
+	defer func() {
+		if written {
+			p.methods.commit()
+			p.meta.commit()
+		} else {
+			p.methods.rollback()
+			p.meta.rollback()
+		}
+	}()
+
+	// header
+	p.writeHeader(bb, m)
+	// body
+	bb.WriteByte(m.BodyCodec())
+	bb.Write(bodyBytes)
+
+	// do transfer pipe
+	payload, err := m.XferPipe().OnPack(bb.B)
+	if err != nil {
+		return err
+	}
+
+	// prefix
+	prefix := utils.AcquireByteBuffer()
+	defer utils.ReleaseByteBuffer(prefix)
+	var settings []byte
+	var flags byte
+	if !p.sentSettings {
+		flags |= flagSettings
+		settings = appendUvarint(settings, uint64(p.cfg.MaxMethods))
+		settings = appendUvarint(settings, uint64(p.cfg.MetaTableSize))
+	}
+	xferLen := m.XferPipe().Len()
+	rest := uint64(len(settings)) + 1 + uint64(xferLen) + uint64(len(payload))
+	sizeLen := uint64(4)
+	if p.cfg.Size64 || 2+4+rest > math.MaxUint32 {
+		flags |= flagSize64
+		sizeLen = 8
+	}
+	total := 2 + sizeLen + rest
+	if total > math.MaxUint32 {
+		return socket.ErrExceedMessageSizeLimit
+	}
+	if err = m.SetSize(uint32(total)); err != nil {
+		return err
+	}
+	prefix.WriteByte(magic)
+	prefix.WriteByte(flags)
+	var size [8]byte
+	if sizeLen == 8 {
+		binary.BigEndian.PutUint64(size[:], rest)
+	} else {
+		binary.BigEndian.PutUint32(size[:], uint32(rest))
+	}
+	prefix.Write(size[:sizeLen])
+	prefix.Write(settings)
+	prefix.WriteByte(byte(xferLen))
+	prefix.Write(m.XferPipe().IDs())
+	prefix.Write(payload)
+
+	// real write
+	if _, err = p.w.Write(prefix.B); err != nil {
+		// the remote peer may have read a part of the message
+		p.broken = true
+		return err
+	}
+	p.sentSettings = true
+	written = true
+	return nil
+}
+
+// packRaw writes the raw message with the negotiation metadata.
+func (p *raw2Proto) packRaw(m erpc.Message) error {
+	m.Meta().Set(NegotiateMetaKey, "1")
+	defer m.Meta().Del(NegotiateMetaKey)
+	return p.raw.Pack(m)
+}
+
+func (p *raw2Proto) writeHeader(bb *utils.ByteBuffer, m erpc.Message) {
+	// seq
+	bb.B = appendVarint(bb.B, int64(m.Seq()))
+	// type
+	bb.WriteByte(m.Mtype())
+
+	// service method
+	serviceMethod := m.ServiceMethod()
+	id := p.methods.encode(serviceMethod)
+	bb.B = appendUvarint(bb.B, id)
+	if id == 0 {
+		bb.B = appendString(bb.B, serviceMethod)
+	}
+
+	// status
+	stat := m.Status()
+	if stat == nil || (stat.OK() && stat.Msg() == "") {
+		bb.WriteByte(0)
+	} else {
+		bb.WriteByte(1)
+		bb.B = appendVarint(bb.B, int64(stat.Code()))
+		msg := stat.Msg()
+		bb.B = appendString(bb.B, msg)
+		var cause string
+		if c := stat.Cause(); c != nil && c.Error() != msg {
+			cause = c.Error()
+		}
+		bb.B = appendString(bb.B, cause)
+	}
+
+	// meta
+	meta := m.Meta()
+	bb.B = appendUvarint(bb.B, uint64(meta.Len()))
+	meta.VisitAll(func(k, v []byte) {
+		e := entry{key: string(k), value: string(v)}
+		if index, ok := p.meta.findPair(e.key, e.value); ok {
+			bb.WriteByte(metaIndexed)
+			bb.B = appendUvarint(bb.B, index)
+			return
+		}
+		add := p.meta.fits(e)
+		if index, ok := p.meta.findKey(e.key); ok {
+			if add {
+				bb.WriteByte(metaKeyIndexedIndexed)
+			} else {
+				bb.WriteByte(metaKeyIndexed)
+			}
+			bb.B = appendUvarint(bb.B, index)
+		} else {
+			if add {
+				bb.WriteByte(metaLiteralIndexed)
+			} else {
+				bb.WriteByte(metaLiteral)
+			}
+			bb.B = appendString(bb.B, e.key)
+		}
+		bb.B = appendString(bb.B, e.value)
+		if add {
+			p.meta.add(e)
+		}
+	})
+}
+
+// Unpack reads bytes from the connection to the Message.
+// NOTE: Concurrent unsafe!
+func (p *raw2Proto) Unpack(m erpc.Message) error {
+	p.rMu.Lock()
+	defer p.rMu.Unlock()
+	var first [1]byte
+	if _, err := io.ReadFull(p.r, first[:]); err != nil {
+		return err
+	}
+	if first[0] != magic {
+		// the raw message
+		p.r.pushback(first[0])
+		if err := p.raw.Unpack(m); err != nil {
+			return err
+		}
+		if m.Meta().Has(NegotiateMetaKey) {
+			m.Meta().Del(NegotiateMetaKey)
+			atomic.StoreInt32(&p.remoteV2, 1)
+		}
+		return nil
+	}
+	atomic.StoreInt32(&p.remoteV2, 1)
+
+	bb := utils.AcquireByteBuffer()
+	defer utils.ReleaseByteBuffer(bb)
+
+	// read message
+	data, err := p.readMessage(bb, m)
+	if err != nil {
+		return err
+	}
+	// do transfer pipe
+	data, err = m.XferPipe().OnUnpack(data)
+	if err != nil {
+		return err
+	}
+	// header
+	data, err = p.readHeader(data, m)
+	if err != nil {
+		return err
+	}
+	// body
+	if len(data) == 0 {
+		return errBadMessage
+	}
+	m.SetBodyCodec(data[0])
+	return m.UnmarshalBody(data[1:])
+}
+
+func (p *raw2Proto) readMessage(bb *utils.ByteBuffer, m erpc.Message) ([]byte, error) {
+	// flags
+	bb.ChangeLen(1)
+	if _, err := io.ReadFull(p.r, bb.B); err != nil {
+		return nil, err
+	}
+	flags := bb.B[0]
+
+	// size
+	var rest uint64
+	if flags&flagSize64 != 0 {
+		bb.ChangeLen(8)
+		if _, err := io.ReadFull(p.r, bb.B); err != nil {
+			return nil, err
+		}
+		rest = binary.BigEndian.Uint64(bb.B)
+	} else {
+		bb.ChangeLen(4)
+		if _, err := io.ReadFull(p.r, bb.B); err != nil {
+			return nil, err
+		}
+		rest = uint64(binary.BigEndian.Uint32(bb.B))
+	}
+	total := 2 + uint64(len(bb.B)) + rest
+	if total > math.MaxUint32 {
+		return nil, socket.ErrExceedMessageSizeLimit
+	}
+	if err := m.SetSize(uint32(total)); err != nil {
+		return nil, err
+	}
+	bb.ChangeLen(int(rest))
+	if _, err := io.ReadFull(p.r, bb.B); err != nil {
+		return nil, err
+	}
+	data := bb.B
+
+	// settings
+	if flags&flagSettings != 0 {
+		maxMethods, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errBadMessage
+		}
+		data = data[n:]
+		metaTableSize, n := binary.Uvarint(data)
+		if n <= 0 || maxMethods > math.MaxInt32 || metaTableSize > math.MaxInt32 {
+			return nil, errBadMessage
+		}
+		data = data[n:]
+		// the remote settings are clamped to the local maxima, to bound the memory of the decoder,
+		// and the message referencing beyond them is rejected
+		if maxMethods > uint64(p.cfg.MaxMethods) {
+			maxMethods = uint64(p.cfg.MaxMethods)
+		}
+		if metaTableSize > uint64(p.cfg.MetaTableSize) {
+			metaTableSize = uint64(p.cfg.MetaTableSize)
+		}
+		p.rMethods = newMethodTable(int(maxMethods))
+		p.rMeta = newMetaTable(int(metaTableSize), false)
+	} else if p.rMethods == nil {
+		return nil, errNoSettings
+	}
+
+	// transfer pipe
+	if len(data) == 0 {
+		return nil, errBadMessage
+	}
+	xferLen := int(data[0])
+	data = data[1:]
+	if len(data) < xferLen {
+		return nil, errBadMessage
+	}
+	if xferLen > 0 {
+		if err := m.XferPipe().Append(data[:xferLen]...); err != nil {
+			return nil, err
+		}
+	}
+	return data[xferLen:], nil
+}
+
+func (p *raw2Proto) readHeader(data []byte, m erpc.Message) ([]byte, error) {
+	d := decoder{data: data}
+
+	// seq
+	m.SetSeq(int32(d.varint()))
+	// type
+	m.SetMtype(d.byte())
+
+	// service method
+	if id := d.uvarint(); id == 0 {
+		serviceMethod := d.string()
+		if d.err == nil {
+			p.rMethods.add(serviceMethod)
+		}
+		m.SetServiceMethod(serviceMethod)
+	} else if serviceMethod, ok := p.rMethods.get(id); ok {
+		m.SetServiceMethod(serviceMethod)
+	} else {
+		return nil, errBadMessage
+	}
+
+	// status
+	if d.byte() == 1 {
+		stat := m.Status(true)
+		stat.SetCode(int32(d.varint()))
+		stat.SetMsg(d.string())
+		if cause := d.string(); cause != "" {
+			stat.SetCause(cause)
+		}
+	}
+
+	// meta
+	count := d.uvarint()
+	meta := m.Meta()
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		var e entry
+		rep := d.byte()
+		switch rep {
+		case metaIndexed, metaKeyIndexedIndexed, metaKeyIndexed:
+			old, ok := p.rMeta.get(d.uvarint())
+			if !ok {
+				return nil, errBadMessage
+			}
+			e = old
+			if rep != metaIndexed {
+				e.value = d.string()
+			}
+		case metaLiteralIndexed, metaLiteral:
+			e.key = d.string()
+			e.value = d.string()
+		default:
+			return nil, errBadMessage
+		}
+		if d.err != nil {
+			break
+		}
+		if rep == metaLiteralIndexed || rep == metaKeyIndexedIndexed {
+			p.rMeta.add(e)
+		}
+		meta.Add(e.key, e.value)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return d.data, nil
+}
+
+// decoder reads the fields, and keeps the first error.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil || len(d.data) == 0 {
+		d.err = errBadMessage
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errBadMessage
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = errBadMessage
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if uint64(len(d.data)) < n {
+		d.err = errBadMessage
+		return ""
+	}
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// pushbackReader is the reader with a byte read back.
+type pushbackReader struct {
+	r       io.Reader
+	b       byte
+	hasByte bool
+}
+
+func (r *pushbackReader) pushback(b byte) {
+	r.b, r.hasByte = b, true
+}
+
+func (r *pushbackReader) Read(p []byte) (int, error) {
+	if !r.hasByte || len(p) == 0 {
+		return r.r.Read(p)
+	}
+	p[0] = r.b
+	r.hasByte = false
+	return 1, nil
+}
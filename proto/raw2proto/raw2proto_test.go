@@ -0,0 +1,265 @@
+package raw2proto_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+	"github.com/andeya/erpc/v7/proto/raw2proto"
+	"github.com/andeya/erpc/v7/socket"
+	"github.com/andeya/erpc/v7/xfer"
+	"github.com/andeya/erpc/v7/xfer/gzip"
+)
+
+func newMessage(seq int32, serviceMethod string, meta map[string]string, body string) erpc.Message {
+	m := socket.GetMessage()
+	m.SetMtype(erpc.TypeCall)
+	m.SetSeq(seq)
+	m.SetServiceMethod(serviceMethod)
+	for k, v := range meta {
+		m.Meta().Set(k, v)
+	}
+	m.SetBodyCodec('s')
+	m.SetBody(body)
+	return m
+}
+
+func TestRaw2Proto(t *testing.T) {
+	var conn bytes.Buffer
+	cfg := raw2proto.Config{NoNegotiation: true, MetaTableSize: 128}
+	w := raw2proto.NewRaw2ProtoFunc(cfg)(&conn)
+	r := raw2proto.NewRaw2ProtoFunc(cfg)(&conn)
+	var raw bytes.Buffer
+	rawProto := socket.RawProtoFunc(&raw)
+
+	meta := map[string]string{"X-Trace": "abc", "X-User": "andeya"}
+	var sizes []int
+	for i := int32(1); i <= 3; i++ {
+		m := newMessage(i*1000, "/home/test", meta, "hello")
+		if i == 3 {
+			m.Meta().Set("X-User", "other")
+			m.Meta().Set("X-Large", strings.Repeat("x", 200)) // never indexed
+			m.SetStatus(erpc.NewStatus(erpc.CodeBadMessage, "bad", "the cause"))
+		}
+		if err := w.Pack(m); err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, conn.Len())
+		if err := rawProto.Pack(m); err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 && conn.Len()*2 >= raw.Len() {
+			t.Fatalf("want the indexed message less than half of raw, got: %d >= %d", conn.Len(), raw.Len())
+		}
+		raw.Reset()
+
+		got := socket.GetMessage()
+		got.SetNewBody(func(socket.Header) interface{} { return new(string) })
+		if err := r.Unpack(got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Seq() != m.Seq() || got.Mtype() != m.Mtype() || got.ServiceMethod() != m.ServiceMethod() ||
+			got.Meta().String() != m.Meta().String() || *got.Body().(*string) != "hello" ||
+			got.Status().Code() != m.Status().Code() || got.Status().Msg() != m.Status().Msg() ||
+			got.Status().Cause() != nil && got.Status().Cause().Error() != m.Status().Cause().Error() {
+			t.Fatalf("want %s, got %s", m, got)
+		}
+		if int(got.Size()) != sizes[i-1] {
+			t.Fatalf("want size %d, got %d", sizes[i-1], got.Size())
+		}
+		socket.PutMessage(m)
+		socket.PutMessage(got)
+	}
+	if sizes[1] >= sizes[0] {
+		t.Fatalf("want the indexed message smaller, got: %v", sizes)
+	}
+}
+
+// pipe reads from r and writes to w.
+type pipe struct {
+	r, w *bytes.Buffer
+}
+
+func (p pipe) Read(b []byte) (int, error)  { return p.r.Read(b) }
+func (p pipe) Write(b []byte) (int, error) { return p.w.Write(b) }
+
+func TestNegotiation(t *testing.T) {
+	var a2b, b2a bytes.Buffer
+	a := raw2proto.NewRaw2ProtoFunc()(pipe{r: &b2a, w: &a2b})
+	b := raw2proto.NewRaw2ProtoFunc()(pipe{r: &a2b, w: &b2a})
+	roundTrip := func(w, r erpc.Proto, conn *bytes.Buffer, wantV2 bool) {
+		t.Helper()
+		m := newMessage(1, "/x", map[string]string{"k": "v"}, "body")
+		defer socket.PutMessage(m)
+		if err := w.Pack(m); err != nil {
+			t.Fatal(err)
+		}
+		if isV2 := conn.Bytes()[0] == 0xB2; isV2 != wantV2 {
+			t.Fatalf("want raw2 %v, got %v", wantV2, isV2)
+		}
+		got := socket.GetMessage()
+		defer socket.PutMessage(got)
+		if err := r.Unpack(got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Meta().Has(raw2proto.NegotiateMetaKey) || string(got.Meta().Peek("k")) != "v" {
+			t.Fatalf("unexpected meta: %s", got.Meta().String())
+		}
+	}
+	// the first message is raw with the announcement
+	roundTrip(a, b, &a2b, false)
+	// b knows that a supports raw2
+	roundTrip(b, a, &b2a, true)
+	// a knows that b supports raw2
+	roundTrip(a, b, &a2b, true)
+}
+
+type Home struct {
+	erpc.CallCtx
+}
+
+func (h *Home) Test(arg *map[string]string) (map[string]string, *erpc.Status) {
+	return map[string]string{
+		"arg":     (*arg)["author"],
+		"peer_id": string(h.PeekMeta("peer_id")),
+	}, nil
+}
+
+func TestInterop(t *testing.T) {
+	gzip.Reg('g', "gizp-5", 5)
+	raw2 := raw2proto.NewRaw2ProtoFunc()
+	cases := []struct {
+		name     string
+		srv, cli erpc.ProtoFunc
+	}{
+		{"raw2", raw2, raw2},
+		{"raw2 server", raw2, socket.RawProtoFunc},
+		{"raw2 client", socket.RawProtoFunc, raw2},
+	}
+	for _, c := range cases {
+		srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+		srv.RouteCall(new(Home))
+		addr := memtest.Serve(srv, c.srv)
+
+		cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+		sess, stat := cli.Dial(addr, c.cli)
+		if !stat.OK() {
+			t.Fatal(stat)
+		}
+		for i := 0; i < 3; i++ {
+			var result map[string]string
+			stat = sess.Call("/home/test",
+				map[string]string{"author": "andeya"},
+				&result,
+				erpc.WithAddMeta("peer_id", "110"),
+				erpc.WithXferPipe('g'),
+			).Status()
+			if !stat.OK() || result["arg"] != "andeya" || result["peer_id"] != "110" {
+				t.Fatalf("%s: unexpected result: %v, %v", c.name, result, stat)
+			}
+		}
+		if stat = sess.Call("/home/unknown", nil, nil).Status(); stat.Code() != erpc.CodeNotFound {
+			t.Fatalf("%s: want CodeNotFound, got: %v", c.name, stat)
+		}
+		cli.Close()
+		srv.Close()
+	}
+}
+
+func TestStateful(t *testing.T) {
+	// the messages share the header tables, so they are never sent as datagrams
+	proto := raw2proto.NewRaw2ProtoFunc()(new(bytes.Buffer))
+	if sp, ok := proto.(erpc.StatefulProto); !ok || !sp.Stateful() {
+		t.Fatal("raw2 must be a stateful protocol")
+	}
+}
+
+// failFilter the transfer filter failing on packing.
+type failFilter struct{}
+
+func (failFilter) ID() byte                          { return 'F' }
+func (failFilter) Name() string                      { return "fail" }
+func (failFilter) OnPack([]byte) ([]byte, error)     { return nil, errors.New("pack failed") }
+func (failFilter) OnUnpack(b []byte) ([]byte, error) { return b, nil }
+
+func TestPackFailure(t *testing.T) {
+	xfer.Reg(failFilter{})
+	var conn bytes.Buffer
+	cfg := raw2proto.Config{NoNegotiation: true}
+	w := raw2proto.NewRaw2ProtoFunc(cfg)(&conn)
+	r := raw2proto.NewRaw2ProtoFunc(cfg)(&conn)
+	meta := map[string]string{"X-Trace": "abc"}
+
+	// the failed message does not change the header tables, and does not break the encoder
+	m := newMessage(1, "/home/failed", meta, "hello")
+	m.XferPipe().Append('F')
+	if err := w.Pack(m); err == nil {
+		t.Fatal("want the transfer filter error")
+	}
+	socket.PutMessage(m)
+	for seq := int32(2); seq <= 3; seq++ {
+		m = newMessage(seq, "/home/failed", meta, "hello")
+		if err := w.Pack(m); err != nil {
+			t.Fatal(err)
+		}
+		got := socket.GetMessage()
+		got.SetNewBody(func(socket.Header) interface{} { return new(string) })
+		if err := r.Unpack(got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ServiceMethod() != m.ServiceMethod() || got.Meta().String() != m.Meta().String() {
+			t.Fatalf("want %s, got %s", m, got)
+		}
+		socket.PutMessage(m)
+		socket.PutMessage(got)
+	}
+}
+
+func TestClampSettings(t *testing.T) {
+	var conn bytes.Buffer
+	w := raw2proto.NewRaw2ProtoFunc(raw2proto.Config{NoNegotiation: true, MaxMethods: 4, MetaTableSize: 4096})(&conn)
+	r := raw2proto.NewRaw2ProtoFunc(raw2proto.Config{NoNegotiation: true, MaxMethods: 1, MetaTableSize: 64})(&conn)
+	unpack := func() error {
+		got := socket.GetMessage()
+		defer socket.PutMessage(got)
+		got.SetNewBody(func(socket.Header) interface{} { return new(string) })
+		return r.Unpack(got)
+	}
+	pack := func(seq int32, serviceMethod string, meta map[string]string) {
+		m := newMessage(seq, serviceMethod, meta, "hello")
+		defer socket.PutMessage(m)
+		if err := w.Pack(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the entry over the local table size is never referenced by the remote encoder silently
+	large := map[string]string{"X-Large": strings.Repeat("x", 40)}
+	pack(1, "/a", large)
+	pack(2, "/a", large)
+	if err := unpack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := unpack(); err == nil {
+		t.Fatal("want the error of the entry beyond the local table size")
+	}
+	// the method beyond the local maximum is rejected
+	conn.Reset()
+	w = raw2proto.NewRaw2ProtoFunc(raw2proto.Config{NoNegotiation: true, MaxMethods: 4})(&conn)
+	r = raw2proto.NewRaw2ProtoFunc(raw2proto.Config{NoNegotiation: true, MaxMethods: 1})(&conn)
+	pack(1, "/a", nil)
+	pack(2, "/b", nil)
+	pack(3, "/a", nil)
+	pack(4, "/b", nil)
+	for i := 0; i < 3; i++ {
+		if err := unpack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := unpack(); err == nil {
+		t.Fatal("want the error of the method beyond the local maximum")
+	}
+}
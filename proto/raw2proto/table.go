@@ -0,0 +1,288 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raw2proto
+
+// methodTable interns the service methods of one direction, the entries are never evicted.
+type methodTable struct {
+	max     int
+	ids     map[string]uint64 // only for the encoder
+	methods []string          // only for the decoder
+	pending []string          // the methods interned by the encoder since begin
+}
+
+func newMethodTable(max int) *methodTable {
+	return &methodTable{max: max, ids: make(map[string]uint64)}
+}
+
+// encode returns the 1-based id of the interned method,
+// or 0 for the literal, which is interned if the table is not full.
+func (t *methodTable) encode(method string) uint64 {
+	if id, ok := t.ids[method]; ok {
+		return id
+	}
+	if len(t.ids) < t.max {
+		t.ids[method] = uint64(len(t.ids)) + 1
+		t.pending = append(t.pending, method)
+	}
+	return 0
+}
+
+// begin starts recording the changes of the encoder, which are kept by commit or undone by rollback.
+func (t *methodTable) begin() {
+	t.pending = t.pending[:0]
+}
+
+func (t *methodTable) commit() {
+	t.pending = t.pending[:0]
+}
+
+func (t *methodTable) rollback() {
+	for _, method := range t.pending {
+		delete(t.ids, method)
+	}
+	t.pending = t.pending[:0]
+}
+
+// add interns the literal method read, if the table is not full.
+func (t *methodTable) add(method string) {
+	if len(t.methods) < t.max {
+		t.methods = append(t.methods, method)
+	}
+}
+
+// get returns the method by the 1-based id.
+func (t *methodTable) get(id uint64) (string, bool) {
+	if id == 0 || id > uint64(len(t.methods)) {
+		return "", false
+	}
+	return t.methods[id-1], true
+}
+
+// entryOverhead is the estimated overhead of a table entry, as HPACK.
+const entryOverhead = 32
+
+type entry struct {
+	key, value string
+}
+
+func (e entry) size() int {
+	return len(e.key) + len(e.value) + entryOverhead
+}
+
+// metaTable the HPACK-style dynamic table of the metadata of one direction,
+// the oldest entries are evicted when the total size exceeds the max size.
+// NOTE:
+//
+//	The index is relative to the newest entry, 0 is the newest;
+//	The decoder table may be smaller than the encoder one, since the remote settings are clamped,
+//	then it keeps the newest entries of the encoder table, and the index beyond them is invalid.
+type metaTable struct {
+	maxSize  int
+	size     int
+	entries  []entry // the oldest first
+	dropped  uint64  // the number of the evicted entries
+	pairIDs  map[entry]uint64
+	keyIDs   map[string]uint64
+	isEncode bool
+
+	// the state before begin, and the undo log of the ID maps, only for the encoder
+	inTx     bool
+	snapshot metaSnapshot
+	undo     []idChange
+}
+
+type metaSnapshot struct {
+	size    int
+	entries []entry
+	dropped uint64
+}
+
+// idChange the previous ID of a pair or a key.
+type idChange struct {
+	isKey   bool
+	e       entry
+	id      uint64
+	existed bool
+}
+
+func newMetaTable(maxSize int, isEncode bool) *metaTable {
+	t := &metaTable{maxSize: maxSize, isEncode: isEncode}
+	if isEncode {
+		t.pairIDs = make(map[entry]uint64)
+		t.keyIDs = make(map[string]uint64)
+	}
+	return t
+}
+
+// inserted returns the number of the inserted entries.
+func (t *metaTable) inserted() uint64 {
+	return t.dropped + uint64(len(t.entries))
+}
+
+func (t *metaTable) index(absID uint64) uint64 {
+	return t.inserted() - 1 - absID
+}
+
+// findPair returns the index of the entry with the key and value.
+func (t *metaTable) findPair(key, value string) (uint64, bool) {
+	id, ok := t.pairIDs[entry{key, value}]
+	if !ok {
+		return 0, false
+	}
+	return t.index(id), true
+}
+
+// findKey returns the index of the newest entry with the key.
+func (t *metaTable) findKey(key string) (uint64, bool) {
+	id, ok := t.keyIDs[key]
+	if !ok {
+		return 0, false
+	}
+	return t.index(id), true
+}
+
+// get returns the entry by the index.
+func (t *metaTable) get(index uint64) (entry, bool) {
+	if index >= uint64(len(t.entries)) {
+		return entry{}, false
+	}
+	return t.entries[uint64(len(t.entries))-1-index], true
+}
+
+// fits returns whether the entry can be added.
+func (t *metaTable) fits(e entry) bool {
+	return e.size() <= t.maxSize
+}
+
+// add inserts the entry, and evicts the oldest entries to fit the max size.
+// NOTE: The decoder table is emptied by the entry larger than the max size, as HPACK.
+func (t *metaTable) add(e entry) {
+	if !t.fits(e) {
+		if !t.isEncode {
+			for len(t.entries) > 0 {
+				t.evict()
+			}
+		}
+		return
+	}
+	for t.size+e.size() > t.maxSize {
+		t.evict()
+	}
+	if t.isEncode {
+		id := t.inserted()
+		t.setID(false, e, id)
+		t.setID(true, e, id)
+	}
+	t.entries = append(t.entries, e)
+	t.size += e.size()
+}
+
+// evict removes the oldest entry.
+func (t *metaTable) evict() {
+	old := t.entries[0]
+	if !t.inTx {
+		// NOTE: In the transaction, it is kept for the rollback, and cleared by the commit.
+		t.entries[0] = entry{}
+	}
+	t.entries = t.entries[1:]
+	t.size -= old.size()
+	if t.isEncode {
+		if t.pairIDs[old] == t.dropped {
+			t.deleteID(false, old)
+		}
+		if t.keyIDs[old.key] == t.dropped {
+			t.deleteID(true, old)
+		}
+	}
+	t.dropped++
+}
+
+func (t *metaTable) setID(isKey bool, e entry, id uint64) {
+	t.record(isKey, e)
+	if isKey {
+		t.keyIDs[e.key] = id
+	} else {
+		t.pairIDs[e] = id
+	}
+}
+
+func (t *metaTable) deleteID(isKey bool, e entry) {
+	t.record(isKey, e)
+	if isKey {
+		delete(t.keyIDs, e.key)
+	} else {
+		delete(t.pairIDs, e)
+	}
+}
+
+// record appends the previous ID to the undo log in the transaction.
+func (t *metaTable) record(isKey bool, e entry) {
+	if !t.inTx {
+		return
+	}
+	c := idChange{isKey: isKey, e: e}
+	if isKey {
+		c.id, c.existed = t.keyIDs[e.key]
+	} else {
+		c.id, c.existed = t.pairIDs[e]
+	}
+	t.undo = append(t.undo, c)
+}
+
+// begin starts the transaction of the encoder, the changes are kept by commit or undone by rollback.
+func (t *metaTable) begin() {
+	t.inTx = true
+	t.snapshot = metaSnapshot{size: t.size, entries: t.entries, dropped: t.dropped}
+	t.undo = t.undo[:0]
+}
+
+func (t *metaTable) commit() {
+	// clear the evicted entries
+	evicted := t.dropped - t.snapshot.dropped
+	if n := uint64(len(t.snapshot.entries)); evicted > n {
+		evicted = n
+	}
+	for i := range t.snapshot.entries[:evicted] {
+		t.snapshot.entries[i] = entry{}
+	}
+	t.endTx()
+}
+
+func (t *metaTable) rollback() {
+	t.size, t.entries, t.dropped = t.snapshot.size, t.snapshot.entries, t.snapshot.dropped
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		c := t.undo[i]
+		switch {
+		case c.isKey && c.existed:
+			t.keyIDs[c.e.key] = c.id
+		case c.isKey:
+			delete(t.keyIDs, c.e.key)
+		case c.existed:
+			t.pairIDs[c.e] = c.id
+		default:
+			delete(t.pairIDs, c.e)
+		}
+	}
+	t.endTx()
+}
+
+func (t *metaTable) endTx() {
+	t.inTx = false
+	t.snapshot = metaSnapshot{}
+	for i := range t.undo {
+		t.undo[i] = idChange{}
+	}
+	t.undo = t.undo[:0]
+}
@@ -0,0 +1,330 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxyConfig outbound proxy options
+// NOTE:
+//
+//	Only for tcp, tcp4 and tcp6 networks, the TLS handshake is tunneled through the proxy.
+type ProxyConfig struct {
+	URL    string   `yaml:"url"     ini:"url"     comment:"Proxy URL; socks5://[user:password@]host:port or http://[user:password@]host:port; empty means dialing directly; for client role"`
+	Bypass []string `yaml:"bypass"  ini:"bypass"  comment:"Destinations dialed directly; host name (matches its subdomains too), .domain suffix, IP, CIDR or *"`
+
+	proxyURL *url.URL
+	bypassIP []*net.IPNet
+}
+
+const (
+	proxySOCKS5 = "socks5"
+	proxyHTTP   = "http"
+)
+
+func (p *ProxyConfig) check() error {
+	p.proxyURL, p.bypassIP = nil, nil
+	if p.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy url: %s", err.Error())
+	}
+	switch u.Scheme {
+	case proxySOCKS5, "socks5h", proxyHTTP:
+	default:
+		return fmt.Errorf("invalid proxy url: %q, refer to the following schemes: socks5 or http", p.URL)
+	}
+	if u.Port() == "" {
+		return fmt.Errorf("invalid proxy url: %q, missing port", p.URL)
+	}
+	for _, rule := range p.Bypass {
+		if _, ipNet, err := net.ParseCIDR(rule); err == nil {
+			p.bypassIP = append(p.bypassIP, ipNet)
+		}
+	}
+	p.proxyURL = u
+	return nil
+}
+
+// enabled returns whether the proxy is configured.
+func (p *ProxyConfig) enabled() bool {
+	return p != nil && p.proxyURL != nil
+}
+
+// bypassed returns whether the destination address is dialed directly.
+func (p *ProxyConfig) bypassed(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, rule := range p.Bypass {
+		rule = strings.ToLower(rule)
+		switch {
+		case rule == "*":
+			return true
+		case strings.HasPrefix(rule, "."):
+			if strings.HasSuffix(host, rule) {
+				return true
+			}
+		case ip != nil:
+			if ruleIP := net.ParseIP(rule); ruleIP != nil && ruleIP.Equal(ip) {
+				return true
+			}
+		default:
+			if host == rule || strings.HasSuffix(host, "."+rule) {
+				return true
+			}
+		}
+	}
+	if ip != nil {
+		for _, ipNet := range p.bypassIP {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dial connects the destination address through the proxy.
+func (p *ProxyConfig) dial(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, network, p.proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else if dialer.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(dialer.Timeout))
+	}
+	// abort the handshake when the context is canceled
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	rawConn := conn
+	if p.proxyURL.Scheme == proxyHTTP {
+		conn, err = p.httpConnect(rawConn, addr)
+	} else {
+		err = p.socks5Connect(rawConn, addr)
+	}
+	close(stop)
+	<-stopped
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		rawConn.Close()
+		return nil, fmt.Errorf("proxy %s: %s", p.proxyURL.Host, err.Error())
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// httpConnect establishes the tunnel with the HTTP CONNECT method.
+func (p *ProxyConfig) httpConnect(conn net.Conn, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := p.proxyURL.User; u != nil {
+		password, _ := u.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads the bytes buffered during the proxy handshake first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+const (
+	socks5Version      = 0x05
+	socks5NoAuth       = 0x00
+	socks5PasswordAuth = 0x02
+	socks5NoAcceptable = 0xff
+	socks5Connect      = 0x01
+	socks5IPv4         = 0x01
+	socks5Domain       = 0x03
+	socks5IPv6         = 0x04
+)
+
+var socks5Errors = []string{
+	"",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// socks5Connect establishes the tunnel with the SOCKS5 CONNECT command, refer to RFC 1928 and RFC 1929.
+// NOTE:
+//
+//	The host name is resolved by the proxy.
+func (p *ProxyConfig) socks5Connect(conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return errors.New("invalid port: " + portStr)
+	}
+
+	// negotiate the authentication method
+	user := p.proxyURL.User
+	b := []byte{socks5Version, 1, socks5NoAuth}
+	if user != nil {
+		b = []byte{socks5Version, 2, socks5NoAuth, socks5PasswordAuth}
+	}
+	if _, err = conn.Write(b); err != nil {
+		return err
+	}
+	if _, err = io.ReadFull(conn, b[:2]); err != nil {
+		return err
+	}
+	if b[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version: %d", b[0])
+	}
+	switch b[1] {
+	case socks5NoAuth:
+	case socks5PasswordAuth:
+		if user == nil {
+			return errors.New("SOCKS authentication is required")
+		}
+		password, _ := user.Password()
+		if len(user.Username()) > 255 || len(password) > 255 {
+			return errors.New("SOCKS username or password is too long")
+		}
+		b = append(b[:0], 0x01, byte(len(user.Username())))
+		b = append(b, user.Username()...)
+		b = append(b, byte(len(password)))
+		b = append(b, password...)
+		if _, err = conn.Write(b); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, b[:2]); err != nil {
+			return err
+		}
+		if b[1] != 0x00 {
+			return errors.New("SOCKS authentication failed")
+		}
+	case socks5NoAcceptable:
+		return errors.New("no acceptable SOCKS authentication method")
+	default:
+		return fmt.Errorf("unexpected SOCKS authentication method: %d", b[1])
+	}
+
+	// send the CONNECT request
+	b = append(b[:0], socks5Version, socks5Connect, 0x00)
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, socks5IPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, socks5IPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("host name is too long: " + host)
+		}
+		b = append(b, socks5Domain, byte(len(host)))
+		b = append(b, host...)
+	}
+	b = append(b, byte(port>>8), byte(port))
+	if _, err = conn.Write(b); err != nil {
+		return err
+	}
+
+	// read the reply, and skip the bound address
+	if _, err = io.ReadFull(conn, b[:4]); err != nil {
+		return err
+	}
+	if b[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version: %d", b[0])
+	}
+	if rep := int(b[1]); rep != 0x00 {
+		if rep < len(socks5Errors) {
+			return errors.New(socks5Errors[rep])
+		}
+		return fmt.Errorf("unknown SOCKS error: %d", rep)
+	}
+	var n int
+	switch b[3] {
+	case socks5IPv4:
+		n = net.IPv4len
+	case socks5IPv6:
+		n = net.IPv6len
+	case socks5Domain:
+		if _, err = io.ReadFull(conn, b[:1]); err != nil {
+			return err
+		}
+		n = int(b[0])
+	default:
+		return fmt.Errorf("unknown SOCKS address type: %d", b[3])
+	}
+	_, err = io.CopyN(io.Discard, conn, int64(n+2))
+	return err
+}
@@ -0,0 +1,280 @@
+package erpc
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProxyBypass(t *testing.T) {
+	p := &ProxyConfig{
+		URL:    "socks5://127.0.0.1:1080",
+		Bypass: []string{"example.com", ".internal", "10.0.0.0/8", "::1"},
+	}
+	if err := p.check(); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"example.com:80":     true,
+		"api.example.com:80": true,
+		"myexample.com:80":   false,
+		"db.internal:5432":   true,
+		"internal:5432":      false,
+		"10.1.2.3:9090":      true,
+		"11.1.2.3:9090":      false,
+		"[::1]:9090":         true,
+	}
+	for addr, want := range cases {
+		if got := p.bypassed(addr); got != want {
+			t.Errorf("%s: want bypassed=%v, got %v", addr, want, got)
+		}
+	}
+	for _, u := range []string{"ftp://127.0.0.1:21", "socks5://127.0.0.1"} {
+		if err := (&ProxyConfig{URL: u}).check(); err == nil {
+			t.Errorf("%s: want invalid proxy url error", u)
+		}
+	}
+}
+
+// testProxy is an in-process proxy stand-in.
+type testProxy struct {
+	lis     net.Listener
+	tunnels int32
+	mu      sync.Mutex
+	conns   []net.Conn
+}
+
+func newTestProxy(t *testing.T, serve func(p *testProxy, conn net.Conn)) *testProxy {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProxy{lis: lis}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			p.mu.Lock()
+			p.conns = append(p.conns, conn)
+			p.mu.Unlock()
+			go serve(p, conn)
+		}
+	}()
+	return p
+}
+
+// tunnel connects addr and pipes the data.
+func (p *testProxy) tunnel(conn net.Conn, addr string, r io.Reader, ready func(ok bool)) {
+	remote, err := net.Dial("tcp", addr)
+	if err != nil {
+		ready(false)
+		conn.Close()
+		return
+	}
+	atomic.AddInt32(&p.tunnels, 1)
+	ready(true)
+	go func() {
+		io.Copy(remote, r)
+		remote.Close()
+	}()
+	io.Copy(conn, remote)
+	conn.Close()
+}
+
+// breakAll closes all the client connections of the proxy.
+func (p *testProxy) breakAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+}
+
+func (p *testProxy) close() {
+	p.lis.Close()
+	p.breakAll()
+}
+
+func serveSOCKS5(user, password string) func(p *testProxy, conn net.Conn) {
+	return func(p *testProxy, conn net.Conn) {
+		b := make([]byte, 512)
+		if _, err := io.ReadFull(conn, b[:2]); err != nil {
+			conn.Close()
+			return
+		}
+		methods := b[2 : 2+int(b[1])]
+		io.ReadFull(conn, methods)
+		method := byte(socks5NoAcceptable)
+		for _, m := range methods {
+			if m == socks5PasswordAuth {
+				method = m
+			}
+		}
+		conn.Write([]byte{socks5Version, method})
+		if method != socks5PasswordAuth {
+			conn.Close()
+			return
+		}
+		io.ReadFull(conn, b[:2])
+		u := make([]byte, b[1])
+		io.ReadFull(conn, u)
+		io.ReadFull(conn, b[:1])
+		pw := make([]byte, b[0])
+		io.ReadFull(conn, pw)
+		if string(u) != user || string(pw) != password {
+			conn.Write([]byte{0x01, 0x01})
+			conn.Close()
+			return
+		}
+		conn.Write([]byte{0x01, 0x00})
+		io.ReadFull(conn, b[:4])
+		var host string
+		switch b[3] {
+		case socks5IPv4:
+			io.ReadFull(conn, b[:4])
+			host = net.IP(b[:4]).String()
+		case socks5Domain:
+			io.ReadFull(conn, b[:1])
+			name := make([]byte, b[0])
+			io.ReadFull(conn, name)
+			host = string(name)
+		}
+		io.ReadFull(conn, b[:2])
+		addr := net.JoinHostPort(host, strconv.Itoa(int(b[0])<<8|int(b[1])))
+		p.tunnel(conn, addr, conn, func(ok bool) {
+			rep := byte(0x00)
+			if !ok {
+				rep = 0x05
+			}
+			conn.Write([]byte{socks5Version, rep, 0x00, socks5IPv4, 0, 0, 0, 0, 0, 0})
+		})
+	}
+}
+
+func serveHTTPConnect(auth string) func(p *testProxy, conn net.Conn) {
+	return func(p *testProxy, conn net.Conn) {
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil || req.Method != http.MethodConnect {
+			conn.Close()
+			return
+		}
+		if req.Header.Get("Proxy-Authorization") != auth {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			conn.Close()
+			return
+		}
+		p.tunnel(conn, req.Host, br, func(ok bool) {
+			if ok {
+				io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+			} else {
+				io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			}
+		})
+	}
+}
+
+func proxyEcho(ctx CallCtx, arg *string) (string, *Status) {
+	return *arg, nil
+}
+
+// newProxyTestServer serves a peer on a loopback listener, and returns its address.
+func newProxyTestServer(t *testing.T) (string, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewPeer(PeerConfig{})
+	srv.RouteCallFunc(proxyEcho)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			srv.ServeConn(conn)
+		}
+	}()
+	return lis.Addr().String(), func() {
+		lis.Close()
+		srv.Close()
+	}
+}
+
+func testDialThroughProxy(t *testing.T, proxy *testProxy, proxyURL, badProxyURL string) {
+	addr, closeSrv := newProxyTestServer(t)
+	defer closeSrv()
+
+	cli := NewPeer(PeerConfig{
+		RedialTimes:    3,
+		RedialInterval: time.Millisecond * 10,
+		Proxy:          ProxyConfig{URL: proxyURL},
+	})
+	defer cli.Close()
+	sess, stat := cli.Dial(addr)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	var result string
+	if stat = sess.Call("/proxy_echo", "hello", &result).Status(); !stat.OK() || result != "hello" {
+		t.Fatalf("want hello, got: %q, %v", result, stat)
+	}
+	if n := atomic.LoadInt32(&proxy.tunnels); n != 1 {
+		t.Fatalf("want 1 tunnel, got: %d", n)
+	}
+
+	// redial through the proxy
+	proxy.breakAll()
+	time.Sleep(time.Millisecond * 100)
+	if stat = sess.Call("/proxy_echo", "again", &result).Status(); !stat.OK() || result != "again" {
+		t.Fatalf("want again, got: %q, %v", result, stat)
+	}
+	if n := atomic.LoadInt32(&proxy.tunnels); n != 2 {
+		t.Fatalf("want 2 tunnels, got: %d", n)
+	}
+
+	// authentication failure
+	badCli := NewPeer(PeerConfig{Proxy: ProxyConfig{URL: badProxyURL}})
+	defer badCli.Close()
+	if _, stat = badCli.Dial(addr); stat.OK() {
+		t.Fatal("want dial failure with the wrong credentials")
+	}
+
+	// bypass the proxy
+	directCli := NewPeer(PeerConfig{Proxy: ProxyConfig{URL: proxyURL, Bypass: []string{"127.0.0.0/8"}}})
+	defer directCli.Close()
+	if _, stat = directCli.Dial(addr); !stat.OK() {
+		t.Fatal(stat)
+	}
+	if n := atomic.LoadInt32(&proxy.tunnels); n != 2 {
+		t.Fatalf("want the bypassed dial not tunneled, got %d tunnels", n)
+	}
+}
+
+func TestProxySOCKS5(t *testing.T) {
+	proxy := newTestProxy(t, serveSOCKS5("user", "secret"))
+	defer proxy.close()
+	testDialThroughProxy(t, proxy,
+		"socks5://user:secret@"+proxy.lis.Addr().String(),
+		"socks5://user:wrong@"+proxy.lis.Addr().String(),
+	)
+}
+
+func TestProxyHTTPConnect(t *testing.T) {
+	// base64("user:secret")
+	proxy := newTestProxy(t, serveHTTPConnect("Basic dXNlcjpzZWNyZXQ="))
+	defer proxy.close()
+	testDialThroughProxy(t, proxy,
+		"http://user:secret@"+proxy.lis.Addr().String(),
+		"http://user:wrong@"+proxy.lis.Addr().String(),
+	)
+}
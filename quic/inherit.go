@@ -17,11 +17,19 @@ import (
 // It returns an inherited net.Listener for the matching network and address, or
 // creates a new one using net.Listen.
 func InheritedListen(network, laddr string, tlsConf *tls.Config, config *quic.Config) (net.Listener, error) {
+	return InheritedListenMux(network, laddr, tlsConf, config, nil)
+}
+
+// InheritedListenMux announces on the local network address laddr. The network net is "quic".
+// It returns an inherited net.Listener for the matching network and address, or
+// creates a new one using net.Listen.
+// If opts is not nil, the listener accepts channels of multi-stream QUIC connections.
+func InheritedListenMux(network, laddr string, tlsConf *tls.Config, config *quic.Config, opts *MuxOptions) (net.Listener, error) {
 	udpAddr, err := net.ResolveUDPAddr(network, laddr)
 	if err != nil {
 		return nil, err
 	}
-	return globalInheritQUIC.InheritedListen(network, udpAddr, tlsConf, config)
+	return globalInheritQUIC.InheritedListen(network, udpAddr, tlsConf, config, opts)
 }
 
 // SetInherited adds the files and envs to be inherited by the new process.
@@ -102,7 +110,7 @@ func (n *inheritQUIC) inherit() error {
 // InheritedListen announces on the local network address laddr.
 // It returns an inherited net.Listener for the matching address,
 // or creates a new one.
-func (n *inheritQUIC) InheritedListen(network string, udpAddr *net.UDPAddr, tlsConf *tls.Config, config *quic.Config) (*Listener, error) {
+func (n *inheritQUIC) InheritedListen(network string, udpAddr *net.UDPAddr, tlsConf *tls.Config, config *quic.Config, opts *MuxOptions) (*Listener, error) {
 	if err := n.inherit(); err != nil {
 		return nil, err
 	}
@@ -127,10 +135,12 @@ func (n *inheritQUIC) InheritedListen(network string, udpAddr *net.UDPAddr, tlsC
 	var l *Listener
 	var err error
 	if udpConn == nil {
-		l, err = ListenUDPAddr(network, udpAddr, tlsConf, config)
-	} else {
-		l, err = Listen(udpConn, tlsConf, config)
+		udpConn, err = net.ListenUDP(network, udpAddr)
+		if err != nil {
+			return nil, err
+		}
 	}
+	l, err = ListenMux(udpConn, tlsConf, config, opts)
 	if err != nil {
 		return nil, err
 	}
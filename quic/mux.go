@@ -0,0 +1,1023 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quic
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+/*
+# multi-stream mode
+
+One QUIC connection carries any number of virtual connections (channels),
+and every Write of a channel travels on its own unidirectional QUIC stream.
+Since erpc protocols write a whole message with a single Write, a big
+message no longer blocks the messages behind it.
+The data frames carry the sequence number of the channel, and the receiver
+reorders them, so that the messages of one channel keep the written order.
+
+# stream format:
+
+{1 byte frame type} # open:1; data:2; close:3
+{uvarint channel id}
+{payload}           # data: uvarint sequence number and message bytes; close: uvarint count of data frames
+
+The client opens a bidirectional stream on every new QUIC connection and
+writes its 16 bytes mux id, so that the server can resume the channels of a
+lost QUIC connection on a new one (connection migration).
+*/
+
+const (
+	frameOpen  byte = 1
+	frameData  byte = 2
+	frameClose byte = 3
+
+	muxIDLen                = 16
+	defaultMigrationTimeout = time.Second * 30
+	closeFrameTimeout       = time.Second * 5
+	idleLinger              = time.Second
+	inboxSize               = 64
+	reorderLimit            = 1024
+)
+
+// MaxFrameSize is the maximum payload size of one stream.
+var MaxFrameSize int64 = 1 << 30
+
+// MuxOptions multi-stream mode options.
+type MuxOptions struct {
+	// Migration keeps the channels alive when the QUIC connection is lost,
+	// and resumes them on a new QUIC connection.
+	Migration bool
+	// MigrationTimeout is the maximum duration to resume the channels,
+	// default 30s.
+	MigrationTimeout time.Duration
+	// MaxMessageSize is the maximum size of a received message,
+	// default and at most MaxFrameSize.
+	MaxMessageSize int64
+}
+
+func (o *MuxOptions) maxMessageSize() int64 {
+	if o.MaxMessageSize <= 0 || o.MaxMessageSize > MaxFrameSize {
+		return MaxFrameSize
+	}
+	return o.MaxMessageSize
+}
+
+func (o *MuxOptions) migrationTimeout() time.Duration {
+	if o.MigrationTimeout <= 0 {
+		return defaultMigrationTimeout
+	}
+	return o.MigrationTimeout
+}
+
+var (
+	// ErrMuxClosed the multi-stream QUIC connection is closed error.
+	ErrMuxClosed       = errors.New("quic: multi-stream connection is closed")
+	errConnLost        = errors.New("quic: connection is lost")
+	errReorderLimit    = errors.New("quic: too many out-of-order messages")
+	errMessageTooLarge = errors.New("quic: message too large")
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// DialMuxContext establishes a new channel to a server in multi-stream mode.
+// Channels with the same addresses and configs share one QUIC connection.
+func DialMuxContext(ctx context.Context, network string, laddr *net.UDPAddr, raddr string, tlsConf *tls.Config, config *quic.Config, opts *MuxOptions) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(raddr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		raddr = "127.0.0.1:" + port
+	}
+	udpAddr, err := net.ResolveUDPAddr(network, raddr)
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = new(MuxOptions)
+	}
+	key := fmt.Sprintf("%s|%v|%s|%p|%p|%p", network, laddr, udpAddr, tlsConf, config, opts)
+	entry := clientMuxes.entry(key)
+	entry.mu.Lock()
+	var (
+		m = entry.mux
+		c *StreamConn
+	)
+	for c == nil {
+		if m == nil || m.isClosed() {
+			newm := newMux(true, *opts)
+			newm.dial = func(ctx context.Context) (quic.Connection, error) {
+				return dialMuxConn(ctx, network, laddr, udpAddr, tlsConf, config, newm.id)
+			}
+			newm.release = func() { clientMuxes.remove(key, newm) }
+			conn, err := newm.dial(ctx)
+			if err != nil {
+				entry.mu.Unlock()
+				return nil, err
+			}
+			newm.attach(conn)
+			entry.mux, m = newm, newm
+		}
+		// the idle connection may be closed just now, then dial a new one
+		c, _ = m.openChannel()
+	}
+	entry.mu.Unlock()
+	if err = m.writeFrame(ctx, frameOpen, c.id, nil); err != nil {
+		c.fail(err)
+		return nil, err
+	}
+	return c, nil
+}
+
+func dialMuxConn(ctx context.Context, network string, laddr *net.UDPAddr, raddr *net.UDPAddr, tlsConf *tls.Config, config *quic.Config, id [muxIDLen]byte) (quic.Connection, error) {
+	udpConn, err := net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	var conn quic.Connection
+	if config != nil && config.Allow0RTT {
+		conn, err = quic.DialEarly(ctx, udpConn, raddr, tlsConf, config)
+	} else {
+		conn, err = quic.Dial(ctx, udpConn, raddr, tlsConf, config)
+	}
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	go func() {
+		<-conn.Context().Done()
+		udpConn.Close()
+	}()
+	stream, err := conn.OpenStreamSync(ctx)
+	if err == nil {
+		_, err = stream.Write(id[:])
+		if err == nil {
+			err = stream.Close()
+		}
+	}
+	if err != nil {
+		conn.CloseWithError(1, err.Error())
+		return nil, err
+	}
+	return conn, nil
+}
+
+type muxEntry struct {
+	mu  sync.Mutex
+	mux *mux
+}
+
+type muxPool struct {
+	mu      sync.Mutex
+	entries map[string]*muxEntry
+}
+
+var clientMuxes = &muxPool{entries: make(map[string]*muxEntry)}
+
+func (p *muxPool) entry(key string) *muxEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.entries[key]
+	if !ok {
+		e = new(muxEntry)
+		p.entries[key] = e
+	}
+	return e
+}
+
+func (p *muxPool) remove(key string, m *mux) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.entries[key]; ok {
+		e.mu.Lock()
+		if e.mux == m {
+			delete(p.entries, key)
+		}
+		e.mu.Unlock()
+	}
+}
+
+// mux multiplexes channels over a QUIC connection, on both client and server side.
+type mux struct {
+	id       [muxIDLen]byte
+	isClient bool
+	opts     MuxOptions
+	mu       sync.Mutex
+	conn     quic.Connection
+	readyCh  chan struct{} // closed when conn is attached
+	closeCh  chan struct{}
+	closed   bool
+	channels map[uint64]*StreamConn
+	dropped  map[uint64]struct{} // recently closed channel ids, server only
+	nextID   uint64
+	dial     func(context.Context) (quic.Connection, error) // client only
+	accept   func(*StreamConn) bool                         // server only
+	release  func()
+}
+
+func newMux(isClient bool, opts MuxOptions) *mux {
+	m := &mux{
+		isClient: isClient,
+		opts:     opts,
+		readyCh:  make(chan struct{}),
+		closeCh:  make(chan struct{}),
+		channels: make(map[uint64]*StreamConn),
+	}
+	if isClient {
+		rand.Read(m.id[:])
+	} else {
+		m.dropped = make(map[uint64]struct{})
+	}
+	return m
+}
+
+func (m *mux) isClosed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
+
+// attach makes conn the current QUIC connection of the mux.
+func (m *mux) attach(conn quic.Connection) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		conn.CloseWithError(0, "")
+		return
+	}
+	old := m.conn
+	m.conn = conn
+	select {
+	case <-m.readyCh:
+	default:
+		close(m.readyCh)
+	}
+	m.mu.Unlock()
+	if old != nil {
+		old.CloseWithError(0, "migrated")
+	}
+	go m.acceptStreams(conn)
+	go func() {
+		<-conn.Context().Done()
+		m.lost(conn)
+	}()
+}
+
+// lost is called when the QUIC connection is gone.
+func (m *mux) lost(conn quic.Connection) {
+	m.mu.Lock()
+	if m.conn != conn || m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.conn = nil
+	m.readyCh = make(chan struct{})
+	migration := m.opts.Migration && len(m.channels) > 0
+	m.mu.Unlock()
+	if !migration {
+		m.fail(errConnLost)
+		return
+	}
+	if m.isClient {
+		go m.migrate()
+		return
+	}
+	time.AfterFunc(m.opts.migrationTimeout(), func() {
+		m.mu.Lock()
+		expired := m.conn == nil
+		m.mu.Unlock()
+		if expired {
+			m.fail(errConnLost)
+		}
+	})
+}
+
+// migrate dials a new QUIC connection and resumes the channels on it.
+func (m *mux) migrate() {
+	ctx, cancel := context.WithTimeout(context.Background(), m.opts.migrationTimeout())
+	defer cancel()
+	interval := time.Millisecond * 100
+	for {
+		conn, err := m.dial(ctx)
+		if err == nil {
+			m.attach(conn)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			m.fail(err)
+			return
+		case <-m.closeCh:
+			return
+		case <-time.After(interval):
+		}
+		if interval < time.Second {
+			interval *= 2
+		}
+	}
+}
+
+// fail closes the mux and all of its channels.
+func (m *mux) fail(err error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	close(m.closeCh)
+	conn := m.conn
+	channels := m.channels
+	m.channels = make(map[uint64]*StreamConn)
+	m.mu.Unlock()
+	for _, c := range channels {
+		c.fail(err)
+	}
+	if conn != nil {
+		conn.CloseWithError(0, "")
+	}
+	if m.release != nil {
+		m.release()
+	}
+}
+
+func (m *mux) openChannel() (*StreamConn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrMuxClosed
+	}
+	m.nextID++
+	c := newStreamConn(m, m.nextID, m.conn)
+	m.channels[c.id] = c
+	return c, nil
+}
+
+// channel returns the channel of the id, the server creates it if not exist.
+func (m *mux) channel(id uint64) (c *StreamConn, isNew bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.channels[id]; ok || m.isClient || m.closed {
+		return c, false
+	}
+	if _, ok := m.dropped[id]; ok {
+		return nil, false
+	}
+	c = newStreamConn(m, id, m.conn)
+	m.channels[id] = c
+	return c, true
+}
+
+func (m *mux) removeChannel(id uint64) {
+	m.mu.Lock()
+	delete(m.channels, id)
+	if m.dropped != nil {
+		m.dropped[id] = struct{}{}
+		// the late frames arrive within the migration timeout at most
+		time.AfterFunc(m.opts.migrationTimeout()+closeFrameTimeout, func() {
+			m.mu.Lock()
+			delete(m.dropped, id)
+			m.mu.Unlock()
+		})
+	}
+	idle := m.isClient && len(m.channels) == 0
+	m.mu.Unlock()
+	if idle {
+		time.AfterFunc(idleLinger, m.closeIfIdle)
+	}
+}
+
+// closeIfIdle closes the client QUIC connection that has no channels.
+func (m *mux) closeIfIdle() {
+	m.mu.Lock()
+	idle := len(m.channels) == 0
+	m.mu.Unlock()
+	if idle {
+		m.fail(ErrMuxClosed)
+	}
+}
+
+// getConn returns the current QUIC connection, waits for the migration if necessary.
+func (m *mux) getConn(ctx context.Context) (quic.Connection, error) {
+	for {
+		m.mu.Lock()
+		conn, readyCh, closed := m.conn, m.readyCh, m.closed
+		m.mu.Unlock()
+		if closed {
+			return nil, ErrMuxClosed
+		}
+		if conn != nil {
+			return conn, nil
+		}
+		select {
+		case <-readyCh:
+		case <-m.closeCh:
+			return nil, ErrMuxClosed
+		case <-ctx.Done():
+			return nil, timeoutError{}
+		}
+	}
+}
+
+// writeFrame sends a frame on a new unidirectional stream.
+func (m *mux) writeFrame(ctx context.Context, typ byte, id uint64, payload ...[]byte) error {
+	var header [1 + binary.MaxVarintLen64]byte
+	header[0] = typ
+	n := 1 + binary.PutUvarint(header[1:], id)
+	for {
+		conn, err := m.getConn(ctx)
+		if err != nil {
+			return err
+		}
+		err = writeStream(ctx, conn, header[:n], payload)
+		if err == nil {
+			return nil
+		}
+		// An unfinished stream is discarded by the receiver,
+		// so it is safe to resend the frame on the new connection.
+		if !m.opts.Migration || conn.Context().Err() == nil {
+			return err
+		}
+	}
+}
+
+func writeStream(ctx context.Context, conn quic.Connection, header []byte, payload [][]byte) error {
+	stream, err := conn.OpenUniStreamSync(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return timeoutError{}
+		}
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetWriteDeadline(deadline)
+	}
+	_, err = stream.Write(header)
+	for i := 0; err == nil && i < len(payload); i++ {
+		if len(payload[i]) > 0 {
+			_, err = stream.Write(payload[i])
+		}
+	}
+	if err != nil {
+		stream.CancelWrite(1)
+		return err
+	}
+	return stream.Close()
+}
+
+func (m *mux) acceptStreams(conn quic.Connection) {
+	for {
+		stream, err := conn.AcceptUniStream(context.Background())
+		if err != nil {
+			return
+		}
+		go m.handleStream(stream)
+	}
+}
+
+func (m *mux) handleStream(stream quic.ReceiveStream) {
+	maxSize := m.opts.maxMessageSize()
+	r := bufio.NewReader(io.LimitReader(stream, maxSize+1+binary.MaxVarintLen64*2))
+	typ, err := r.ReadByte()
+	if err != nil {
+		return
+	}
+	id, err := binary.ReadUvarint(r)
+	if err != nil {
+		return
+	}
+	switch typ {
+	case frameOpen:
+		m.openedByRemote(id)
+	case frameData:
+		seq, err := binary.ReadUvarint(r)
+		if err != nil {
+			stream.CancelRead(1)
+			return
+		}
+		payload, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+		if err != nil {
+			stream.CancelRead(1)
+			return
+		}
+		if int64(len(payload)) > maxSize {
+			stream.CancelRead(1)
+			m.mu.Lock()
+			c := m.channels[id]
+			m.mu.Unlock()
+			if c != nil {
+				c.fail(errMessageTooLarge)
+			}
+			return
+		}
+		if c := m.openedByRemote(id); c != nil {
+			c.deliver(seq, payload)
+		}
+	case frameClose:
+		count, err := binary.ReadUvarint(r)
+		if err != nil {
+			return
+		}
+		// the close frame may arrive before the data frames of the channel
+		if c := m.openedByRemote(id); c != nil {
+			c.remoteClose(count)
+		}
+	default:
+		stream.CancelRead(1)
+	}
+}
+
+// openedByRemote returns the channel, and the server accepts it if it is new.
+func (m *mux) openedByRemote(id uint64) *StreamConn {
+	c, isNew := m.channel(id)
+	if isNew && !m.accept(c) {
+		c.fail(ErrMuxClosed)
+		return nil
+	}
+	return c
+}
+
+// muxServer accepts the channels of all the multi-stream QUIC connections.
+type muxServer struct {
+	opts     MuxOptions
+	mu       sync.Mutex
+	muxes    map[[muxIDLen]byte]*mux
+	acceptCh chan *StreamConn
+	closeCh  chan struct{}
+	err      error
+}
+
+func newMuxServer(opts MuxOptions) *muxServer {
+	return &muxServer{
+		opts:     opts,
+		muxes:    make(map[[muxIDLen]byte]*mux),
+		acceptCh: make(chan *StreamConn, 128),
+		closeCh:  make(chan struct{}),
+	}
+}
+
+func (s *muxServer) serve(lis quicListener) {
+	for {
+		conn, err := lis.Accept(context.Background())
+		if err != nil {
+			s.close(err)
+			return
+		}
+		go s.handshake(conn)
+	}
+}
+
+// handshake reads the mux id of the new QUIC connection.
+func (s *muxServer) handshake(conn quic.Connection) {
+	ctx, cancel := context.WithTimeout(conn.Context(), closeFrameTimeout)
+	defer cancel()
+	var id [muxIDLen]byte
+	stream, err := conn.AcceptStream(ctx)
+	if err == nil {
+		_, err = io.ReadFull(stream, id[:])
+	}
+	if err != nil {
+		conn.CloseWithError(1, "quic: bad multi-stream handshake")
+		return
+	}
+	s.mu.Lock()
+	m, ok := s.muxes[id]
+	if !ok || m.isClosed() {
+		select {
+		case <-s.closeCh:
+			s.mu.Unlock()
+			conn.CloseWithError(0, "")
+			return
+		default:
+		}
+		m = newMux(false, s.opts)
+		m.id = id
+		m.accept = s.deliver
+		m.release = func() { s.remove(m) }
+		s.muxes[id] = m
+	}
+	s.mu.Unlock()
+	m.attach(conn)
+}
+
+func (s *muxServer) deliver(c *StreamConn) bool {
+	select {
+	case s.acceptCh <- c:
+		return true
+	case <-s.closeCh:
+		return false
+	}
+}
+
+func (s *muxServer) remove(m *mux) {
+	s.mu.Lock()
+	if s.muxes[m.id] == m {
+		delete(s.muxes, m.id)
+	}
+	s.mu.Unlock()
+}
+
+func (s *muxServer) accept() (net.Conn, error) {
+	select {
+	case c := <-s.acceptCh:
+		return c, nil
+	case <-s.closeCh:
+		return nil, s.err
+	}
+}
+
+func (s *muxServer) close(err error) {
+	s.mu.Lock()
+	select {
+	case <-s.closeCh:
+		s.mu.Unlock()
+		return
+	default:
+	}
+	s.err = err
+	close(s.closeCh)
+	muxes := s.muxes
+	s.muxes = make(map[[muxIDLen]byte]*mux)
+	s.mu.Unlock()
+	for _, m := range muxes {
+		m.fail(ErrMuxClosed)
+	}
+}
+
+// StreamConn is a channel of a multi-stream QUIC connection.
+//
+// Multiple goroutines may invoke methods on a StreamConn simultaneously.
+type StreamConn struct {
+	mux           *mux
+	id            uint64
+	localAddr     net.Addr
+	remoteAddr    net.Addr
+	inbox         chan []byte
+	buf           []byte
+	orderMu       sync.Mutex
+	nextRecv      uint64            // sequence number of the next message to deliver
+	pending       map[uint64][]byte // out-of-order messages
+	nextSend      uint64
+	rMu           sync.Mutex
+	wMu           sync.RWMutex
+	sent          uint64
+	received      uint64
+	expected      int64 // count of data frames announced by the remote close, -1 means open
+	stateMu       sync.Mutex
+	eofCh         chan struct{}
+	closeCh       chan struct{}
+	closeOnce     sync.Once
+	failCh        chan struct{}
+	failOnce      sync.Once
+	err           error
+	readDeadline  *deadline
+	writeDeadline *deadline
+}
+
+var _ net.Conn = (*StreamConn)(nil)
+
+func newStreamConn(m *mux, id uint64, conn quic.Connection) *StreamConn {
+	c := &StreamConn{
+		mux:           m,
+		id:            id,
+		inbox:         make(chan []byte, inboxSize),
+		pending:       make(map[uint64][]byte),
+		expected:      -1,
+		eofCh:         make(chan struct{}),
+		closeCh:       make(chan struct{}),
+		failCh:        make(chan struct{}),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+	}
+	if conn != nil {
+		c.localAddr = &Addr{Addr: conn.LocalAddr(), Channel: id}
+		c.remoteAddr = &Addr{Addr: conn.RemoteAddr(), Channel: id}
+	} else {
+		c.localAddr = &Addr{Channel: id}
+		c.remoteAddr = &Addr{Channel: id}
+	}
+	return c
+}
+
+// ChannelID returns the channel id in the QUIC connection.
+func (c *StreamConn) ChannelID() uint64 {
+	return c.id
+}
+
+// deliver puts the message into the inbox in the order of the sequence numbers,
+// and drops the duplicated ones resent after migration.
+func (c *StreamConn) deliver(seq uint64, payload []byte) {
+	c.orderMu.Lock()
+	defer c.orderMu.Unlock()
+	if _, ok := c.pending[seq]; ok || seq < c.nextRecv {
+		return
+	}
+	if len(c.pending) >= reorderLimit {
+		c.fail(errReorderLimit)
+		return
+	}
+	c.pending[seq] = payload
+	for {
+		payload, ok := c.pending[c.nextRecv]
+		if !ok {
+			return
+		}
+		delete(c.pending, c.nextRecv)
+		c.nextRecv++
+		select {
+		case c.inbox <- payload:
+		case <-c.closeCh:
+			return
+		case <-c.failCh:
+			return
+		}
+		c.stateMu.Lock()
+		c.received++
+		c.checkEOFLocked()
+		c.stateMu.Unlock()
+	}
+}
+
+func (c *StreamConn) remoteClose(count uint64) {
+	c.stateMu.Lock()
+	if c.expected < 0 {
+		c.expected = int64(count)
+		c.checkEOFLocked()
+	}
+	c.stateMu.Unlock()
+}
+
+func (c *StreamConn) checkEOFLocked() {
+	if c.expected >= 0 && c.received >= uint64(c.expected) {
+		select {
+		case <-c.eofCh:
+		default:
+			close(c.eofCh)
+		}
+	}
+}
+
+func (c *StreamConn) fail(err error) {
+	c.failOnce.Do(func() {
+		c.err = err
+		close(c.failCh)
+		c.mux.removeChannel(c.id)
+	})
+}
+
+// Read reads data from the connection.
+// Read can be made to time out and return an Error with Timeout() == true
+// after a fixed time limit; see SetDeadline and SetReadDeadline.
+func (c *StreamConn) Read(b []byte) (int, error) {
+	c.rMu.Lock()
+	defer c.rMu.Unlock()
+	for len(c.buf) == 0 {
+		select {
+		case c.buf = <-c.inbox:
+			continue
+		default:
+		}
+		select {
+		case c.buf = <-c.inbox:
+		case <-c.eofCh:
+			select {
+			case c.buf = <-c.inbox:
+			default:
+				return 0, io.EOF
+			}
+		case <-c.closeCh:
+			return 0, net.ErrClosed
+		case <-c.failCh:
+			return 0, c.err
+		case <-c.readDeadline.wait():
+			return 0, timeoutError{}
+		}
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Write writes data to the connection on a new QUIC stream.
+// Write can be made to time out and return an Error with Timeout() == true
+// after a fixed time limit; see SetDeadline and SetWriteDeadline.
+// NOTE: The channel fails if Write fails, since the following messages can not be delivered in order.
+func (c *StreamConn) Write(b []byte) (int, error) {
+	c.wMu.RLock()
+	defer c.wMu.RUnlock()
+	select {
+	case <-c.closeCh:
+		return 0, net.ErrClosed
+	case <-c.failCh:
+		return 0, c.err
+	case <-c.eofCh:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	ctx, cancel := c.writeDeadline.context()
+	defer cancel()
+	var seq [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(seq[:], atomic.AddUint64(&c.nextSend, 1)-1)
+	if err := c.mux.writeFrame(ctx, frameData, c.id, seq[:n], b); err != nil {
+		c.fail(err)
+		return 0, err
+	}
+	atomic.AddUint64(&c.sent, 1)
+	return len(b), nil
+}
+
+// Close closes the channel, and closes the QUIC connection if it is the last
+// channel of the client.
+// Any blocked Read or Write operations will be unblocked and return errors.
+func (c *StreamConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.wMu.Lock()
+		close(c.closeCh)
+		count := atomic.LoadUint64(&c.sent)
+		c.wMu.Unlock()
+		select {
+		case <-c.failCh:
+			return
+		default:
+		}
+		go func() {
+			var payload [binary.MaxVarintLen64]byte
+			n := binary.PutUvarint(payload[:], count)
+			ctx, cancel := context.WithTimeout(context.Background(), closeFrameTimeout)
+			c.mux.writeFrame(ctx, frameClose, c.id, payload[:n])
+			cancel()
+			c.fail(net.ErrClosed)
+		}()
+	})
+	return nil
+}
+
+// LocalAddr returns the local network address.
+func (c *StreamConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+// RemoteAddr returns the remote network address.
+func (c *StreamConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// SetDeadline sets the read and write deadlines associated
+// with the connection. It is equivalent to calling both
+// SetReadDeadline and SetWriteDeadline.
+func (c *StreamConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for future Read calls
+// and any currently-blocked Read call.
+// A zero value for t means Read will not time out.
+func (c *StreamConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for future Write calls.
+// A zero value for t means Write will not time out.
+func (c *StreamConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// Addr is the address of a channel in the multi-stream QUIC connection.
+type Addr struct {
+	net.Addr
+	Channel uint64
+}
+
+// Network returns the address's network name.
+func (a *Addr) Network() string {
+	if a.Addr == nil {
+		return "udp"
+	}
+	return a.Addr.Network()
+}
+
+// String returns the address in the form "host:port#channel".
+func (a *Addr) String() string {
+	var s string
+	if a.Addr != nil {
+		s = a.Addr.String()
+	}
+	return s + "#" + strconv.FormatUint(a.Channel, 10)
+}
+
+// deadline is an abstraction for handling timeouts.
+type deadline struct {
+	mu     sync.Mutex
+	t      time.Time
+	timer  *time.Timer
+	cancel chan struct{} // must be non-nil
+}
+
+func makeDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+// set sets the point in time when the deadline will time out.
+// A timeout event is signaled by closing the channel returned by waiter.
+// Once a timeout has occurred, the deadline can be refreshed by specifying a
+// t value in the future.
+//
+// A zero value for t prevents timeout.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // Wait for the timer callback to finish and close cancel
+	}
+	d.timer = nil
+	d.t = t
+
+	// Time is zero, then there is no deadline.
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	// Time in the future, setup a timer to cancel in the future.
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+
+	// Time in the past, so close immediately.
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel that is closed when the deadline is exceeded.
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+// context returns a context that is done when the deadline is exceeded.
+func (d *deadline) context() (context.Context, context.CancelFunc) {
+	d.mu.Lock()
+	t := d.t
+	d.mu.Unlock()
+	if t.IsZero() {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), t)
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,264 @@
+package quic_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/quic"
+)
+
+func TestMux(t *testing.T) {
+	lis, err := quic.ListenMuxAddr("udp", "127.0.0.1:0", erpc.GenerateTLSConfigForServer(), nil, &quic.MuxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				b := make([]byte, 1<<20)
+				for {
+					n, err := conn.Read(b)
+					if err != nil {
+						return
+					}
+					conn.Write(b[:n])
+				}
+			}(conn)
+		}
+	}()
+
+	var (
+		tlsConf = &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}}
+		config  = &quic.Config{MaxIncomingUniStreams: 1024}
+		opts    = &quic.MuxOptions{}
+		conns   [2]net.Conn
+	)
+	for i := range conns {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		conns[i], err = quic.DialMuxContext(ctx, "udp", nil, lis.Addr().String(), tlsConf, config, opts)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if conns[0].LocalAddr().String() == conns[1].LocalAddr().String() {
+		t.Fatalf("channels must have different addresses: %s", conns[0].LocalAddr())
+	}
+	for i, conn := range conns {
+		msg := bytes.Repeat([]byte{byte('a' + i)}, 1000*(i+1))
+		if _, err = conn.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		got := make([]byte, len(msg))
+		if _, err = io.ReadFull(conn, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatalf("channel %d: unexpected echo", i)
+		}
+	}
+
+	conns[0].Close()
+	conns[1].SetReadDeadline(time.Now().Add(time.Millisecond * 10))
+	if _, err = conns[1].Read(make([]byte, 1)); err == nil {
+		t.Fatal("expect timeout error")
+	} else if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("expect timeout error, got: %v", err)
+	}
+	conns[1].Close()
+}
+
+func TestDatagram(t *testing.T) {
+	config := &quic.Config{EnableDatagrams: true}
+	lis, err := quic.ListenAddr("udp", "127.0.0.1:0", erpc.GenerateTLSConfigForServer(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	accepted := make(chan *quic.Conn, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn.(*quic.Conn)
+	}()
+	tlsConf := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	conn, err := quic.DialAddrContext(ctx, "udp", nil, lis.Addr().String(), tlsConf, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cli := conn.(*quic.Conn)
+	if cli.MaxDatagramSize() != 0 {
+		t.Fatal("expect no datagram before the first write")
+	}
+	if _, err = cli.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	srv := <-accepted
+	if cli.MaxDatagramSize() != quic.MaxDatagramSize || srv.MaxDatagramSize() != quic.MaxDatagramSize {
+		t.Fatal("expect datagram support")
+	}
+	msg := bytes.Repeat([]byte("d"), quic.MaxDatagramSize)
+	if err = cli.SendDatagram(msg); err != nil {
+		t.Fatal(err)
+	}
+	got, err := srv.ReceiveDatagram(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatal("unexpected datagram")
+	}
+	if err = cli.SendDatagram(append(msg, 'd')); err == nil {
+		t.Fatal("expect datagram too large error")
+	}
+}
+
+func TestMuxOrder(t *testing.T) {
+	lis, err := quic.ListenMuxAddr("udp", "127.0.0.1:0", erpc.GenerateTLSConfigForServer(), nil, &quic.MuxOptions{MaxMessageSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	readErr := make(chan error, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			readErr <- err
+			return
+		}
+		defer conn.Close()
+		b := make([]byte, 1<<21)
+		for {
+			if _, err := conn.Read(b); err != nil {
+				readErr <- err
+				return
+			}
+			conn.Write(b[:4])
+		}
+	}()
+
+	tlsConf := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	conn, err := quic.DialMuxContext(ctx, "udp", nil, lis.Addr().String(), tlsConf, &quic.Config{MaxIncomingUniStreams: 1024}, &quic.MuxOptions{})
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	const count = 100
+	go func() {
+		for i := 0; i < count; i++ {
+			// the big messages take longer than the small ones behind them
+			size := 10
+			if i%2 == 0 {
+				size = 256 << 10
+			}
+			msg := make([]byte, size)
+			binary.BigEndian.PutUint32(msg, uint32(i))
+			if _, err := conn.Write(msg); err != nil {
+				return
+			}
+		}
+	}()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 10))
+	got := make([]byte, 4)
+	for i := 0; i < count; i++ {
+		if _, err = io.ReadFull(conn, got); err != nil {
+			t.Fatal(err)
+		}
+		if n := binary.BigEndian.Uint32(got); n != uint32(i) {
+			t.Fatalf("message %d: got %d", i, n)
+		}
+	}
+
+	// the message larger than the limit fails the channel
+	if _, err = conn.Write(make([]byte, 1<<20+1)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-readErr:
+		if err == nil || err.Error() != "quic: message too large" {
+			t.Fatalf("expect error for the message too large, got: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("expect error for the message too large")
+	}
+}
+
+func TestMuxCloseFirst(t *testing.T) {
+	lis, err := quic.ListenMuxAddr("udp", "127.0.0.1:0", erpc.GenerateTLSConfigForServer(), nil, &quic.MuxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	tlsConf := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}}
+	conn, err := quicgo.DialAddr(ctx, lis.Addr().String(), tlsConf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseWithError(0, "")
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Write(bytes.Repeat([]byte{7}, 16))
+	stream.Close()
+	writeFrame := func(frame ...byte) {
+		t.Helper()
+		s, err := conn.OpenUniStreamSync(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Write(frame)
+		s.Close()
+	}
+
+	// the close frame of the channel 1 with one message arrives before its data frame
+	writeFrame(3, 1, 1)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := lis.Accept()
+		accepted <- c
+	}()
+	var c net.Conn
+	select {
+	case c = <-accepted:
+	case <-time.After(time.Second * 5):
+		t.Fatal("the channel is not accepted by the close frame")
+	}
+	defer c.Close()
+	writeFrame(2, 1, 0, 'h', 'i')
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	b := make([]byte, 8)
+	n, err := c.Read(b)
+	if err != nil || string(b[:n]) != "hi" {
+		t.Fatalf("read: %q, %v", b[:n], err)
+	}
+	if _, err = c.Read(b); err != io.EOF {
+		t.Fatalf("expect EOF after the closed channel, got: %v", err)
+	}
+}
@@ -3,7 +3,9 @@ package quic
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/quic-go/quic-go"
@@ -28,7 +30,12 @@ func DialAddrContext(ctx context.Context, network string, laddr *net.UDPAddr, ra
 	if err != nil {
 		return nil, err
 	}
-	sess, err := quic.Dial(ctx, udpConn, udpAddr, tlsConf, config)
+	var sess quic.Connection
+	if config != nil && config.Allow0RTT {
+		sess, err = quic.DialEarly(ctx, udpConn, udpAddr, tlsConf, config)
+	} else {
+		sess, err = quic.Dial(ctx, udpConn, udpAddr, tlsConf, config)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -42,12 +49,31 @@ func DialAddrContext(ctx context.Context, network string, laddr *net.UDPAddr, ra
 	}, nil
 }
 
+// Config contains all configuration data needed for a QUIC server or client.
+type Config = quic.Config
+
 // A Listener is a generic network listener for stream-oriented protocols.
 //
 // Multiple goroutines may invoke methods on a Listener simultaneously.
 type Listener struct {
-	lis  *quic.Listener
+	lis  quicListener
 	conn net.PacketConn
+	mux  *muxServer
+}
+
+// quicListener is implemented by *quic.Listener and *quic.EarlyListener.
+type quicListener interface {
+	Accept(context.Context) (quic.Connection, error)
+	Close() error
+	Addr() net.Addr
+}
+
+type earlyListener struct {
+	*quic.EarlyListener
+}
+
+func (l earlyListener) Accept(ctx context.Context) (quic.Connection, error) {
+	return l.EarlyListener.Accept(ctx)
 }
 
 var _ net.Listener = (*Listener)(nil)
@@ -56,11 +82,23 @@ var _ net.Listener = (*Listener)(nil)
 // The tls.Config must not be nil and must contain a certificate configuration.
 // The quic.Config may be nil, in that case the default values will be used.
 func ListenAddr(network, addr string, tlsConf *tls.Config, config *quic.Config) (*Listener, error) {
+	return ListenMuxAddr(network, addr, tlsConf, config, nil)
+}
+
+// ListenMuxAddr announces on the local network address addr.
+// If opts is not nil, the listener accepts channels of multi-stream QUIC connections.
+// The tls.Config must not be nil and must contain a certificate configuration.
+// The quic.Config may be nil, in that case the default values will be used.
+func ListenMuxAddr(network, addr string, tlsConf *tls.Config, config *quic.Config, opts *MuxOptions) (*Listener, error) {
 	udpAddr, err := net.ResolveUDPAddr(network, addr)
 	if err != nil {
 		return nil, err
 	}
-	return ListenUDPAddr(network, udpAddr, tlsConf, config)
+	conn, err := net.ListenUDP(network, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return ListenMux(conn, tlsConf, config, opts)
 }
 
 // ListenUDPAddr announces on the local network address udpAddr.
@@ -81,17 +119,40 @@ func ListenUDPAddr(network string, udpAddr *net.UDPAddr, tlsConf *tls.Config, co
 // The tls.Config must not be nil and must contain a certificate configuration.
 // The quic.Config may be nil, in that case the default values will be used.
 func Listen(conn net.PacketConn, tlsConf *tls.Config, config *quic.Config) (*Listener, error) {
+	return ListenMux(conn, tlsConf, config, nil)
+}
+
+// ListenMux listens for QUIC connections on a given net.PacketConn.
+// If opts is not nil, the listener accepts channels of multi-stream QUIC connections.
+// The tls.Config must not be nil and must contain a certificate configuration.
+// The quic.Config may be nil, in that case the default values will be used.
+func ListenMux(conn net.PacketConn, tlsConf *tls.Config, config *quic.Config, opts *MuxOptions) (*Listener, error) {
 	if config == nil {
 		config = &quic.Config{KeepAlivePeriod: time.Second * 15}
 	}
-	lis, err := quic.Listen(conn, tlsConf, config)
+	var (
+		lis quicListener
+		err error
+	)
+	if config.Allow0RTT {
+		var early *quic.EarlyListener
+		early, err = quic.ListenEarly(conn, tlsConf, config)
+		lis = earlyListener{early}
+	} else {
+		lis, err = quic.Listen(conn, tlsConf, config)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &Listener{
+	l := &Listener{
 		lis:  lis,
 		conn: conn,
-	}, nil
+	}
+	if opts != nil {
+		l.mux = newMuxServer(*opts)
+		go l.mux.serve(lis)
+	}
+	return l, nil
 }
 
 // PacketConn returns the net.PacketConn.
@@ -100,7 +161,11 @@ func (l *Listener) PacketConn() net.PacketConn {
 }
 
 // Accept waits for and returns the next connection to the listener.
+// In multi-stream mode, it returns the next channel.
 func (l *Listener) Accept() (net.Conn, error) {
+	if l.mux != nil {
+		return l.mux.accept()
+	}
 	sess, err := l.lis.Accept(context.TODO())
 	if err != nil {
 		return nil, err
@@ -110,8 +175,9 @@ func (l *Listener) Accept() (net.Conn, error) {
 		return nil, err
 	}
 	return &Conn{
-		sess:   sess,
-		stream: stream,
+		sess:      sess,
+		stream:    stream,
+		announced: 1,
 	}, nil
 }
 
@@ -137,6 +203,8 @@ func (l *Listener) Addr() net.Addr {
 type Conn struct {
 	sess   quic.Connection
 	stream quic.Stream
+	// announced is 1 after the stream is known by the peer
+	announced int32
 }
 
 // Read reads data from the connection.
@@ -150,7 +218,11 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 // Write can be made to time out and return an Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetWriteDeadline.
 func (c *Conn) Write(b []byte) (n int, err error) {
-	return c.stream.Write(b)
+	n, err = c.stream.Write(b)
+	if n > 0 && atomic.LoadInt32(&c.announced) == 0 {
+		atomic.StoreInt32(&c.announced, 1)
+	}
+	return n, err
 }
 
 // Close closes the connection.
@@ -208,3 +280,35 @@ func (c *Conn) SetReadDeadline(t time.Time) error {
 func (c *Conn) SetWriteDeadline(t time.Time) error {
 	return c.stream.SetWriteDeadline(t)
 }
+
+// MaxDatagramSize is the maximum payload size of a QUIC datagram,
+// it keeps the DATAGRAM frame within the minimum QUIC packet size.
+const MaxDatagramSize = 1100
+
+// SendDatagram sends b as an unreliable QUIC datagram.
+func (c *Conn) SendDatagram(b []byte) error {
+	if len(b) > MaxDatagramSize {
+		return errDatagramTooLarge
+	}
+	return c.sess.SendMessage(b)
+}
+
+// ReceiveDatagram receives the next QUIC datagram.
+func (c *Conn) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	return c.sess.ReceiveMessage(ctx)
+}
+
+// MaxDatagramSize returns the maximum payload size of a datagram,
+// it returns 0 if the datagram support is not negotiated.
+// NOTE:
+//
+//	The server accepts the connection after the first write of the stream,
+//	so it also returns 0 on the client side before that.
+func (c *Conn) MaxDatagramSize() int {
+	if atomic.LoadInt32(&c.announced) == 0 || !c.sess.ConnectionState().SupportsDatagrams {
+		return 0
+	}
+	return MaxDatagramSize
+}
+
+var errDatagramTooLarge = errors.New("quic: datagram too large")
@@ -0,0 +1,469 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andeya/erpc/v7/codec"
+	"github.com/andeya/erpc/v7/socket"
+)
+
+// ResumeConfig session resumption options
+type ResumeConfig struct {
+	Enable      bool          `yaml:"enable"        ini:"enable"        comment:"Resume the session with its in-flight CALLs and PUSHes after the client redials; both peers must enable it, the server serves the other clients as usual"`
+	GracePeriod time.Duration `yaml:"grace_period"  ini:"grace_period"  comment:"Maximum duration the server keeps the disconnected session, default 30s; for server role; ns,µs,ms,s,m,h"`
+	BufferSize  int           `yaml:"buffer_size"   ini:"buffer_size"   comment:"Maximum number of the REPLY and PUSH messages kept for redelivery, default 256; for server role"`
+}
+
+const (
+	defaultResumeGracePeriod = time.Second * 30
+	defaultResumeBufferSize  = 256
+	resumeHandshakeTimeout   = time.Second * 5
+	resumeServiceMethod      = "/erpc/resume"
+)
+
+func (r *ResumeConfig) check() {
+	if r.GracePeriod <= 0 {
+		r.GracePeriod = defaultResumeGracePeriod
+	}
+	if r.BufferSize <= 0 {
+		r.BufferSize = defaultResumeBufferSize
+	}
+}
+
+// resumeRequest is the body of the resume handshake CALL.
+type resumeRequest struct {
+	Token    string  `json:"token,omitempty"`
+	Pending  []int32 `json:"pending,omitempty"`   // CALLs written but not replied
+	LastPush int32   `json:"last_push,omitempty"` // the maximum seq of the received PUSHes
+}
+
+// resumeReply is the body of the resume handshake REPLY.
+type resumeReply struct {
+	Token   string  `json:"token"`
+	Resumed bool    `json:"resumed,omitempty"`
+	Resend  []int32 `json:"resend,omitempty"` // CALLs unknown to the server
+}
+
+// resumeState is the resumption state of a session.
+// NOTE:
+//
+//	The server keeps the recently written REPLY and PUSH messages,
+//	and holds the ones written while the session is suspended;
+//	It is best effort, a CALL may be handled twice if its REPLY has been dropped from the buffer.
+type resumeState struct {
+	cfg      *ResumeConfig
+	mu       sync.Mutex
+	token    string
+	server   bool
+	disabled bool // the remote peer does not support resumption
+
+	// only for server role
+	ring   []resumeMsg
+	calls  map[int32]struct{} // CALLs being handled
+	lost   bool               // a held message has been dropped from the buffer
+	expire *time.Timer
+
+	// only for client role
+	lastPush int32
+	resend   []int32
+}
+
+type resumeMsg struct {
+	mtype byte
+	seq   int32
+	b     []byte
+	sent  bool
+}
+
+func newResumeState(cfg *ResumeConfig) *resumeState {
+	if cfg == nil || !cfg.Enable {
+		return nil
+	}
+	return &resumeState{cfg: cfg, calls: make(map[int32]struct{})}
+}
+
+func newResumeToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// active returns whether the session can be resumed.
+func (r *resumeState) active() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.token != "" && !r.disabled && !r.lost
+}
+
+func (r *resumeState) isServer() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.server
+}
+
+// received records the message read from the connection.
+func (r *resumeState) received(m Message) {
+	r.mu.Lock()
+	switch m.Mtype() {
+	case TypeCall:
+		r.calls[m.Seq()] = struct{}{}
+	case TypePush:
+		if m.Seq() > r.lastPush {
+			r.lastPush = m.Seq()
+		}
+	}
+	r.mu.Unlock()
+}
+
+// record keeps the packed REPLY or PUSH message of the server for redelivery.
+func (r *resumeState) record(m Message, b []byte, hold bool) {
+	mtype := m.Mtype()
+	if mtype != TypeReply && mtype != TypePush {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.server {
+		return
+	}
+	if mtype == TypeReply {
+		delete(r.calls, m.Seq())
+	}
+	if len(r.ring) >= r.cfg.BufferSize {
+		if !r.ring[0].sent {
+			r.lost = true
+			if r.expire != nil {
+				r.expire.Reset(0)
+			}
+		}
+		copy(r.ring, r.ring[1:])
+		r.ring = r.ring[:len(r.ring)-1]
+	}
+	r.ring = append(r.ring, resumeMsg{
+		mtype: mtype,
+		seq:   m.Seq(),
+		b:     append([]byte(nil), b...),
+		sent:  !hold,
+	})
+}
+
+// replay returns the bytes to redeliver, and the pending CALLs unknown to the server.
+func (r *resumeState) replay(req *resumeRequest) ([]byte, []int32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := make(map[int32]bool, len(req.Pending))
+	for _, seq := range req.Pending {
+		pending[seq] = false
+	}
+	var b []byte
+	for i := range r.ring {
+		msg := &r.ring[i]
+		switch {
+		case !msg.sent:
+		case msg.mtype == TypeReply:
+			if _, ok := pending[msg.seq]; !ok {
+				continue
+			}
+		case msg.seq <= req.LastPush:
+			continue
+		}
+		if msg.mtype == TypeReply {
+			pending[msg.seq] = true
+		}
+		b = append(b, msg.b...)
+		msg.sent = true
+	}
+	var resend []int32
+	for _, seq := range req.Pending {
+		if _, ok := r.calls[seq]; !ok && !pending[seq] {
+			resend = append(resend, seq)
+		}
+	}
+	return b, resend
+}
+
+// resumeHandshake sends the resume handshake of the client,
+// it must be called during the PostDial phase.
+func (s *session) resumeHandshake() *Status {
+	r := s.resume
+	r.mu.Lock()
+	req := resumeRequest{Token: r.token, LastPush: r.lastPush}
+	disabled := r.disabled
+	r.mu.Unlock()
+	if disabled {
+		return nil
+	}
+	if req.Token != "" {
+		s.callCmdMap.Range(func(_, v interface{}) bool {
+			if cmd := v.(*callCmd); atomic.LoadInt32(&cmd.written) == 1 {
+				req.Pending = append(req.Pending, cmd.output.Seq())
+			}
+			return true
+		})
+	}
+	var rep resumeReply
+	stat := s.PreCall(resumeServiceMethod, &req, &rep, WithBodyCodec(codec.ID_JSON))
+	if !stat.OK() {
+		if stat.Code() == CodeNotFound {
+			Warnf("resume is not supported by the server (network:%s, addr:%s)", s.peer.network, s.RemoteAddr().String())
+			r.mu.Lock()
+			r.disabled = true
+			r.mu.Unlock()
+			s.cancelCallCmds("session not resumed")
+			return nil
+		}
+		return stat
+	}
+	r.mu.Lock()
+	r.token = rep.Token
+	if rep.Resumed {
+		r.resend = rep.Resend
+	} else {
+		r.lastPush, r.resend = 0, nil
+	}
+	r.mu.Unlock()
+	if !rep.Resumed && req.Token != "" {
+		s.cancelCallCmds("session not resumed")
+	}
+	return nil
+}
+
+// resendCalls writes the pending CALLs unknown to the server again after the session is resumed.
+func (s *session) resendCalls() {
+	r := s.resume
+	r.mu.Lock()
+	resend := r.resend
+	r.resend = nil
+	r.mu.Unlock()
+	for _, seq := range resend {
+		if v, ok := s.callCmdMap.Load(seq); ok {
+			s.rewriteCall(v.(*callCmd))
+		}
+	}
+}
+
+// rewriteCall writes the pending CALL again, and completes it if the writing fails.
+func (s *session) rewriteCall(cmd *callCmd) {
+	if _, stat := s.write(cmd.output); !stat.OK() {
+		cmd.mu.Lock()
+		if !cmd.hasReply() && cmd.stat.OK() {
+			cmd.stat = stat
+			cmd.done()
+		}
+		cmd.mu.Unlock()
+	}
+}
+
+// cancelCallCmds cancels the callCmds that are waiting for a reply.
+func (s *session) cancelCallCmds(reason string) {
+	s.callCmdMap.Range(func(_, v interface{}) bool {
+		callCmd := v.(*callCmd)
+		callCmd.mu.Lock()
+		if !callCmd.hasReply() && callCmd.stat.OK() {
+			callCmd.cancel(reason)
+		}
+		callCmd.mu.Unlock()
+		return true
+	})
+}
+
+// acceptResume handles the resume handshake of the accepted connection.
+// It returns true if the connection is taken over by a suspended session, or closed.
+// NOTE:
+//
+//	If the first message is not the handshake, e.g. from a client without resumption,
+//	or the client sends nothing in time, the bytes read are read again by the normal session.
+func (p *peer) acceptResume(sess *session) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), resumeHandshakeTimeout)
+	defer cancel()
+	conn := sess.getConn()
+	rec := &recordReader{r: conn}
+	us, canReplay := sess.socket.(socket.UnsafeSocket)
+	if canReplay {
+		us.ResetReader(rec)
+	}
+	var req resumeRequest
+	input := sess.PreReceive(func(h Header) interface{} {
+		if h.Mtype() == TypeCall && h.ServiceMethod() == resumeServiceMethod {
+			return &req
+		}
+		return nil
+	}, ctx)
+	defer socket.PutMessage(input)
+	rec.stop()
+	stat := input.Status()
+	if input.Mtype() != TypeCall || input.ServiceMethod() != resumeServiceMethod {
+		if canReplay && (stat.OK() || ctx.Err() != nil) {
+			Debugf("resume handshake skipped (network:%s, addr:%s)", p.network, sess.RemoteAddr().String())
+			sess.resume.mu.Lock()
+			sess.resume.disabled = true
+			sess.resume.mu.Unlock()
+			sess.socket.SetReadDeadline(time.Time{})
+			sess.socket.Reset(conn, sess.socketProtoFuncs(sess.protoFuncs)...)
+			us.ResetReader(io.MultiReader(bytes.NewReader(rec.buf), conn))
+			return false
+		}
+		stat = statBadMessage.Copy("not a resume handshake")
+	}
+	if !stat.OK() {
+		Errorf("resume handshake fail (network:%s, addr:%s): %v", p.network, sess.RemoteAddr().String(), stat)
+		sess.socket.Close()
+		return true
+	}
+	if req.Token != "" {
+		if v, ok := p.resumables.Load(req.Token); ok && v.(*session).resumeFrom(sess, input, &req) {
+			return true
+		}
+	}
+	r := sess.resume
+	r.mu.Lock()
+	r.token, r.server = newResumeToken(), true
+	r.mu.Unlock()
+	if stat := sess.PreReply(input, &resumeReply{Token: r.token}, nil, WithBodyCodec(codec.ID_JSON)); !stat.OK() {
+		Errorf("resume handshake fail (network:%s, addr:%s): %v", p.network, sess.RemoteAddr().String(), stat)
+		sess.socket.Close()
+		return true
+	}
+	p.resumables.Store(r.token, sess)
+	return false
+}
+
+// recordReader records the bytes read until it is stopped.
+type recordReader struct {
+	r       io.Reader
+	buf     []byte
+	stopped int32
+}
+
+// Read reads from the underlying reader.
+func (r *recordReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 && atomic.LoadInt32(&r.stopped) == 0 {
+		r.buf = append(r.buf, b[:n]...)
+	}
+	return n, err
+}
+
+func (r *recordReader) stop() {
+	atomic.StoreInt32(&r.stopped, 1)
+}
+
+// resumeFrom takes over the connection of the new session, and redelivers the messages.
+// It returns false if the session cannot be resumed.
+func (s *session) resumeFrom(newSess *session, input Message, req *resumeRequest) bool {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	if s.getStatus() != statusSuspended || !s.resume.active() {
+		return false
+	}
+	b, resend := s.resume.replay(req)
+	rep := &resumeReply{Token: req.Token, Resumed: true, Resend: resend}
+	conn := newSess.getConn()
+	stat := newSess.PreReply(input, rep, nil, WithBodyCodec(codec.ID_JSON))
+	if stat.OK() && len(b) > 0 {
+		if _, err := conn.Write(b); err != nil {
+			stat = statWriteFailed.Copy(err)
+		}
+	}
+	if !stat.OK() {
+		// keep suspended, the client will try again
+		Errorf("resume fail (network:%s, addr:%s, id:%s): %v", s.peer.network, conn.RemoteAddr().String(), s.ID(), stat)
+		newSess.socket.Close()
+		return true
+	}
+	s.resume.mu.Lock()
+	if s.resume.expire != nil {
+		s.resume.expire.Stop()
+	}
+	s.resume.mu.Unlock()
+	id := s.ID()
+	var pub = s.socket.Swap()
+	s.socket.Reset(conn, s.socketProtoFuncs(s.protoFuncs)...)
+	s.socket.Swap(pub)
+	s.socket.SetID(id)
+	s.changeStatus(statusOk)
+	Infof("resume ok (network:%s, addr:%s, id:%s)", s.peer.network, conn.RemoteAddr().String(), id)
+	AnywayGo(s.startReadAndHandle)
+	return true
+}
+
+// suspend keeps the server session for resumption after the connection is broken.
+// It returns false if the session cannot be resumed.
+func (s *session) suspend(oldConn net.Conn, reason string) bool {
+	if !s.resume.active() || !s.resume.isServer() {
+		return false
+	}
+	s.writeLock.Lock()
+	ok := s.tryChangeStatus(statusSuspended, statusOk)
+	s.writeLock.Unlock()
+	if !ok {
+		return false
+	}
+	oldConn.Close()
+	// the CALLs of the server are not resumed
+	s.cancelCallCmds(reason)
+	s.resume.mu.Lock()
+	s.resume.expire = time.AfterFunc(s.resume.cfg.GracePeriod, s.expireSuspended)
+	s.resume.mu.Unlock()
+	Debugf("session suspended (network:%s, addr:%s, id:%s)", s.peer.network, s.RemoteAddr().String(), s.ID())
+	return true
+}
+
+// expireSuspended closes the suspended session after the grace period.
+func (s *session) expireSuspended() {
+	s.writeLock.Lock()
+	ok := s.tryChangeStatus(statusPassiveClosing, statusSuspended)
+	s.writeLock.Unlock()
+	if !ok {
+		return
+	}
+	s.peer.sessHub.delete(s.ID())
+	s.graceCtxWait()
+	s.socket.Close()
+	s.changeStatus(statusPassiveClosed)
+	s.notifyClosed()
+	s.forgetResume()
+	s.leaveGroups()
+	s.peer.pluginContainer.postDisconnect(s)
+}
+
+// forgetResume unregisters the resumable session of the server.
+func (s *session) forgetResume() {
+	if s.resume == nil || !s.resume.isServer() {
+		return
+	}
+	s.resume.mu.Lock()
+	token := s.resume.token
+	if s.resume.expire != nil {
+		s.resume.expire.Stop()
+	}
+	s.resume.mu.Unlock()
+	if v, ok := s.peer.resumables.Load(token); ok && v == s {
+		s.peer.resumables.Delete(token)
+	}
+}
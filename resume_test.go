@@ -0,0 +1,188 @@
+package erpc_test
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+)
+
+type dropConnPlugin struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (p *dropConnPlugin) Name() string {
+	return "drop_conn"
+}
+
+func (p *dropConnPlugin) PostDial(sess erpc.PreSession, isRedial bool) *erpc.Status {
+	sess.ModifySocket(func(conn net.Conn) (net.Conn, erpc.ProtoFunc) {
+		p.mu.Lock()
+		p.conn = conn
+		p.mu.Unlock()
+		return nil, nil
+	})
+	return nil
+}
+
+// drop breaks the current connection of the client.
+func (p *dropConnPlugin) drop() {
+	p.mu.Lock()
+	p.conn.Close()
+	p.mu.Unlock()
+}
+
+var (
+	resumeNotices = make(chan string, 10)
+	resumeGroup   *erpc.SessionGroup
+)
+
+func resumeEcho(ctx erpc.CallCtx, arg *string) (string, *erpc.Status) {
+	ctx.Peer().Group("resume").Join(ctx.Session())
+	time.Sleep(time.Millisecond * 100)
+	// push during suspension
+	if stat := ctx.Session().Push("/resume_notice", *arg); !stat.OK() {
+		return "", stat
+	}
+	return *arg, nil
+}
+
+func resumeNotice(ctx erpc.PushCtx, arg *string) *erpc.Status {
+	resumeNotices <- *arg
+	return nil
+}
+
+// newResumePeers creates a server and a client,
+// the messages of the client are delayed 100ms, so that the REPLY is written during suspension.
+func newResumePeers(t *testing.T, grace time.Duration) (erpc.Session, *dropConnPlugin, func()) {
+	for len(resumeNotices) > 0 {
+		<-resumeNotices
+	}
+	srv := erpc.NewPeer(erpc.PeerConfig{
+		Network: mem.Network,
+		Resume:  erpc.ResumeConfig{Enable: true, GracePeriod: grace},
+	})
+	srv.RouteCallFunc(resumeEcho)
+	resumeGroup = srv.Group("resume")
+	addr := memtest.Serve(srv)
+
+	dropper := new(dropConnPlugin)
+	cli := erpc.NewPeer(erpc.PeerConfig{
+		Network:     mem.Network,
+		RedialTimes: 3,
+		Resume:      erpc.ResumeConfig{Enable: true},
+		Mem:         erpc.MemConfig{Latency: time.Millisecond * 100},
+	}, dropper)
+	cli.RoutePushFunc(resumeNotice)
+	sess, stat := cli.Dial(addr)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	return sess, dropper, func() {
+		cli.Close()
+		srv.Close()
+	}
+}
+
+func TestResume(t *testing.T) {
+	sess, dropper, closeFn := newResumePeers(t, time.Second*5)
+	defer closeFn()
+	var result string
+	callCmd := sess.AsyncCall("/resume_echo", "hello", &result, make(chan erpc.CallCmd, 1))
+	time.Sleep(time.Millisecond * 150)
+	dropper.drop()
+	select {
+	case <-callCmd.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+	if stat := callCmd.Status(); !stat.OK() || result != "hello" {
+		t.Fatalf("want hello, got: %q, %v", result, stat)
+	}
+	select {
+	case notice := <-resumeNotices:
+		if notice != "hello" {
+			t.Fatalf("want notice hello, got: %q", notice)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the PUSH during suspension is lost")
+	}
+	// the session works after resumption
+	if stat := sess.Call("/resume_echo", "again", &result).Status(); !stat.OK() || result != "again" {
+		t.Fatalf("want again, got: %q, %v", result, stat)
+	}
+}
+
+func TestResumeExpired(t *testing.T) {
+	sess, dropper, closeFn := newResumePeers(t, time.Millisecond*20)
+	defer closeFn()
+	var result string
+	callCmd := sess.AsyncCall("/resume_echo", "hello", &result, make(chan erpc.CallCmd, 1))
+	time.Sleep(time.Millisecond * 150)
+	dropper.drop()
+	select {
+	case <-callCmd.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+	if stat := callCmd.Status(); stat.Code() != erpc.CodeConnClosed {
+		t.Fatalf("want conn closed, got: %v", stat)
+	}
+	// the client is connected to a new session
+	if stat := sess.Call("/resume_echo", "again", &result).Status(); !stat.OK() || result != "again" {
+		t.Fatalf("want again, got: %q, %v", result, stat)
+	}
+	// the expired session has left its groups
+	if n := resumeGroup.Len(); n != 1 {
+		t.Fatalf("want 1 session in the group, got: %d", n)
+	}
+}
+
+func TestResumeOptional(t *testing.T) {
+	srv := erpc.NewPeer(erpc.PeerConfig{
+		Network: mem.Network,
+		Resume:  erpc.ResumeConfig{Enable: true},
+	})
+	srv.RouteCallFunc(resumeEcho)
+	resumeGroup = srv.Group("resume")
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+
+	// the client without resumption is served as a normal session
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network, DialTimeout: time.Second * 3})
+	defer cli.Close()
+	cli.RoutePushFunc(resumeNotice)
+	sess, stat := cli.Dial(addr)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	defer func() {
+		for len(resumeNotices) > 0 {
+			<-resumeNotices
+		}
+	}()
+	// the messages written together are all read after the first one
+	var (
+		results [5]string
+		cmds    [5]erpc.CallCmd
+	)
+	for i := range cmds {
+		cmds[i] = sess.AsyncCall("/resume_echo", strconv.Itoa(i), &results[i], make(chan erpc.CallCmd, 1))
+	}
+	for i, cmd := range cmds {
+		select {
+		case <-cmd.Done():
+		case <-time.After(time.Second * 5):
+			t.Fatal("timeout")
+		}
+		if stat := cmd.Status(); !stat.OK() || results[i] != strconv.Itoa(i) {
+			t.Fatalf("want %d, got: %q, %v", i, results[i], stat)
+		}
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"path"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"unsafe"
@@ -925,6 +926,30 @@ func objectName(v reflect.Value) string {
 	return t.String()
 }
 
+// Handlers returns all the registered handlers sorted by name, including the unknown ones.
+func (r *Router) Handlers() []*Handler {
+	sr := r.subRouter
+	handlers := make([]*Handler, 0, len(sr.callHandlers)+len(sr.pushHandlers)+2)
+	for _, h := range sr.callHandlers {
+		handlers = append(handlers, h)
+	}
+	for _, h := range sr.pushHandlers {
+		handlers = append(handlers, h)
+	}
+	sort.Slice(handlers, func(i, j int) bool {
+		if handlers[i].name == handlers[j].name {
+			return handlers[i].routerTypeName < handlers[j].routerTypeName
+		}
+		return handlers[i].name < handlers[j].name
+	})
+	for _, h := range []*Handler{*sr.unknownCall, *sr.unknownPush} {
+		if h != nil {
+			handlers = append(handlers, h)
+		}
+	}
+	return handlers
+}
+
 // Name returns the handler name.
 func (h *Handler) Name() string {
 	return h.name
@@ -960,6 +985,11 @@ func (h *Handler) IsUnknown() bool {
 	return h.isUnknown
 }
 
+// PluginContainer returns the plugin container of the handler.
+func (h *Handler) PluginContainer() *PluginContainer {
+	return h.pluginContainer
+}
+
 // RouterTypeName returns the router type name.
 func (h *Handler) RouterTypeName() string {
 	return h.routerTypeName
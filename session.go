@@ -37,6 +37,8 @@ type (
 	PreSession interface {
 		// Peer returns the peer.
 		Peer() Peer
+		// ID returns the session id.
+		ID() string
 		// LocalAddr returns the local network address.
 		LocalAddr() net.Addr
 		// RemoteAddr returns the remote network address.
@@ -156,6 +158,9 @@ type (
 		SessionAge() time.Duration
 		// ContextAge returns CALL or PUSH context max age.
 		ContextAge() time.Duration
+		// Negotiation returns the body codec and xfer filters negotiated with the remote peer,
+		// ok is false if the negotiation is disabled or not supported by the remote peer.
+		Negotiation() (Negotiation, bool)
 		// Logger logger interface
 		Logger
 	}
@@ -187,6 +192,13 @@ type session struct {
 	socket                         socket.Socket
 	closeNotifyCh                  chan struct{} // closeNotifyCh is the channel returned by CloseNotify.
 	writeLock                      sync.Mutex
+	batcher                        *writeBatcher // nil if the write coalescing and the resumption are disabled
+	resume                         *resumeState  // nil if the resumption is disabled
+	goAway                         goAwayState
+	negotiated                     atomic.Value
+	groups                         map[*SessionGroup]struct{}
+	groupsLock                     sync.Mutex
+	groupsClosed                   bool // the session can not join any group after disconnection
 	graceCtxWaitGroup              sync.WaitGroup
 	graceCtxMutex                  sync.Mutex
 	graceCallCmdWaitGroup          sync.WaitGroup
@@ -196,6 +208,7 @@ type session struct {
 	contextAgeLock                 sync.RWMutex
 	lock                           sync.RWMutex
 	redialForClientLocked          func() bool // only for client role
+	createdAt                      time.Time
 	seq                            int32
 	status                         int32
 	didCloseNotify                 int32
@@ -209,15 +222,31 @@ func newSession(peer *peer, conn net.Conn, protoFuncs []ProtoFunc) *session {
 		timeNow:        peer.timeNow,
 		protoFuncs:     protoFuncs,
 		status:         statusPreparing,
-		socket:         socket.NewSocket(conn, protoFuncs...),
+		createdAt:      time.Now(),
 		closeNotifyCh:  make(chan struct{}),
 		callCmdMap:     goutil.AtomicMap(),
 		sessionAge:     peer.defaultSessionAge,
 		contextAge:     peer.defaultContextAge,
+		batcher:        newWriteBatcher(peer.writeBatch, peer.resume != nil && peer.resume.Enable),
+		resume:         newResumeState(peer.resume),
 	}
+	s.socket = socket.NewSocket(conn, s.socketProtoFuncs(protoFuncs)...)
 	return s
 }
 
+// socketProtoFuncs returns the ProtoFuncs used to reset the socket,
+// the protocol writes to the batcher if the write coalescing is enabled.
+func (s *session) socketProtoFuncs(protoFuncs []ProtoFunc) []ProtoFunc {
+	if s.batcher == nil {
+		return protoFuncs
+	}
+	protoFunc := DefaultProtoFunc()
+	if len(protoFuncs) > 0 && protoFuncs[0] != nil {
+		protoFunc = protoFuncs[0]
+	}
+	return []ProtoFunc{s.batcher.wrapProtoFunc(protoFunc)}
+}
+
 // NOTE: Do not change the order
 const (
 	statusPreparing int32 = iota
@@ -228,6 +257,8 @@ const (
 	statusPassiveClosed
 	statusRedialing
 	statusRedialFailed
+	statusSuspended // the server session is waiting for resumption
+	statusDraining  // the client session is waiting for the accepted CALLs before the migration
 )
 
 func (s *session) changeStatus(stat int32) {
@@ -258,7 +289,7 @@ func (s *session) getStatus() int32 {
 }
 
 func (s *session) goonRead() bool {
-	return s.checkStatus(statusOk, statusActiveClosing)
+	return s.checkStatus(statusOk, statusActiveClosing, statusDraining)
 }
 
 func (s *session) notifyClosed() {
@@ -285,7 +316,7 @@ func (s *session) IsPassiveClosed() bool {
 // Health checks if the session is usable.
 func (s *session) Health() bool {
 	status := s.getStatus()
-	if status == statusOk {
+	if status == statusOk || status == statusDraining {
 		return true
 	}
 	if s.redialForClientLocked == nil {
@@ -363,7 +394,7 @@ func (s *session) ModifySocket(fn func(conn net.Conn) (modifiedConn net.Conn, ne
 		pub = s.socket.Swap()
 	}
 	id := s.ID()
-	s.socket.Reset(modifiedConn, s.protoFuncs...)
+	s.socket.Reset(modifiedConn, s.socketProtoFuncs(s.protoFuncs)...)
 	s.socket.Swap(pub) // set the old swap
 	s.socket.SetID(id)
 }
@@ -452,9 +483,7 @@ func (s *session) send(mtype byte, seq int32, serviceMethod string, body interfa
 		seq = atomic.AddInt32(&s.seq, 1)
 	}
 	output.SetSeq(seq)
-	if output.BodyCodec() == codec.NilCodecID {
-		output.SetBodyCodec(s.peer.defaultBodyCodec)
-	}
+	s.setNegotiated(output)
 	if len(serviceMethod) > 0 {
 		output.SetServiceMethod(serviceMethod)
 	}
@@ -609,6 +638,12 @@ func (s *session) RawPush(serviceMethod string, args interface{}, setting ...Mes
 // If the args is []byte or *[]byte type, it can automatically fill in the body codec name;
 // If the session is a client role and PeerConfig.RedialTimes>0, it is automatically re-called once after a failure.
 func (s *session) Push(serviceMethod string, args interface{}, setting ...MessageSetting) *Status {
+	return s.push(serviceMethod, args, nil, setting)
+}
+
+// push sends a message of TypePush type.
+// NOTE: If shared is not nil, the body is replaced with the shared encoding after PreWritePush plugins.
+func (s *session) push(serviceMethod string, args interface{}, shared *sharedBody, setting []MessageSetting) *Status {
 	ctx := s.peer.getContext(s, true)
 	defer func() {
 		s.peer.putContext(ctx, true)
@@ -630,9 +665,7 @@ func (s *session) Push(serviceMethod string, args interface{}, setting ...Messag
 	}
 	output.SetSeq(atomic.AddInt32(&s.seq, 1))
 
-	if output.BodyCodec() == codec.NilCodecID {
-		output.SetBodyCodec(s.peer.defaultBodyCodec)
-	}
+	s.setNegotiated(output)
 	if age := s.ContextAge(); age > 0 {
 		ctxTimout, _ := context.WithTimeout(output.Context(), age)
 		socket.WithContext(ctxTimout)(output)
@@ -642,14 +675,30 @@ func (s *session) Push(serviceMethod string, args interface{}, setting ...Messag
 	if !stat.OK() {
 		return stat
 	}
+	if shared != nil {
+		bodyBytes, err := shared.encode(output.BodyCodec())
+		if err != nil {
+			return statBadMessage.Copy(err)
+		}
+		output.SetBody(bodyBytes)
+		defer output.SetBody(args)
+	}
 
-	var usedConn net.Conn
-W:
-	if usedConn, stat = s.write(output); !stat.OK() {
-		if stat == statConnClosed && s.redialForClient(usedConn) {
-			goto W
+	var sent bool
+	if isDatagram(output) {
+		if stat, sent = s.writeDatagram(output); sent && !stat.OK() {
+			return stat
+		}
+	}
+	if !sent {
+		var usedConn net.Conn
+	W:
+		if usedConn, stat = s.write(output); !stat.OK() {
+			if stat == statConnClosed && s.redialForClient(usedConn) {
+				goto W
+			}
+			return stat
 		}
-		return stat
 	}
 	if enablePrintRunLog() {
 		s.printRunLog("", time.Duration(s.timeNow()-ctx.start), nil, output, typePushLaunch)
@@ -693,9 +742,7 @@ func (s *session) AsyncCall(
 	seq := atomic.AddInt32(&s.seq, 1)
 	output.SetSeq(seq)
 
-	if output.BodyCodec() == codec.NilCodecID {
-		output.SetBodyCodec(s.peer.defaultBodyCodec)
-	}
+	s.setNegotiated(output)
 	if age := s.ContextAge(); age > 0 {
 		ctxTimout, _ := context.WithTimeout(output.Context(), age)
 		socket.WithContext(ctxTimout)(output)
@@ -746,6 +793,7 @@ W:
 		cmd.done()
 		return cmd
 	}
+	atomic.StoreInt32(&cmd.written, 1)
 
 	s.peer.pluginContainer.postWriteCall(cmd)
 	return cmd
@@ -774,7 +822,7 @@ func (s *session) Close() error {
 }
 
 func (s *session) closeLocked() error {
-	if !s.tryChangeStatus(statusActiveClosing, statusOk, statusPreparing) {
+	if !s.tryChangeStatus(statusActiveClosing, statusOk, statusPreparing, statusSuspended, statusDraining) {
 		return nil
 	} // readDisconnected is being called
 	s.peer.sessHub.delete(s.ID())
@@ -783,6 +831,8 @@ func (s *session) closeLocked() error {
 	s.graceCallCmdWaitGroup.Wait()
 	s.changeStatus(statusActiveClosed)
 	err := s.socket.Close()
+	s.forgetResume()
+	s.leaveGroups()
 	s.peer.pluginContainer.postDisconnect(s)
 	return err
 }
@@ -790,15 +840,15 @@ func (s *session) closeLocked() error {
 func (s *session) readDisconnected(oldConn net.Conn, err error) {
 	status := s.getStatus()
 	switch status {
-	case statusPassiveClosed, statusActiveClosed, statusPassiveClosing:
+	case statusPassiveClosed, statusActiveClosed, statusPassiveClosing, statusSuspended:
 		return
-	case statusActiveClosing:
-	default:
-		s.changeStatus(statusPassiveClosing)
+	case statusDraining:
+		// the migration takes over
+		if s.readClosedWhenDraining() {
+			return
+		}
 	}
 
-	s.peer.sessHub.delete(s.ID())
-
 	var reason string
 	if err != nil && err != socket.ErrProactivelyCloseSocket {
 		if errStr := err.Error(); errStr != "EOF" {
@@ -806,18 +856,22 @@ func (s *session) readDisconnected(oldConn net.Conn, err error) {
 			Debugf("disconnect(%s) when reading: %T %s", s.RemoteAddr().String(), err, errStr)
 		}
 	}
+	if status != statusActiveClosing && s.suspend(oldConn, reason) {
+		return
+	}
+	if status != statusActiveClosing {
+		s.changeStatus(statusPassiveClosing)
+	}
+
+	s.peer.sessHub.delete(s.ID())
 	s.graceCtxWait()
 
-	// cancel the callCmd that is waiting for a reply
-	s.callCmdMap.Range(func(_, v interface{}) bool {
-		callCmd := v.(*callCmd)
-		callCmd.mu.Lock()
-		if !callCmd.hasReply() && callCmd.stat.OK() {
-			callCmd.cancel(reason)
-		}
-		callCmd.mu.Unlock()
-		return true
-	})
+	// the callCmds are kept for resumption after redialing
+	resumable := status != statusActiveClosing && s.redialForClientLocked != nil && s.resume.active()
+	if !resumable {
+		// cancel the callCmd that is waiting for a reply
+		s.cancelCallCmds(reason)
+	}
 
 	if status == statusActiveClosing {
 		return
@@ -825,8 +879,13 @@ func (s *session) readDisconnected(oldConn net.Conn, err error) {
 
 	s.socket.Close()
 	if !s.redialForClient(oldConn) {
+		if resumable {
+			s.cancelCallCmds(reason)
+		}
 		s.changeStatus(statusPassiveClosed)
 		s.notifyClosed()
+		s.forgetResume()
+		s.leaveGroups()
 		s.peer.pluginContainer.postDisconnect(s)
 	}
 }
@@ -835,6 +894,10 @@ func (s *session) redialForClient(oldConn net.Conn) bool {
 	if s.redialForClientLocked == nil {
 		return false
 	}
+	if done := s.migrating(); done != nil {
+		<-done
+		return oldConn != s.getConn()
+	}
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	// Avoid repeated calls from write and readDisconnected methods
@@ -862,6 +925,9 @@ func (s *session) startReadAndHandle() {
 		err      error
 		usedConn = s.getConn()
 	)
+	if dc, ok := usedConn.(datagramConn); ok {
+		AnywayGo(func() { s.startReadDatagrams(dc) })
+	}
 	defer func() {
 		if p := recover(); p != nil {
 			err = fmt.Errorf("panic:%v\n%s", p, goutil.PanicTrace(2))
@@ -881,8 +947,14 @@ func (s *session) startReadAndHandle() {
 			s.peer.putContext(ctx, false)
 			return
 		}
+		if (err == nil && s.filterGoAway(ctx.input)) || s.filterNegotiate(ctx.input, err) {
+			s.peer.putContext(ctx, false)
+			continue
+		}
 		if err != nil {
 			ctx.stat = statBadMessage.Copy(err)
+		} else if s.resume != nil {
+			s.resume.received(ctx.input)
 		}
 		s.graceCtxWaitGroup.Add(1)
 		if !Go(func() {
@@ -897,7 +969,8 @@ func (s *session) startReadAndHandle() {
 func (s *session) write(message Message) (net.Conn, *Status) {
 	usedConn := s.getConn()
 	status := s.getStatus()
-	if !(status == statusOk || (status == statusActiveClosing && message.Mtype() == TypeReply)) {
+	if !(status == statusOk || ((status == statusActiveClosing || status == statusDraining) && message.Mtype() == TypeReply) ||
+		(status == statusSuspended && message.Mtype() != TypeCall)) {
 		return usedConn, statConnClosed
 	}
 
@@ -914,15 +987,42 @@ func (s *session) write(message Message) (net.Conn, *Status) {
 	}
 
 	s.writeLock.Lock()
-	defer s.writeLock.Unlock()
-
 	select {
 	case <-ctx.Done():
+		s.writeLock.Unlock()
 		err = ctx.Err()
 		goto ERR
 	default:
+	}
+	if s.batcher != nil && canBatch(usedConn) {
+		// hold the message until the suspended session is resumed
+		hold := s.getStatus() == statusSuspended
+		if hold && message.Mtype() == TypeCall {
+			s.writeLock.Unlock()
+			return usedConn, statConnClosed
+		}
+		if s.batcher.sync {
+			s.socket.SetWriteDeadline(deadline)
+		}
+		// flush the message with the others written concurrently
+		entry := s.batcher.begin(usedConn, deadline)
+		err = s.socket.WriteMessage(message)
+		if err == nil && s.resume != nil {
+			s.resume.record(message, entry.b, hold)
+		}
+		var wait bool
+		wait, err = s.batcher.end(entry, err, hold)
+		s.writeLock.Unlock()
+		if wait {
+			err = s.batcher.wait(ctx, entry)
+		}
+	} else if s.getStatus() == statusSuspended {
+		s.writeLock.Unlock()
+		return usedConn, statConnClosed
+	} else {
 		s.socket.SetWriteDeadline(deadline)
 		err = s.socket.WriteMessage(message)
+		s.writeLock.Unlock()
 	}
 
 	if err == nil {
@@ -1053,15 +1153,16 @@ func (s *session) printRunLog(realIP string, costTime time.Duration, input, outp
 		costTimeStr = "(-)"
 	}
 
+	printDetail := s.peer.PrintDetail()
 	switch logType {
 	case typePushLaunch:
-		printFunc(logFormatPushLaunch, addr, costTimeStr, output.ServiceMethod(), messageLogBytes(output, s.peer.printDetail))
+		printFunc(logFormatPushLaunch, addr, costTimeStr, output.ServiceMethod(), messageLogBytes(output, printDetail))
 	case typePushHandle:
-		printFunc(logFormatPushHandle, addr, costTimeStr, input.ServiceMethod(), messageLogBytes(input, s.peer.printDetail))
+		printFunc(logFormatPushHandle, addr, costTimeStr, input.ServiceMethod(), messageLogBytes(input, printDetail))
 	case typeCallLaunch:
-		printFunc(logFormatCallLaunch, addr, costTimeStr, output.ServiceMethod(), messageLogBytes(output, s.peer.printDetail), messageLogBytes(input, s.peer.printDetail))
+		printFunc(logFormatCallLaunch, addr, costTimeStr, output.ServiceMethod(), messageLogBytes(output, printDetail), messageLogBytes(input, printDetail))
 	case typeCallHandle:
-		printFunc(logFormatCallHandle, addr, costTimeStr, input.ServiceMethod(), messageLogBytes(input, s.peer.printDetail), messageLogBytes(output, s.peer.printDetail))
+		printFunc(logFormatCallHandle, addr, costTimeStr, input.ServiceMethod(), messageLogBytes(input, printDetail), messageLogBytes(output, printDetail))
 	}
 }
 
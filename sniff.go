@@ -0,0 +1,292 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erpc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/andeya/erpc/v7/socket"
+)
+
+// Sniffer identifies a protocol by the leading bytes of the accepted connections.
+type Sniffer struct {
+	// Name is the unique name of the protocol.
+	Name string
+	// Magic are the alternative leading bytes of the protocol, it is used if Match is nil.
+	Magic [][]byte
+	// Match reports whether the peeked leading bytes are of the protocol,
+	// more reports whether it needs more bytes to decide, then the later sniffers are still tried.
+	Match func(peek []byte) (ok, more bool)
+	// ProtoFunc is the protocol of the matched connections.
+	ProtoFunc ProtoFunc
+	// Upgrade optionally replaces the matched connection before it is served, e.g. the websocket handshake.
+	// NOTE: The connection reads the peeked bytes first.
+	Upgrade func(peer Peer, conn net.Conn) (net.Conn, error)
+}
+
+func (s *Sniffer) match(peek []byte) (ok, more bool) {
+	if s.Match != nil {
+		return s.Match(peek)
+	}
+	for _, magic := range s.Magic {
+		if len(peek) < len(magic) {
+			more = more || bytes.HasPrefix(magic, peek)
+		} else if bytes.HasPrefix(peek, magic) {
+			return true, false
+		}
+	}
+	return false, more
+}
+
+var sniffers struct {
+	mu   sync.RWMutex
+	list []*Sniffer
+}
+
+// RegSniffer registers the sniffer, the registered ones are tried in the registration order.
+// NOTE:
+//
+//	The protocol packages register their default sniffers when imported;
+//	The registered one of the same name is replaced in place, e.g. to change the protocol options.
+func RegSniffer(sniffer *Sniffer) {
+	if sniffer.Name == "" || sniffer.ProtoFunc == nil {
+		panic("sniffer name and ProtoFunc can not be empty")
+	}
+	sniffers.mu.Lock()
+	defer sniffers.mu.Unlock()
+	for i, s := range sniffers.list {
+		if s.Name == sniffer.Name {
+			sniffers.list[i] = sniffer
+			return
+		}
+	}
+	sniffers.list = append(sniffers.list, sniffer)
+}
+
+// GetSniffer returns the registered sniffer by the name.
+func GetSniffer(name string) (*Sniffer, bool) {
+	sniffers.mu.RLock()
+	defer sniffers.mu.RUnlock()
+	for _, s := range sniffers.list {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+func registeredSniffers() []*Sniffer {
+	sniffers.mu.RLock()
+	defer sniffers.mu.RUnlock()
+	return append([]*Sniffer(nil), sniffers.list...)
+}
+
+// SniffConfig protocol auto-detection options of the accepted connections
+type SniffConfig struct {
+	Enable         bool          `yaml:"enable"    ini:"enable"    comment:"Detect the protocol of each accepted connection by its leading bytes, so that one port serves several protocols; for server role"`
+	AllowPlaintext bool          `yaml:"allow_plaintext" ini:"allow_plaintext" comment:"Also serve the plain clients on the port of the TLS stream listener; TLS is mandatory if false"`
+	Timeout        time.Duration `yaml:"timeout"   ini:"timeout"   comment:"Maximum duration to wait for the leading bytes, the silent connections are served with the fallback protocol, default 3s; ns,µs,ms,s,m,h"`
+	Protos         []string      `yaml:"protos"    ini:"protos"    comment:"Names of the registered sniffers tried in order; all the registered ones if empty"`
+	Fallback       string        `yaml:"fallback"  ini:"fallback"  comment:"Name of the registered sniffer whose protocol serves the unrecognized and silent connections; the protocol of the listener if empty"`
+	// Sniffers are the extra sniffers of the peer, they are tried before the registered ones.
+	Sniffers []*Sniffer `yaml:"-" ini:"-"`
+
+	sniffers []*Sniffer
+	fallback *Sniffer
+}
+
+const (
+	defaultSniffTimeout = time.Second * 3
+	sniffBufferSize     = 4096
+)
+
+func (s *SniffConfig) check() error {
+	if !s.Enable {
+		return nil
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = defaultSniffTimeout
+	}
+	s.sniffers = append([]*Sniffer(nil), s.Sniffers...)
+	if len(s.Protos) == 0 {
+		s.sniffers = append(s.sniffers, registeredSniffers()...)
+	} else {
+		for _, name := range s.Protos {
+			sniffer, ok := GetSniffer(name)
+			if !ok {
+				return fmt.Errorf("sniff: unknown sniffer %q", name)
+			}
+			s.sniffers = append(s.sniffers, sniffer)
+		}
+	}
+	if s.Fallback != "" {
+		var ok bool
+		if s.fallback, ok = GetSniffer(s.Fallback); !ok {
+			return fmt.Errorf("sniff: unknown fallback sniffer %q", s.Fallback)
+		}
+	}
+	return nil
+}
+
+// tlsRecordMagic the leading bytes of the TLS handshake record: {content type handshake}{major version 3}
+var tlsRecordMagic = []byte{0x16, 0x03}
+
+// sniffConn the accepted connection with the peeked leading bytes.
+type sniffConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newSniffConn(conn net.Conn) *sniffConn {
+	return &sniffConn{Conn: conn, r: bufio.NewReaderSize(conn, sniffBufferSize)}
+}
+
+// Read reads the peeked bytes first.
+func (c *sniffConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// NetConn returns the underlying connection that is wrapped by the sniffing, e.g. the *tls.Conn.
+func (c *sniffConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// ConnectionState returns the TLS state of the underlying connection,
+// it is the zero value if the connection is not TLS.
+func (c *sniffConn) ConnectionState() tls.ConnectionState {
+	if tlsConn, ok := c.Conn.(*tls.Conn); ok {
+		return tlsConn.ConnectionState()
+	}
+	return tls.ConnectionState{}
+}
+
+// tlsConnOf returns the TLS connection of the accepted connection, which may be wrapped by the sniffing.
+func tlsConnOf(conn net.Conn) (*tls.Conn, bool) {
+	if sc, ok := conn.(*sniffConn); ok {
+		conn = sc.Conn
+	}
+	c, ok := conn.(*tls.Conn)
+	return c, ok
+}
+
+// SyscallConn returns the raw connection of the underlying connection.
+func (c *sniffConn) SyscallConn() (syscall.RawConn, error) {
+	if sc, ok := c.Conn.(syscall.Conn); ok {
+		return sc.SyscallConn()
+	}
+	return nil, syscall.EINVAL
+}
+
+// detect peeks the leading bytes until a sniffer matches,
+// it returns nil sniffer when none matches or the deadline is exceeded.
+// NOTE: The first matched sniffer in order wins, and it waits for more bytes only if none matches.
+func (c *sniffConn) detect(sniffers []*Sniffer, withTLS bool) (sniffer *Sniffer, isTLS bool, err error) {
+	for n := 1; ; {
+		peek, err := c.r.Peek(n)
+		if err != nil {
+			if ne, ok := err.(net.Error); (ok && ne.Timeout()) || err == bufio.ErrBufferFull {
+				return nil, false, nil
+			}
+			return nil, false, err
+		}
+		peek, _ = c.r.Peek(c.r.Buffered())
+		if withTLS {
+			if len(peek) < len(tlsRecordMagic) {
+				if bytes.HasPrefix(tlsRecordMagic, peek) {
+					n = len(peek) + 1
+					continue
+				}
+			} else if bytes.HasPrefix(peek, tlsRecordMagic) {
+				return nil, true, nil
+			}
+		}
+		more := false
+		for _, s := range sniffers {
+			ok, m := s.match(peek)
+			if ok {
+				return s, false, nil
+			}
+			more = more || m
+		}
+		if !more {
+			return nil, false, nil
+		}
+		n = len(peek) + 1
+	}
+}
+
+// sniff detects the protocol of the accepted connection, and returns the connection to serve with its protocol.
+// NOTE: The TLS connection is detected if tlsConfig is not nil, and it is sniffed again after the handshake;
+// the plain connection is refused then unless AllowPlaintext is set.
+func (p *peer) sniff(conn net.Conn, tlsConfig *tls.Config, protoFuncs []ProtoFunc) (net.Conn, []ProtoFunc, error) {
+	cfg := p.sniffConfig
+	socket.TryOptimize(conn)
+	for {
+		sc := newSniffConn(conn)
+		sc.SetReadDeadline(time.Now().Add(cfg.Timeout))
+		sniffer, isTLS, err := sc.detect(cfg.sniffers, tlsConfig != nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isTLS {
+			tlsConn := tls.Server(sc, tlsConfig)
+			tlsConn.SetDeadline(time.Now().Add(cfg.Timeout))
+			if err = tlsConn.Handshake(); err != nil {
+				return nil, nil, fmt.Errorf("TLS handshake: %s", err.Error())
+			}
+			tlsConn.SetDeadline(time.Time{})
+			conn, tlsConfig = tlsConn, nil
+			continue
+		}
+		if tlsConfig != nil && !cfg.AllowPlaintext {
+			return nil, nil, errors.New("plaintext connection refused, TLS is required")
+		}
+		if sniffer == nil {
+			sniffer = cfg.fallback
+		}
+		if sniffer == nil {
+			sc.SetReadDeadline(time.Time{})
+			Debugf("sniff (addr:%s, proto:fallback)", conn.RemoteAddr().String())
+			return sc, protoFuncs, nil
+		}
+		conn = sc
+		if sniffer.Upgrade != nil {
+			if conn, err = sniffer.Upgrade(p, sc); err != nil {
+				return nil, nil, fmt.Errorf("%s upgrade: %s", sniffer.Name, err.Error())
+			}
+		}
+		conn.SetReadDeadline(time.Time{})
+		Debugf("sniff (addr:%s, proto:%s)", sc.RemoteAddr().String(), sniffer.Name)
+		return conn, []ProtoFunc{sniffer.ProtoFunc}, nil
+	}
+}
+
+// splitTLS returns the TLS config applied by the listener and the one detected per connection when sniffing,
+// so that the TLS handshake follows the sniffing on the stream networks.
+func (p *peer) splitTLS(addr net.Addr, tlsConfig *tls.Config) (lisTLS, sniffTLS *tls.Config) {
+	network := addr.Network()
+	if p.sniffConfig == nil || tlsConfig == nil || asQUIC(network) != "" || asKCP(network) != "" {
+		return tlsConfig, nil
+	}
+	return nil, tlsConfig
+}
@@ -0,0 +1,159 @@
+package erpc_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+	"github.com/andeya/erpc/v7/proto/jsonproto"
+	"github.com/andeya/erpc/v7/proto/raw2proto"
+)
+
+func sniffEcho(ctx erpc.CallCtx, arg *string) (string, *erpc.Status) {
+	return *arg, nil
+}
+
+func TestSniff(t *testing.T) {
+	srv := erpc.NewPeer(erpc.PeerConfig{
+		Network: mem.Network,
+		Sniff:   erpc.SniffConfig{Enable: true, AllowPlaintext: true, Timeout: time.Millisecond * 100},
+	})
+	srv.SetTLSConfig(erpc.GenerateTLSConfigForServer())
+	srv.RouteCallFunc(sniffEcho)
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network, DialTimeout: time.Second})
+	defer cli.Close()
+	call := func(name string) func(erpc.Session, *erpc.Status) {
+		return func(sess erpc.Session, stat *erpc.Status) {
+			t.Helper()
+			if !stat.OK() {
+				t.Fatalf("%s: %v", name, stat)
+			}
+			var reply string
+			if stat = sess.Call("/sniff_echo", name, &reply).Status(); !stat.OK() || reply != name {
+				t.Fatalf("%s: unexpected reply: %q, %v", name, reply, stat)
+			}
+			sess.Close()
+		}
+	}
+
+	// the listener protocol serves the unrecognized
+	call("raw")(cli.Dial(addr))
+	call("json")(cli.Dial(addr, jsonproto.NewJSONProtoFunc()))
+	call("raw2")(cli.Dial(addr, raw2proto.NewRaw2ProtoFunc(raw2proto.Config{NoNegotiation: true})))
+
+	// sniffed again after the TLS handshake
+	conn, err := mem.DialContext(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call("tls+json")(cli.ServeConn(tls.Client(conn, erpc.GenerateTLSConfigForClient()), jsonproto.NewJSONProtoFunc()))
+
+	// the silent client is served with the listener protocol after the timeout
+	conn, err = mem.DialContext(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 200)
+	call("silent")(cli.ServeConn(conn))
+}
+
+// sniffTLSPlugin records whether the accepted connections are TLS.
+type sniffTLSPlugin chan bool
+
+func (sniffTLSPlugin) Name() string { return "sniff_tls" }
+
+func (p sniffTLSPlugin) PostAccept(sess erpc.PreSession) *erpc.Status {
+	sess.ModifySocket(func(conn net.Conn) (net.Conn, erpc.ProtoFunc) {
+		c, ok := conn.(interface{ ConnectionState() tls.ConnectionState })
+		p <- ok && c.ConnectionState().HandshakeComplete
+		return nil, nil
+	})
+	return nil
+}
+
+func TestSniffTLSRequired(t *testing.T) {
+	tlsAccepted := make(sniffTLSPlugin, 1)
+	srv := erpc.NewPeer(erpc.PeerConfig{
+		Network: mem.Network,
+		Sniff:   erpc.SniffConfig{Enable: true, Timeout: time.Millisecond * 100},
+	}, tlsAccepted)
+	srv.SetTLSConfig(erpc.GenerateTLSConfigForServer())
+	srv.RouteCallFunc(sniffEcho)
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network, DialTimeout: time.Second})
+	defer cli.Close()
+
+	// the plain client is refused
+	sess, stat := cli.Dial(addr, jsonproto.NewJSONProtoFunc())
+	if stat.OK() {
+		var reply string
+		if stat = sess.Call("/sniff_echo", "plain", &reply).Status(); stat.OK() {
+			t.Fatalf("plain: unexpected reply: %q", reply)
+		}
+		sess.Close()
+	}
+
+	conn, err := mem.DialContext(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, stat = cli.ServeConn(tls.Client(conn, erpc.GenerateTLSConfigForClient()), jsonproto.NewJSONProtoFunc())
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	defer sess.Close()
+	var reply string
+	if stat = sess.Call("/sniff_echo", "tls+json", &reply).Status(); !stat.OK() || reply != "tls+json" {
+		t.Fatalf("tls+json: unexpected reply: %q, %v", reply, stat)
+	}
+	// the sniffed connection exposes the TLS state to the plugins
+	if !<-tlsAccepted {
+		t.Fatal("the TLS state of the sniffed connection is hidden")
+	}
+}
+
+func TestSniffOverlapping(t *testing.T) {
+	matched := make(chan string, 1)
+	upgrade := func(name string) func(erpc.Peer, net.Conn) (net.Conn, error) {
+		return func(_ erpc.Peer, conn net.Conn) (net.Conn, error) {
+			matched <- name
+			return conn, nil
+		}
+	}
+	rawProto := erpc.DefaultProtoFunc()
+	srv := erpc.NewPeer(erpc.PeerConfig{
+		Network: mem.Network,
+		Sniff: erpc.SniffConfig{Enable: true, Timeout: time.Second * 5, Sniffers: []*erpc.Sniffer{
+			{Name: "long", Magic: [][]byte{[]byte("ABCDEF")}, ProtoFunc: rawProto, Upgrade: upgrade("long")},
+			{Name: "short", Magic: [][]byte{[]byte("AB")}, ProtoFunc: rawProto, Upgrade: upgrade("short")},
+		}},
+	})
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+
+	conn, err := mem.DialContext(context.Background(), addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the later sniffer matches the bytes that the earlier one needs more of
+	conn.Write([]byte("AB"))
+	select {
+	case name := <-matched:
+		if name != "short" {
+			t.Fatalf("unexpected sniffer: %s", name)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("the matched sniffer waits for the earlier one")
+	}
+}
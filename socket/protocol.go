@@ -40,6 +40,14 @@ type (
 		// NOTE: Concurrent unsafe!
 		Unpack(Message) error
 	}
+	// StatefulProto is the Proto whose messages depend on the ones sent before,
+	// such as the ones with the header compression tables.
+	// NOTE: Its messages are never sent as datagrams, which may be lost or reordered.
+	StatefulProto interface {
+		Proto
+		// Stateful returns true if the packed messages depend on the ones before.
+		Stateful() bool
+	}
 	// IOWithReadBuffer implements buffered I/O with buffered reader.
 	IOWithReadBuffer interface {
 		io.ReadWriter
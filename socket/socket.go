@@ -18,6 +18,7 @@ package socket
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -108,6 +109,9 @@ type (
 		// NOTE:
 		//  Make sure the external is locked before calling
 		RawLocked() net.Conn
+		// ResetReader makes the socket read from r, and discards the buffered data;
+		// the raw net.Conn is still used for writing.
+		ResetReader(r io.Reader)
 	}
 	socket struct {
 		net.Conn
@@ -301,6 +305,14 @@ func (s *socket) Reset(netConn net.Conn, protoFunc ...ProtoFunc) {
 	s.mu.Unlock()
 }
 
+// ResetReader makes the socket read from r, and discards the buffered data;
+// the raw net.Conn is still used for writing.
+func (s *socket) ResetReader(r io.Reader) {
+	s.mu.Lock()
+	s.readerWithBuffer.Reset(r)
+	s.mu.Unlock()
+}
+
 // Close closes the connection socket.
 // Any blocked Read or Write operations will be unblocked and return errors.
 // If it is from 'GetSocket()' function(a pool), return itself to pool.
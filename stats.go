@@ -0,0 +1,86 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erpc
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SessionStat the runtime statistics of a session.
+type SessionStat struct {
+	ID           string        `json:"id"`
+	LocalAddr    string        `json:"local_addr"`
+	RemoteAddr   string        `json:"remote_addr"`
+	Age          time.Duration `json:"age"`
+	Status       string        `json:"status"`
+	SwapKeys     []string      `json:"swap_keys"`
+	PendingCalls int           `json:"pending_calls"` // the number of the CALLs waiting for the reply
+	Negotiation  *Negotiation  `json:"negotiation,omitempty"`
+}
+
+var statusTexts = [...]string{
+	statusPreparing:      "preparing",
+	statusOk:             "ok",
+	statusActiveClosing:  "active_closing",
+	statusActiveClosed:   "active_closed",
+	statusPassiveClosing: "passive_closing",
+	statusPassiveClosed:  "passive_closed",
+	statusRedialing:      "redialing",
+	statusRedialFailed:   "redial_failed",
+	statusSuspended:      "suspended",
+	statusDraining:       "draining",
+}
+
+// statusText returns the text of the session status.
+func statusText(status int32) string {
+	if status < 0 || int(status) >= len(statusTexts) || statusTexts[status] == "" {
+		return "unknown"
+	}
+	return statusTexts[status]
+}
+
+// SessionStats returns the runtime statistics of all sessions, sorted by the session id.
+func (p *peer) SessionStats() []SessionStat {
+	var stats []SessionStat
+	p.sessHub.rangeCallback(func(sess *session) bool {
+		stats = append(stats, sess.stat())
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+func (s *session) stat() SessionStat {
+	stat := SessionStat{
+		ID:           s.ID(),
+		LocalAddr:    s.LocalAddr().String(),
+		RemoteAddr:   s.RemoteAddr().String(),
+		Age:          time.Since(s.createdAt),
+		Status:       statusText(s.getStatus()),
+		SwapKeys:     []string{},
+		PendingCalls: s.callCmdMap.Len(),
+	}
+	s.Swap().Range(func(key, _ interface{}) bool {
+		stat.SwapKeys = append(stat.SwapKeys, fmt.Sprint(key))
+		return true
+	})
+	sort.Strings(stat.SwapKeys)
+	if n, ok := s.Negotiation(); ok {
+		stat.Negotiation = &n
+	}
+	return stat
+}
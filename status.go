@@ -86,11 +86,11 @@ const (
 	CodeHandleTimeout       int32 = 408
 	CodeInternalServerError int32 = 500
 	CodeBadGateway          int32 = 502
+	CodeServiceUnavailable  int32 = 503
 
 	// CodeConflict                      int32 = 409
 	// CodeUnsupportedTx                 int32 = 410
 	// CodeUnsupportedCodecType          int32 = 415
-	// CodeServiceUnavailable            int32 = 503
 	// CodeGatewayTimeout                int32 = 504
 	// CodeVariantAlsoNegotiates         int32 = 506
 	// CodeInsufficientStorage           int32 = 507
@@ -99,6 +99,52 @@ const (
 	// CodeNetworkAuthenticationRequired int32 = 511
 )
 
+// Canonical Status code, aligned with the gRPC codes and the HTTP semantics.
+// NOTE:
+//
+//	Retry, circuit-breaking and HTTP translation should use CanonicalCode(code) to classify a status;
+//	The codes that have an identical framework code are aliases of it;
+//	The code values are the HTTP status codes they are mapped to, except for CodeUnknown (-1, mapped to 500),
+//	CodeDeadlineExceeded (408, mapped to 504), CodeAborted (430, mapped to 409) and CodeDataLoss (530, mapped to 500).
+const (
+	CodeUnknown            int32 = CodeUnknownError // unknown error, or the status code is not canonical
+	CodeCancelled          int32 = 499              // the operation was cancelled, typically by the caller
+	CodeInvalidArgument    int32 = CodeBadMessage   // the argument is invalid regardless of the system state
+	CodeDeadlineExceeded   int32 = CodeHandleTimeout
+	CodeAlreadyExists      int32 = 409
+	CodePermissionDenied   int32 = 403 // the caller is identified but not permitted
+	CodeUnauthenticated    int32 = CodeUnauthorized
+	CodeResourceExhausted  int32 = 429 // e.g. a quota or the overload protection
+	CodeFailedPrecondition int32 = 412 // the system is not in the state required, do not retry until it is fixed
+	CodeAborted            int32 = 430 // e.g. a concurrency conflict, retry at a higher level
+	CodeOutOfRange         int32 = 416
+	CodeUnimplemented      int32 = 501
+	CodeInternal           int32 = CodeInternalServerError
+	CodeUnavailable        int32 = CodeServiceUnavailable // transient, safe to retry with backoff
+	CodeDataLoss           int32 = 530                    // unrecoverable data loss or corruption
+)
+
+// CanonicalCode returns the canonical code of the status code,
+// the framework codes are mapped to the canonical ones with the same meaning,
+// and the custom codes are mapped to CodeUnknown.
+func CanonicalCode(statCode int32) int32 {
+	switch statCode {
+	case CodeOK, CodeUnknown, CodeCancelled, CodeInvalidArgument, CodeDeadlineExceeded,
+		CodeNotFound, CodeAlreadyExists, CodePermissionDenied, CodeUnauthenticated,
+		CodeResourceExhausted, CodeFailedPrecondition, CodeAborted, CodeOutOfRange,
+		CodeUnimplemented, CodeInternal, CodeUnavailable, CodeDataLoss:
+		return statCode
+	case CodeInvalidOp, CodeWrongConn:
+		return CodeFailedPrecondition
+	case CodeDialFailed, CodeConnClosed, CodeWriteFailed, CodeBadGateway:
+		return CodeUnavailable
+	case CodeMtypeNotAllowed:
+		return CodeUnimplemented
+	default:
+		return CodeUnknown
+	}
+}
+
 // CodeText returns the reply error code text.
 // If the type is undefined returns 'Unknown Error'.
 func CodeText(statCode int32) string {
@@ -129,6 +175,26 @@ func CodeText(statCode int32) string {
 		return "Internal Server Error"
 	case CodeBadGateway:
 		return "Bad Gateway"
+	case CodeServiceUnavailable:
+		return "Service Unavailable"
+	case CodeCancelled:
+		return "Cancelled"
+	case CodeAlreadyExists:
+		return "Already Exists"
+	case CodePermissionDenied:
+		return "Permission Denied"
+	case CodeResourceExhausted:
+		return "Resource Exhausted"
+	case CodeFailedPrecondition:
+		return "Failed Precondition"
+	case CodeAborted:
+		return "Aborted"
+	case CodeOutOfRange:
+		return "Out Of Range"
+	case CodeUnimplemented:
+		return "Unimplemented"
+	case CodeDataLoss:
+		return "Data Loss"
 	case CodeUnknownError:
 		fallthrough
 	default:
@@ -0,0 +1,96 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erpc
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// StatusDetails the typed error details carried by the status cause.
+// NOTE:
+//
+//	The status cause is transferred as text, so StatusDetails implements error with its JSON encoding;
+//	Use NewStatusWithDetails to create the status, and GetStatusDetails to read them back on either side.
+type StatusDetails struct {
+	Cause           string           `json:"cause,omitempty"`            // the original cause
+	FieldViolations []FieldViolation `json:"field_violations,omitempty"` // the invalid fields of the argument
+	RetryInfo       *RetryInfo       `json:"retry_info,omitempty"`       // when to retry
+	DebugInfo       *DebugInfo       `json:"debug_info,omitempty"`       // for debugging, do not expose it to the end users
+}
+
+// FieldViolation a field of the argument which is invalid.
+type FieldViolation struct {
+	Field       string `json:"field"`       // the path of the field, e.g. "users[0].email"
+	Description string `json:"description"` // why the field is invalid
+}
+
+// RetryInfo when the client may retry the failed request.
+type RetryInfo struct {
+	RetryDelay time.Duration `json:"retry_delay"` // the minimum delay before retrying, in nanoseconds
+}
+
+// DebugInfo the debugging information of the server.
+type DebugInfo struct {
+	StackEntries []string `json:"stack_entries,omitempty"`
+	Detail       string   `json:"detail,omitempty"`
+}
+
+// statusDetailsKey is the key of the JSON object of the encoded StatusDetails,
+// which tells them from the plain causes.
+const statusDetailsKey = `{"@status_details":`
+
+// Error returns the JSON encoding of the details.
+func (d *StatusDetails) Error() string {
+	b, _ := json.Marshal(d)
+	return statusDetailsKey + string(b) + "}"
+}
+
+// NewStatusWithDetails creates a message status with code, msg and the typed error details as cause.
+// NOTE:
+//
+//	If msg is empty, it comes from the CodeText(code) value.
+func NewStatusWithDetails(code int32, msg string, details *StatusDetails) *Status {
+	if msg == "" {
+		msg = CodeText(code)
+	}
+	return NewStatus(code, msg, details)
+}
+
+// GetStatusDetails returns the typed error details carried by the status cause.
+func GetStatusDetails(stat *Status) (*StatusDetails, bool) {
+	if stat.OK() {
+		return nil, false
+	}
+	cause := stat.Cause()
+	var details *StatusDetails
+	if errors.As(cause, &details) {
+		return details, true
+	}
+	// decode the one received from the remote peer
+	text := cause.Error()
+	if !strings.HasPrefix(text, statusDetailsKey) {
+		return nil, false
+	}
+	var v struct {
+		Details *StatusDetails `json:"@status_details"`
+	}
+	if json.Unmarshal([]byte(text), &v) != nil || v.Details == nil {
+		return nil, false
+	}
+	return v.Details, true
+}
@@ -0,0 +1,82 @@
+package erpc_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+)
+
+func TestCanonicalCode(t *testing.T) {
+	for code, canonical := range map[int32]int32{
+		erpc.CodeOK:              erpc.CodeOK,
+		erpc.CodeNotFound:        erpc.CodeNotFound,
+		erpc.CodeBadMessage:      erpc.CodeInvalidArgument,
+		erpc.CodeHandleTimeout:   erpc.CodeDeadlineExceeded,
+		erpc.CodeDialFailed:      erpc.CodeUnavailable,
+		erpc.CodeConnClosed:      erpc.CodeUnavailable,
+		erpc.CodeWriteFailed:     erpc.CodeUnavailable,
+		erpc.CodeBadGateway:      erpc.CodeUnavailable,
+		erpc.CodeMtypeNotAllowed: erpc.CodeUnimplemented,
+		erpc.CodeInvalidOp:       erpc.CodeFailedPrecondition,
+		erpc.CodeAborted:         erpc.CodeAborted,
+		1001:                     erpc.CodeUnknown,
+	} {
+		if have := erpc.CanonicalCode(code); have != canonical {
+			t.Fatalf("code %d: want %d, have %d", code, canonical, have)
+		}
+	}
+	if erpc.CodeText(erpc.CodeResourceExhausted) != "Resource Exhausted" {
+		t.Fatalf("unexpected code text: %q", erpc.CodeText(erpc.CodeResourceExhausted))
+	}
+}
+
+func TestStatusDetails(t *testing.T) {
+	details := &erpc.StatusDetails{
+		Cause:           "invalid user",
+		FieldViolations: []erpc.FieldViolation{{Field: "users[0].email", Description: "malformed"}},
+		RetryInfo:       &erpc.RetryInfo{RetryDelay: time.Second},
+		DebugInfo:       &erpc.DebugInfo{StackEntries: []string{"main.go:10"}, Detail: "debug"},
+	}
+	stat := erpc.NewStatusWithDetails(erpc.CodeInvalidArgument, "", details)
+	if stat.Msg() != "Bad Message" {
+		t.Fatalf("unexpected msg: %q", stat.Msg())
+	}
+	local, ok := erpc.GetStatusDetails(stat)
+	if !ok || local != details {
+		t.Fatalf("unexpected local details: %+v, %v", local, ok)
+	}
+	var target *erpc.StatusDetails
+	if !errors.As(stat.Cause(), &target) {
+		t.Fatal("errors.As(cause, *StatusDetails) = false")
+	}
+
+	// transferred as query and JSON
+	remote := erpc.NewStatusFromQuery(stat.EncodeQuery(), false)
+	b, _ := stat.MarshalJSON()
+	fromJSON := new(erpc.Status)
+	if err := fromJSON.UnmarshalJSON(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*erpc.Status{remote, fromJSON} {
+		d, ok := erpc.GetStatusDetails(s)
+		if !ok {
+			t.Fatalf("no details: %v", s)
+		}
+		if d.Cause != details.Cause || d.FieldViolations[0] != details.FieldViolations[0] ||
+			d.RetryInfo.RetryDelay != time.Second || d.DebugInfo.Detail != "debug" || d.DebugInfo.StackEntries[0] != "main.go:10" {
+			t.Fatalf("unexpected details: %+v", d)
+		}
+	}
+
+	for _, s := range []*erpc.Status{
+		nil,
+		erpc.NewStatus(erpc.CodeNotFound, "not found", "plain cause"),
+		erpc.NewStatus(erpc.CodeNotFound, "not found", `{"@status_details":`),
+	} {
+		if d, ok := erpc.GetStatusDetails(s); ok {
+			t.Fatalf("unexpected details of %v: %+v", s, d)
+		}
+	}
+}
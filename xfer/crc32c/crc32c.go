@@ -0,0 +1,78 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package crc32c provides a CRC-32C (Castagnoli) corruption check transfer filter
+package crc32c
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/andeya/erpc/v7/xfer"
+)
+
+// ErrChecksum the error that the checksum mismatches
+var ErrChecksum = errors.New("crc32c: checksum mismatch")
+
+var table = crc32.MakeTable(crc32.Castagnoli)
+
+// Reg registers a CRC-32C checker filter for transfer.
+// NOTE: It detects the accidental corruption only, use the hmac or ed25519 filter against tampering.
+func Reg(id byte, name string) {
+	xfer.Reg(&crc32c{
+		id:   id,
+		name: name,
+	})
+}
+
+// crc32c corruption check filter
+type crc32c struct {
+	id   byte
+	name string
+}
+
+const checksumLength = 4
+
+// ID returns transfer filter id.
+func (c *crc32c) ID() byte {
+	return c.id
+}
+
+// Name returns transfer filter name.
+func (c *crc32c) Name() string {
+	return c.name
+}
+
+// OnPack appends the big-endian checksum of src.
+func (c *crc32c) OnPack(src []byte) ([]byte, error) {
+	dest := make([]byte, len(src)+checksumLength)
+	copy(dest, src)
+	binary.BigEndian.PutUint32(dest[len(src):], crc32.Checksum(src, table))
+	return dest, nil
+}
+
+// OnUnpack verifies and strips the checksum.
+func (c *crc32c) OnUnpack(src []byte) ([]byte, error) {
+	n := len(src) - checksumLength
+	if n < 0 {
+		return nil, fmt.Errorf("crc32c: too short: %d bytes", len(src))
+	}
+	data := src[:n]
+	if crc32.Checksum(data, table) != binary.BigEndian.Uint32(src[n:]) {
+		return nil, ErrChecksum
+	}
+	return data, nil
+}
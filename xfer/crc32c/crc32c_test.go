@@ -0,0 +1,37 @@
+package crc32c_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/andeya/erpc/v7/xfer"
+	"github.com/andeya/erpc/v7/xfer/crc32c"
+)
+
+func TestCrc32c(t *testing.T) {
+	crc32c.Reg('c', "crc32c")
+	c, err := xfer.GetByName("crc32c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 3720 B.4: 32 bytes of zeroes
+	b, err := c.OnPack(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("onpack: %v", err)
+	}
+	if have := b[32:]; string(have) != "\x8a\x91\x36\xaa" {
+		t.Fatalf("unexpected checksum: %x", have)
+	}
+	dest, err := c.OnUnpack(b)
+	if err != nil || len(dest) != 32 {
+		t.Fatalf("crc32c check failed: %v", err)
+	}
+	// Corrupt data
+	b[3] ^= 0x10
+	if _, err = c.OnUnpack(b); !errors.Is(err, crc32c.ErrChecksum) {
+		t.Fatalf("corrupted: want ErrChecksum, have %v", err)
+	}
+	if _, err = c.OnUnpack([]byte{1, 2}); err == nil {
+		t.Fatal("too short: expect error")
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ed25519 provides an Ed25519 signature transfer filter,
+// for the messages relayed through untrusted intermediaries.
+package ed25519
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/andeya/erpc/v7/xfer"
+)
+
+var (
+	// ErrUnknownKey the error that the message is signed by a key which is not trusted
+	ErrUnknownKey = errors.New("ed25519: unknown key id")
+	// ErrSignature the error that the message is tampered or signed by a foreign key
+	ErrSignature = errors.New("ed25519: invalid signature")
+	// ErrNoPrivateKey the error that the verify-only filter is used to sign
+	ErrNoPrivateKey = errors.New("ed25519: no private key to sign")
+)
+
+const keyIDLength = 8
+
+// Reg registers an Ed25519 signature filter for transfer.
+// The messages are signed by privateKey, and verified by the one of the trusted publicKeys
+// whose KeyID they carry; the public key of privateKey is always trusted.
+// NOTE:
+//
+//	privateKey can be nil for the verify-only peers;
+//	The signature carries no nonce, timestamp or sequence, so a captured message verifies again when replayed;
+//	It panics if a key has an invalid length.
+func Reg(id byte, name string, privateKey ed25519.PrivateKey, publicKeys ...ed25519.PublicKey) {
+	xfer.Reg(newEd25519(id, name, privateKey, publicKeys...))
+}
+
+// KeyID returns the ID of the public key carried by the signed messages,
+// which is the big-endian uint64 of the first 8 bytes of its SHA-256.
+func KeyID(publicKey ed25519.PublicKey) uint64 {
+	sum := sha256.Sum256(publicKey)
+	return binary.BigEndian.Uint64(sum[:keyIDLength])
+}
+
+// ReadPrivateKey reads a PEM encoded PKCS #8 Ed25519 private key from file,
+// e.g. created by `openssl genpkey -algorithm ed25519`.
+func ReadPrivateKey(filename string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("ed25519: %s: %s", filename, err.Error())
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("ed25519: %s: not an Ed25519 private key", filename)
+	}
+	return privateKey, nil
+}
+
+// ReadPublicKey reads a PEM encoded PKIX Ed25519 public key from file,
+// e.g. created by `openssl pkey -pubout`.
+func ReadPublicKey(filename string) (ed25519.PublicKey, error) {
+	der, err := readPEM(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("ed25519: %s: %s", filename, err.Error())
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("ed25519: %s: not an Ed25519 public key", filename)
+	}
+	return publicKey, nil
+}
+
+func readPEM(filename string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("ed25519: %s: no PEM data", filename)
+	}
+	return block.Bytes, nil
+}
+
+// newEd25519 creates a new ed25519 filter.
+func newEd25519(id byte, name string, privateKey ed25519.PrivateKey, publicKeys ...ed25519.PublicKey) *signer {
+	s := &signer{
+		id:         id,
+		name:       name,
+		publicKeys: make(map[uint64]ed25519.PublicKey, len(publicKeys)+1),
+	}
+	if privateKey != nil {
+		if len(privateKey) != ed25519.PrivateKeySize {
+			panic(fmt.Sprintf("ed25519: invalid private key length: %d", len(privateKey)))
+		}
+		publicKey := privateKey.Public().(ed25519.PublicKey)
+		s.privateKey = privateKey
+		s.keyID = KeyID(publicKey)
+		publicKeys = append(publicKeys, publicKey)
+	}
+	for _, publicKey := range publicKeys {
+		if len(publicKey) != ed25519.PublicKeySize {
+			panic(fmt.Sprintf("ed25519: invalid public key length: %d", len(publicKey)))
+		}
+		s.publicKeys[KeyID(publicKey)] = publicKey
+	}
+	return s
+}
+
+// signer Ed25519 signature filter
+type signer struct {
+	id         byte
+	name       string
+	privateKey ed25519.PrivateKey
+	keyID      uint64
+	publicKeys map[uint64]ed25519.PublicKey
+}
+
+// ID returns transfer filter id.
+func (s *signer) ID() byte {
+	return s.id
+}
+
+// Name returns transfer filter name.
+func (s *signer) Name() string {
+	return s.name
+}
+
+// OnPack appends the big-endian key ID and the signature of src and the key ID.
+func (s *signer) OnPack(src []byte) ([]byte, error) {
+	if s.privateKey == nil {
+		return nil, ErrNoPrivateKey
+	}
+	dest := make([]byte, len(src)+keyIDLength, len(src)+keyIDLength+ed25519.SignatureSize)
+	copy(dest, src)
+	binary.BigEndian.PutUint64(dest[len(src):], s.keyID)
+	return append(dest, ed25519.Sign(s.privateKey, dest)...), nil
+}
+
+// OnUnpack verifies and strips the key ID and the signature.
+func (s *signer) OnUnpack(src []byte) ([]byte, error) {
+	n := len(src) - keyIDLength - ed25519.SignatureSize
+	if n < 0 {
+		return nil, fmt.Errorf("ed25519: too short: %d bytes", len(src))
+	}
+	keyID := binary.BigEndian.Uint64(src[n:])
+	publicKey, ok := s.publicKeys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %016x", ErrUnknownKey, keyID)
+	}
+	if !ed25519.Verify(publicKey, src[:n+keyIDLength], src[n+keyIDLength:]) {
+		return nil, fmt.Errorf("%w (key id: %016x)", ErrSignature, keyID)
+	}
+	return src[:n], nil
+}
@@ -0,0 +1,100 @@
+package ed25519_test
+
+import (
+	stded25519 "crypto/ed25519"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andeya/erpc/v7/xfer"
+	"github.com/andeya/erpc/v7/xfer/ed25519"
+)
+
+func TestEd25519(t *testing.T) {
+	pub, priv, _ := stded25519.GenerateKey(nil)
+	_, foreignPriv, _ := stded25519.GenerateKey(nil)
+	ed25519.Reg('e', "ed25519", priv)
+	// the verify-only peer
+	ed25519.Reg('v', "ed25519-verify", nil, pub)
+	ed25519.Reg('x', "ed25519-foreign", foreignPriv)
+	signer, _ := xfer.Get('e')
+	verifier, _ := xfer.Get('v')
+	foreign, _ := xfer.Get('x')
+
+	input := []byte("ed25519")
+	b, err := signer.OnPack(input)
+	if err != nil {
+		t.Fatalf("onpack: %v", err)
+	}
+	if len(b) != len(input)+8+64 || binary.BigEndian.Uint64(b[len(input):]) != ed25519.KeyID(pub) {
+		t.Fatalf("unexpected signed message: %x", b)
+	}
+	dest, err := verifier.OnUnpack(b)
+	if err != nil {
+		t.Fatalf("ed25519 check failed: %v", err)
+	}
+	if string(dest) != "ed25519" {
+		t.Fatalf("want \"ed25519\", have %q", dest)
+	}
+	if _, err = verifier.OnPack(input); !errors.Is(err, ed25519.ErrNoPrivateKey) {
+		t.Fatalf("verify-only: want ErrNoPrivateKey, have %v", err)
+	}
+
+	// Tamper with data
+	b[0] ^= 1
+	if _, err = verifier.OnUnpack(b); !errors.Is(err, ed25519.ErrSignature) {
+		t.Fatalf("tampered: want ErrSignature, have %v", err)
+	}
+	b, _ = foreign.OnPack(input)
+	if _, err = verifier.OnUnpack(b); !errors.Is(err, ed25519.ErrUnknownKey) {
+		t.Fatalf("foreign: want ErrUnknownKey, have %v", err)
+	}
+	// forge the key ID of the trusted key
+	binary.BigEndian.PutUint64(b[len(input):], ed25519.KeyID(pub))
+	if _, err = verifier.OnUnpack(b); !errors.Is(err, ed25519.ErrSignature) {
+		t.Fatalf("forged: want ErrSignature, have %v", err)
+	}
+	if _, err = verifier.OnUnpack(input); err == nil {
+		t.Fatal("too short: expect error")
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	pub, priv, _ := stded25519.GenerateKey(nil)
+	dir := t.TempDir()
+	der, _ := x509.MarshalPKCS8PrivateKey(priv)
+	privFile := filepath.Join(dir, "private.pem")
+	if err := os.WriteFile(privFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	der, _ = x509.MarshalPKIXPublicKey(pub)
+	pubFile := filepath.Join(dir, "public.pem")
+	if err := os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	priv2, err := ed25519.ReadPrivateKey(privFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !priv.Equal(priv2) {
+		t.Fatal("the private key mismatches")
+	}
+	pub2, err := ed25519.ReadPublicKey(pubFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.Equal(pub2) {
+		t.Fatal("the public key mismatches")
+	}
+	if _, err = ed25519.ReadPublicKey(privFile); err == nil {
+		t.Fatal("read the private key as public key: expect error")
+	}
+	if _, err = ed25519.ReadPrivateKey(filepath.Join(dir, "none.pem")); err == nil {
+		t.Fatal("missing file: expect error")
+	}
+}
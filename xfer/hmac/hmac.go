@@ -0,0 +1,130 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hmac provides a HMAC-SHA256 signing transfer filter with key IDs
+package hmac
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"sync"
+
+	"github.com/andeya/erpc/v7/xfer"
+)
+
+var (
+	// ErrUnknownKey the error that the message is signed by a key which is not in the key ring
+	ErrUnknownKey = errors.New("hmac: unknown key id")
+	// ErrSignature the error that the message is tampered or signed by a foreign key
+	ErrSignature = errors.New("hmac: signature mismatch")
+)
+
+const (
+	keyIDLength = 4
+	macLength   = sha256.Size
+)
+
+// Reg registers a HMAC-SHA256 signing filter for transfer.
+// The messages are signed by the key of signKeyID, and verified by the key of the ID they carry.
+// NOTE:
+//
+//	To rotate a key, first deploy the new key in keys to all peers, then switch signKeyID to it;
+//	The signature carries no nonce, timestamp or sequence, so a captured message verifies again when replayed;
+//	It panics if the key of signKeyID is not in keys, or a key is empty.
+func Reg(id byte, name string, signKeyID uint32, keys map[uint32][]byte) {
+	xfer.Reg(newHmac(id, name, signKeyID, keys))
+}
+
+// newHmac creates a new hmac filter.
+func newHmac(id byte, name string, signKeyID uint32, keys map[uint32][]byte) *hmacSigner {
+	if _, ok := keys[signKeyID]; !ok {
+		panic(fmt.Sprintf("hmac: the sign key is not found: %d", signKeyID))
+	}
+	h := &hmacSigner{
+		id:        id,
+		name:      name,
+		signKeyID: signKeyID,
+		pools:     make(map[uint32]*sync.Pool, len(keys)),
+	}
+	for keyID, key := range keys {
+		if len(key) == 0 {
+			panic(fmt.Sprintf("hmac: empty key: %d", keyID))
+		}
+		key := append([]byte(nil), key...)
+		h.pools[keyID] = &sync.Pool{
+			New: func() interface{} {
+				return hmac.New(sha256.New, key)
+			},
+		}
+	}
+	return h
+}
+
+// hmacSigner HMAC-SHA256 signing filter
+type hmacSigner struct {
+	id        byte
+	name      string
+	signKeyID uint32
+	pools     map[uint32]*sync.Pool
+}
+
+// ID returns transfer filter id.
+func (h *hmacSigner) ID() byte {
+	return h.id
+}
+
+// Name returns transfer filter name.
+func (h *hmacSigner) Name() string {
+	return h.name
+}
+
+// OnPack appends the big-endian key ID and the MAC of src and the key ID.
+func (h *hmacSigner) OnPack(src []byte) ([]byte, error) {
+	dest := make([]byte, len(src)+keyIDLength, len(src)+keyIDLength+macLength)
+	copy(dest, src)
+	binary.BigEndian.PutUint32(dest[len(src):], h.signKeyID)
+	return h.sum(h.pools[h.signKeyID], dest, dest), nil
+}
+
+// OnUnpack verifies and strips the key ID and the MAC.
+func (h *hmacSigner) OnUnpack(src []byte) ([]byte, error) {
+	n := len(src) - keyIDLength - macLength
+	if n < 0 {
+		return nil, fmt.Errorf("hmac: too short: %d bytes", len(src))
+	}
+	keyID := binary.BigEndian.Uint32(src[n:])
+	pool, ok := h.pools[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKey, keyID)
+	}
+	var mac [macLength]byte
+	signed := src[:n+keyIDLength]
+	if !hmac.Equal(h.sum(pool, signed, mac[:0]), src[n+keyIDLength:]) {
+		return nil, fmt.Errorf("%w (key id: %d)", ErrSignature, keyID)
+	}
+	return src[:n], nil
+}
+
+func (h *hmacSigner) sum(pool *sync.Pool, data, b []byte) []byte {
+	mac := pool.Get().(hash.Hash)
+	mac.Write(data)
+	b = mac.Sum(b)
+	mac.Reset()
+	pool.Put(mac)
+	return b
+}
@@ -0,0 +1,95 @@
+package hmac_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andeya/erpc/v7"
+	"github.com/andeya/erpc/v7/mem"
+	"github.com/andeya/erpc/v7/mem/memtest"
+	"github.com/andeya/erpc/v7/xfer"
+	"github.com/andeya/erpc/v7/xfer/hmac"
+)
+
+func init() {
+	keys := map[uint32][]byte{1: []byte("key-1"), 2: []byte("key-2")}
+	hmac.Reg('h', "hmac", 1, keys)
+	// the rotated peer
+	hmac.Reg('H', "hmac-rotated", 2, keys)
+	// the foreign peer
+	hmac.Reg('f', "hmac-foreign", 1, map[uint32][]byte{1: []byte("other")})
+	hmac.Reg('u', "hmac-unknown", 3, map[uint32][]byte{3: []byte("key-3")})
+}
+
+func TestSeparate(t *testing.T) {
+	h, _ := xfer.Get('h')
+	rotated, _ := xfer.Get('H')
+	foreign, _ := xfer.Get('f')
+	unknown, _ := xfer.Get('u')
+	input := []byte("hmac")
+
+	for _, signer := range []xfer.XferFilter{h, rotated} {
+		b, err := signer.OnPack(input)
+		if err != nil {
+			t.Fatalf("onpack: %v", err)
+		}
+		if len(b) != len(input)+4+32 {
+			t.Fatalf("unexpected length: %d", len(b))
+		}
+		dest, err := h.OnUnpack(b)
+		if err != nil {
+			t.Fatalf("hmac check failed: %v", err)
+		}
+		if string(dest) != "hmac" {
+			t.Fatalf("want \"hmac\", have %q", dest)
+		}
+		// Tamper with data
+		b[0] ^= 1
+		if _, err = h.OnUnpack(b); !errors.Is(err, hmac.ErrSignature) {
+			t.Fatalf("tampered: want ErrSignature, have %v", err)
+		}
+	}
+
+	b, _ := foreign.OnPack(input)
+	if _, err := h.OnUnpack(b); !errors.Is(err, hmac.ErrSignature) {
+		t.Fatalf("foreign: want ErrSignature, have %v", err)
+	}
+	b, _ = unknown.OnPack(input)
+	if _, err := h.OnUnpack(b); !errors.Is(err, hmac.ErrUnknownKey) {
+		t.Fatalf("unknown key: want ErrUnknownKey, have %v", err)
+	}
+	if _, err := h.OnUnpack([]byte("short")); err == nil {
+		t.Fatal("too short: expect error")
+	}
+}
+
+func TestCombined(t *testing.T) {
+	srv := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network})
+	srv.RouteCall(new(Home))
+	addr := memtest.Serve(srv)
+	defer srv.Close()
+
+	cli := erpc.NewPeer(erpc.PeerConfig{Network: mem.Network, DialTimeout: time.Second})
+	defer cli.Close()
+	sess, stat := cli.Dial(addr)
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	var result string
+	stat = sess.Call("/home/test", "test", &result, erpc.WithXferPipe('h')).Status()
+	if !stat.OK() {
+		t.Fatal(stat)
+	}
+	if result != "your request is:test" {
+		t.Fatalf("unexpected result: %q", result)
+	}
+}
+
+type Home struct {
+	erpc.CallCtx
+}
+
+func (h *Home) Test(arg *string) (string, *erpc.Status) {
+	return "your request is:" + *arg, nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lz4 provides a lz4 block compression transfer filter
+package lz4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/pierrec/lz4/v4"
+
+	"github.com/andeya/erpc/v7/socket"
+	"github.com/andeya/erpc/v7/xfer"
+)
+
+const (
+	markerRaw        byte = 0
+	markerCompressed byte = 1
+)
+
+var ids = map[byte]bool{}
+
+// Reg registers a lz4 filter for transfer.
+// NOTE:
+//
+//	The payloads shorter than minSize, or not shrunk by the compression, are passed through with a raw marker.
+func Reg(id byte, name string, minSize int) {
+	xfer.Reg(newLz4(id, name, minSize))
+	ids[id] = true
+}
+
+// Is determines if the id is lz4.
+func Is(id byte) bool {
+	return ids[id]
+}
+
+// newLz4 creates a new lz4 filter.
+func newLz4(id byte, name string, minSize int) *Lz4 {
+	if minSize < 0 {
+		panic(fmt.Sprintf("lz4: invalid minimum size: %d", minSize))
+	}
+	return &Lz4{
+		id:      id,
+		name:    name,
+		minSize: minSize,
+		cPool: sync.Pool{
+			New: func() interface{} {
+				return new(lz4.Compressor)
+			},
+		},
+	}
+}
+
+// Lz4 compression filter
+type Lz4 struct {
+	id      byte
+	name    string
+	minSize int
+	cPool   sync.Pool
+}
+
+// ID returns transfer filter id.
+func (l *Lz4) ID() byte {
+	return l.id
+}
+
+// Name returns transfer filter name.
+func (l *Lz4) Name() string {
+	return l.name
+}
+
+// OnPack performs filtering on packing.
+// The compressed frame is the marker, the uvarint length of src and the lz4 block.
+func (l *Lz4) OnPack(src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return src, nil
+	}
+	if len(src) >= l.minSize {
+		dest := make([]byte, 1+binary.MaxVarintLen64+lz4.CompressBlockBound(len(src)))
+		dest[0] = markerCompressed
+		n := 1 + binary.PutUvarint(dest[1:], uint64(len(src)))
+		c := l.cPool.Get().(*lz4.Compressor)
+		size, err := c.CompressBlock(src, dest[n:])
+		l.cPool.Put(c)
+		if err != nil {
+			return nil, err
+		}
+		// size is 0 if src is incompressible
+		if size > 0 && n+size < 1+len(src) {
+			return dest[:n+size], nil
+		}
+	}
+	return raw(src), nil
+}
+
+// OnUnpack performs filtering on unpacking.
+func (l *Lz4) OnUnpack(src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return src, nil
+	}
+	switch src[0] {
+	case markerRaw:
+		return src[1:], nil
+	case markerCompressed:
+		size, n := binary.Uvarint(src[1:])
+		if n <= 0 {
+			return nil, errors.New("lz4: invalid length")
+		}
+		block := src[1+n:]
+		// the maximum compression ratio of lz4 is about 255
+		if size > uint64(len(block))*255+16 {
+			return nil, fmt.Errorf("lz4: invalid length: %d", size)
+		}
+		if limit := socket.MessageSizeLimit(); size > uint64(limit) {
+			return nil, fmt.Errorf("lz4: decoded length %d exceeds the message size limit %d", size, limit)
+		}
+		dest := make([]byte, size)
+		m, err := lz4.UncompressBlock(block, dest)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(m) != size {
+			return nil, fmt.Errorf("lz4: length mismatch: want %d, have %d", size, m)
+		}
+		return dest, nil
+	default:
+		return nil, fmt.Errorf("lz4: unknown marker: %d", src[0])
+	}
+}
+
+func raw(src []byte) []byte {
+	dest := make([]byte, 1+len(src))
+	dest[0] = markerRaw
+	copy(dest[1:], src)
+	return dest
+}
@@ -0,0 +1,87 @@
+package lz4_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/andeya/erpc/v7/socket"
+	"github.com/andeya/erpc/v7/xfer"
+	"github.com/andeya/erpc/v7/xfer/lz4"
+)
+
+func TestLz4(t *testing.T) {
+	// test register
+	lz4.Reg('l', "lz4", 64)
+
+	if _, err := xfer.Get('l'); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfer.GetByName("lz4"); err != nil {
+		t.Fatal(err)
+	}
+	if !lz4.Is('l') {
+		t.Fatal("lz4.Is('l') = false")
+	}
+	xferPipe := xfer.NewXferPipe()
+	xferPipe.Append('l')
+	t.Logf("transfer filter: ids:%v, names:%v", xferPipe.IDs(), xferPipe.Names())
+
+	// test logic
+	small := []byte("src")
+	large := bytes.Repeat([]byte(`{"field1":"许多往事在眼前一幕一幕","field2":100000,"field3":true},`), 100)
+	for _, src := range [][]byte{small, large} {
+		b, err := xferPipe.OnPack(src)
+		if err != nil {
+			t.Fatalf("onpack: %v", err)
+		}
+		if len(src) < 64 && b[0] != 0 {
+			t.Fatalf("the payload below the threshold is compressed: %v", b)
+		}
+		if len(src) >= 64 && (b[0] != 1 || len(b) >= len(src)) {
+			t.Fatalf("the payload above the threshold is not compressed: len=%d", len(b))
+		}
+		dest, err := xferPipe.OnUnpack(b)
+		if err != nil {
+			t.Fatalf("onunpack: %v", err)
+		}
+		if !bytes.Equal(dest, src) {
+			t.Fatalf("lz4 has error: want %q, have %q", src, dest)
+		}
+	}
+	if _, err := xferPipe.OnUnpack([]byte{9, 1, 2}); err == nil {
+		t.Fatal("unknown marker: expect error")
+	}
+}
+
+func TestLz4Bomb(t *testing.T) {
+	l, err := xfer.GetByName("lz4")
+	if err != nil {
+		lz4.Reg('l', "lz4", 64)
+		l, _ = xfer.Get('l')
+	}
+	// claims 1GB from a 2-byte block
+	if _, err := l.OnUnpack([]byte{1, 0x80, 0x80, 0x80, 0x80, 0x04, 0, 0}); err == nil {
+		t.Fatal("oversize length: expect error")
+	}
+}
+
+func TestLz4SizeLimit(t *testing.T) {
+	f, err := xfer.GetByName("lz4")
+	if err != nil {
+		lz4.Reg('l', "lz4", 64)
+		f, _ = xfer.Get('l')
+	}
+	b, err := f.OnPack(bytes.Repeat([]byte("a"), 4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.SetMessageSizeLimit(socket.MessageSizeLimit())
+	socket.SetMessageSizeLimit(1024)
+	if _, err = f.OnUnpack(b); err == nil {
+		t.Fatal("oversize payload: expect error")
+	}
+	socket.SetMessageSizeLimit(4096)
+	if dest, err := f.OnUnpack(b); err != nil || len(dest) != 4096 {
+		t.Fatalf("onunpack: %d, %v", len(dest), err)
+	}
+}
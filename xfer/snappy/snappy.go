@@ -0,0 +1,123 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package snappy provides a snappy compression transfer filter
+package snappy
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/snappy"
+
+	"github.com/andeya/erpc/v7/socket"
+	"github.com/andeya/erpc/v7/xfer"
+)
+
+const (
+	markerRaw        byte = 0
+	markerCompressed byte = 1
+)
+
+var ids = map[byte]bool{}
+
+// Reg registers a snappy filter for transfer.
+// NOTE:
+//
+//	The payloads shorter than minSize, or not shrunk by the compression, are passed through with a raw marker.
+func Reg(id byte, name string, minSize int) {
+	xfer.Reg(newSnappy(id, name, minSize))
+	ids[id] = true
+}
+
+// Is determines if the id is snappy.
+func Is(id byte) bool {
+	return ids[id]
+}
+
+// newSnappy creates a new snappy filter.
+func newSnappy(id byte, name string, minSize int) *Snappy {
+	if minSize < 0 {
+		panic(fmt.Sprintf("snappy: invalid minimum size: %d", minSize))
+	}
+	return &Snappy{
+		id:      id,
+		name:    name,
+		minSize: minSize,
+	}
+}
+
+// Snappy compression filter
+type Snappy struct {
+	id      byte
+	name    string
+	minSize int
+}
+
+// ID returns transfer filter id.
+func (s *Snappy) ID() byte {
+	return s.id
+}
+
+// Name returns transfer filter name.
+func (s *Snappy) Name() string {
+	return s.name
+}
+
+// OnPack performs filtering on packing.
+func (s *Snappy) OnPack(src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return src, nil
+	}
+	if len(src) >= s.minSize {
+		n := snappy.MaxEncodedLen(len(src))
+		if n > 0 {
+			dest := make([]byte, 1+n)
+			dest[0] = markerCompressed
+			enc := snappy.Encode(dest[1:], src)
+			if len(enc) < len(src) {
+				return dest[:1+len(enc)], nil
+			}
+		}
+	}
+	return raw(src), nil
+}
+
+// OnUnpack performs filtering on unpacking.
+func (s *Snappy) OnUnpack(src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return src, nil
+	}
+	switch src[0] {
+	case markerRaw:
+		return src[1:], nil
+	case markerCompressed:
+		n, err := snappy.DecodedLen(src[1:])
+		if err != nil {
+			return nil, err
+		}
+		if limit := socket.MessageSizeLimit(); uint64(n) > uint64(limit) {
+			return nil, fmt.Errorf("snappy: decoded length %d exceeds the message size limit %d", n, limit)
+		}
+		return snappy.Decode(nil, src[1:])
+	default:
+		return nil, fmt.Errorf("snappy: unknown marker: %d", src[0])
+	}
+}
+
+func raw(src []byte) []byte {
+	dest := make([]byte, 1+len(src))
+	dest[0] = markerRaw
+	copy(dest[1:], src)
+	return dest
+}
@@ -0,0 +1,191 @@
+// Copyright 2015-2019 HenryLee. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package zstd provides a zstd compression transfer filter with optional trained dictionaries
+package zstd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/andeya/erpc/v7/socket"
+	"github.com/andeya/erpc/v7/xfer"
+)
+
+const (
+	markerRaw        byte = 0
+	markerCompressed byte = 1
+)
+
+var ids = map[byte]bool{}
+
+// Reg registers a zstd filter for transfer.
+// level is the zstd compression level from 1 to 22, which is mapped to the closest supported encoder level.
+// NOTE:
+//
+//	The payloads shorter than minSize, or not shrunk by the compression, are passed through with a raw marker;
+//	The first of dicts is used to compress, and all of them can be used to decompress,
+//	so that the dictionary can be rotated by putting the new one first in the upgraded peers;
+//	The decompressed payload is limited to socket.MessageSizeLimit();
+//	It panics if the level is invalid or a dictionary is malformed.
+func Reg(id byte, name string, level int, minSize int, dicts ...[]byte) {
+	xfer.Reg(newZstd(id, name, level, minSize, dicts...))
+	ids[id] = true
+}
+
+// Is determines if the id is zstd.
+func Is(id byte) bool {
+	return ids[id]
+}
+
+// ReadDict reads a trained zstd dictionary from file, e.g. created by `zstd --train`.
+func ReadDict(filename string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = zstd.InspectDictionary(b); err != nil {
+		return nil, fmt.Errorf("zstd: invalid dictionary %s: %s", filename, err.Error())
+	}
+	return b, nil
+}
+
+// newZstd creates a new zstd filter.
+func newZstd(id byte, name string, level int, minSize int, dicts ...[]byte) *Zstd {
+	if level < 1 || level > 22 {
+		panic(fmt.Sprintf("zstd: invalid compression level: %d", level))
+	}
+	if minSize < 0 {
+		panic(fmt.Sprintf("zstd: invalid minimum size: %d", minSize))
+	}
+	encOpts := []zstd.EOption{
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+	}
+	decOpts := []zstd.DOption{
+		zstd.WithDecoderConcurrency(0),
+	}
+	if len(dicts) > 0 {
+		encOpts = append(encOpts, zstd.WithEncoderDict(dicts[0]))
+		decOpts = append(decOpts, zstd.WithDecoderDicts(dicts...))
+	}
+	enc, err := zstd.NewWriter(nil, encOpts...)
+	if err != nil {
+		panic(fmt.Sprintf("zstd: %s", err.Error()))
+	}
+	z := &Zstd{
+		id:      id,
+		name:    name,
+		level:   level,
+		minSize: minSize,
+		enc:     enc,
+		decOpts: decOpts,
+	}
+	if _, err = z.decoder(); err != nil {
+		panic(fmt.Sprintf("zstd: %s", err.Error()))
+	}
+	return z
+}
+
+// Zstd compression filter
+type Zstd struct {
+	id      byte
+	name    string
+	level   int
+	minSize int
+	enc     *zstd.Encoder
+	decOpts []zstd.DOption
+
+	decMu    sync.RWMutex
+	dec      *zstd.Decoder
+	decLimit uint32
+}
+
+// decoder returns the decoder limited to the current message size limit,
+// it is recreated once the limit is changed.
+func (z *Zstd) decoder() (*zstd.Decoder, error) {
+	limit := socket.MessageSizeLimit()
+	z.decMu.RLock()
+	dec, decLimit := z.dec, z.decLimit
+	z.decMu.RUnlock()
+	if dec != nil && decLimit == limit {
+		return dec, nil
+	}
+	z.decMu.Lock()
+	defer z.decMu.Unlock()
+	if z.dec != nil && z.decLimit == limit {
+		return z.dec, nil
+	}
+	dec, err := zstd.NewReader(nil, append(z.decOpts[:len(z.decOpts):len(z.decOpts)], zstd.WithDecoderMaxMemory(uint64(limit)))...)
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: the replaced decoder is not closed, since it may be in use, and DecodeAll holds no goroutines.
+	z.dec, z.decLimit = dec, limit
+	return dec, nil
+}
+
+// ID returns transfer filter id.
+func (z *Zstd) ID() byte {
+	return z.id
+}
+
+// Name returns transfer filter name.
+func (z *Zstd) Name() string {
+	return z.name
+}
+
+// OnPack performs filtering on packing.
+func (z *Zstd) OnPack(src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return src, nil
+	}
+	if len(src) >= z.minSize {
+		dest := make([]byte, 1, 1+len(src))
+		dest[0] = markerCompressed
+		dest = z.enc.EncodeAll(src, dest)
+		if len(dest) < 1+len(src) {
+			return dest, nil
+		}
+	}
+	return raw(src), nil
+}
+
+// OnUnpack performs filtering on unpacking.
+func (z *Zstd) OnUnpack(src []byte) ([]byte, error) {
+	if len(src) == 0 {
+		return src, nil
+	}
+	switch src[0] {
+	case markerRaw:
+		return src[1:], nil
+	case markerCompressed:
+		dec, err := z.decoder()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(src[1:], nil)
+	default:
+		return nil, fmt.Errorf("zstd: unknown marker: %d", src[0])
+	}
+}
+
+func raw(src []byte) []byte {
+	dest := make([]byte, 1+len(src))
+	dest[0] = markerRaw
+	copy(dest[1:], src)
+	return dest
+}
@@ -0,0 +1,142 @@
+package zstd_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/dict"
+
+	"github.com/andeya/erpc/v7/socket"
+	"github.com/andeya/erpc/v7/xfer"
+	"github.com/andeya/erpc/v7/xfer/zstd"
+)
+
+func TestZstd(t *testing.T) {
+	// test register
+	zstd.Reg('z', "zstd", 3, 64)
+
+	if _, err := xfer.Get('z'); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xfer.GetByName("zstd"); err != nil {
+		t.Fatal(err)
+	}
+	if !zstd.Is('z') {
+		t.Fatal("zstd.Is('z') = false")
+	}
+	xferPipe := xfer.NewXferPipe()
+	xferPipe.Append('z')
+	t.Logf("transfer filter: ids:%v, names:%v", xferPipe.IDs(), xferPipe.Names())
+
+	// test logic
+	small := []byte("src")
+	large := bytes.Repeat([]byte(`{"field1":"许多往事在眼前一幕一幕","field2":100000,"field3":true},`), 100)
+	for i := 0; i < 1000; i++ {
+		for _, src := range [][]byte{small, large} {
+			b, err := xferPipe.OnPack(src)
+			if err != nil {
+				t.Fatalf("onpack: %v", err)
+			}
+			if len(src) < 64 && b[0] != 0 {
+				t.Fatalf("the payload below the threshold is compressed: %v", b)
+			}
+			if len(src) >= 64 && (b[0] != 1 || len(b) >= len(src)) {
+				t.Fatalf("the payload above the threshold is not compressed: len=%d", len(b))
+			}
+			dest, err := xferPipe.OnUnpack(b)
+			if err != nil {
+				t.Fatalf("onunpack: %v", err)
+			}
+			if !bytes.Equal(dest, src) {
+				t.Fatalf("zstd has error: want %q, have %q", src, dest)
+			}
+		}
+	}
+	if _, err := xferPipe.OnUnpack([]byte{9, 1, 2}); err == nil {
+		t.Fatal("unknown marker: expect error")
+	}
+}
+
+func TestZstdDict(t *testing.T) {
+	samples := make([][]byte, 0, 200)
+	for i := 0; i < cap(samples); i++ {
+		samples = append(samples, []byte(fmt.Sprintf(
+			`{"id":%d,"name":"user-%d","email":"user-%d@example.com","roles":["reader","writer"],"active":%t}`,
+			i, i, i, i%2 == 0,
+		)))
+	}
+	d, err := dict.BuildZstdDict(samples, dict.Options{MaxDictSize: 4096, HashBytes: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "payload.dict")
+	if err = os.WriteFile(filename, d, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = zstd.ReadDict(filepath.Join(t.TempDir(), "none.dict")); err == nil {
+		t.Fatal("missing dictionary: expect error")
+	}
+	badFilename := filepath.Join(t.TempDir(), "bad.dict")
+	if err = os.WriteFile(badFilename, []byte("not a dictionary"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = zstd.ReadDict(badFilename); err == nil {
+		t.Fatal("malformed dictionary: expect error")
+	}
+	d, err = zstd.ReadDict(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zstd.Reg('d', "zstd-dict", 3, 0, d)
+	zstd.Reg('D', "zstd-nodict", 3, 0)
+	withDict, _ := xfer.Get('d')
+	withoutDict, _ := xfer.Get('D')
+
+	src := []byte(`{"id":1234,"name":"user-1234","email":"user-1234@example.com","roles":["reader","writer"],"active":true}`)
+	b, err := withDict.OnPack(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, _ := withoutDict.OnPack(src)
+	t.Logf("src:%d, dict:%d, no dict:%d", len(src), len(b), len(b2))
+	if len(b) >= len(b2) {
+		t.Fatalf("the dictionary does not help: dict:%d, no dict:%d", len(b), len(b2))
+	}
+	dest, err := withDict.OnUnpack(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dest, src) {
+		t.Fatalf("zstd has error: want %q, have %q", src, dest)
+	}
+	if b[0] == 1 {
+		if _, err = withoutDict.OnUnpack(b); err == nil {
+			t.Fatal("decompress without the dictionary: expect error")
+		}
+	}
+}
+
+func TestZstdSizeLimit(t *testing.T) {
+	f, err := xfer.GetByName("zstd")
+	if err != nil {
+		zstd.Reg('z', "zstd", 3, 64)
+		f, _ = xfer.Get('z')
+	}
+	b, err := f.OnPack(bytes.Repeat([]byte("a"), 4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.SetMessageSizeLimit(socket.MessageSizeLimit())
+	socket.SetMessageSizeLimit(1024)
+	if _, err = f.OnUnpack(b); err == nil {
+		t.Fatal("oversize payload: expect error")
+	}
+	socket.SetMessageSizeLimit(4096)
+	if dest, err := f.OnUnpack(b); err != nil || len(dest) != 4096 {
+		t.Fatalf("onunpack: %d, %v", len(dest), err)
+	}
+}